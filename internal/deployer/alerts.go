@@ -0,0 +1,171 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var prometheusRuleGVR = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1",
+	Resource: "prometheusrules",
+}
+
+// alertThresholds are the limits above which a function alerts.
+type alertThresholds struct {
+	// ErrorRate is the fraction of requests answered with a 5xx.
+	ErrorRate float64
+	// Saturation is the fraction of max-scale the autoscaler wants.
+	Saturation float64
+	// ColdStartSeconds is the p95 latency of requests buffered by the
+	// activator while the function scales from zero.
+	ColdStartSeconds float64
+	Severity         string
+}
+
+var alertTiers = map[string]alertThresholds{
+	"gold":   {ErrorRate: 0.01, Saturation: 0.8, ColdStartSeconds: 2, Severity: "critical"},
+	"silver": {ErrorRate: 0.05, Saturation: 0.9, ColdStartSeconds: 5, Severity: "warning"},
+	"bronze": {ErrorRate: 0.1, Saturation: 0.95, ColdStartSeconds: 10, Severity: "warning"},
+}
+
+func alertsRuleName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-alerts"
+}
+
+// resolveAlertThresholds starts from the ALERT_TIER defaults (silver when
+// unset) and applies the ALERT_*_THRESHOLD overrides.
+func resolveAlertThresholds(cfg *EnvConfig) (alertThresholds, error) {
+	tier := cfg.AlertTier
+	if tier == "" {
+		tier = "silver"
+	}
+	thresholds, ok := alertTiers[tier]
+	if !ok {
+		return alertThresholds{}, fmt.Errorf("unknown ALERT_TIER: %s", tier)
+	}
+
+	for _, o := range []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"ALERT_ERROR_RATE_THRESHOLD", cfg.AlertErrorRateThreshold, &thresholds.ErrorRate},
+		{"ALERT_SATURATION_THRESHOLD", cfg.AlertSaturationThreshold, &thresholds.Saturation},
+		{"ALERT_COLD_START_THRESHOLD", cfg.AlertColdStartThreshold, &thresholds.ColdStartSeconds},
+	} {
+		if o.value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(o.value, 64)
+		if err != nil || v <= 0 {
+			return alertThresholds{}, fmt.Errorf("invalid %s %q: must be a positive number", o.name, o.value)
+		}
+		*o.dest = v
+	}
+
+	return thresholds, nil
+}
+
+// buildAlertRules renders the PrometheusRule holding the function alerts.
+// The saturation alert needs a max-scale to measure against and is left
+// out without one.
+func buildAlertRules(cfg *EnvConfig, thresholds alertThresholds) *unstructured.Unstructured {
+	selector := fmt.Sprintf(`namespace_name="%s", configuration_name="%s"`, cfg.FunctionNamespace, cfg.FunctionName)
+	labels := map[string]any{
+		"severity":      thresholds.Severity,
+		"kdex_function": cfg.FunctionName,
+		"namespace":     cfg.FunctionNamespace,
+	}
+
+	rules := []any{
+		map[string]any{
+			"alert": "KDexFunctionHighErrorRate",
+			"expr": fmt.Sprintf(`sum(rate(revision_request_count{%s, response_code_class="5xx"}[5m])) / sum(rate(revision_request_count{%s}[5m])) > %g`,
+				selector, selector, thresholds.ErrorRate),
+			"for":    "5m",
+			"labels": labels,
+			"annotations": map[string]any{
+				"summary": fmt.Sprintf("Function %s/%s answers more than %g%% of requests with errors", cfg.FunctionNamespace, cfg.FunctionName, thresholds.ErrorRate*100),
+			},
+		},
+		map[string]any{
+			"alert": "KDexFunctionSlowColdStart",
+			"expr": fmt.Sprintf(`histogram_quantile(0.95, sum by (le) (rate(activator_request_latencies_bucket{%s}[10m]))) > %g`,
+				selector, thresholds.ColdStartSeconds*1000),
+			"for":    "10m",
+			"labels": labels,
+			"annotations": map[string]any{
+				"summary": fmt.Sprintf("Function %s/%s takes more than %gs to serve requests from zero", cfg.FunctionNamespace, cfg.FunctionName, thresholds.ColdStartSeconds),
+			},
+		},
+	}
+
+	if maxScale, err := strconv.ParseInt(cfg.ScalingMaxScale, 10, 64); err == nil && maxScale > 0 {
+		rules = append(rules, map[string]any{
+			"alert": "KDexFunctionSaturated",
+			"expr": fmt.Sprintf(`sum(autoscaler_desired_pods{%s}) / %d > %g`,
+				selector, maxScale, thresholds.Saturation),
+			"for":    "10m",
+			"labels": labels,
+			"annotations": map[string]any{
+				"summary": fmt.Sprintf("Function %s/%s wants more than %g%% of its max-scale of %d", cfg.FunctionNamespace, cfg.FunctionName, thresholds.Saturation*100, maxScale),
+			},
+		})
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"metadata": map[string]any{
+				"name":      alertsRuleName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": map[string]any{
+				"groups": []any{
+					map[string]any{
+						"name":  "kdex-function-" + cfg.FunctionName,
+						"rules": rules,
+					},
+				},
+			},
+		},
+	}
+}
+
+// provisionAlerts applies the function's PrometheusRule.
+func provisionAlerts(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	thresholds, err := resolveAlertThresholds(cfg)
+	if err != nil {
+		return err
+	}
+
+	rule := buildAlertRules(cfg, thresholds)
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	_, err = client.Resource(prometheusRuleGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, rule.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply prometheus rule: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResolveAlertThresholds(t *testing.T) {
+	thresholds, err := resolveAlertThresholds(&EnvConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if thresholds != alertTiers["silver"] {
+		t.Errorf("Expected silver defaults, got %+v", thresholds)
+	}
+
+	thresholds, err = resolveAlertThresholds(&EnvConfig{AlertTier: "gold", AlertErrorRateThreshold: "0.02"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if thresholds.ErrorRate != 0.02 || thresholds.Severity != "critical" {
+		t.Errorf("Expected gold with overridden error rate, got %+v", thresholds)
+	}
+
+	if _, err := resolveAlertThresholds(&EnvConfig{AlertTier: "platinum"}); err == nil {
+		t.Error("Expected error for unknown tier")
+	}
+	if _, err := resolveAlertThresholds(&EnvConfig{AlertColdStartThreshold: "-1"}); err == nil {
+		t.Error("Expected error for negative threshold")
+	}
+}
+
+func TestBuildAlertRules(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+
+	rules := func(obj *unstructured.Unstructured) []any {
+		groups, _, _ := unstructured.NestedSlice(obj.Object, "spec", "groups")
+		r, _, _ := unstructured.NestedSlice(groups[0].(map[string]any), "rules")
+		return r
+	}
+
+	rule := buildAlertRules(cfg, alertTiers["gold"])
+	if rule.GetName() != "myfunc-alerts" {
+		t.Errorf("Unexpected name: %s", rule.GetName())
+	}
+	if got := rules(rule); len(got) != 2 {
+		t.Errorf("Expected no saturation alert without max-scale, got %d rules", len(got))
+	}
+
+	cfg.ScalingMaxScale = "10"
+	got := rules(buildAlertRules(cfg, alertTiers["gold"]))
+	if len(got) != 3 {
+		t.Fatalf("Expected saturation alert with max-scale, got %d rules", len(got))
+	}
+	saturation := got[2].(map[string]any)
+	if !strings.Contains(saturation["expr"].(string), "/ 10 > 0.8") {
+		t.Errorf("Unexpected saturation expression: %s", saturation["expr"])
+	}
+}
@@ -0,0 +1,194 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// requestAuthIstio has Istio check the JWT of every request to the
+// function against ISSUER, AUDIENCE and JWKS_URL.
+const requestAuthIstio = "istio"
+
+// authNone is the authentication the observer reports for a function
+// anyone can call.
+const authNone = "none"
+
+var (
+	requestAuthenticationGVR = schema.GroupVersionResource{
+		Group:    "security.istio.io",
+		Version:  "v1",
+		Resource: "requestauthentications",
+	}
+
+	authorizationPolicyGVR = schema.GroupVersionResource{
+		Group:    "security.istio.io",
+		Version:  "v1",
+		Resource: "authorizationpolicies",
+	}
+)
+
+// knativeProbePaths are requested by Knative itself, without a token, to
+// tell whether the function is up.
+var knativeProbePaths = []any{"/healthz", "/metrics"}
+
+func validateRequestAuthentication(cfg *EnvConfig) error {
+	switch cfg.RequestAuthentication {
+	case "":
+		return nil
+	case requestAuthIstio:
+		if cfg.Issuer == "" || cfg.JWKSURL == "" {
+			return fmt.Errorf("ISSUER and JWKS_URL are required for istio request authentication")
+		}
+		if u, err := url.Parse(cfg.JWKSURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid JWKS_URL %q: expected an https URL", cfg.JWKSURL)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown REQUEST_AUTHENTICATION: %s", cfg.RequestAuthentication)
+	}
+}
+
+// functionWorkloadSelector selects the pods of every revision of the
+// function.
+func functionWorkloadSelector(cfg *EnvConfig) map[string]any {
+	return map[string]any{
+		"matchLabels": map[string]any{"serving.knative.dev/service": cfg.FunctionName},
+	}
+}
+
+// buildRequestAuthentication renders the RequestAuthentication validating
+// the tokens of the configured issuer. On its own it only rejects invalid
+// tokens; the AuthorizationPolicy rejects requests without one.
+func buildRequestAuthentication(cfg *EnvConfig) *unstructured.Unstructured {
+	rule := map[string]any{
+		"issuer":  cfg.Issuer,
+		"jwksUri": cfg.JWKSURL,
+		// Handlers may check claims of their own
+		"forwardOriginalToken": true,
+	}
+	if cfg.Audience != "" {
+		rule["audiences"] = []any{cfg.Audience}
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": requestAuthenticationGVR.GroupVersion().String(),
+			"kind":       "RequestAuthentication",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"selector": functionWorkloadSelector(cfg),
+				"jwtRules": []any{rule},
+			},
+		},
+	}
+}
+
+// buildAuthorizationPolicy renders the AuthorizationPolicy admitting only
+// requests with a token of the configured issuer, and Knative's probes.
+func buildAuthorizationPolicy(cfg *EnvConfig) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": authorizationPolicyGVR.GroupVersion().String(),
+			"kind":       "AuthorizationPolicy",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"selector": functionWorkloadSelector(cfg),
+				"action":   "ALLOW",
+				"rules": []any{
+					map[string]any{
+						"from": []any{
+							map[string]any{"source": map[string]any{"requestPrincipals": []any{cfg.Issuer + "/*"}}},
+						},
+					},
+					map[string]any{
+						"from": []any{
+							map[string]any{"source": map[string]any{"namespaces": []any{"knative-serving"}}},
+						},
+						"to": []any{
+							map[string]any{"operation": map[string]any{"paths": knativeProbePaths}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func authMetadata(cfg *EnvConfig) map[string]any {
+	return map[string]any{
+		"name":      cfg.FunctionName,
+		"namespace": cfg.FunctionNamespace,
+		"labels": map[string]any{
+			"kdex.dev/function":   cfg.FunctionName,
+			"kdex.dev/generation": cfg.FunctionGeneration,
+		},
+	}
+}
+
+// provisionRequestAuthentication applies the Istio policies for
+// REQUEST_AUTHENTICATION before the revision serves, or removes those of
+// an earlier deploy when it is unset.
+func provisionRequestAuthentication(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	policies := []struct {
+		gvr schema.GroupVersionResource
+		obj *unstructured.Unstructured
+	}{
+		{requestAuthenticationGVR, buildRequestAuthentication(cfg)},
+		{authorizationPolicyGVR, buildAuthorizationPolicy(cfg)},
+	}
+
+	for _, p := range policies {
+		resourceClient := client.Resource(p.gvr).Namespace(cfg.FunctionNamespace)
+		if cfg.RequestAuthentication == "" {
+			// The CRDs may not even be installed, which is fine
+			err := resourceClient.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s %s: %w", p.gvr.Resource, cfg.FunctionName, err)
+			}
+			continue
+		}
+
+		data, err := json.Marshal(p.obj)
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = resourceClient.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply %s: %w", p.gvr.Resource, err)
+		}
+	}
+	return nil
+}
+
+// observeAuthentication reports how requests to the function are
+// authenticated, as recorded in the KDexFunction status. It returns nil
+// when that cannot be told.
+func observeAuthentication(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) map[string]any {
+	auth, err := client.Resource(requestAuthenticationGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return map[string]any{"mode": authNone, "issuer": ""}
+	}
+	if err != nil {
+		warnf("failed to get request authentication: %v\n", err)
+		return nil
+	}
+
+	issuer := ""
+	rules, _, _ := unstructured.NestedSlice(auth.Object, "spec", "jwtRules")
+	if len(rules) > 0 {
+		issuer, _ = rules[0].(map[string]any)["issuer"].(string)
+	}
+	return map[string]any{"mode": requestAuthIstio, "issuer": issuer}
+}
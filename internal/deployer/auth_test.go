@@ -0,0 +1,113 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateRequestAuthentication(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{RequestAuthentication: "istio", Issuer: "https://issuer.example.com", JWKSURL: "https://issuer.example.com/jwks"}},
+		{cfg: EnvConfig{RequestAuthentication: "istio", Issuer: "https://issuer.example.com"}, wantErr: true},
+		{cfg: EnvConfig{RequestAuthentication: "istio", Issuer: "https://issuer.example.com", JWKSURL: "http://issuer.example.com/jwks"}, wantErr: true},
+		{cfg: EnvConfig{RequestAuthentication: "oauth2-proxy"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateRequestAuthentication(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBuildRequestAuthentication(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:          "fn",
+		FunctionNamespace:     "default",
+		RequestAuthentication: requestAuthIstio,
+		Issuer:                "https://issuer.example.com",
+		Audience:              "kdex",
+		JWKSURL:               "https://issuer.example.com/jwks",
+	}
+
+	auth := buildRequestAuthentication(cfg)
+	rules, _, _ := unstructured.NestedSlice(auth.Object, "spec", "jwtRules")
+	if len(rules) != 1 {
+		t.Fatalf("Expected one JWT rule, got %v", rules)
+	}
+	rule := rules[0].(map[string]any)
+	if rule["issuer"] != cfg.Issuer || rule["jwksUri"] != cfg.JWKSURL || rule["audiences"].([]any)[0] != "kdex" {
+		t.Errorf("Unexpected JWT rule: %v", rule)
+	}
+	selector, _, _ := unstructured.NestedStringMap(auth.Object, "spec", "selector", "matchLabels")
+	if selector["serving.knative.dev/service"] != "fn" {
+		t.Errorf("Expected the policy scoped to the function, got %v", selector)
+	}
+
+	policy := buildAuthorizationPolicy(cfg)
+	policyRules, _, _ := unstructured.NestedSlice(policy.Object, "spec", "rules")
+	principals, _, _ := unstructured.NestedStringSlice(policyRules[0].(map[string]any)["from"].([]any)[0].(map[string]any), "source", "requestPrincipals")
+	if len(principals) != 1 || principals[0] != "https://issuer.example.com/*" {
+		t.Errorf("Expected tokens of the issuer to be required, got %v", principals)
+	}
+}
+
+func TestProvisionRequestAuthenticationRemoves(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(
+		newObject("security.istio.io/v1", "RequestAuthentication", "default", "fn", nil),
+		newObject("security.istio.io/v1", "AuthorizationPolicy", "default", "fn", nil),
+	)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+
+	if err := provisionRequestAuthentication(ctx, client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Resource(requestAuthenticationGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the request authentication to be removed, got %v", err)
+	}
+	if _, err := client.Resource(authorizationPolicyGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the authorization policy to be removed, got %v", err)
+	}
+
+	// Nothing to remove
+	if err := provisionRequestAuthentication(ctx, client, cfg); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestObserveAuthentication(t *testing.T) {
+	ctx := context.Background()
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", Issuer: "https://issuer.example.com", JWKSURL: "https://issuer.example.com/jwks"}
+
+	client := newFakeDynamicClient(buildRequestAuthentication(cfg))
+	auth := observeAuthentication(ctx, client, cfg)
+	if auth["mode"] != requestAuthIstio || auth["issuer"] != cfg.Issuer {
+		t.Errorf("Unexpected authentication: %v", auth)
+	}
+
+	function := kdexFunctionInState("Ready")
+	update := observeStatus(cfg, knativeServiceWithReady("True"), function, map[string]map[string]any{"authentication": auth})
+	if update == nil || update.blocks["authentication"]["mode"] != requestAuthIstio {
+		t.Errorf("Expected the authentication to be recorded, got %+v", update)
+	}
+
+	_ = unstructured.SetNestedMap(function.Object, map[string]any{"mode": requestAuthIstio, "issuer": cfg.Issuer}, "status", "authentication")
+	if update := observeStatus(cfg, knativeServiceWithReady("True"), function, map[string]map[string]any{"authentication": auth}); update != nil {
+		t.Errorf("Expected no update for unchanged authentication, got %+v", update)
+	}
+
+	auth = observeAuthentication(ctx, newFakeDynamicClient(), cfg)
+	if auth["mode"] != authNone {
+		t.Errorf("Expected no authentication, got %v", auth)
+	}
+}
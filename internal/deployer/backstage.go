@@ -0,0 +1,129 @@
+package deployer
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const lifecycleAnnotation = "kdex.dev/lifecycle"
+
+// runCatalogInfo emits a Backstage catalog-info Component for the function,
+// or updates the one in --output in place, keeping whatever else it holds.
+func runCatalogInfo(args []string) error {
+	flags := flag.NewFlagSet("catalog-info", flag.ContinueOnError)
+	output := flags.String("output", "", "catalog-info.yaml file to create or update (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+
+	// The KDexFunction provides owner and description; without cluster
+	// access the entry is still useful
+	var function *unstructured.Unstructured
+	client, err := getDynamicClient()
+	if err == nil {
+		function, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Get(context.Background(), cfg.FunctionName, metav1.GetOptions{})
+	}
+	if err != nil {
+		warnf("failed to get kdex function: %v\n", err)
+		function = nil
+	}
+
+	entity := buildCatalogInfo(cfg, function)
+
+	if *output != "" {
+		existing, err := os.ReadFile(*output)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", *output, err)
+		}
+		if len(existing) > 0 {
+			current := map[string]any{}
+			if err := yaml.Unmarshal(existing, &current); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", *output, err)
+			}
+			entity = mergeCatalogInfo(current, entity)
+		}
+	}
+
+	data, err := yaml.Marshal(entity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal catalog info: %w", err)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0644)
+}
+
+func buildCatalogInfo(cfg *EnvConfig, function *unstructured.Unstructured) map[string]any {
+	var annotations map[string]string
+	if function != nil {
+		annotations = function.GetAnnotations()
+	}
+
+	owner := annotations[ownerAnnotation]
+	if owner == "" {
+		owner = "unknown"
+	}
+	lifecycle := annotations[lifecycleAnnotation]
+	if lifecycle == "" {
+		lifecycle = "production"
+	}
+
+	metadata := map[string]any{
+		"name": cfg.FunctionName,
+		"annotations": map[string]any{
+			"backstage.io/kubernetes-id":             cfg.FunctionName,
+			"backstage.io/kubernetes-namespace":      cfg.FunctionNamespace,
+			"backstage.io/kubernetes-label-selector": "kdex.dev/function=" + cfg.FunctionName,
+			"knative.dev/service":                    cfg.FunctionNamespace + "/" + cfg.FunctionName,
+			"grafana/dashboard-selector":             fmt.Sprintf("tags @> 'kdex-function:%s'", cfg.FunctionName),
+		},
+	}
+	if description := annotations[descriptionAnnotation]; description != "" {
+		metadata["description"] = description
+	}
+
+	return map[string]any{
+		"apiVersion": "backstage.io/v1alpha1",
+		"kind":       "Component",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"type":      "service",
+			"lifecycle": lifecycle,
+			"owner":     owner,
+		},
+	}
+}
+
+// mergeCatalogInfo overlays the generated entity onto an existing one so
+// fields maintained by hand (links, tags, relations, extra annotations)
+// survive regeneration.
+func mergeCatalogInfo(existing, generated map[string]any) map[string]any {
+	for k, v := range generated {
+		generatedMap, ok := v.(map[string]any)
+		if !ok {
+			existing[k] = v
+			continue
+		}
+		existingMap, ok := existing[k].(map[string]any)
+		if !ok {
+			existing[k] = generatedMap
+			continue
+		}
+		existing[k] = mergeCatalogInfo(existingMap, generatedMap)
+	}
+	return existing
+}
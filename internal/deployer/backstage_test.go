@@ -0,0 +1,66 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildCatalogInfo(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+	}
+
+	entity := buildCatalogInfo(cfg, nil)
+	owner, _, _ := unstructured.NestedString(entity, "spec", "owner")
+	if owner != "unknown" {
+		t.Errorf("Expected unknown owner, got %s", owner)
+	}
+	selector, _, _ := unstructured.NestedString(entity, "metadata", "annotations", "backstage.io/kubernetes-label-selector")
+	if selector != "kdex.dev/function=myfunc" {
+		t.Errorf("Unexpected label selector: %s", selector)
+	}
+
+	function := &unstructured.Unstructured{Object: map[string]any{}}
+	function.SetAnnotations(map[string]string{
+		ownerAnnotation:       "team-a",
+		descriptionAnnotation: "Does things",
+	})
+	entity = buildCatalogInfo(cfg, function)
+	owner, _, _ = unstructured.NestedString(entity, "spec", "owner")
+	description, _, _ := unstructured.NestedString(entity, "metadata", "description")
+	if owner != "team-a" || description != "Does things" {
+		t.Errorf("Expected owner and description from function, got %s, %s", owner, description)
+	}
+}
+
+func TestMergeCatalogInfo(t *testing.T) {
+	existing := map[string]any{
+		"metadata": map[string]any{
+			"name": "old",
+			"tags": []any{"python"},
+			"annotations": map[string]any{
+				"github.com/project-slug": "org/repo",
+			},
+		},
+		"spec": map[string]any{
+			"owner":  "someone",
+			"system": "payments",
+		},
+	}
+
+	merged := mergeCatalogInfo(existing, buildCatalogInfo(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}, nil))
+
+	name, _, _ := unstructured.NestedString(merged, "metadata", "name")
+	slug, _, _ := unstructured.NestedString(merged, "metadata", "annotations", "github.com/project-slug")
+	kubeID, _, _ := unstructured.NestedString(merged, "metadata", "annotations", "backstage.io/kubernetes-id")
+	system, _, _ := unstructured.NestedString(merged, "spec", "system")
+	tags, _, _ := unstructured.NestedSlice(merged, "metadata", "tags")
+	if name != "myfunc" || kubeID != "myfunc" {
+		t.Errorf("Expected generated fields to win: %v", merged)
+	}
+	if slug != "org/repo" || system != "payments" || len(tags) != 1 {
+		t.Errorf("Expected hand-maintained fields to survive: %v", merged)
+	}
+}
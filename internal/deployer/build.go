@@ -0,0 +1,274 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	kpackImageGVR = schema.GroupVersionResource{
+		Group:    "kpack.io",
+		Version:  "v1alpha2",
+		Resource: "images",
+	}
+
+	tektonPipelineRunGVR = schema.GroupVersionResource{
+		Group:    "tekton.dev",
+		Version:  "v1",
+		Resource: "pipelineruns",
+	}
+)
+
+const (
+	buildStrategyKpack  = "kpack"
+	buildStrategyTekton = "tekton"
+)
+
+// runBuild builds the function image from FUNCTION_SOURCE_GIT and returns the
+// digest reference of the result, which the deploy then uses in place of
+// FUNCTION_IMAGE.
+func runBuild(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (string, error) {
+	if cfg.BuildImage == "" {
+		return "", fmt.Errorf("BUILD_IMAGE is required when FUNCTION_SOURCE_GIT is set")
+	}
+
+	strategy := cfg.BuildStrategy
+	if strategy == "" {
+		strategy = buildStrategyKpack
+	}
+
+	logf("Building %s from %s using %s\n", cfg.BuildImage, cfg.FunctionSourceGit, strategy)
+
+	switch strategy {
+	case buildStrategyKpack:
+		resourceClient := client.Resource(kpackImageGVR).Namespace(cfg.FunctionNamespace)
+		image := buildKpackImage(cfg)
+		data, err := json.Marshal(image)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal kpack image: %w", err)
+		}
+		force := true
+		_, err = resourceClient.Patch(ctx, image.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to apply kpack image: %w", err)
+		}
+		return waitForBuild(ctx, resourceClient, image.GetName(), parseKpackImageStatus)
+	case buildStrategyTekton:
+		if cfg.BuildPipeline == "" {
+			return "", fmt.Errorf("BUILD_PIPELINE is required for the tekton build strategy")
+		}
+		resourceClient := client.Resource(tektonPipelineRunGVR).Namespace(cfg.FunctionNamespace)
+		created, err := resourceClient.Create(ctx, buildPipelineRun(cfg), metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to create tekton pipeline run: %w", err)
+		}
+		return waitForBuild(ctx, resourceClient, created.GetName(), parsePipelineRunStatus)
+	default:
+		return "", fmt.Errorf("unknown BUILD_STRATEGY: %s", strategy)
+	}
+}
+
+func buildKpackImage(cfg *EnvConfig) *unstructured.Unstructured {
+	builder := cfg.BuildBuilder
+	if builder == "" {
+		builder = "default"
+	}
+
+	git := map[string]any{
+		"url": cfg.FunctionSourceGit,
+	}
+	if cfg.FunctionSourceRevision != "" {
+		git["revision"] = cfg.FunctionSourceRevision
+	}
+
+	spec := map[string]any{
+		"tag": cfg.BuildImage,
+		"builder": map[string]any{
+			"kind": "ClusterBuilder",
+			"name": builder,
+		},
+		"source": map[string]any{
+			"git": git,
+		},
+	}
+	if cfg.BuildServiceAccount != "" {
+		spec["serviceAccountName"] = cfg.BuildServiceAccount
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "kpack.io/v1alpha2",
+			"kind":       "Image",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionName,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func buildPipelineRun(cfg *EnvConfig) *unstructured.Unstructured {
+	params := []any{
+		map[string]any{"name": "git-url", "value": cfg.FunctionSourceGit},
+		map[string]any{"name": "image", "value": cfg.BuildImage},
+	}
+	if cfg.FunctionSourceRevision != "" {
+		params = append(params, map[string]any{"name": "git-revision", "value": cfg.FunctionSourceRevision})
+	}
+
+	spec := map[string]any{
+		"pipelineRef": map[string]any{
+			"name": cfg.BuildPipeline,
+		},
+		"params": params,
+	}
+	if cfg.BuildServiceAccount != "" {
+		spec["taskRunTemplate"] = map[string]any{
+			"serviceAccountName": cfg.BuildServiceAccount,
+		}
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "tekton.dev/v1",
+			"kind":       "PipelineRun",
+			"metadata": map[string]any{
+				"generateName": cfg.FunctionName + "-build-",
+				"namespace":    cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// buildStatusFunc reports whether a build finished, the resulting image
+// reference when it succeeded, and an error when it failed.
+type buildStatusFunc func(obj *unstructured.Unstructured) (done bool, image string, err error)
+
+func parseKpackImageStatus(obj *unstructured.Unstructured) (bool, string, error) {
+	latestImage, _, _ := unstructured.NestedString(obj.Object, "status", "latestImage")
+	buildGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if buildGeneration < obj.GetGeneration() {
+		return false, "", nil
+	}
+
+	ready, msg, found := findCondition(obj, "Ready")
+	if !found {
+		return false, "", nil
+	}
+	switch ready {
+	case "True":
+		if latestImage == "" {
+			return false, "", nil
+		}
+		return true, latestImage, nil
+	case "False":
+		return true, "", fmt.Errorf("kpack build failed: %s", msg)
+	}
+	return false, "", nil
+}
+
+func parsePipelineRunStatus(obj *unstructured.Unstructured) (bool, string, error) {
+	succeeded, msg, found := findCondition(obj, "Succeeded")
+	if !found {
+		return false, "", nil
+	}
+	switch succeeded {
+	case "True":
+	case "False":
+		return true, "", fmt.Errorf("tekton pipeline run failed: %s", msg)
+	default:
+		return false, "", nil
+	}
+
+	results, _, _ := unstructured.NestedSlice(obj.Object, "status", "results")
+	var url, digest string
+	for _, r := range results {
+		result, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		value, _ := result["value"].(string)
+		switch result["name"] {
+		case "IMAGE_URL":
+			url = value
+		case "IMAGE_DIGEST":
+			digest = value
+		}
+	}
+	if url == "" || digest == "" {
+		return true, "", fmt.Errorf("tekton pipeline run did not report IMAGE_URL and IMAGE_DIGEST results")
+	}
+
+	return true, fmt.Sprintf("%s@%s", url, digest), nil
+}
+
+// findCondition returns the status and message of the named condition in
+// the object's status.
+func findCondition(obj *unstructured.Unstructured, conditionType string) (string, string, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if cond["type"] == conditionType {
+			status, _ := cond["status"].(string)
+			message, _ := cond["message"].(string)
+			return status, message, true
+		}
+	}
+	return "", "", false
+}
+
+func waitForBuild(ctx context.Context, client dynamic.ResourceInterface, name string, parse buildStatusFunc) (string, error) {
+	timeout := time.After(30 * time.Minute)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("timeout waiting for build %s", name)
+		case <-ticker.C:
+			obj, err := client.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return "", err
+			}
+
+			done, image, err := parse(obj)
+			if err != nil {
+				return "", err
+			}
+			if done {
+				return image, nil
+			}
+		}
+	}
+}
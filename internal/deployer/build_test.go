@@ -0,0 +1,105 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildKpackImage(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:           "myfunc",
+		FunctionNamespace:      "myns",
+		FunctionSourceGit:      "https://example.com/repo.git",
+		FunctionSourceRevision: "main",
+		BuildImage:             "registry.example.com/myfunc",
+	}
+
+	image := buildKpackImage(cfg)
+	tag, _, _ := unstructured.NestedString(image.Object, "spec", "tag")
+	if tag != "registry.example.com/myfunc" {
+		t.Errorf("Unexpected tag: %s", tag)
+	}
+	builder, _, _ := unstructured.NestedString(image.Object, "spec", "builder", "name")
+	if builder != "default" {
+		t.Errorf("Expected default builder, got %s", builder)
+	}
+	revision, _, _ := unstructured.NestedString(image.Object, "spec", "source", "git", "revision")
+	if revision != "main" {
+		t.Errorf("Unexpected revision: %s", revision)
+	}
+}
+
+func TestParseKpackImageStatus(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]any{
+			"metadata": map[string]any{"generation": int64(2)},
+			"status": map[string]any{
+				"observedGeneration": int64(1),
+				"latestImage":        "registry.example.com/myfunc@sha256:old",
+				"conditions": []any{
+					map[string]any{"type": "Ready", "status": "True"},
+				},
+			},
+		},
+	}
+
+	done, _, err := parseKpackImageStatus(obj)
+	if done || err != nil {
+		t.Errorf("Expected stale generation to be pending. Got %v, %v", done, err)
+	}
+
+	_ = unstructured.SetNestedField(obj.Object, int64(2), "status", "observedGeneration")
+	done, image, err := parseKpackImageStatus(obj)
+	if !done || err != nil || image != "registry.example.com/myfunc@sha256:old" {
+		t.Errorf("Expected done with latest image. Got %v, %s, %v", done, image, err)
+	}
+
+	_ = unstructured.SetNestedSlice(obj.Object, []any{
+		map[string]any{"type": "Ready", "status": "False", "message": "build failed"},
+	}, "status", "conditions")
+	done, _, err = parseKpackImageStatus(obj)
+	if !done || err == nil {
+		t.Errorf("Expected failure. Got %v, %v", done, err)
+	}
+}
+
+func TestParsePipelineRunStatus(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]any{
+			"status": map[string]any{
+				"conditions": []any{
+					map[string]any{"type": "Succeeded", "status": "Unknown"},
+				},
+			},
+		},
+	}
+
+	done, _, err := parsePipelineRunStatus(obj)
+	if done || err != nil {
+		t.Errorf("Expected running pipeline to be pending. Got %v, %v", done, err)
+	}
+
+	obj.Object["status"] = map[string]any{
+		"conditions": []any{
+			map[string]any{"type": "Succeeded", "status": "True"},
+		},
+		"results": []any{
+			map[string]any{"name": "IMAGE_URL", "value": "registry.example.com/myfunc"},
+			map[string]any{"name": "IMAGE_DIGEST", "value": "sha256:abc"},
+		},
+	}
+	done, image, err := parsePipelineRunStatus(obj)
+	if !done || err != nil || image != "registry.example.com/myfunc@sha256:abc" {
+		t.Errorf("Expected built image. Got %v, %s, %v", done, image, err)
+	}
+
+	obj.Object["status"] = map[string]any{
+		"conditions": []any{
+			map[string]any{"type": "Succeeded", "status": "True"},
+		},
+	}
+	if _, _, err := parsePipelineRunStatus(obj); err == nil {
+		t.Error("Expected error when results are missing")
+	}
+}
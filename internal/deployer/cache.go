@@ -0,0 +1,183 @@
+package deployer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// readCacheTTL bounds how stale a cached read can be. It is short enough
+// that only the reads of a single deploy or observation share results.
+const readCacheTTL = 15 * time.Second
+
+// cachedResources are the auxiliary resources read more than once in a run:
+// the KDexFunction by the env loader, the drift check and the catalog step,
+// and the pull credentials by the image keychain.
+var cachedResources = []schema.GroupVersionResource{kdexFunctionGVR, serviceAccountGVR, secretGVR}
+
+type readCacheKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+type readCacheEntry struct {
+	obj     *unstructured.Unstructured
+	expires time.Time
+}
+
+// readCache is a dynamic client that answers repeated Gets of the cached
+// resources from memory for a short while. A write through the cache drops
+// the object it writes once the write returns, so the run always reads its
+// own writes; changes by others show up once the entry expires.
+type readCache struct {
+	dynamic.Interface
+	ttl       time.Duration
+	now       func() time.Time
+	resources map[schema.GroupVersionResource]bool
+
+	mu      sync.Mutex
+	entries map[readCacheKey]readCacheEntry
+}
+
+func newReadCache(client dynamic.Interface, ttl time.Duration, resources ...schema.GroupVersionResource) *readCache {
+	c := &readCache{
+		Interface: client,
+		ttl:       ttl,
+		now:       time.Now,
+		resources: map[schema.GroupVersionResource]bool{},
+		entries:   map[readCacheKey]readCacheEntry{},
+	}
+	for _, gvr := range resources {
+		c.resources[gvr] = true
+	}
+	return c
+}
+
+func (c *readCache) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+	if !c.resources[gvr] {
+		return resource
+	}
+	return &cachedNamespaceableResource{
+		cachedResource: &cachedResource{ResourceInterface: resource, cache: c, gvr: gvr},
+		namespaceable:  resource,
+	}
+}
+
+func (c *readCache) get(key readCacheKey) *unstructured.Unstructured {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.obj.DeepCopy()
+}
+
+func (c *readCache) put(key readCacheKey, obj *unstructured.Unstructured) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = readCacheEntry{obj: obj.DeepCopy(), expires: c.now().Add(c.ttl)}
+}
+
+// forget drops the entry for key, or every entry of its resource and
+// namespace when key has no name.
+func (c *readCache) forget(key readCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k == key || (key.name == "" && k.gvr == key.gvr && k.namespace == key.namespace) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+type cachedNamespaceableResource struct {
+	*cachedResource
+	namespaceable dynamic.NamespaceableResourceInterface
+}
+
+func (r *cachedNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &cachedResource{
+		ResourceInterface: r.namespaceable.Namespace(namespace),
+		cache:             r.cache,
+		gvr:               r.gvr,
+		namespace:         namespace,
+	}
+}
+
+// cachedResource caches plain Gets of the whole object; Gets of a
+// subresource or at a resource version go to the API server.
+type cachedResource struct {
+	dynamic.ResourceInterface
+	cache     *readCache
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+func (r *cachedResource) key(name string) readCacheKey {
+	return readCacheKey{gvr: r.gvr, namespace: r.namespace, name: name}
+}
+
+func (r *cachedResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 || options.ResourceVersion != "" {
+		return r.ResourceInterface.Get(ctx, name, options, subresources...)
+	}
+	if obj := r.cache.get(r.key(name)); obj != nil {
+		return obj, nil
+	}
+	obj, err := r.ResourceInterface.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(r.key(name), obj)
+	return obj, nil
+}
+
+func (r *cachedResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(obj.GetName()))
+	return r.ResourceInterface.Create(ctx, obj, options, subresources...)
+}
+
+func (r *cachedResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(obj.GetName()))
+	return r.ResourceInterface.Update(ctx, obj, options, subresources...)
+}
+
+func (r *cachedResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(obj.GetName()))
+	return r.ResourceInterface.UpdateStatus(ctx, obj, options)
+}
+
+func (r *cachedResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	defer r.cache.forget(r.key(name))
+	return r.ResourceInterface.Delete(ctx, name, options, subresources...)
+}
+
+func (r *cachedResource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	defer r.cache.forget(r.key(""))
+	return r.ResourceInterface.DeleteCollection(ctx, options, listOptions)
+}
+
+func (r *cachedResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(name))
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+func (r *cachedResource) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(name))
+	return r.ResourceInterface.Apply(ctx, name, obj, options, subresources...)
+}
+
+func (r *cachedResource) ApplyStatus(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions) (*unstructured.Unstructured, error) {
+	defer r.cache.forget(r.key(name))
+	return r.ResourceInterface.ApplyStatus(ctx, name, obj, options)
+}
@@ -0,0 +1,72 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReadCache(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDynamicClient(
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "default", "fn", nil),
+		newObject("v1", "ConfigMap", "default", "cm", nil),
+	)
+	now := time.Now()
+	cache := newReadCache(fake, time.Minute, kdexFunctionGVR)
+	cache.now = func() time.Time { return now }
+
+	gets := func() int {
+		n := 0
+		for _, a := range fake.Actions() {
+			if a.GetVerb() == "get" {
+				n++
+			}
+		}
+		return n
+	}
+
+	functions := cache.Resource(kdexFunctionGVR).Namespace("default")
+	for range 3 {
+		function, err := functions.Get(ctx, "fn", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// Callers own what they get
+		function.SetLabels(map[string]string{"changed": "true"})
+	}
+	if gets() != 1 {
+		t.Errorf("Expected one get, got %d", gets())
+	}
+	function, _ := functions.Get(ctx, "fn", metav1.GetOptions{})
+	if function.GetLabels()["changed"] != "" {
+		t.Error("Expected the cached object to be unaffected by callers")
+	}
+
+	if _, err := functions.Patch(ctx, "fn", types.MergePatchType, []byte(`{"metadata":{"labels":{"patched":"true"}}}`), metav1.PatchOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	function, _ = functions.Get(ctx, "fn", metav1.GetOptions{})
+	if function.GetLabels()["patched"] != "true" {
+		t.Error("Expected a get after a patch to see the patch")
+	}
+	if gets() != 2 {
+		t.Errorf("Expected the patch to drop the cached object, got %d gets", gets())
+	}
+
+	now = now.Add(2 * time.Minute)
+	_, _ = functions.Get(ctx, "fn", metav1.GetOptions{})
+	if gets() != 3 {
+		t.Errorf("Expected an expired entry to be fetched again, got %d gets", gets())
+	}
+
+	configMaps := cache.Resource(configMapGVR).Namespace("default")
+	_, _ = configMaps.Get(ctx, "cm", metav1.GetOptions{})
+	_, _ = configMaps.Get(ctx, "cm", metav1.GetOptions{})
+	if gets() != 5 {
+		t.Errorf("Expected resources outside the cache to be fetched every time, got %d gets", gets())
+	}
+}
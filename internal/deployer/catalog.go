@@ -0,0 +1,91 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Annotations on the KDexFunction that feed the catalog entry.
+const (
+	ownerAnnotation       = "kdex.dev/owner"
+	descriptionAnnotation = "kdex.dev/description"
+	sloTierAnnotation     = "kdex.dev/slo-tier"
+)
+
+// catalogEntry is the document emitted to the developer portal for every
+// deploy.
+type catalogEntry struct {
+	Name        string      `json:"name"`
+	Namespace   string      `json:"namespace"`
+	Owner       string      `json:"owner,omitempty"`
+	Description string      `json:"description,omitempty"`
+	URL         string      `json:"url"`
+	BasePath    string      `json:"basePath,omitempty"`
+	Auth        catalogAuth `json:"auth"`
+	SLOTier     string      `json:"sloTier,omitempty"`
+	Generation  string      `json:"generation,omitempty"`
+	Image       string      `json:"image"`
+	DeployedAt  time.Time   `json:"deployedAt"`
+}
+
+type catalogAuth struct {
+	Required bool   `json:"required"`
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+func buildCatalogEntry(cfg *EnvConfig, function *unstructured.Unstructured, url string, now time.Time) catalogEntry {
+	var annotations map[string]string
+	if function != nil {
+		annotations = function.GetAnnotations()
+	}
+
+	return catalogEntry{
+		Name:        cfg.FunctionName,
+		Namespace:   cfg.FunctionNamespace,
+		Owner:       annotations[ownerAnnotation],
+		Description: annotations[descriptionAnnotation],
+		URL:         url,
+		BasePath:    cfg.FunctionBasePath,
+		Auth: catalogAuth{
+			Required: cfg.Issuer != "",
+			Issuer:   cfg.Issuer,
+			Audience: cfg.Audience,
+		},
+		SLOTier:    annotations[sloTierAnnotation],
+		Generation: cfg.FunctionGeneration,
+		Image:      cfg.FunctionImage,
+		DeployedAt: now.UTC(),
+	}
+}
+
+// emitCatalogEntry sends the catalog entry to CATALOG_URL. The URL may use
+// the env templates (e.g. ${FUNCTION_NAMESPACE}/${FUNCTION_NAME}.json) so
+// object stores can be written with CATALOG_METHOD=PUT, while webhooks are
+// POSTed to by default.
+func emitCatalogEntry(ctx context.Context, cfg *EnvConfig, entry catalogEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	method := cfg.CatalogMethod
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	target := expandTemplate(cfg.CatalogURL, templateVars(cfg, entry.URL))
+	status, err := sendJSON(ctx, method, target, cfg.CatalogToken, body)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("catalog returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildCatalogEntry(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "myimg",
+		Issuer:            "https://issuer.example.com",
+	}
+	function := &unstructured.Unstructured{Object: map[string]any{}}
+	function.SetAnnotations(map[string]string{
+		ownerAnnotation:       "team-a",
+		descriptionAnnotation: "Does things",
+		sloTierAnnotation:     "gold",
+	})
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := buildCatalogEntry(cfg, function, "http://myfunc", now)
+	if entry.Owner != "team-a" || entry.Description != "Does things" || entry.SLOTier != "gold" {
+		t.Errorf("Expected annotations in entry: %+v", entry)
+	}
+	if !entry.Auth.Required || entry.DeployedAt != now {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+
+	entry = buildCatalogEntry(cfg, nil, "http://myfunc", now)
+	if entry.Owner != "" || entry.URL != "http://myfunc" {
+		t.Errorf("Unexpected entry without function: %+v", entry)
+	}
+}
+
+func TestEmitCatalogEntry(t *testing.T) {
+	var method, path string
+	var entry catalogEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+	}))
+	defer server.Close()
+
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		CatalogURL:        server.URL + "/catalog/${FUNCTION_NAMESPACE}/${FUNCTION_NAME}.json",
+		CatalogMethod:     http.MethodPut,
+	}
+
+	if err := emitCatalogEntry(context.Background(), cfg, buildCatalogEntry(cfg, nil, "http://myfunc", time.Now())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if method != http.MethodPut || path != "/catalog/myns/myfunc.json" {
+		t.Errorf("Unexpected request: %s %s", method, path)
+	}
+	if entry.Name != "myfunc" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+}
@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var certificateGVR = schema.GroupVersionResource{
+	Group:    "cert-manager.io",
+	Version:  "v1",
+	Resource: "certificates",
+}
+
+// Kinds of cert-manager issuer FUNCTION_HOST_TLS_ISSUER_KIND accepts.
+const (
+	issuerKindClusterIssuer = "ClusterIssuer"
+	issuerKindIssuer        = "Issuer"
+)
+
+func validateHostTLS(cfg *EnvConfig) error {
+	if cfg.FunctionHostTLSIssuer == "" {
+		if cfg.FunctionHostTLSIssuerKind != "" {
+			return fmt.Errorf("FUNCTION_HOST_TLS_ISSUER_KIND requires FUNCTION_HOST_TLS_ISSUER")
+		}
+		return nil
+	}
+	if cfg.FunctionHost == "" {
+		return fmt.Errorf("FUNCTION_HOST_TLS_ISSUER requires FUNCTION_HOST")
+	}
+	switch cfg.FunctionHostTLSIssuerKind {
+	case "", issuerKindClusterIssuer, issuerKindIssuer:
+		return nil
+	default:
+		return fmt.Errorf("invalid FUNCTION_HOST_TLS_ISSUER_KIND %q: expected %s or %s", cfg.FunctionHostTLSIssuerKind, issuerKindClusterIssuer, issuerKindIssuer)
+	}
+}
+
+// hostTLSSecretName is the Secret cert-manager stores the certificate for
+// FUNCTION_HOST in, and the DomainMapping serves it from.
+func hostTLSSecretName(cfg *EnvConfig) string {
+	return cfg.FunctionHost + "-tls"
+}
+
+// buildCertificate renders the cert-manager Certificate for FUNCTION_HOST,
+// owned by the Knative Service so it is removed along with it.
+func buildCertificate(cfg *EnvConfig, service *unstructured.Unstructured) *unstructured.Unstructured {
+	kind := cfg.FunctionHostTLSIssuerKind
+	if kind == "" {
+		kind = issuerKindClusterIssuer
+	}
+
+	certificate := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": certificateGVR.GroupVersion().String(),
+			"kind":       "Certificate",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionHost,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": map[string]any{
+				"secretName": hostTLSSecretName(cfg),
+				"dnsNames":   []any{cfg.FunctionHost},
+				"issuerRef": map[string]any{
+					"group": certificateGVR.Group,
+					"kind":  kind,
+					"name":  cfg.FunctionHostTLSIssuer,
+				},
+			},
+		},
+	}
+	certificate.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: service.GetAPIVersion(),
+			Kind:       service.GetKind(),
+			Name:       service.GetName(),
+			UID:        service.GetUID(),
+		},
+	})
+	return certificate
+}
+
+// provisionCertificate applies the Certificate for FUNCTION_HOST and waits
+// for cert-manager to issue it.
+func provisionCertificate(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, service *unstructured.Unstructured, timing waitTiming) error {
+	data, err := json.Marshal(buildCertificate(cfg, service))
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+
+	certificates := client.Resource(certificateGVR).Namespace(cfg.FunctionNamespace)
+	force := true
+	_, err = certificates.Patch(ctx, cfg.FunctionHost, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply certificate: %w", err)
+	}
+
+	logf("Waiting for certificate %s to be issued\n", cfg.FunctionHost)
+	return waitForCertificate(ctx, certificates, cfg.FunctionHost, timing)
+}
+
+// waitForCertificate polls the Certificate until it is ready for its
+// latest spec.
+func waitForCertificate(ctx context.Context, certificates dynamic.ResourceInterface, name string, timing waitTiming) error {
+	ctx, cancel := context.WithTimeout(ctx, timing.Timeout)
+	defer cancel()
+
+	reason := ""
+	for {
+		certificate, err := certificates.Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) && ctx.Err() == nil {
+			return fmt.Errorf("failed to get certificate %s: %w", name, err)
+		}
+		if err == nil {
+			var ready bool
+			ready, reason = certificateReady(certificate)
+			if ready {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if reason != "" {
+				return fmt.Errorf("timeout waiting for certificate %s: %s", name, reason)
+			}
+			return fmt.Errorf("timeout waiting for certificate %s", name)
+		case <-time.After(timing.PollInterval):
+		}
+	}
+}
+
+// certificateReady tells whether cert-manager issued the certificate for
+// its current spec, and if not, why.
+func certificateReady(certificate *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		// A Ready condition from before the last change of the spec
+		// describes the previous certificate
+		if generation, ok := cond["observedGeneration"].(int64); ok && generation < certificate.GetGeneration() {
+			return false, ""
+		}
+		message, _ := cond["message"].(string)
+		return cond["status"] == "True", message
+	}
+	return false, ""
+}
@@ -0,0 +1,82 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildCertificate(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:          "fn",
+		FunctionNamespace:     "default",
+		FunctionHost:          "fn.example.com",
+		FunctionHostTLSIssuer: "letsencrypt",
+	}
+	if err := validateHostTLS(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := newObject("serving.knative.dev/v1", "Service", "default", "fn", nil)
+
+	certificate := buildCertificate(cfg, service)
+	issuer, _, _ := unstructured.NestedStringMap(certificate.Object, "spec", "issuerRef")
+	if issuer["name"] != "letsencrypt" || issuer["kind"] != issuerKindClusterIssuer {
+		t.Errorf("Unexpected issuer: %v", issuer)
+	}
+	if secret, _, _ := unstructured.NestedString(certificate.Object, "spec", "secretName"); secret != "fn.example.com-tls" {
+		t.Errorf("Unexpected secret name %q", secret)
+	}
+
+	mapping := buildDomainMapping(cfg, service)
+	if secret, _, _ := unstructured.NestedString(mapping.Object, "spec", "tls", "secretName"); secret != "fn.example.com-tls" {
+		t.Errorf("Expected the domain mapping to serve the certificate, got %q", secret)
+	}
+}
+
+func TestValidateHostTLS(t *testing.T) {
+	if err := validateHostTLS(&EnvConfig{FunctionHostTLSIssuer: "letsencrypt"}); err == nil {
+		t.Error("Expected an error without FUNCTION_HOST")
+	}
+	if err := validateHostTLS(&EnvConfig{FunctionHost: "fn.example.com", FunctionHostTLSIssuer: "letsencrypt", FunctionHostTLSIssuerKind: "Vault"}); err == nil {
+		t.Error("Expected an error for an unknown issuer kind")
+	}
+	if err := validateHostTLS(&EnvConfig{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWaitForCertificate(t *testing.T) {
+	certificate := newObject("cert-manager.io/v1", "Certificate", "default", "fn.example.com", nil)
+	certificate.SetGeneration(2)
+	certificate.Object["status"] = map[string]any{
+		"conditions": []any{
+			map[string]any{"type": "Ready", "status": "False", "message": "Issuing certificate", "observedGeneration": int64(2)},
+		},
+	}
+	client := newFakeDynamicClient(certificate)
+	certificates := client.Resource(certificateGVR).Namespace("default")
+	timing := waitTiming{Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+
+	err := waitForCertificate(context.Background(), certificates, "fn.example.com", timing)
+	if err == nil || !strings.Contains(err.Error(), "Issuing certificate") {
+		t.Errorf("Expected a timeout naming the reason, got %v", err)
+	}
+
+	unstructured.SetNestedSlice(certificate.Object, []any{
+		map[string]any{"type": "Ready", "status": "True", "observedGeneration": int64(1)},
+	}, "status", "conditions")
+	if ready, _ := certificateReady(certificate); ready {
+		t.Error("Expected a Ready condition for an older generation to be ignored")
+	}
+
+	unstructured.SetNestedSlice(certificate.Object, []any{
+		map[string]any{"type": "Ready", "status": "True", "observedGeneration": int64(2)},
+	}, "status", "conditions")
+	client = newFakeDynamicClient(certificate)
+	if err := waitForCertificate(context.Background(), client.Resource(certificateGVR).Namespace("default"), "fn.example.com", timing); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Deploy phases recorded in the checkpoint.
+const (
+	// phaseApplied means the Service was applied and became ready.
+	phaseApplied = "Applied"
+	// phaseFinalized means every step after the apply completed.
+	phaseFinalized = "Finalized"
+)
+
+// Steps after the apply phase, recorded once done so a retried Job does not
+// repeat them.
+const (
+	stepPublicURL     = "public-url"
+	stepDomainMapping = "domain-mapping"
+	stepSchedule      = "schedule"
+	stepRegister      = "register"
+	stepAlerts        = "alerts"
+	stepSLO           = "slo"
+	stepDashboard     = "dashboard"
+	stepCatalog       = "catalog"
+	stepBuildMetadata = "build-metadata"
+	stepNotify        = "notify"
+)
+
+// checkpointKey is the ConfigMap key holding the checkpoint.
+const checkpointKey = "checkpoint"
+
+// deployCheckpoint is the progress of a deploy, persisted so a Job retried
+// after a failure resumes where the last attempt stopped.
+type deployCheckpoint struct {
+	DeployID   string `json:"deployId"`
+	Generation string `json:"generation"`
+	// Source is the image or git source the deploy started from.
+	Source string `json:"source"`
+	Phase  string `json:"phase,omitempty"`
+	// Image is the image applied, built from Source when deploying from
+	// source.
+	Image string `json:"image,omitempty"`
+	// ImageDigest is the digest Image was pinned to.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	Revision    string `json:"revision,omitempty"`
+	URL         string `json:"url,omitempty"`
+	// CustomURL is the URL of the DomainMapping for FUNCTION_HOST.
+	CustomURL string    `json:"customUrl,omitempty"`
+	Completed []string  `json:"completed,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// checkpointConfigMapName is the ConfigMap holding the deploy checkpoint.
+func checkpointConfigMapName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-deploy-progress"
+}
+
+func checkpointSource(cfg *EnvConfig) string {
+	if cfg.FunctionImage != "" {
+		return cfg.FunctionImage
+	}
+	return cfg.FunctionSourceGit + "@" + cfg.FunctionSourceRevision
+}
+
+// resumeCheckpoint returns the checkpoint of an unfinished attempt at the
+// same deploy: the same generation and source, and the same DEPLOY_ID when
+// one is given. Anything else starts a new checkpoint. A missing or
+// unreadable checkpoint is not an error; the deploy just starts over.
+func resumeCheckpoint(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) *deployCheckpoint {
+	fresh := &deployCheckpoint{
+		DeployID:   cfg.DeployID,
+		Generation: cfg.FunctionGeneration,
+		Source:     checkpointSource(cfg),
+	}
+
+	configMap, err := client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace).Get(ctx, checkpointConfigMapName(cfg), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			warnf("failed to read deploy checkpoint: %v\n", err)
+		}
+		return fresh
+	}
+	data, _, _ := unstructured.NestedString(configMap.Object, "data", checkpointKey)
+	previous := &deployCheckpoint{}
+	if err := json.Unmarshal([]byte(data), previous); err != nil {
+		warnf("ignoring unreadable deploy checkpoint: %v\n", err)
+		return fresh
+	}
+
+	if previous.Phase == phaseFinalized || cfg.FunctionGeneration == "" ||
+		previous.Generation != fresh.Generation || previous.Source != fresh.Source ||
+		(cfg.DeployID != "" && previous.DeployID != cfg.DeployID) {
+		return fresh
+	}
+	return previous
+}
+
+// resumable tells whether the apply phase can be skipped: it completed and
+// the Service still serves the revision it produced.
+func (c *deployCheckpoint) resumable(service *unstructured.Unstructured) bool {
+	if c.Phase != phaseApplied || service == nil {
+		return false
+	}
+	ready, _, _ := parseKnativeStatus(service)
+	return ready && serviceStateOf(service).LatestReadyRevision == c.Revision
+}
+
+func (c *deployCheckpoint) done(step string) bool {
+	return slices.Contains(c.Completed, step)
+}
+
+// complete records step as done.
+func (c *deployCheckpoint) complete(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, step string) {
+	if !c.done(step) {
+		c.Completed = append(c.Completed, step)
+	}
+	c.save(ctx, client, cfg)
+}
+
+// save persists the checkpoint. Failing to is only logged, as it merely
+// costs a retried Job the chance to resume.
+func (c *deployCheckpoint) save(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) {
+	c.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(c)
+	if err != nil {
+		warnf("failed to save deploy checkpoint: %v\n", err)
+		return
+	}
+
+	configMap := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":      checkpointConfigMapName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"data": map[string]any{
+				checkpointKey: string(data),
+			},
+		},
+	}
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		warnf("failed to save deploy checkpoint: %v\n", err)
+		return
+	}
+
+	force := true
+	_, err = client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, configMap.GetName(), types.ApplyPatchType, body, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		warnf("failed to save deploy checkpoint: %v\n", err)
+	}
+}
+
+// String describes the checkpoint for the log.
+func (c *deployCheckpoint) String() string {
+	return fmt.Sprintf("deploy %s at phase %s, revision %s", c.DeployID, c.Phase, c.Revision)
+}
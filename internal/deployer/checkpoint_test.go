@@ -0,0 +1,89 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newCheckpointConfigMap(t *testing.T, checkpoint deployCheckpoint) *unstructured.Unstructured {
+	t.Helper()
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap := newObject("v1", "ConfigMap", "myns", "myfunc-deploy-progress", nil)
+	configMap.Object["data"] = map[string]any{checkpointKey: string(data)}
+	return configMap
+}
+
+func TestResumeCheckpoint(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionGeneration: "3",
+		FunctionImage:      "myimg:3",
+	}
+	applied := deployCheckpoint{
+		DeployID:   "rollout-42",
+		Generation: "3",
+		Source:     "myimg:3",
+		Phase:      phaseApplied,
+		Image:      "myimg:3",
+		Revision:   "myfunc-00003",
+		Completed:  []string{stepRegister},
+	}
+
+	client := newFakeDynamicClient(newCheckpointConfigMap(t, applied))
+	checkpoint := resumeCheckpoint(context.Background(), client, cfg)
+	if checkpoint.DeployID != "rollout-42" || !checkpoint.done(stepRegister) || checkpoint.done(stepNotify) {
+		t.Errorf("Expected the unfinished attempt to be resumed, got %+v", checkpoint)
+	}
+
+	for name, c := range map[string]*EnvConfig{
+		"new generation": {FunctionName: "myfunc", FunctionNamespace: "myns", FunctionGeneration: "4", FunctionImage: "myimg:3"},
+		"new image":      {FunctionName: "myfunc", FunctionNamespace: "myns", FunctionGeneration: "3", FunctionImage: "myimg:4"},
+		"other deploy":   {FunctionName: "myfunc", FunctionNamespace: "myns", FunctionGeneration: "3", FunctionImage: "myimg:3", DeployID: "rollout-43"},
+	} {
+		if checkpoint := resumeCheckpoint(context.Background(), client, c); checkpoint.Phase != "" {
+			t.Errorf("%s: expected a fresh checkpoint, got %+v", name, checkpoint)
+		}
+	}
+
+	finalized := applied
+	finalized.Phase = phaseFinalized
+	client = newFakeDynamicClient(newCheckpointConfigMap(t, finalized))
+	if checkpoint := resumeCheckpoint(context.Background(), client, cfg); checkpoint.Phase != "" {
+		t.Errorf("Expected a finished deploy to start over, got %+v", checkpoint)
+	}
+
+	if checkpoint := resumeCheckpoint(context.Background(), newFakeDynamicClient(), cfg); checkpoint.Phase != "" || checkpoint.Source != "myimg:3" {
+		t.Errorf("Expected a fresh checkpoint without one stored, got %+v", checkpoint)
+	}
+}
+
+func TestCheckpointResumable(t *testing.T) {
+	checkpoint := &deployCheckpoint{Phase: phaseApplied, Revision: "myfunc-00003"}
+
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.Object["status"] = map[string]any{
+		"latestReadyRevisionName": "myfunc-00003",
+		"conditions":              []any{map[string]any{"type": "Ready", "status": "True"}},
+	}
+	if !checkpoint.resumable(service) {
+		t.Error("Expected a ready service on the recorded revision to be resumable")
+	}
+
+	_ = unstructured.SetNestedField(service.Object, "myfunc-00004", "status", "latestReadyRevisionName")
+	if checkpoint.resumable(service) {
+		t.Error("Expected a service on another revision not to be resumable")
+	}
+	if checkpoint.resumable(nil) {
+		t.Error("Expected a missing service not to be resumable")
+	}
+	if (&deployCheckpoint{}).resumable(service) {
+		t.Error("Expected a checkpoint before the apply phase not to be resumable")
+	}
+}
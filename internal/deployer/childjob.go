@@ -0,0 +1,209 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+var jobGVR = schema.GroupVersionResource{
+	Group:    "batch",
+	Version:  "v1",
+	Resource: "jobs",
+}
+
+// deployJobSelector selects the deploy Jobs of a function, and only those:
+// other Jobs, such as those of scale profile CronJobs, carry the function
+// label too.
+const deployJobSelector = "kdex.dev/job=deploy,kdex.dev/function="
+
+// defaultJobHistoryLimit is how many finished deploy Jobs of a function the
+// reconciler keeps without JOB_HISTORY_LIMIT.
+const defaultJobHistoryLimit = 3
+
+// isolatedNamespace tells whether the reconciler deploys the functions of
+// namespace in a Job of their own rather than in its process, as
+// ISOLATED_NAMESPACES, a comma separated list of namespaces or *, asks.
+func isolatedNamespace(cfg *EnvConfig, namespace string) bool {
+	for _, n := range strings.Split(cfg.IsolatedNamespaces, ",") {
+		if n = strings.TrimSpace(n); n == "*" || n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJobHistoryLimit reads JOB_HISTORY_LIMIT, the number of finished
+// deploy Jobs of a function kept for their logs.
+func parseJobHistoryLimit(cfg *EnvConfig) (int, error) {
+	if cfg.JobHistoryLimit == "" {
+		return defaultJobHistoryLimit, nil
+	}
+	limit, err := strconv.Atoi(cfg.JobHistoryLimit)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid JOB_HISTORY_LIMIT %q: expected a non-negative integer", cfg.JobHistoryLimit)
+	}
+	return limit, nil
+}
+
+// validateChildJobs checks the settings of the deploy Jobs the controller
+// launches for ISOLATED_NAMESPACES.
+func validateChildJobs(cfg *EnvConfig) error {
+	if _, err := parseJobHistoryLimit(cfg); err != nil {
+		return err
+	}
+	if cfg.IsolatedNamespaces != "" && cfg.JobImage == "" {
+		return fmt.Errorf("JOB_IMAGE is required with ISOLATED_NAMESPACES")
+	}
+	return nil
+}
+
+// runChildJob launches the Job deploying the generation of the function,
+// or reads how the one launched before went. The Job reads the function
+// itself, as a Job started with --from-kdexfunction does, and is owned by
+// it so it goes away with the function. It tells whether the Job finished
+// and, when it failed, why.
+func runChildJob(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, function *unstructured.Unstructured) (bool, string, error) {
+	manifest, err := buildJobManifest(cfg, []string{"--from-kdexfunction", function.GetNamespace() + "/" + function.GetName()})
+	if err != nil {
+		return false, "", err
+	}
+	job := &unstructured.Unstructured{Object: manifest}
+	controller := true
+	job.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: function.GetAPIVersion(),
+			Kind:       function.GetKind(),
+			Name:       function.GetName(),
+			UID:        function.GetUID(),
+			Controller: &controller,
+		},
+	})
+
+	jobs := client.Resource(jobGVR).Namespace(cfg.FunctionNamespace)
+	live, err := jobs.Get(ctx, job.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err := jobs.Create(ctx, job, metav1.CreateOptions{FieldManager: "kdex-knative-controller"})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return false, "", fmt.Errorf("failed to create deploy job: %w", err)
+		}
+		logf("Launched deploy Job %s\n", job.GetName())
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get deploy job: %w", err)
+	}
+
+	switch {
+	case jobCondition(live, "Complete") != nil:
+		return true, "", nil
+	case jobCondition(live, "Failed") != nil:
+		return true, childJobFailure(ctx, client, live), nil
+	}
+	return false, "", nil
+}
+
+// childJobFailed tells whether the deploy Job of the current generation of
+// the function already failed for good, as its Deployed condition records.
+// The Job itself may be gone by then, deleted after JOB_TTL.
+func childJobFailed(function *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(function.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != conditionDeployed {
+			continue
+		}
+		generation, _, _ := unstructured.NestedInt64(cond, "observedGeneration")
+		return cond["reason"] == reasonDeployJobFailed && generation == function.GetGeneration()
+	}
+	return false
+}
+
+// jobCondition returns the condition of type conditionType of the Job when
+// it is True.
+func jobCondition(job *unstructured.Unstructured, conditionType string) map[string]any {
+	conditions, _, _ := unstructured.NestedSlice(job.Object, "status", "conditions")
+	for _, c := range conditions {
+		if cond, ok := c.(map[string]any); ok && cond["type"] == conditionType && cond["status"] == "True" {
+			return cond
+		}
+	}
+	return nil
+}
+
+// childJobFailure is why the deploy Job failed: the error of the
+// termination message of its last pod, or the Job's own reason when no
+// pod left one, as when the Job timed out.
+func childJobFailure(ctx context.Context, client dynamic.Interface, job *unstructured.Unstructured) string {
+	failure := fmt.Sprintf("deploy job %s failed", job.GetName())
+	if cond := jobCondition(job, "Failed"); cond != nil {
+		failure = fmt.Sprintf("deploy job %s failed: %v: %v", job.GetName(), cond["reason"], cond["message"])
+	}
+
+	pods, err := client.Resource(podGVR).Namespace(job.GetNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + job.GetName(),
+	})
+	if err != nil {
+		warnf("failed to list the pods of deploy job %s: %v\n", job.GetName(), err)
+		return failure
+	}
+	items := pods.Items
+	slices.SortFunc(items, func(a, b unstructured.Unstructured) int {
+		return a.GetCreationTimestamp().Compare(b.GetCreationTimestamp().Time)
+	})
+	for i := len(items) - 1; i >= 0; i-- {
+		statuses, _, _ := unstructured.NestedSlice(items[i].Object, "status", "containerStatuses")
+		for _, s := range statuses {
+			status, _ := s.(map[string]any)
+			message, _, _ := unstructured.NestedString(status, "state", "terminated", "message")
+			var msg report.TerminationMessage
+			if message == "" || json.Unmarshal([]byte(message), &msg) != nil || msg.Error == "" {
+				continue
+			}
+			if msg.FailedPhase != "" {
+				return fmt.Sprintf("%s phase: %s", msg.FailedPhase, msg.Error)
+			}
+			return msg.Error
+		}
+	}
+	return failure
+}
+
+// pruneChildJobs deletes the finished deploy Jobs of the function but the
+// limit most recent, with their pods. Running Jobs are left alone.
+func pruneChildJobs(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, limit int) error {
+	jobs := client.Resource(jobGVR).Namespace(cfg.FunctionNamespace)
+	list, err := jobs.List(ctx, metav1.ListOptions{LabelSelector: deployJobSelector + cfg.FunctionName})
+	if err != nil {
+		return fmt.Errorf("failed to list deploy jobs: %w", err)
+	}
+	finished := slices.DeleteFunc(list.Items, func(job unstructured.Unstructured) bool {
+		return jobCondition(&job, "Complete") == nil && jobCondition(&job, "Failed") == nil
+	})
+	if len(finished) <= limit {
+		return nil
+	}
+	slices.SortFunc(finished, func(a, b unstructured.Unstructured) int {
+		return b.GetCreationTimestamp().Compare(a.GetCreationTimestamp().Time)
+	})
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range finished[limit:] {
+		err := jobs.Delete(ctx, job.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete deploy job %s: %w", job.GetName(), err)
+		}
+		logf("Deleted deploy Job %s\n", job.GetName())
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newFinishedJob(name, conditionType string, created time.Time) *unstructured.Unstructured {
+	job := newObject("batch/v1", "Job", "myns", name, map[string]string{"kdex.dev/function": "myfunc", "kdex.dev/job": "deploy"})
+	job.SetCreationTimestamp(metav1.NewTime(created))
+	_ = unstructured.SetNestedSlice(job.Object, []any{
+		map[string]any{"type": conditionType, "status": "True", "reason": "BackoffLimitExceeded", "message": "Job has reached the specified backoff limit"},
+	}, "status", "conditions")
+	return job
+}
+
+func TestIsolatedNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		isolated string
+		want     bool
+	}{
+		{"", false},
+		{"other", false},
+		{"other, myns", true},
+		{"*", true},
+	} {
+		if got := isolatedNamespace(&EnvConfig{IsolatedNamespaces: tt.isolated}, "myns"); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.isolated, tt.want, got)
+		}
+	}
+}
+
+func TestValidateChildJobs(t *testing.T) {
+	if err := validateChildJobs(&EnvConfig{IsolatedNamespaces: "myns", JobImage: "deployer:1", JobHistoryLimit: "1"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateChildJobs(&EnvConfig{IsolatedNamespaces: "myns"}); err == nil {
+		t.Error("Expected JOB_IMAGE to be required")
+	}
+	if err := validateChildJobs(&EnvConfig{JobHistoryLimit: "-1"}); err == nil {
+		t.Error("Expected an error for a negative JOB_HISTORY_LIMIT")
+	}
+}
+
+func TestReconcileIsolatedFunction(t *testing.T) {
+	ctx := context.Background()
+	function := newKDexFunction(4, map[string]any{"image": "myimg"})
+	function.SetFinalizers([]string{functionFinalizer})
+	function.SetUID("function-uid")
+	client := newFakeDynamicClient(function)
+	base := &EnvConfig{IsolatedNamespaces: "myns", JobImage: "deployer:1", JobTTL: "1h", JobBackoffLimit: "2", JobHistoryLimit: "1"}
+	r := newFunctionReconciler(client, base, time.Minute)
+
+	// The deploy runs in a Job rather than in the reconciler
+	if result := reconcileFunction(t, r); result.RequeueAfter != 0 {
+		t.Errorf("Expected to wait for the job, got %+v", result)
+	}
+	jobs := client.Resource(jobGVR).Namespace("myns")
+	job, err := jobs.Get(ctx, "myfunc-deploy-4", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the deploy job to be launched: %v", err)
+	}
+	if owners := job.GetOwnerReferences(); len(owners) != 1 || owners[0].UID != "function-uid" || owners[0].Controller == nil || !*owners[0].Controller {
+		t.Errorf("Expected the job to be controlled by the kdex function, got %v", owners)
+	}
+	if ttl, _, _ := unstructured.NestedInt64(job.Object, "spec", "ttlSecondsAfterFinished"); ttl != 3600 {
+		t.Errorf("Expected the job to be kept an hour, got %d", ttl)
+	}
+	if limit, _, _ := unstructured.NestedInt64(job.Object, "spec", "backoffLimit"); limit != 2 {
+		t.Errorf("Expected a back-off limit of 2, got %d", limit)
+	}
+	containers, _, _ := unstructured.NestedSlice(job.Object, "spec", "template", "spec", "containers")
+	args := fmt.Sprint(containers[0].(map[string]any)["args"])
+	if args != "[deploy --from-kdexfunction myns/myfunc]" {
+		t.Errorf("Expected the job to deploy from the kdex function, got %s", args)
+	}
+	if _, err := client.Resource(knativeServiceGVR).Namespace("myns").Get(ctx, "myfunc", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the reconciler not to deploy the function itself")
+	}
+
+	// An earlier Job is pruned once this one failed, and the failure its pod
+	// reported is recorded on the function
+	old := newFinishedJob("myfunc-deploy-3", "Complete", time.Now().Add(-time.Hour))
+	if _, err := jobs.Create(ctx, old, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	failed := newFinishedJob("myfunc-deploy-4", "Failed", time.Now())
+	failed.SetOwnerReferences(job.GetOwnerReferences())
+	if _, err := jobs.Update(ctx, failed, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pod := newObject("v1", "Pod", "myns", "myfunc-deploy-4-abcde", map[string]string{"job-name": "myfunc-deploy-4"})
+	_ = unstructured.SetNestedSlice(pod.Object, []any{
+		map[string]any{"state": map[string]any{"terminated": map[string]any{
+			"message": `{"version":1,"url":"","outcome":"Failed","failedPhase":"AwaitRevision","error":"revision myfunc-00004: ImagePullBackOff"}`,
+		}}},
+	}, "status", "containerStatuses")
+	if _, err := client.Resource(podGVR).Namespace("myns").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileFunction(t, r)
+	got, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(ctx, "myfunc", metav1.GetOptions{})
+	conditions, _, _ := unstructured.NestedSlice(got.Object, "status", "conditions")
+	if len(conditions) != 1 {
+		t.Fatalf("Expected a Deployed condition, got %v", conditions)
+	}
+	condition := conditions[0].(map[string]any)
+	if condition["status"] != "False" || condition["reason"] != reasonDeployJobFailed ||
+		!strings.Contains(fmt.Sprint(condition["message"]), "AwaitRevision phase: revision myfunc-00004: ImagePullBackOff") {
+		t.Errorf("Expected the failure of the job to be surfaced, got %v", condition)
+	}
+	if _, err := jobs.Get(ctx, "myfunc-deploy-3", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the earlier job to be pruned past JOB_HISTORY_LIMIT")
+	}
+	if _, err := jobs.Get(ctx, "myfunc-deploy-4", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the latest job to be kept: %v", err)
+	}
+
+	// Once JOB_TTL deleted the failed Job, the generation is not deployed
+	// again
+	if err := jobs.Delete(ctx, "myfunc-deploy-4", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	reconcileFunction(t, r)
+	if _, err := jobs.Get(ctx, "myfunc-deploy-4", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the failed generation not to be launched again")
+	}
+}
+
+func TestPruneChildJobsKeepsRunningJobs(t *testing.T) {
+	ctx := context.Background()
+	running := newObject("batch/v1", "Job", "myns", "myfunc-deploy-9", map[string]string{"kdex.dev/function": "myfunc", "kdex.dev/job": "deploy"})
+	// A Job of a CronJob of the function rather than a deploy
+	other := newObject("batch/v1", "Job", "myns", "myfunc-scale-peak-1", map[string]string{"kdex.dev/function": "myfunc"})
+	_ = unstructured.SetNestedSlice(other.Object, []any{map[string]any{"type": "Complete", "status": "True"}}, "status", "conditions")
+	client := newFakeDynamicClient(
+		running,
+		other,
+		newFinishedJob("myfunc-deploy-7", "Complete", time.Now().Add(-2*time.Hour)),
+		newFinishedJob("myfunc-deploy-8", "Failed", time.Now().Add(-time.Hour)),
+	)
+	if err := pruneChildJobs(ctx, client, &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list, _ := client.Resource(jobGVR).Namespace("myns").List(ctx, metav1.ListOptions{})
+	var names []string
+	for _, job := range list.Items {
+		names = append(names, job.GetName())
+	}
+	if strings.Join(names, ",") != "myfunc-deploy-8,myfunc-deploy-9,myfunc-scale-peak-1" {
+		t.Errorf("Expected the oldest finished deploy job to go, got %v", names)
+	}
+}
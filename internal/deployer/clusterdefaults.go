@@ -0,0 +1,119 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+const knativeServingNamespace = "knative-serving"
+
+// Knative's defaults for the cluster settings checked, used when the
+// ConfigMaps leave them out.
+const (
+	defaultMaxRevisionTimeoutSeconds    = 600
+	defaultContainerConcurrencyMaxLimit = 1000
+)
+
+// warnClusterDefaults logs the settings of the function that conflict with
+// the caps of config-autoscaler and config-defaults in knative-serving,
+// and why Knative will reject or ignore them. Reading those takes RBAC the
+// deployer may not have, in which case nothing is checked.
+func warnClusterDefaults(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) {
+	autoscaler, ok := knativeConfig(ctx, client, "config-autoscaler")
+	if !ok {
+		return
+	}
+	defaults, ok := knativeConfig(ctx, client, "config-defaults")
+	if !ok {
+		return
+	}
+	for _, warning := range clusterDefaultConflicts(cfg, autoscaler, defaults) {
+		warnf("%s\n", warning)
+	}
+}
+
+// knativeConfig reads the data of a knative-serving ConfigMap. It reports
+// false when the ConfigMap cannot be read.
+func knativeConfig(ctx context.Context, client dynamic.Interface, name string) (map[string]string, bool) {
+	cm, err := client.Resource(configMapGVR).Namespace(knativeServingNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Knative runs on its built-in defaults
+			return map[string]string{}, true
+		}
+		logf("Cannot read %s/%s, not checking against the cluster defaults: %v\n", knativeServingNamespace, name, err)
+		return nil, false
+	}
+	data, _, _ := unstructured.NestedStringMap(cm.Object, "data")
+	if data == nil {
+		data = map[string]string{}
+	}
+	return data, true
+}
+
+// clusterDefaultConflicts explains each setting of the function that the
+// cluster-level Knative configuration overrides or rejects.
+func clusterDefaultConflicts(cfg *EnvConfig, autoscaler, defaults map[string]string) []string {
+	warnings := []string{}
+	annotations, err := buildScalingAnnotations(cfg)
+	if err != nil {
+		// Validate reports it
+		return warnings
+	}
+
+	if limit := configInt(autoscaler, "max-scale-limit", 0); limit > 0 {
+		maxScale := configInt(annotations, "autoscaling.knative.dev/max-scale", configInt(autoscaler, "max-scale", 0))
+		switch {
+		case maxScale == 0:
+			warnings = append(warnings, fmt.Sprintf("max-scale is unlimited but config-autoscaler caps it with max-scale-limit %d; Knative will reject the revision unless SCALING_MAX_SCALE is set at most to it", limit))
+		case maxScale > limit:
+			warnings = append(warnings, fmt.Sprintf("max-scale %d is above max-scale-limit %d of config-autoscaler; Knative will reject the revision", maxScale, limit))
+		}
+	}
+
+	if initial, ok := annotations["autoscaling.knative.dev/initial-scale"]; ok && initial == "0" && autoscaler["allow-zero-initial-scale"] != "true" {
+		warnings = append(warnings, "initial-scale 0 needs allow-zero-initial-scale in config-autoscaler; Knative will reject the revision")
+	}
+
+	if autoscaler["enable-scale-to-zero"] == "false" {
+		if annotations["autoscaling.knative.dev/min-scale"] == "0" {
+			warnings = append(warnings, "min-scale 0 is ignored: config-autoscaler disables scale to zero, so at least one pod keeps running")
+		}
+		if _, ok := annotations["autoscaling.knative.dev/scale-to-zero-pod-retention-period"]; ok {
+			warnings = append(warnings, "scale-to-zero-pod-retention-period is ignored: config-autoscaler disables scale to zero")
+		}
+	}
+
+	if timeout, err := requestTimeout(cfg); err == nil && timeout > 0 {
+		limit := configInt(defaults, "max-revision-timeout-seconds", defaultMaxRevisionTimeoutSeconds)
+		if seconds := int64(timeout / time.Second); seconds > limit {
+			warnings = append(warnings, fmt.Sprintf("REQUEST_TIMEOUT of %ds is above max-revision-timeout-seconds %d of config-defaults; Knative will reject the revision", seconds, limit))
+		}
+	}
+
+	if concurrency, err := containerConcurrency(cfg); err == nil && concurrency > 0 {
+		limit := configInt(defaults, "container-concurrency-max-limit", defaultContainerConcurrencyMaxLimit)
+		if concurrency > limit {
+			warnings = append(warnings, fmt.Sprintf("CONTAINER_CONCURRENCY %d is above container-concurrency-max-limit %d of config-defaults; Knative will reject the revision", concurrency, limit))
+		}
+	}
+
+	return warnings
+}
+
+// configInt reads an integer setting, falling back to def when it is
+// missing or not an integer.
+func configInt(config map[string]string, key string, def int64) int64 {
+	n, err := strconv.ParseInt(config[key], 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestClusterDefaultConflicts(t *testing.T) {
+	cfg := &EnvConfig{
+		ScalingMaxScale:                      "20",
+		ScalingMinScale:                      "0",
+		ScalingInitialScale:                  "0",
+		ScalingScaleToZeroPodRetentionPeriod: "1m",
+		RequestTimeout:                       "15m",
+		ContainerConcurrency:                 "50",
+	}
+	autoscaler := map[string]string{"max-scale-limit": "10", "enable-scale-to-zero": "false"}
+	defaults := map[string]string{"container-concurrency-max-limit": "25"}
+
+	warnings := clusterDefaultConflicts(cfg, autoscaler, defaults)
+	for _, want := range []string{"max-scale 20", "initial-scale 0", "min-scale 0", "scale-to-zero-pod-retention-period", "REQUEST_TIMEOUT of 900s", "CONTAINER_CONCURRENCY 50"} {
+		found := false
+		for _, w := range warnings {
+			found = found || strings.Contains(w, want)
+		}
+		if !found {
+			t.Errorf("Expected a warning about %q, got %v", want, warnings)
+		}
+	}
+
+	if warnings := clusterDefaultConflicts(&EnvConfig{}, map[string]string{"max-scale-limit": "10", "max-scale": "5"}, nil); len(warnings) != 0 {
+		t.Errorf("Expected the cluster default max-scale to satisfy the limit, got %v", warnings)
+	}
+	if warnings := clusterDefaultConflicts(&EnvConfig{}, map[string]string{"max-scale-limit": "10"}, nil); len(warnings) != 1 {
+		t.Errorf("Expected unlimited max-scale to conflict with the limit, got %v", warnings)
+	}
+}
+
+func TestKnativeConfig(t *testing.T) {
+	cm := newObject("v1", "ConfigMap", knativeServingNamespace, "config-autoscaler", nil)
+	cm.Object["data"] = map[string]any{"max-scale-limit": "10"}
+	client := newFakeDynamicClient(cm)
+
+	data, ok := knativeConfig(context.Background(), client, "config-autoscaler")
+	if !ok || data["max-scale-limit"] != "10" {
+		t.Errorf("Unexpected config: %v %v", data, ok)
+	}
+	if data, ok := knativeConfig(context.Background(), client, "config-defaults"); !ok || len(data) != 0 {
+		t.Errorf("Expected a missing ConfigMap to mean Knative's defaults, got %v %v", data, ok)
+	}
+}
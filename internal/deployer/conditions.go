@@ -0,0 +1,179 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// The conditions the KDexFunction mirrors from its Knative Service, for
+// kubectl wait and the UI.
+const (
+	conditionReady              = "Ready"
+	conditionConfigurationReady = "ConfigurationReady"
+	conditionRoutesReady        = "RoutesReady"
+)
+
+var serviceConditionTypes = []string{conditionReady, conditionConfigurationReady, conditionRoutesReady}
+
+// serviceConditions renders the Service's Ready, ConfigurationReady and
+// RoutesReady conditions as metav1.Conditions of the function generation.
+// Knative leaves the reason empty when all is well, which the API
+// conventions do not allow.
+func serviceConditions(ksObj *unstructured.Unstructured, generation int64) []map[string]any {
+	conditions := []map[string]any{}
+	for _, conditionType := range serviceConditionTypes {
+		status, message, found := findCondition(ksObj, conditionType)
+		if !found || status == "" {
+			status = string(metav1.ConditionUnknown)
+		}
+		reason := serviceConditionReason(ksObj, conditionType)
+		if reason == "" {
+			switch metav1.ConditionStatus(status) {
+			case metav1.ConditionTrue:
+				reason = conditionType
+			case metav1.ConditionFalse:
+				reason = "Not" + conditionType
+			default:
+				reason = "InProgress"
+			}
+		}
+		conditions = append(conditions, map[string]any{
+			"type":               conditionType,
+			"status":             status,
+			"reason":             reason,
+			"message":            message,
+			"observedGeneration": generation,
+			"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	return conditions
+}
+
+// serviceConditionReason is the reason of the Service's condition.
+func serviceConditionReason(ksObj *unstructured.Unstructured, conditionType string) string {
+	conditions, _, _ := unstructured.NestedSlice(ksObj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if ok && cond["type"] == conditionType {
+			reason, _ := cond["reason"].(string)
+			return reason
+		}
+	}
+	return ""
+}
+
+// reportedGeneration is the KDexFunction generation the Service status
+// reports on: the kdex.dev/generation label the deploy put on the Service,
+// once Knative observed the spec carrying it. Until then the status still
+// reports on the generation recorded before. A Service deployed without a
+// generation is taken to report on the latest.
+func reportedGeneration(ksObj, kfObj *unstructured.Unstructured) int64 {
+	generation, err := strconv.ParseInt(ksObj.GetLabels()["kdex.dev/generation"], 10, 64)
+	if err != nil {
+		return kfObj.GetGeneration()
+	}
+	if observed, _, _ := unstructured.NestedInt64(ksObj.Object, "status", "observedGeneration"); observed < ksObj.GetGeneration() {
+		recorded, _, _ := unstructured.NestedInt64(kfObj.Object, "status", "observedGeneration")
+		return recorded
+	}
+	return generation
+}
+
+// setCondition replaces the condition of the same type, keeping its
+// lastTransitionTime when the status did not change. Conditions of other
+// types are kept.
+func setCondition(conditions []any, condition map[string]any) []any {
+	updated := []any{}
+	for _, c := range conditions {
+		existing, ok := c.(map[string]any)
+		if !ok || existing["type"] != condition["type"] {
+			updated = append(updated, c)
+			continue
+		}
+		if existing["status"] == condition["status"] && existing["lastTransitionTime"] != nil {
+			condition["lastTransitionTime"] = existing["lastTransitionTime"]
+		}
+	}
+	return append(updated, condition)
+}
+
+// conditionsChanged tells whether any observed condition differs from the
+// status in anything but its lastTransitionTime.
+func conditionsChanged(current []any, observed []map[string]any) bool {
+	for _, condition := range observed {
+		var existing map[string]any
+		for _, c := range current {
+			if cond, ok := c.(map[string]any); ok && cond["type"] == condition["type"] {
+				existing = cond
+			}
+		}
+		if existing == nil {
+			return true
+		}
+		for _, key := range []string{"status", "reason", "message"} {
+			if existing[key] != condition[key] {
+				return true
+			}
+		}
+		if generation, _, _ := unstructured.NestedInt64(existing, "observedGeneration"); generation != condition["observedGeneration"] {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeServiceConditions sets the Service conditions on those of the
+// function status.
+func mergeServiceConditions(current []any, observed []map[string]any) []any {
+	conditions := append([]any{}, current...)
+	for _, condition := range observed {
+		conditions = setCondition(conditions, condition)
+	}
+	return conditions
+}
+
+// recordServiceConditions writes the Service conditions and the generation
+// they report on to the KDexFunction status once a deploy has rolled out,
+// without waiting for the observer.
+func recordServiceConditions(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, ksObj *unstructured.Unstructured) error {
+	functions := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace)
+	function, err := functions.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get kdex function: %w", err)
+	}
+	current, _, _ := unstructured.NestedSlice(function.Object, "status", "conditions")
+	generation := reportedGeneration(ksObj, function)
+	recorded, _, _ := unstructured.NestedInt64(function.Object, "status", "observedGeneration")
+	observed := serviceConditions(ksObj, generation)
+	if !conditionsChanged(current, observed) && generation == recorded {
+		return nil
+	}
+
+	patchBytes, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"resourceVersion": function.GetResourceVersion()},
+		"status": map[string]any{
+			"conditions":         mergeServiceConditions(current, observed),
+			"observedGeneration": generation,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, span := startSpan(ctx, "status.patch", cfg)
+	_, err = functions.Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to patch kdex function status: %w", err)
+	}
+	return nil
+}
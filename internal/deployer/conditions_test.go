@@ -0,0 +1,107 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestServiceConditions(t *testing.T) {
+	ksObj := &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"conditions": []any{
+				map[string]any{"type": "Ready", "status": "False", "reason": "RevisionFailed", "message": "image pull failed"},
+				map[string]any{"type": "ConfigurationReady", "status": "True"},
+			},
+		},
+	}}
+
+	conditions := serviceConditions(ksObj, 3)
+	if len(conditions) != 3 {
+		t.Fatalf("Expected three conditions, got %v", conditions)
+	}
+	want := map[string][2]string{
+		conditionReady:              {"False", "RevisionFailed"},
+		conditionConfigurationReady: {"True", "ConfigurationReady"},
+		conditionRoutesReady:        {"Unknown", "InProgress"},
+	}
+	for _, c := range conditions {
+		w := want[c["type"].(string)]
+		if c["status"] != w[0] || c["reason"] != w[1] || c["observedGeneration"] != int64(3) {
+			t.Errorf("Unexpected condition %v", c)
+		}
+	}
+}
+
+func TestReportedGeneration(t *testing.T) {
+	function := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{"generation": int64(5)},
+		"status":   map[string]any{"observedGeneration": int64(3)},
+	}}
+	service := func(label string, generation, observed int64) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"metadata": map[string]any{"generation": generation, "labels": map[string]any{"kdex.dev/generation": label}},
+			"status":   map[string]any{"observedGeneration": observed},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		service *unstructured.Unstructured
+		want    int64
+	}{
+		{"reconciled", service("4", 2, 2), 4},
+		{"not reconciled", service("4", 2, 1), 3},
+		{"no generation label", service("", 2, 2), 5},
+	}
+	for _, tt := range tests {
+		if got := reportedGeneration(tt.service, function); got != tt.want {
+			t.Errorf("%s: expected generation %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	conditions := []any{
+		map[string]any{"type": conditionDeployed, "status": "True", "lastTransitionTime": "2026-01-01T00:00:00Z"},
+		map[string]any{"type": conditionReady, "status": "True", "lastTransitionTime": "2026-01-01T00:00:00Z"},
+	}
+
+	updated := setCondition(conditions, map[string]any{"type": conditionReady, "status": "True", "lastTransitionTime": "2026-02-01T00:00:00Z"})
+	if len(updated) != 2 || updated[1].(map[string]any)["lastTransitionTime"] != "2026-01-01T00:00:00Z" {
+		t.Errorf("Expected the transition time kept for an unchanged status, got %v", updated)
+	}
+
+	updated = setCondition(conditions, map[string]any{"type": conditionReady, "status": "False", "lastTransitionTime": "2026-02-01T00:00:00Z"})
+	if len(updated) != 2 || updated[0].(map[string]any)["type"] != conditionDeployed || updated[1].(map[string]any)["lastTransitionTime"] != "2026-02-01T00:00:00Z" {
+		t.Errorf("Expected a new transition time, with the Deployed condition kept, got %v", updated)
+	}
+}
+
+func TestRecordServiceConditions(t *testing.T) {
+	ctx := context.Background()
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "default", "fn", nil)
+	function.SetGeneration(2)
+	_ = unstructured.SetNestedSlice(function.Object, []any{
+		map[string]any{"type": conditionDeployed, "status": "True"},
+	}, "status", "conditions")
+	client := newFakeDynamicClient(function)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+
+	if err := recordServiceConditions(ctx, client, cfg, knativeServiceWithReady("True")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err := client.Resource(kdexFunctionGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(updated.Object, "status", "conditions")
+	if len(conditions) != 4 {
+		t.Fatalf("Expected the Deployed condition and three Service conditions, got %v", conditions)
+	}
+	if status, _, _ := findCondition(updated, conditionReady); status != "True" {
+		t.Errorf("Expected the function Ready, got %v", conditions)
+	}
+}
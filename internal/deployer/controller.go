@@ -0,0 +1,370 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// functionFinalizer holds a deleted KDexFunction until the reconciler has
+// torn down what it deployed, with the platform settings it deployed with.
+const functionFinalizer = "kdex.dev/knative-deployer"
+
+// conditionDeployed reports on the KDexFunction whether its current
+// generation is deployed.
+const conditionDeployed = "Deployed"
+
+const (
+	reasonDeployed     = "Deployed"
+	reasonDeployFailed = "DeployFailed"
+	// reasonDeployJobFailed is a deploy Job that failed for good, which is
+	// not launched again for the same generation.
+	reasonDeployJobFailed = "DeployJobFailed"
+	reasonInvalidSpec     = "InvalidSpec"
+)
+
+const defaultObserveInterval = 5 * time.Minute
+
+func runController(args []string) error {
+	flags := flag.NewFlagSet("controller", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "only reconcile the KDexFunctions of this namespace, instead of all of them")
+	observeInterval := flags.Duration("observe-interval", defaultObserveInterval, "how often to sync the status of a deployed function when nothing changes")
+	leaderElect := flags.Bool("leader-elect", false, "elect a leader, so only one replica reconciles")
+	probeAddress := flags.String("health-probe-bind-address", ":8081", "address the health probes are served on")
+	metricsAddress := flags.String("metrics-bind-address", "0", "address the metrics are served on, 0 to disable them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *observeInterval <= 0 {
+		return fmt.Errorf("invalid --observe-interval %s: expected a positive duration", *observeInterval)
+	}
+
+	ctrl.SetLogger(logr.FromSlogHandler(logger.Handler()))
+
+	config, err := restConfig(clientOptions.Kubeconfig, clientOptions.Context)
+	if err != nil {
+		return err
+	}
+	cluster, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	base := readEnv()
+	if err := validateChildJobs(base); err != nil {
+		return err
+	}
+
+	options := ctrl.Options{
+		HealthProbeBindAddress: *probeAddress,
+		LeaderElection:         *leaderElect,
+		LeaderElectionID:       "kdex-knative-deployer",
+		Metrics:                metricsserver.Options{BindAddress: *metricsAddress},
+	}
+	if *namespace != "" {
+		options.Cache = cache.Options{DefaultNamespaces: map[string]cache.Config{*namespace: {}}}
+		options.LeaderElectionNamespace = *namespace
+	}
+	mgr, err := ctrl.NewManager(config, options)
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		return err
+	}
+
+	r := newFunctionReconciler(cluster, base, *observeInterval)
+	if err := r.SetupWithManager(mgr); err != nil {
+		return err
+	}
+
+	scope := "all namespaces"
+	if *namespace != "" {
+		scope = "namespace " + *namespace
+	}
+	logf("Reconciling KDexFunctions in %s\n", scope)
+	return mgr.Start(ctrl.SetupSignalHandler())
+}
+
+// FunctionReconciler deploys KDexFunctions to Knative and keeps their
+// status in sync, the way the deploy Job and observe CronJob would.
+type FunctionReconciler struct {
+	client dynamic.Interface
+	// base is the platform configuration of the reconciler, which the
+	// settings of each function are layered onto.
+	base            *EnvConfig
+	observeInterval time.Duration
+}
+
+// NewFunctionReconciler returns a reconciler deploying with client.
+// settings are the platform settings by the env var a deploy Job reads
+// them from, such as VERIFY_IMAGE_SIGNATURE; the process env is not read
+// for them. A deployed function is observed every observeInterval.
+func NewFunctionReconciler(client dynamic.Interface, settings map[string]string, observeInterval time.Duration) *FunctionReconciler {
+	return newFunctionReconciler(client, envConfig(func(name string) string { return settings[name] }), observeInterval)
+}
+
+func newFunctionReconciler(client dynamic.Interface, base *EnvConfig, observeInterval time.Duration) *FunctionReconciler {
+	if base.ReadOnly == "true" {
+		logf("Read-only: writes to the cluster are logged, not made\n")
+		client = readOnlyClient{client}
+	}
+	if observeInterval <= 0 {
+		observeInterval = defaultObserveInterval
+	}
+	return &FunctionReconciler{client: client, base: base, observeInterval: observeInterval}
+}
+
+// SetupWithManager registers the reconciler with mgr, reconciling a
+// KDexFunction when its spec or the status of its Service changes.
+func (r *FunctionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	function := &unstructured.Unstructured{}
+	function.SetGroupVersionKind(kdexFunctionGVR.GroupVersion().WithKind("KDexFunction"))
+	service := &unstructured.Unstructured{}
+	service.SetGroupVersionKind(knativeServiceGVR.GroupVersion().WithKind("Service"))
+	job := &unstructured.Unstructured{}
+	job.SetGroupVersionKind(jobGVR.GroupVersion().WithKind("Job"))
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("kdexfunction").
+		For(function, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Status changes of the Service are what the observer used to poll for
+		Watches(service, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj ctrlclient.Object) []reconcile.Request {
+			name := obj.GetLabels()["kdex.dev/function"]
+			if name == "" {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+		})).
+		// The deploy Jobs of ISOLATED_NAMESPACES report back when they end
+		Owns(job).
+		// The deploy ID and the log fields of a deploy are process state,
+		// so functions are reconciled one at a time
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
+
+func (r *FunctionReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	// Reads are cached for one reconcile, as they are for one Job
+	client := newReadCache(r.client, readCacheTTL, cachedResources...)
+	functions := client.Resource(kdexFunctionGVR).Namespace(req.Namespace)
+	function, err := functions.Get(ctx, req.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("failed to get kdex function: %w", err)
+	}
+
+	// The deploy ID of the function reconciled before must not tag the log
+	// records of this one
+	deployID = ""
+	cfg, cfgErr := r.functionConfig(function)
+
+	if function.GetDeletionTimestamp() != nil {
+		if !slices.Contains(function.GetFinalizers(), functionFinalizer) {
+			return reconcile.Result{}, nil
+		}
+		if cfgErr != nil {
+			// Still tear down what can be found by name
+			cfg = &EnvConfig{FunctionName: function.GetName(), FunctionNamespace: function.GetNamespace()}
+		}
+		outcome, err := deleteFunction(ctx, client, cfg)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		logf("Knative Service %s/%s: %s\n", cfg.FunctionNamespace, cfg.FunctionName, outcome)
+		if outcome == report.OutcomeDeleted {
+			r.recordEvent(ctx, client, cfg, notificationDeleted, "")
+		}
+		return reconcile.Result{}, setFinalizers(ctx, functions, function, slices.DeleteFunc(function.GetFinalizers(), func(f string) bool { return f == functionFinalizer }))
+	}
+
+	if !slices.Contains(function.GetFinalizers(), functionFinalizer) {
+		if err := setFinalizers(ctx, functions, function, append(function.GetFinalizers(), functionFinalizer)); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if cfgErr != nil {
+		// Retrying does not help until the spec changes
+		logf("Invalid KDexFunction %s/%s: %v\n", req.Namespace, req.Name, cfgErr)
+		return reconcile.Result{}, setDeployedCondition(ctx, functions, function, metav1.ConditionFalse, reasonInvalidSpec, cfgErr.Error())
+	}
+
+	observed, _, _ := unstructured.NestedInt64(function.Object, "status", "observedGeneration")
+	if observed != function.GetGeneration() && isolatedNamespace(r.base, req.Namespace) {
+		deployed, err := r.deployInJob(ctx, client, functions, function, cfg)
+		if err != nil || !deployed {
+			return reconcile.Result{}, err
+		}
+	} else if observed != function.GetGeneration() {
+		d := &deployment{cfg: cfg, client: client}
+		if err := newDeployPipeline().run(ctx, d); err != nil {
+			r.recordEvent(ctx, client, cfg, notificationDeployFailed, err.Error())
+			if err := setDeployedCondition(ctx, functions, function, metav1.ConditionFalse, reasonDeployFailed, err.Error()); err != nil {
+				warnf("failed to update kdex function status: %v\n", err)
+			}
+			// A failed deploy is retried with backoff, resuming from its
+			// checkpoint like a retried Job
+			return reconcile.Result{}, err
+		}
+		r.recordEvent(ctx, client, cfg, notificationDeployed, d.url)
+		if err := setDeployedCondition(ctx, functions, function, metav1.ConditionTrue, reasonDeployed, ""); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := observeFunction(ctx, client, cfg, 0); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: r.observeInterval}, nil
+}
+
+// deployInJob deploys the function in a Job of its own, for the tenants
+// whose deploys must not share the process of the reconciler, and tells
+// whether the deploy succeeded. The Job retries the deploy JOB_BACKOFF_LIMIT
+// times; once it failed for good its failure is recorded in the status, and
+// only the next generation launches a new Job. The end of the Job triggers
+// the reconcile that records it.
+func (r *FunctionReconciler) deployInJob(ctx context.Context, client dynamic.Interface, functions dynamic.ResourceInterface, function *unstructured.Unstructured, cfg *EnvConfig) (bool, error) {
+	if childJobFailed(function) {
+		return false, nil
+	}
+	finished, failure, err := runChildJob(ctx, client, cfg, function)
+	if err != nil {
+		if err := setDeployedCondition(ctx, functions, function, metav1.ConditionFalse, reasonDeployFailed, err.Error()); err != nil {
+			warnf("failed to update kdex function status: %v\n", err)
+		}
+		return false, err
+	}
+	if !finished {
+		return false, nil
+	}
+
+	limit, err := parseJobHistoryLimit(cfg)
+	if err != nil {
+		return false, err
+	}
+	if err := pruneChildJobs(ctx, client, cfg, limit); err != nil {
+		warnf("failed to prune deploy jobs: %v\n", err)
+	}
+
+	if failure != "" {
+		r.recordEvent(ctx, client, cfg, notificationDeployFailed, failure)
+		return false, setDeployedCondition(ctx, functions, function, metav1.ConditionFalse, reasonDeployJobFailed, failure)
+	}
+	r.recordEvent(ctx, client, cfg, notificationDeployed, "")
+	return true, setDeployedCondition(ctx, functions, function, metav1.ConditionTrue, reasonDeployed, "")
+}
+
+// functionConfig loads the configuration to deploy the function with: the
+// reconciler's own settings with the function's on top, exactly as a
+// deploy Job started with --from-kdexfunction would see it.
+func (r *FunctionReconciler) functionConfig(function *unstructured.Unstructured) (*EnvConfig, error) {
+	cfg, err := kdexFunctionConfig(function, r.base)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" {
+		return nil, fmt.Errorf("spec.image or spec.source.git is required")
+	}
+	return cfg, nil
+}
+
+// recordEvent records a Kubernetes Event for the outcome of a reconcile on
+// the KDexFunction.
+func (r *FunctionReconciler) recordEvent(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, notificationType, message string) {
+	n := newNotification(cfg, notificationType)
+	n.Message = message
+	if err := (eventNotifier{client: client}).Notify(ctx, n); err != nil {
+		warnf("failed to record event: %v\n", err)
+	}
+}
+
+// setFinalizers replaces the finalizers of the function, failing on a
+// conflict rather than dropping those added since it was read.
+func setFinalizers(ctx context.Context, functions dynamic.ResourceInterface, function *unstructured.Unstructured, finalizers []string) error {
+	patchBytes, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"finalizers":      finalizers,
+			"resourceVersion": function.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	updated, err := functions.Patch(ctx, function.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-controller",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update kdex function finalizers: %w", err)
+	}
+	function.SetFinalizers(updated.GetFinalizers())
+	function.SetResourceVersion(updated.GetResourceVersion())
+	return nil
+}
+
+// setDeployedCondition records the Deployed condition for the generation
+// of the function, and the generation as observed once it is deployed.
+// Conditions of other types are kept.
+func setDeployedCondition(ctx context.Context, functions dynamic.ResourceInterface, function *unstructured.Unstructured, status metav1.ConditionStatus, reason, message string) error {
+	current, err := functions.Get(ctx, function.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get kdex function: %w", err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(current.Object, "status", "conditions")
+	condition := map[string]any{
+		"type":               conditionDeployed,
+		"status":             string(status),
+		"reason":             reason,
+		"message":            message,
+		"observedGeneration": function.GetGeneration(),
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	patch := map[string]any{
+		"conditions": setCondition(conditions, condition),
+	}
+	if status == metav1.ConditionTrue {
+		patch["observedGeneration"] = function.GetGeneration()
+	}
+	patchBytes, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"resourceVersion": current.GetResourceVersion()},
+		"status":   patch,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = functions.Patch(ctx, function.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-controller",
+	}, "status")
+	if err != nil {
+		return fmt.Errorf("failed to patch kdex function status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newKDexFunction(generation int64, spec map[string]any) *unstructured.Unstructured {
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil)
+	function.SetGeneration(generation)
+	_ = unstructured.SetNestedField(function.Object, spec, "spec")
+	return function
+}
+
+func reconcileFunction(t *testing.T, r *FunctionReconciler) reconcile.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "myns", Name: "myfunc"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return result
+}
+
+func TestReconcileObservesDeployedFunction(t *testing.T) {
+	function := newKDexFunction(2, map[string]any{"image": "myimg"})
+	function.SetFinalizers([]string{functionFinalizer})
+	_ = unstructured.SetNestedField(function.Object, int64(2), "status", "observedGeneration")
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", map[string]string{"kdex.dev/function": "myfunc"})
+	_ = unstructured.SetNestedField(service.Object, map[string]any{
+		"url":        "http://myfunc.myns.example.com",
+		"conditions": []any{map[string]any{"type": "Ready", "status": "True"}},
+	}, "status")
+
+	t.Setenv("FUNCTION_IMAGE", "")
+	_ = os.Unsetenv("FUNCTION_IMAGE")
+	client := newFakeDynamicClient(function, service)
+	r := newFunctionReconciler(client, readEnv(), time.Minute)
+	if result := reconcileFunction(t, r); result.RequeueAfter != time.Minute {
+		t.Errorf("Expected the function to be observed again after a minute, got %+v", result)
+	}
+
+	got, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if state, _, _ := unstructured.NestedString(got.Object, "status", "state"); state != "Ready" {
+		t.Errorf("Expected the observed state to be written, got %q", state)
+	}
+	if _, ok := os.LookupEnv("FUNCTION_IMAGE"); ok {
+		t.Error("Expected the settings of the function to stay out of the process env")
+	}
+}
+
+func TestReconcileInvalidSpec(t *testing.T) {
+	client := newFakeDynamicClient(newKDexFunction(1, map[string]any{}))
+	r := newFunctionReconciler(client, readEnv(), time.Minute)
+	reconcileFunction(t, r)
+
+	got, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if !slices.Contains(got.GetFinalizers(), functionFinalizer) {
+		t.Errorf("Expected the finalizer to be added, got %v", got.GetFinalizers())
+	}
+	conditions, _, _ := unstructured.NestedSlice(got.Object, "status", "conditions")
+	if len(conditions) != 1 {
+		t.Fatalf("Expected a Deployed condition, got %v", conditions)
+	}
+	condition := conditions[0].(map[string]any)
+	if condition["type"] != conditionDeployed || condition["status"] != "False" || condition["reason"] != reasonInvalidSpec {
+		t.Errorf("Unexpected condition: %v", condition)
+	}
+	if _, found, _ := unstructured.NestedInt64(got.Object, "status", "observedGeneration"); found {
+		t.Error("Expected a failed generation not to be recorded as observed")
+	}
+}
+
+func TestFunctionConfigKeepsPlatformSettings(t *testing.T) {
+	function := newKDexFunction(1, map[string]any{
+		"image": "myimg",
+		"env": []any{
+			map[string]any{"name": "DEPLOY_QUOTA", "value": ""},
+			map[string]any{"name": "READ_ONLY", "value": "false"},
+		},
+	})
+	r := &FunctionReconciler{base: &EnvConfig{DeployQuota: "20/1h", ReadOnly: "true"}}
+	cfg, err := r.functionConfig(function)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.DeployQuota != "20/1h" || cfg.ReadOnly != "true" {
+		t.Errorf("Expected spec.env not to override platform settings, got %q and %q", cfg.DeployQuota, cfg.ReadOnly)
+	}
+	if cfg.FunctionEnv["READ_ONLY"] != "false" {
+		t.Errorf("Expected spec.env to be kept for the function, got %v", cfg.FunctionEnv)
+	}
+	if r.base.FunctionName != "" {
+		t.Error("Expected the controller settings to be left untouched")
+	}
+}
+
+func TestReconcileDeletedFunction(t *testing.T) {
+	function := newKDexFunction(3, map[string]any{"image": "myimg"})
+	function.SetFinalizers([]string{"other", functionFinalizer})
+	now := metav1.Now()
+	function.SetDeletionTimestamp(&now)
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", map[string]string{"kdex.dev/function": "myfunc"})
+
+	client := newFakeDynamicClient(function, service)
+	r := newFunctionReconciler(client, readEnv(), time.Minute)
+	if result := reconcileFunction(t, r); result.RequeueAfter != 0 {
+		t.Errorf("Expected a deleted function not to be observed again, got %+v", result)
+	}
+
+	_, err := client.Resource(knativeServiceGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected the service to be deleted, got %v", err)
+	}
+	got, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if finalizers := got.GetFinalizers(); len(finalizers) != 1 || finalizers[0] != "other" {
+		t.Errorf("Expected only our finalizer to be removed, got %v", finalizers)
+	}
+}
+
+func TestSetDeployedCondition(t *testing.T) {
+	function := newKDexFunction(4, nil)
+	_ = unstructured.SetNestedSlice(function.Object, []any{
+		map[string]any{"type": "Other", "status": "True"},
+		map[string]any{"type": conditionDeployed, "status": "True", "lastTransitionTime": "2026-01-01T00:00:00Z"},
+	}, "status", "conditions")
+	client := newFakeDynamicClient(function)
+	functions := client.Resource(kdexFunctionGVR).Namespace("myns")
+
+	if err := setDeployedCondition(context.Background(), functions, function, metav1.ConditionTrue, reasonDeployed, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got, _ := functions.Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if generation, _, _ := unstructured.NestedInt64(got.Object, "status", "observedGeneration"); generation != 4 {
+		t.Errorf("Expected generation 4 to be observed, got %d", generation)
+	}
+	conditions, _, _ := unstructured.NestedSlice(got.Object, "status", "conditions")
+	if len(conditions) != 2 || conditions[0].(map[string]any)["type"] != "Other" {
+		t.Fatalf("Expected the other condition to be kept, got %v", conditions)
+	}
+	deployed := conditions[1].(map[string]any)
+	if deployed["lastTransitionTime"] != "2026-01-01T00:00:00Z" || deployed["observedGeneration"] != int64(4) {
+		t.Errorf("Expected the transition time to be kept while the status holds, got %v", deployed)
+	}
+}
+
+func TestNewFunctionReconciler(t *testing.T) {
+	t.Setenv("DEPLOY_QUOTA", "5/1h")
+	r := NewFunctionReconciler(newFakeDynamicClient(), map[string]string{"DEPLOY_QUOTA": "20/1h", "READ_ONLY": "true"}, 0)
+	if r.base.DeployQuota != "20/1h" {
+		t.Errorf("Expected the settings to be taken from the map, not the process env, got %q", r.base.DeployQuota)
+	}
+	if _, ok := r.client.(readOnlyClient); !ok {
+		t.Errorf("Expected READ_ONLY to wrap the client, got %T", r.client)
+	}
+	if r.observeInterval != defaultObserveInterval {
+		t.Errorf("Expected the default observe interval, got %s", r.observeInterval)
+	}
+}
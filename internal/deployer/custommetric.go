@@ -0,0 +1,136 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// customMetricPrefix marks a SCALING_METRIC the function reports itself,
+// as in custom:queue_depth, scaled on by the HPA autoscaler class.
+const customMetricPrefix = "custom:"
+
+const hpaAutoscalerClass = "hpa.autoscaling.knative.dev"
+
+const (
+	defaultCustomMetricPort = "9090"
+	defaultCustomMetricPath = "/metrics"
+)
+
+// customMetricsGVR serves the metrics of pods the Prometheus adapter
+// exposes to the HPA.
+var customMetricsGVR = schema.GroupVersionResource{
+	Group:    "custom.metrics.k8s.io",
+	Version:  "v1beta1",
+	Resource: "pods",
+}
+
+var prometheusMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// customMetric is the name of the custom SCALING_METRIC, or empty for the
+// metrics Knative measures.
+func customMetric(cfg *EnvConfig) string {
+	name, ok := strings.CutPrefix(strings.TrimSpace(cfg.ScalingMetric), customMetricPrefix)
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// validateCustomMetric checks the settings scaling on a custom metric: the
+// HPA needs a target, and Prometheus where to scrape the function.
+func validateCustomMetric(cfg *EnvConfig) error {
+	if customMetric(cfg) == "" {
+		if cfg.ScalingMetricPort != "" || cfg.ScalingMetricPath != "" {
+			return fmt.Errorf("SCALING_METRIC_PORT and SCALING_METRIC_PATH require a custom SCALING_METRIC")
+		}
+		return nil
+	}
+	if cfg.ScalingTarget == "" {
+		return fmt.Errorf("SCALING_TARGET is required for custom SCALING_METRIC %s", cfg.ScalingMetric)
+	}
+	if cfg.ScalingMetricPort != "" {
+		if port, err := strconv.Atoi(cfg.ScalingMetricPort); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid SCALING_METRIC_PORT %q: expected a port number", cfg.ScalingMetricPort)
+		}
+	}
+	if cfg.ScalingMetricPath != "" && !strings.HasPrefix(cfg.ScalingMetricPath, "/") {
+		return fmt.Errorf("invalid SCALING_METRIC_PATH %q: expected an absolute path", cfg.ScalingMetricPath)
+	}
+	return nil
+}
+
+// customMetricAnnotations are the revision template annotations having
+// the HPA scale the revision, as Knative's own autoscaler only knows its
+// metrics and the class is chosen per revision, and Prometheus scrape the
+// custom metric off the function's pods.
+func customMetricAnnotations(cfg *EnvConfig) map[string]any {
+	if customMetric(cfg) == "" {
+		return nil
+	}
+	port, path := cfg.ScalingMetricPort, cfg.ScalingMetricPath
+	if port == "" {
+		port = defaultCustomMetricPort
+	}
+	if path == "" {
+		path = defaultCustomMetricPath
+	}
+	return map[string]any{
+		"autoscaling.knative.dev/class": hpaAutoscalerClass,
+		"prometheus.io/scrape":          "true",
+		"prometheus.io/port":            port,
+		"prometheus.io/path":            path,
+	}
+}
+
+// waitForCustomMetric waits for the custom metrics API to report the
+// metric for a pod of the revision, which the HPA cannot scale without.
+func waitForCustomMetric(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, revision string, timing waitTiming) error {
+	ctx, cancel := context.WithTimeout(ctx, timing.Timeout)
+	defer cancel()
+
+	metric := customMetric(cfg)
+	logf("Waiting for metric %s to be reported for %s\n", metric, revision)
+	metrics := client.Resource(customMetricsGVR).Namespace(cfg.FunctionNamespace)
+	for {
+		values, err := metrics.Get(ctx, "*", metav1.GetOptions{}, metric)
+		if err == nil && reportsRevision(values, revision) {
+			logf("Metric %s is reported\n", metric)
+			return nil
+		}
+		if err != nil {
+			logf("Metric %s not available yet: %v\n", metric, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("metric %s was not reported for %s within %s", metric, revision, timing.Timeout)
+		case <-time.After(timing.PollInterval):
+		}
+	}
+}
+
+// reportsRevision tells whether a MetricValueList holds a value for a pod
+// of the revision, whose pods are named after it.
+func reportsRevision(values *unstructured.Unstructured, revision string) bool {
+	items, _, _ := unstructured.NestedSlice(values.Object, "items")
+	for _, item := range items {
+		value, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(value, "describedObject", "name")
+		if strings.HasPrefix(name, revision+"-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestValidateCustomMetric(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{ScalingMetric: "rps"}},
+		{cfg: EnvConfig{ScalingMetric: "custom:queue_depth", ScalingTarget: "10"}},
+		{cfg: EnvConfig{ScalingMetric: "custom:queue_depth", ScalingTarget: "10", ScalingMetricPort: "8081", ScalingMetricPath: "/stats"}},
+		{cfg: EnvConfig{ScalingMetric: "custom:queue_depth"}, wantErr: true},
+		{cfg: EnvConfig{ScalingMetric: "custom:queue_depth", ScalingTarget: "10", ScalingMetricPort: "http"}, wantErr: true},
+		{cfg: EnvConfig{ScalingMetric: "custom:queue_depth", ScalingTarget: "10", ScalingMetricPath: "stats"}, wantErr: true},
+		{cfg: EnvConfig{ScalingMetric: "rps", ScalingMetricPort: "8081"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateCustomMetric(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestCustomMetricService(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "fn",
+		FunctionNamespace: "default",
+		FunctionImage:     "example.com/fn:1",
+		ScalingMetric:     "custom:queue_depth",
+		ScalingTarget:     "10",
+	}
+
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := service.GetAnnotations()
+	if annotations["autoscaling.knative.dev/metric"] != "queue_depth" {
+		t.Errorf("Expected scaling on queue_depth, got %v", annotations)
+	}
+	if _, ok := annotations["autoscaling.knative.dev/class"]; ok {
+		t.Errorf("Expected no autoscaler class on the Service, got %v", annotations)
+	}
+	template, _, _ := unstructured.NestedStringMap(service.Object, "spec", "template", "metadata", "annotations")
+	if template["autoscaling.knative.dev/class"] != hpaAutoscalerClass {
+		t.Errorf("Expected the revision to be scaled by the HPA, got %v", template)
+	}
+	if template["prometheus.io/scrape"] != "true" || template["prometheus.io/port"] != defaultCustomMetricPort || template["prometheus.io/path"] != defaultCustomMetricPath {
+		t.Errorf("Expected the metric to be scraped, got %v", template)
+	}
+}
+
+func TestWaitForCustomMetric(t *testing.T) {
+	cfg := &EnvConfig{FunctionNamespace: "default", ScalingMetric: "custom:queue_depth"}
+	timing := waitTiming{PollInterval: time.Millisecond, Timeout: 50 * time.Millisecond}
+
+	reported := []any{}
+	client := newFakeDynamicClient()
+	client.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "queue_depth" {
+			t.Errorf("Expected the metric to be requested, got %q", action.GetSubresource())
+		}
+		return true, &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "custom.metrics.k8s.io/v1beta1",
+			"kind":       "MetricValueList",
+			"items":      reported,
+		}}, nil
+	})
+
+	if err := waitForCustomMetric(context.Background(), client, cfg, "fn-00002", timing); err == nil {
+		t.Error("Expected an error while the metric is not reported")
+	}
+
+	reported = []any{
+		map[string]any{"describedObject": map[string]any{"kind": "Pod", "name": "fn-00001-deployment-abc"}},
+		map[string]any{"describedObject": map[string]any{"kind": "Pod", "name": "fn-00002-deployment-def"}},
+	}
+	if err := waitForCustomMetric(context.Background(), client, cfg, "fn-00002", timing); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
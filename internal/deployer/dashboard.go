@@ -0,0 +1,182 @@
+package deployer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var grafanaDashboardGVR = schema.GroupVersionResource{
+	Group:    "grafana.integreatly.org",
+	Version:  "v1beta1",
+	Resource: "grafanadashboards",
+}
+
+const (
+	dashboardProvisioningOperator = "operator"
+	dashboardProvisioningAPI      = "api"
+)
+
+// dashboardUID is stable per function so redeploys update the same
+// dashboard. Grafana limits uids to 40 characters.
+func dashboardUID(cfg *EnvConfig) string {
+	uid := "kdex-" + cfg.FunctionNamespace + "-" + cfg.FunctionName
+	if len(uid) <= 40 {
+		return uid
+	}
+	sum := sha256.Sum256([]byte(uid))
+	return "kdex-" + hex.EncodeToString(sum[:])[:35]
+}
+
+// buildDashboard renders the Grafana dashboard model for the function with
+// latency, error and scale panels over the Knative metrics of its
+// revisions.
+func buildDashboard(cfg *EnvConfig) map[string]any {
+	selector := fmt.Sprintf(`namespace_name="%s", configuration_name="%s", revision_name=~"$revision"`, cfg.FunctionNamespace, cfg.FunctionName)
+
+	panel := func(id int, title, unit string, x, y int, exprs ...string) map[string]any {
+		targets := []any{}
+		for i, expr := range exprs {
+			targets = append(targets, map[string]any{
+				"refId": string(rune('A' + i)),
+				"expr":  expr,
+			})
+		}
+		return map[string]any{
+			"id":    id,
+			"type":  "timeseries",
+			"title": title,
+			"gridPos": map[string]any{
+				"x": x, "y": y, "w": 12, "h": 8,
+			},
+			"fieldConfig": map[string]any{
+				"defaults": map[string]any{"unit": unit},
+			},
+			"targets": targets,
+		}
+	}
+
+	return map[string]any{
+		"uid":           dashboardUID(cfg),
+		"title":         fmt.Sprintf("KDex Function %s/%s", cfg.FunctionNamespace, cfg.FunctionName),
+		"tags":          []any{"kdex", "kdex-function:" + cfg.FunctionName},
+		"schemaVersion": 39,
+		"templating": map[string]any{
+			"list": []any{
+				map[string]any{
+					"name":       "revision",
+					"type":       "query",
+					"query":      fmt.Sprintf(`label_values(revision_request_count{namespace_name="%s", configuration_name="%s"}, revision_name)`, cfg.FunctionNamespace, cfg.FunctionName),
+					"includeAll": true,
+					"multi":      true,
+					"current":    map[string]any{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+		"panels": []any{
+			panel(1, "Request rate", "reqps", 0, 0,
+				fmt.Sprintf(`sum by (revision_name) (rate(revision_request_count{%s}[1m]))`, selector)),
+			panel(2, "Error rate", "percentunit", 12, 0,
+				fmt.Sprintf(`sum(rate(revision_request_count{%s, response_code_class="5xx"}[1m])) / sum(rate(revision_request_count{%s}[1m]))`, selector, selector)),
+			panel(3, "Latency", "ms", 0, 8,
+				fmt.Sprintf(`histogram_quantile(0.50, sum by (le) (rate(revision_request_latencies_bucket{%s}[1m])))`, selector),
+				fmt.Sprintf(`histogram_quantile(0.95, sum by (le) (rate(revision_request_latencies_bucket{%s}[1m])))`, selector),
+				fmt.Sprintf(`histogram_quantile(0.99, sum by (le) (rate(revision_request_latencies_bucket{%s}[1m])))`, selector)),
+			panel(4, "Pods", "short", 12, 8,
+				fmt.Sprintf(`sum by (revision_name) (autoscaler_actual_pods{%s})`, selector),
+				fmt.Sprintf(`sum by (revision_name) (autoscaler_desired_pods{%s})`, selector)),
+		},
+	}
+}
+
+// provisionDashboard creates or updates the function dashboard through the
+// Grafana Operator or the Grafana HTTP API, as set by DASHBOARD_PROVISIONING.
+func provisionDashboard(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	dashboard := buildDashboard(cfg)
+
+	switch cfg.DashboardProvisioning {
+	case dashboardProvisioningOperator:
+		model, err := json.Marshal(dashboard)
+		if err != nil {
+			return err
+		}
+		matchLabels, err := parseKeyValues(cfg.GrafanaInstanceSelector)
+		if err != nil {
+			return fmt.Errorf("invalid GRAFANA_INSTANCE_SELECTOR: %w", err)
+		}
+
+		cr := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "grafana.integreatly.org/v1beta1",
+				"kind":       "GrafanaDashboard",
+				"metadata": map[string]any{
+					"name":      cfg.FunctionName,
+					"namespace": cfg.FunctionNamespace,
+					"labels": map[string]any{
+						"kdex.dev/function":   cfg.FunctionName,
+						"kdex.dev/generation": cfg.FunctionGeneration,
+					},
+				},
+				"spec": map[string]any{
+					"json": string(model),
+					"instanceSelector": map[string]any{
+						"matchLabels": toAnyMap(matchLabels),
+					},
+				},
+			},
+		}
+		data, err := json.Marshal(cr)
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = client.Resource(grafanaDashboardGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply grafana dashboard: %w", err)
+		}
+		return nil
+	case dashboardProvisioningAPI:
+		if cfg.GrafanaURL == "" {
+			return fmt.Errorf("GRAFANA_URL is required for api dashboard provisioning")
+		}
+		body, err := json.Marshal(map[string]any{
+			"dashboard": dashboard,
+			"overwrite": true,
+			"message":   "Deployed generation " + cfg.FunctionGeneration,
+		})
+		if err != nil {
+			return err
+		}
+		status, err := sendJSON(ctx, http.MethodPost, strings.TrimSuffix(cfg.GrafanaURL, "/")+"/api/dashboards/db", cfg.GrafanaToken, body)
+		if err != nil {
+			return err
+		}
+		if status < 200 || status > 299 {
+			return fmt.Errorf("grafana returned %d %s", status, http.StatusText(status))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown DASHBOARD_PROVISIONING: %s", cfg.DashboardProvisioning)
+	}
+}
+
+func toAnyMap(m map[string]string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
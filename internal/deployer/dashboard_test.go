@@ -0,0 +1,74 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardUID(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+	if uid := dashboardUID(cfg); uid != "kdex-myns-myfunc" {
+		t.Errorf("Unexpected uid: %s", uid)
+	}
+
+	cfg.FunctionName = strings.Repeat("f", 50)
+	uid := dashboardUID(cfg)
+	if len(uid) > 40 || uid != dashboardUID(cfg) {
+		t.Errorf("Expected stable uid of at most 40 characters, got %s", uid)
+	}
+}
+
+func TestProvisionDashboardAPI(t *testing.T) {
+	var path string
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	cfg := &EnvConfig{
+		FunctionName:          "myfunc",
+		FunctionNamespace:     "myns",
+		DashboardProvisioning: dashboardProvisioningAPI,
+		GrafanaURL:            server.URL + "/",
+	}
+
+	if err := provisionDashboard(context.Background(), nil, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/api/dashboards/db" {
+		t.Errorf("Unexpected path: %s", path)
+	}
+	dashboard, _ := body["dashboard"].(map[string]any)
+	if dashboard["uid"] != "kdex-myns-myfunc" || body["overwrite"] != true {
+		t.Errorf("Unexpected body: %v", body)
+	}
+	panels, _ := dashboard["panels"].([]any)
+	if len(panels) != 4 {
+		t.Errorf("Expected 4 panels, got %d", len(panels))
+	}
+
+	cfg.DashboardProvisioning = "magic"
+	if err := provisionDashboard(context.Background(), nil, cfg); err == nil {
+		t.Error("Expected error for unknown provisioning mode")
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	pairs, err := parseKeyValues("app=grafana, team = a,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pairs) != 2 || pairs["app"] != "grafana" || pairs["team"] != "a" {
+		t.Errorf("Unexpected pairs: %v", pairs)
+	}
+
+	if _, err := parseKeyValues("novalue"); err == nil {
+		t.Error("Expected error for missing value")
+	}
+}
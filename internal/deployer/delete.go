@@ -0,0 +1,179 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func runDelete() error {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	notifiers, err := loadNotifiers(cfg, client)
+	if err != nil {
+		return err
+	}
+
+	outcome, err := deleteFunction(context.Background(), client, cfg)
+	if err != nil {
+		return err
+	}
+
+	logf("Knative Service %s/%s: %s\n", cfg.FunctionNamespace, cfg.FunctionName, outcome)
+
+	if outcome == report.OutcomeDeleted {
+		notifiers.notify(context.Background(), newNotification(cfg, notificationDeleted))
+	}
+
+	if err := writeTerminationMessage(report.TerminationMessage{Outcome: outcome}); err != nil {
+		return fmt.Errorf("failed to write termination message: %w", err)
+	}
+
+	return nil
+}
+
+// deleteFunction removes the Knative Service and everything the deployer
+// created alongside it, then waits for the Service to be gone. It reports
+// whether there was anything to delete.
+func deleteFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (string, error) {
+	resourceClient := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+
+	// Foreground deletion keeps the Service around until its revisions are
+	// gone, so waiting for it means the function is fully torn down
+	outcome := report.OutcomeDeleted
+	propagation := metav1.DeletePropagationForeground
+	err := resourceClient.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete knative service: %w", err)
+		}
+		outcome = report.OutcomeNotFound
+	}
+
+	// Resources created next to the Service. They may not exist, or their
+	// CRDs may not even be installed, which is fine.
+	for _, r := range []struct {
+		gvr  schema.GroupVersionResource
+		name string
+	}{
+		{configMapGVR, publicURLConfigMapName(cfg)},
+		{configMapGVR, checkpointConfigMapName(cfg)},
+		{configMapGVR, terminationConfigMapName(cfg)},
+		{configMapGVR, deployResultConfigMapName(cfg)},
+		{kpackImageGVR, cfg.FunctionName},
+		{grafanaDashboardGVR, cfg.FunctionName},
+		{prometheusRuleGVR, alertsRuleName(cfg)},
+		{prometheusRuleGVR, sloRuleName(cfg)},
+		{otelCollectorGVR, logCollectorName(cfg)},
+		{requestAuthenticationGVR, cfg.FunctionName},
+		{authorizationPolicyGVR, cfg.FunctionName},
+		{pingSourceGVR, cfg.FunctionName},
+		{sinkBindingGVR, cfg.FunctionName},
+		{networkPolicyGVR, cfg.FunctionName},
+		{serviceEntryGVR, cfg.FunctionName},
+		{sidecarGVR, cfg.FunctionName},
+		{envoyFilterGVR, cfg.FunctionName},
+		{destinationRuleGVR, cfg.FunctionName},
+	} {
+		err := client.Resource(r.gvr).Namespace(cfg.FunctionNamespace).Delete(ctx, r.name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete %s %s: %w", r.gvr.Resource, r.name, err)
+		}
+	}
+	if err := deleteByLabel(ctx, client, tektonPipelineRunGVR, cfg); err != nil {
+		return "", err
+	}
+	if err := deleteByLabel(ctx, client, cronJobGVR, cfg); err != nil {
+		return "", err
+	}
+
+	if cfg.DashboardProvisioning == dashboardProvisioningAPI && cfg.GrafanaURL != "" {
+		status, err := sendJSON(ctx, http.MethodDelete, strings.TrimSuffix(cfg.GrafanaURL, "/")+"/api/dashboards/uid/"+dashboardUID(cfg), cfg.GrafanaToken, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to delete grafana dashboard: %w", err)
+		}
+		if status != http.StatusNotFound && (status < 200 || status > 299) {
+			return "", fmt.Errorf("failed to delete grafana dashboard: grafana returned %d %s", status, http.StatusText(status))
+		}
+	}
+
+	if cfg.RegistryURL != "" {
+		if err := deregisterFunction(ctx, cfg); err != nil {
+			return "", fmt.Errorf("failed to deregister function: %w", err)
+		}
+	}
+
+	if outcome == report.OutcomeDeleted {
+		logf("Waiting for service to be deleted...\n")
+		if err := waitForDeletion(ctx, resourceClient, cfg.FunctionName); err != nil {
+			return "", fmt.Errorf("failed to wait for service deletion: %w", err)
+		}
+	}
+
+	return outcome, nil
+}
+
+// deleteByLabel deletes every resource of the function labelled with
+// kdex.dev/function.
+func deleteByLabel(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, cfg *EnvConfig) error {
+	resourceClient := client.Resource(gvr).Namespace(cfg.FunctionNamespace)
+	list, err := resourceClient.List(ctx, metav1.ListOptions{
+		LabelSelector: "kdex.dev/function=" + cfg.FunctionName,
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list %s: %w", gvr.Resource, err)
+	}
+	for _, item := range list.Items {
+		err := resourceClient.Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %w", gvr.Resource, item.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func waitForDeletion(ctx context.Context, client dynamic.ResourceInterface, name string) error {
+	timeout := time.After(5 * time.Minute)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		_, err := client.Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("timeout waiting for service deletion")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func newObject(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		knativeServiceGVR:        "ServiceList",
+		knativeRevisionGVR:       "RevisionList",
+		domainMappingGVR:         "DomainMappingList",
+		certificateGVR:           "CertificateList",
+		requestAuthenticationGVR: "RequestAuthenticationList",
+		authorizationPolicyGVR:   "AuthorizationPolicyList",
+		pingSourceGVR:            "PingSourceList",
+		sinkBindingGVR:           "SinkBindingList",
+		networkPolicyGVR:         "NetworkPolicyList",
+		serviceEntryGVR:          "ServiceEntryList",
+		sidecarGVR:               "SidecarList",
+		envoyFilterGVR:           "EnvoyFilterList",
+		destinationRuleGVR:       "DestinationRuleList",
+		coreServiceGVR:           "ServiceList",
+		kdexFunctionGVR:          "KDexFunctionList",
+		configMapGVR:             "ConfigMapList",
+		podGVR:                   "PodList",
+		podAutoscalerGVR:         "PodAutoscalerList",
+		secretGVR:                "SecretList",
+		serviceAccountGVR:        "ServiceAccountList",
+		eventGVR:                 "EventList",
+		kpackImageGVR:            "ImageList",
+		grafanaDashboardGVR:      "GrafanaDashboardList",
+		prometheusRuleGVR:        "PrometheusRuleList",
+		otelCollectorGVR:         "OpenTelemetryCollectorList",
+		tektonPipelineRunGVR:     "PipelineRunList",
+		jobGVR:                   "JobList",
+		cronJobGVR:               "CronJobList",
+	}, objects...)
+}
+
+func TestDeleteFunction(t *testing.T) {
+	labels := map[string]string{"kdex.dev/function": "myfunc"}
+	client := newFakeDynamicClient(
+		newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", labels),
+		newObject("v1", "ConfigMap", "myns", "myfunc-public-url", labels),
+		newObject("monitoring.coreos.com/v1", "PrometheusRule", "myns", "myfunc-alerts", labels),
+		newObject("monitoring.coreos.com/v1", "PrometheusRule", "myns", "myfunc-slo", labels),
+		newObject("tekton.dev/v1", "PipelineRun", "myns", "myfunc-build-abcde", labels),
+		newObject("tekton.dev/v1", "PipelineRun", "myns", "other-build-abcde", map[string]string{"kdex.dev/function": "other"}),
+	)
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+
+	outcome, err := deleteFunction(context.Background(), client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if outcome != report.OutcomeDeleted {
+		t.Errorf("Expected %s, got %s", report.OutcomeDeleted, outcome)
+	}
+
+	for _, r := range []struct {
+		gvr  schema.GroupVersionResource
+		name string
+	}{
+		{knativeServiceGVR, "myfunc"},
+		{configMapGVR, "myfunc-public-url"},
+		{prometheusRuleGVR, "myfunc-alerts"},
+		{prometheusRuleGVR, "myfunc-slo"},
+		{tektonPipelineRunGVR, "myfunc-build-abcde"},
+	} {
+		_, err := client.Resource(r.gvr).Namespace("myns").Get(context.Background(), r.name, metav1.GetOptions{})
+		if !errors.IsNotFound(err) {
+			t.Errorf("Expected %s %s to be deleted, got %v", r.gvr.Resource, r.name, err)
+		}
+	}
+	if _, err := client.Resource(tektonPipelineRunGVR).Namespace("myns").Get(context.Background(), "other-build-abcde", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected other function's pipeline run to survive: %v", err)
+	}
+
+	outcome, err = deleteFunction(context.Background(), client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if outcome != report.OutcomeNotFound {
+		t.Errorf("Expected %s, got %s", report.OutcomeNotFound, outcome)
+	}
+}
@@ -0,0 +1,64 @@
+package deployer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// deployIDAnnotation records on the Service and on Events which rollout
+// produced them.
+const deployIDAnnotation = "kdex.dev/deploy-id"
+
+// deployID correlates the log records of one rollout. It is empty outside
+// of deploys.
+var deployID string
+
+// newDeployID generates a deploy ID that sorts by start time, such as
+// 20261016-021450-3f9a2c.
+func newDeployID() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// resolveDeployID takes DEPLOY_ID from the caller, such as the controller
+// launching the Job, or generates one, and tags the log with it.
+func resolveDeployID(cfg *EnvConfig) error {
+	if cfg.DeployID == "" {
+		id, err := newDeployID()
+		if err != nil {
+			return fmt.Errorf("failed to generate deploy id: %w", err)
+		}
+		cfg.DeployID = id
+	}
+	deployID = cfg.DeployID
+	return nil
+}
+
+// recordDeployID stores the deploy ID on the KDexFunction status so the
+// controller and monitoring can find the rollout's logs and Events.
+func recordDeployID(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"deployId": cfg.DeployID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	return err
+}
@@ -0,0 +1,62 @@
+package deployer
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResolveDeployID(t *testing.T) {
+	t.Cleanup(func() { deployID = "" })
+
+	cfg := &EnvConfig{}
+	if err := resolveDeployID(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`).MatchString(cfg.DeployID) || deployID != cfg.DeployID {
+		t.Errorf("Expected a generated deploy id, got %q", cfg.DeployID)
+	}
+
+	cfg = &EnvConfig{DeployID: "rollout-42"}
+	if err := resolveDeployID(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.DeployID != "rollout-42" || deployID != "rollout-42" {
+		t.Errorf("Expected the given deploy id to be kept, got %q", cfg.DeployID)
+	}
+}
+
+func TestDeployIDAnnotationAndStatus(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "myimg",
+		DeployID:          "rollout-42",
+	}
+
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id := service.GetAnnotations()[deployIDAnnotation]; id != "rollout-42" {
+		t.Errorf("Expected deploy id annotation, got %q", id)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "metadata", "annotations", deployIDAnnotation); found {
+		t.Error("Expected no deploy id on the revision template")
+	}
+
+	client := newFakeDynamicClient(newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil))
+	if err := recordDeployID(context.Background(), client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	function, err := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id, _, _ := unstructured.NestedString(function.Object, "status", "deployId"); id != "rollout-42" {
+		t.Errorf("Expected deploy id on the function status, got %q", id)
+	}
+}
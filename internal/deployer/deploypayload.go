@@ -0,0 +1,109 @@
+package deployer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+)
+
+// unsignedSettings are the settings left out of the deploy payload: the
+// signature itself, and credentials that rotate without changing what is
+// deployed.
+var unsignedSettings = []string{
+	"DEPLOY_PAYLOAD_SIGNATURE",
+	"CATALOG_TOKEN",
+	"GRAFANA_TOKEN",
+	"REGISTRY_TOKEN",
+}
+
+// deployPayload is what the approver of a deploy signs: the settings of
+// the deploy and the values of the env vars forwarded to the function.
+type deployPayload struct {
+	Settings map[string]string `json:"settings"`
+	Env      map[string]string `json:"env,omitempty"`
+}
+
+// buildDeployPayload renders the deploy payload of cfg. Maps marshal with
+// sorted keys, so the same deploy always renders the same bytes.
+func buildDeployPayload(cfg *EnvConfig) ([]byte, error) {
+	payload := deployPayload{Settings: map[string]string{}}
+	config := reflect.ValueOf(cfg).Elem()
+	for i, field := range reflect.VisibleFields(config.Type()) {
+		setting := field.Tag.Get("env")
+		if setting == "" {
+			continue
+		}
+		value := config.Field(i).String()
+		if value == "" || slices.Contains(unsignedSettings, setting) {
+			continue
+		}
+		payload.Settings[setting] = value
+	}
+
+	forwarded, err := parseForwardedEnvVars(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range forwarded {
+		// References are signed as part of FORWARDED_ENV_VARS
+		if v.Source != "" {
+			continue
+		}
+		if payload.Env == nil {
+			payload.Env = map[string]string{}
+		}
+		payload.Env[v.Name] = forwardedValue(cfg, v.Name)
+	}
+	return json.Marshal(payload)
+}
+
+// verifyDeployPayload checks DEPLOY_PAYLOAD_SIGNATURE, a base64 signature
+// over the deploy payload, against the DEPLOY_PAYLOAD_PUBLIC_KEY PEM file.
+// Once a key is configured, unsigned deploys are refused.
+func verifyDeployPayload(cfg *EnvConfig) error {
+	if cfg.DeployPayloadPublicKey == "" {
+		if cfg.DeployPayloadSignature != "" {
+			return fmt.Errorf("DEPLOY_PAYLOAD_SIGNATURE needs DEPLOY_PAYLOAD_PUBLIC_KEY")
+		}
+		return nil
+	}
+	if cfg.DeployPayloadSignature == "" {
+		return fmt.Errorf("refusing unsigned deploy: DEPLOY_PAYLOAD_PUBLIC_KEY needs a DEPLOY_PAYLOAD_SIGNATURE")
+	}
+
+	key, err := readPublicKey(cfg.DeployPayloadPublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid DEPLOY_PAYLOAD_PUBLIC_KEY: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(cfg.DeployPayloadSignature)
+	if err != nil {
+		return fmt.Errorf("invalid DEPLOY_PAYLOAD_SIGNATURE: expected base64")
+	}
+	payload, err := buildDeployPayload(cfg)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(key, payload, signature); err != nil {
+		return fmt.Errorf("refusing deploy: invalid DEPLOY_PAYLOAD_SIGNATURE: %w", err)
+	}
+	logf("Verified deploy payload signature\n")
+	return nil
+}
+
+// runDeployPayload prints the deploy payload for the approver to sign, for
+// example with openssl dgst -sha256 -sign key.pem | base64 -w0.
+func runDeployPayload() error {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	payload, err := buildDeployPayload(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(payload)
+	return err
+}
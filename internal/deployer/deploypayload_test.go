@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildDeployPayload(t *testing.T) {
+	t.Setenv("API_KEY", "s3cr3t")
+	cfg := &EnvConfig{
+		FunctionName:           "fn",
+		FunctionImage:          "registry/fn:v1",
+		ForwardedEnvVars:       "API_KEY,DB_PASSWORD=secret:db:password",
+		RegistryToken:          "token",
+		DeployPayloadSignature: "c2ln",
+	}
+	data, err := buildDeployPayload(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var payload deployPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Settings["FUNCTION_IMAGE"] != "registry/fn:v1" || payload.Settings["FUNCTION_NAME"] != "fn" {
+		t.Errorf("Expected the function settings to be signed, got %v", payload.Settings)
+	}
+	for _, setting := range []string{"REGISTRY_TOKEN", "DEPLOY_PAYLOAD_SIGNATURE", "SCALING_MIN_SCALE"} {
+		if _, ok := payload.Settings[setting]; ok {
+			t.Errorf("Expected %s to be left out of the payload", setting)
+		}
+	}
+	if len(payload.Env) != 1 || payload.Env["API_KEY"] != "s3cr3t" {
+		t.Errorf("Expected only the copied env var to be signed, got %v", payload.Env)
+	}
+
+	again, _ := buildDeployPayload(cfg)
+	if string(again) != string(data) {
+		t.Error("Expected the payload to render the same bytes every time")
+	}
+}
+
+func TestVerifyDeployPayload(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKey := writePEM(t, "PUBLIC KEY", der)
+
+	cfg := &EnvConfig{FunctionName: "fn", FunctionImage: "registry/fn:v1", DeployPayloadPublicKey: publicKey}
+	payload, err := buildDeployPayload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DeployPayloadSignature = base64.StdEncoding.EncodeToString(signPayload(t, key, payload))
+	if err := verifyDeployPayload(cfg); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+
+	cfg.FunctionImage = "registry/evil:v1"
+	if err := verifyDeployPayload(cfg); err == nil {
+		t.Error("Expected a changed image to be rejected")
+	}
+
+	for _, tc := range []struct {
+		name string
+		cfg  *EnvConfig
+	}{
+		{"unsigned", &EnvConfig{DeployPayloadPublicKey: publicKey}},
+		{"no key", &EnvConfig{DeployPayloadSignature: "c2ln"}},
+		{"not base64", &EnvConfig{DeployPayloadPublicKey: publicKey, DeployPayloadSignature: "!"}},
+	} {
+		if err := verifyDeployPayload(tc.cfg); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	if err := verifyDeployPayload(&EnvConfig{}); err != nil {
+		t.Errorf("Expected no verification without a key, got %v", err)
+	}
+}
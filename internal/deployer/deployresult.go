@@ -0,0 +1,141 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// deployResultConfigMapName is the ConfigMap holding the result of the
+// latest deploy of the function, with DEPLOY_RESULT_CONFIGMAP.
+func deployResultConfigMapName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-deploy-result"
+}
+
+// saveResult writes the result ConfigMap when DEPLOY_RESULT_CONFIGMAP asks
+// for it. A deploy that failed before it had a client has none.
+func (d *deployment) saveResult(ctx context.Context, msg report.TerminationMessage) {
+	if d.cfg.DeployResultConfigMap != "true" || d.client == nil || d.cfg.ReadOnly == "true" {
+		return
+	}
+	if err := saveDeployResult(ctx, d.client, d.cfg, msg, d.started, time.Now()); err != nil {
+		warnf("failed to save deploy result: %v\n", err)
+	}
+}
+
+// saveDeployResult writes the outcome of the deploy to the result
+// ConfigMap, so components in the cluster read it without going through
+// the pod of the Job. The ConfigMap is owned by the KDexFunction, or by the
+// Service for a deploy without one, to go away with the function.
+func saveDeployResult(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, msg report.TerminationMessage, started, finished time.Time) error {
+	owner, err := deployResultOwner(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	redact := outputRedactor().String
+	result := report.Result{
+		Status:      msg.Outcome,
+		URL:         msg.URL,
+		Revision:    msg.LatestReadyRevision,
+		Generation:  msg.Generation,
+		StartedAt:   started.UTC().Format(time.RFC3339),
+		FinishedAt:  finished.UTC().Format(time.RFC3339),
+		FunctionURL: redact(msg.FunctionURL),
+		CustomURL:   redact(msg.CustomURL),
+		DeployID:    redact(msg.DeployID),
+		ImageDigest: redact(msg.ImageDigest),
+		TimeToReady: redact(msg.TimeToReady),
+		FailedPhase: report.Phase(redact(string(msg.FailedPhase))),
+		Reason:      redact(msg.Reason),
+		Error:       redact(msg.Error),
+	}
+	if result.Status == "" {
+		result.Status = report.PhaseSucceeded
+	}
+	if result.Revision == "" {
+		result.Revision = msg.Revision
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy result: %w", err)
+	}
+	data := map[string]any{}
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return err
+	}
+
+	configMap := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":      deployResultConfigMapName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"data": data,
+		},
+	}
+	if owner != nil {
+		configMap.SetOwnerReferences([]metav1.OwnerReference{
+			{
+				APIVersion: owner.GetAPIVersion(),
+				Kind:       owner.GetKind(),
+				Name:       owner.GetName(),
+				UID:        owner.GetUID(),
+			},
+		})
+	}
+
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy result config map: %w", err)
+	}
+
+	// Fields of a previous result the deploy has nothing for are dropped,
+	// as the apply owns every key it wrote
+	force := true
+	_, err = client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, configMap.GetName(), types.ApplyPatchType, body, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply deploy result config map: %w", err)
+	}
+	return nil
+}
+
+// deployResultOwner is the KDexFunction of the deploy, or its Service when
+// there is none. It is nil when neither exists, as when the first deploy
+// of a function fails before its Service is applied.
+func deployResultOwner(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (*unstructured.Unstructured, error) {
+	for _, r := range []struct {
+		resource    dynamic.NamespaceableResourceInterface
+		description string
+	}{
+		{client.Resource(kdexFunctionGVR), "kdex function"},
+		{client.Resource(knativeServiceGVR), "knative service"},
+	} {
+		owner, err := r.resource.Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err == nil {
+			return owner, nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get %s: %w", r.description, err)
+		}
+	}
+	return nil, nil
+}
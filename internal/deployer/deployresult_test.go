@@ -0,0 +1,78 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestSaveDeployResult(t *testing.T) {
+	ctx := context.Background()
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil)
+	function.SetUID(types.UID("function-uid"))
+	client := newFakeDynamicClient(function, newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil))
+	// The fake client cannot apply, so the applied config map is captured
+	configMap := &unstructured.Unstructured{}
+	client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType || patch.GetName() != "fn-deploy-result" {
+			t.Errorf("Unexpected %s patch of %s", patch.GetPatchType(), patch.GetName())
+		}
+		return true, configMap, json.Unmarshal(patch.GetPatch(), &configMap.Object)
+	})
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionGeneration: "7"}
+	started := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	msg := report.TerminationMessage{
+		URL:                 "http://fn.ns.example.com",
+		LatestReadyRevision: "fn-00007",
+		Generation:          "7",
+		DeployID:            "abc",
+	}
+	if err := saveDeployResult(ctx, client, cfg, msg, started, started.Add(90*time.Second)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+	for key, want := range map[string]string{
+		"status":     "Succeeded",
+		"url":        "http://fn.ns.example.com",
+		"revision":   "fn-00007",
+		"generation": "7",
+		"deployId":   "abc",
+		"startedAt":  "2026-10-16T12:00:00Z",
+		"finishedAt": "2026-10-16T12:01:30Z",
+	} {
+		if data[key] != want {
+			t.Errorf("Expected %s %q, got %v", key, want, data[key])
+		}
+	}
+	if _, ok := data["error"]; ok || len(data) == 0 {
+		t.Errorf("Expected the result without an error, got %v", data)
+	}
+	owners := configMap.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Kind != "KDexFunction" || owners[0].UID != "function-uid" {
+		t.Errorf("Expected the config map to be owned by the kdex function, got %v", owners)
+	}
+}
+
+func TestDeployResultOwnerFallsBackToService(t *testing.T) {
+	client := newFakeDynamicClient(newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil))
+	owner, err := deployResultOwner(context.Background(), client, &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"})
+	if err != nil || owner == nil || owner.GetKind() != "Service" {
+		t.Errorf("Expected the service to own the result, got %v (%v)", owner, err)
+	}
+
+	owner, err = deployResultOwner(context.Background(), newFakeDynamicClient(), &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"})
+	if err != nil || owner != nil {
+		t.Errorf("Expected no owner, got %v (%v)", owner, err)
+	}
+}
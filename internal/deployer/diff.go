@@ -0,0 +1,286 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	changeAdded    = "+"
+	changeRemoved  = "-"
+	changeModified = "~"
+)
+
+// fieldChange is one field a deploy would change on the live Service.
+type fieldChange struct {
+	Op      string
+	Path    string
+	Live    any
+	Desired any
+}
+
+func runDiff() error {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	if cfg.FunctionImage == "" {
+		return fmt.Errorf("FUNCTION_IMAGE is required for a diff; source builds are not run")
+	}
+
+	resolvePreviewRuntime(context.Background(), cfg)
+
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	changes, err := diffService(context.Background(), client, cfg)
+	if err != nil {
+		return err
+	}
+	revision, err := diffRevision(context.Background(), client, cfg)
+	if err != nil {
+		return err
+	}
+
+	out := outputRedactor().Writer(os.Stdout)
+	if err := printChanges(out, changes); err != nil {
+		return err
+	}
+	return printRevisionDiff(out, revision)
+}
+
+// diffService compares the Service a deploy would apply with the live one.
+// Only fields the deploy sets, or that the deployer owns through server-side
+// apply, are compared; fields defaulted by the server or owned by other
+// managers are left out.
+func diffService(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) ([]fieldChange, error) {
+	resourceClient := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+
+	var live map[string]any
+	state := serviceState{}
+	existing, err := resourceClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get knative service: %w", err)
+		}
+	} else {
+		state = serviceStateOf(existing)
+		live = existing.Object
+	}
+
+	service, err := buildService(cfg, state)
+	if err != nil {
+		return nil, err
+	}
+
+	desired, err := normalizeObject(service.Object)
+	if err != nil {
+		return nil, err
+	}
+	if live == nil {
+		return []fieldChange{{Op: changeAdded, Path: "", Desired: desired}}, nil
+	}
+
+	changes := []fieldChange{}
+	diffValues("", desired, live, &changes)
+
+	// Fields the deployer applied before but no longer sets would be
+	// removed by the next apply
+	for _, path := range ownedFieldPaths(existing, "kdex-knative-deployer") {
+		if _, found, _ := unstructured.NestedFieldNoCopy(desired, path...); found {
+			continue
+		}
+		// Every deploy sets its own ID; only diffs run with DEPLOY_ID compare it
+		if strings.Join(path, ".") == "metadata.annotations."+deployIDAnnotation {
+			continue
+		}
+		value, found, _ := unstructured.NestedFieldNoCopy(live, path...)
+		if !found {
+			continue
+		}
+		changes = append(changes, fieldChange{Op: changeRemoved, Path: strings.Join(path, "."), Live: value})
+	}
+
+	return changes, nil
+}
+
+// normalizeObject round-trips an object through JSON so typed slices and
+// integers compare equal to their decoded live counterparts.
+func normalizeObject(obj map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// diffValues records how live differs from desired at path. Live is
+// normalized lazily, as it decodes integers as int64.
+func diffValues(path string, desired, live any, changes *[]fieldChange) {
+	switch d := desired.(type) {
+	case map[string]any:
+		l, ok := live.(map[string]any)
+		if !ok {
+			*changes = append(*changes, fieldChange{Op: changeModified, Path: path, Live: live, Desired: desired})
+			return
+		}
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			child := joinPath(path, k)
+			lv, found := l[k]
+			if !found {
+				*changes = append(*changes, fieldChange{Op: changeAdded, Path: child, Desired: d[k]})
+				continue
+			}
+			diffValues(child, d[k], lv, changes)
+		}
+	case []any:
+		l, ok := live.([]any)
+		if !ok {
+			*changes = append(*changes, fieldChange{Op: changeModified, Path: path, Live: live, Desired: desired})
+			return
+		}
+		for i := range d {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			if i >= len(l) {
+				*changes = append(*changes, fieldChange{Op: changeAdded, Path: child, Desired: d[i]})
+				continue
+			}
+			diffValues(child, d[i], l[i], changes)
+		}
+		for i := len(d); i < len(l); i++ {
+			*changes = append(*changes, fieldChange{Op: changeRemoved, Path: fmt.Sprintf("%s[%d]", path, i), Live: l[i]})
+		}
+	default:
+		if !reflect.DeepEqual(desired, normalizeScalar(live)) {
+			*changes = append(*changes, fieldChange{Op: changeModified, Path: path, Live: live, Desired: desired})
+		}
+	}
+}
+
+func normalizeScalar(v any) any {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	default:
+		return v
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// ownedFieldPaths lists the map fields the manager owns through apply, read
+// from managedFields. Lists count as a single field.
+func ownedFieldPaths(obj *unstructured.Unstructured, manager string) [][]string {
+	paths := [][]string{}
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager != manager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		collectFieldPaths(nil, fields, &paths)
+	}
+	slices.SortFunc(paths, func(a, b []string) int {
+		return slices.Compare(a, b)
+	})
+	return paths
+}
+
+func collectFieldPaths(prefix []string, fields map[string]any, paths *[][]string) {
+	children := 0
+	for key, value := range fields {
+		name, ok := strings.CutPrefix(key, "f:")
+		if !ok {
+			continue
+		}
+		children++
+		path := append(append([]string{}, prefix...), name)
+		nested, _ := value.(map[string]any)
+		if isListFieldSet(nested) {
+			*paths = append(*paths, path)
+			continue
+		}
+		collectFieldPaths(path, nested, paths)
+	}
+	if children == 0 && len(prefix) > 0 {
+		*paths = append(*paths, prefix)
+	}
+}
+
+// isListFieldSet tells whether a fieldsV1 set describes list items, which
+// are keyed with k:, v: or i: rather than f:.
+func isListFieldSet(fields map[string]any) bool {
+	for key := range fields {
+		if strings.HasPrefix(key, "k:") || strings.HasPrefix(key, "v:") || strings.HasPrefix(key, "i:") {
+			return true
+		}
+	}
+	return false
+}
+
+func printChanges(w io.Writer, changes []fieldChange) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+	r := outputRedactor()
+	for _, c := range changes {
+		c.Live, c.Desired = r.Object(c.Live), r.Object(c.Desired)
+		var line string
+		switch c.Op {
+		case changeAdded:
+			if c.Path == "" {
+				line = "+ service does not exist and would be created"
+			} else {
+				line = fmt.Sprintf("+ %s: %s", c.Path, compactJSON(c.Desired))
+			}
+		case changeRemoved:
+			line = fmt.Sprintf("- %s: %s", c.Path, compactJSON(c.Live))
+		default:
+			line = fmt.Sprintf("~ %s: %s -> %s", c.Path, compactJSON(c.Live), compactJSON(c.Desired))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
@@ -0,0 +1,125 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiffValues(t *testing.T) {
+	desired := map[string]any{
+		"spec": map[string]any{
+			"image":   "new",
+			"port":    float64(8080),
+			"env":     []any{map[string]any{"name": "A", "value": "1"}},
+			"timeout": float64(30),
+		},
+	}
+	live := map[string]any{
+		"spec": map[string]any{
+			"image": "old",
+			"port":  int64(8080),
+			"env": []any{
+				map[string]any{"name": "A", "value": "1"},
+				map[string]any{"name": "B", "value": "2"},
+			},
+			"defaulted": true,
+		},
+	}
+
+	changes := []fieldChange{}
+	diffValues("", desired, live, &changes)
+
+	want := []fieldChange{
+		{Op: changeRemoved, Path: "spec.env[1]", Live: map[string]any{"name": "B", "value": "2"}},
+		{Op: changeModified, Path: "spec.image", Live: "old", Desired: "new"},
+		{Op: changeAdded, Path: "spec.timeout", Desired: float64(30)},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %+v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i].Op != want[i].Op || changes[i].Path != want[i].Path {
+			t.Errorf("Change %d: expected %s %s, got %s %s", i, want[i].Op, want[i].Path, changes[i].Op, changes[i].Path)
+		}
+	}
+}
+
+func TestOwnedFieldPaths(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	obj.SetManagedFields([]metav1.ManagedFieldsEntry{
+		{
+			Manager:   "kdex-knative-deployer",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:autoscaling.knative.dev/max-scale":{}}},` +
+				`"f:spec":{"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"\"}":{".":{}}}}}}}`)},
+		},
+		{
+			Manager:   "controller",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:url":{}}}`)},
+		},
+	})
+
+	got := map[string]bool{}
+	for _, path := range ownedFieldPaths(obj, "kdex-knative-deployer") {
+		got[strings.Join(path, ".")] = true
+	}
+	for _, want := range []string{"metadata.annotations.autoscaling.knative.dev/max-scale", "spec.template.spec.containers"} {
+		if !got[want] {
+			t.Errorf("Expected owned path %s, got %v", want, got)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected only the deployer's paths, got %v", got)
+	}
+}
+
+func TestDiffService(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimage:2", FunctionGeneration: "2"}
+
+	changes, err := diffService(context.Background(), newFakeDynamicClient(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Op != changeAdded || changes[0].Path != "" {
+		t.Errorf("Expected creation, got %+v", changes)
+	}
+
+	live, err := buildService(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimage:1", FunctionGeneration: "2", ScalingMaxScale: "5"}, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	liveObj, err := normalizeObject(live.Object)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	live.Object = liveObj
+	live.SetManagedFields([]metav1.ManagedFieldsEntry{{
+		Manager:   "kdex-knative-deployer",
+		Operation: metav1.ManagedFieldsOperationApply,
+		FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:autoscaling.knative.dev/max-scale":{}}}}`)},
+	}})
+
+	changes, err = diffService(context.Background(), newFakeDynamicClient(live), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := printChanges(&out, changes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`~ spec.template.spec.containers[0].image: "myimage:1" -> "myimage:2"`,
+		`- metadata.annotations.autoscaling.knative.dev/max-scale: "5"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in diff:\n%s", want, out.String())
+		}
+	}
+}
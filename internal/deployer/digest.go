@@ -0,0 +1,208 @@
+package deployer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Annotations on the revision template recording the image reference the
+// deploy was asked for and the digest it was pinned to.
+const (
+	imageTagAnnotation    = "kdex.dev/image-tag"
+	imageDigestAnnotation = "kdex.dev/image-digest"
+)
+
+var (
+	secretGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "secrets",
+	}
+
+	serviceAccountGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "serviceaccounts",
+	}
+)
+
+// validateImageDigest checks that FUNCTION_IMAGE_DIGEST, when given, is a
+// digest such as sha256:...
+func validateImageDigest(cfg *EnvConfig) error {
+	if cfg.FunctionImageDigest == "" {
+		return nil
+	}
+	if _, err := v1.NewHash(cfg.FunctionImageDigest); err != nil {
+		return fmt.Errorf("invalid FUNCTION_IMAGE_DIGEST %q: expected algorithm:hex", cfg.FunctionImageDigest)
+	}
+	return nil
+}
+
+// pinnedImage is the image the revision runs: FUNCTION_IMAGE with its tag
+// replaced by FUNCTION_IMAGE_DIGEST when one is set.
+func pinnedImage(cfg *EnvConfig) string {
+	if cfg.FunctionImageDigest == "" {
+		return cfg.FunctionImage
+	}
+	repository, _, _ := strings.Cut(cfg.FunctionImage, "@")
+	// A colon after the last slash starts the tag, not a registry port
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return repository + "@" + cfg.FunctionImageDigest
+}
+
+// revisionImageDigest is the digest Knative resolved the image of the
+// revision to, that of its first container serving with several, empty when
+// it did not resolve it.
+func revisionImageDigest(revision *unstructured.Unstructured) string {
+	statuses, _, _ := unstructured.NestedSlice(revision.Object, "status", "containerStatuses")
+	for _, s := range statuses {
+		status, _ := s.(map[string]any)
+		image, _ := status["imageDigest"].(string)
+		if _, digest, ok := strings.Cut(image, "@"); ok {
+			return digest
+		}
+	}
+	return ""
+}
+
+// resolveImageDigest looks up the digest image currently points to. An
+// image already given by digest resolves to that digest.
+func resolveImageDigest(ctx context.Context, keychain authn.Keychain, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", image, err)
+	}
+	if digest, ok := ref.(name.Digest); ok {
+		return digest.DigestStr(), nil
+	}
+
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve digest of %s: %w", image, err)
+	}
+	return desc.Digest.String(), nil
+}
+
+// imageKeychain returns the credentials to reach the function image with:
+// the pull secrets the revision would use, then those of the deployer.
+func imageKeychain(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (authn.Keychain, error) {
+	keychain, err := pullSecretKeychain(ctx, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return authn.NewMultiKeychain(keychain, authn.DefaultKeychain), nil
+}
+
+// pullSecretKeychain returns the registry credentials in the image pull
+// secrets of the service account the revision runs as, like the kubelet
+// pulling the image would use.
+func pullSecretKeychain(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (authn.Keychain, error) {
+	serviceAccount := cfg.FunctionServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	account, err := client.Resource(serviceAccountGVR).Namespace(cfg.FunctionNamespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return dockerConfigKeychain{}, nil
+		}
+		return nil, fmt.Errorf("failed to get service account %s: %w", serviceAccount, err)
+	}
+
+	keychain := dockerConfigKeychain{}
+	pullSecrets, _, _ := unstructured.NestedSlice(account.Object, "imagePullSecrets")
+	for _, s := range pullSecrets {
+		secretName, _ := s.(map[string]any)["name"].(string)
+		if secretName == "" {
+			continue
+		}
+		secret, err := client.Resource(secretGVR).Namespace(cfg.FunctionNamespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			warnf("failed to read image pull secret %s: %v\n", secretName, err)
+			continue
+		}
+		if err := keychain.add(secret); err != nil {
+			warnf("ignoring image pull secret %s: %v\n", secretName, err)
+		}
+	}
+	return keychain, nil
+}
+
+// dockerConfigKeychain holds registry credentials from docker config pull
+// secrets, keyed by registry host.
+type dockerConfigKeychain map[string]authn.AuthConfig
+
+type dockerConfig struct {
+	Auths map[string]authn.AuthConfig `json:"auths"`
+}
+
+// add takes the credentials of a kubernetes.io/dockerconfigjson or
+// kubernetes.io/dockercfg secret. Credentials already known for a registry
+// win, following the order of the pull secrets.
+func (k dockerConfigKeychain) add(secret *unstructured.Unstructured) error {
+	data, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+	var auths map[string]authn.AuthConfig
+	if encoded, ok := data[".dockerconfigjson"]; ok {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		config := dockerConfig{}
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return err
+		}
+		auths = config.Auths
+	} else if encoded, ok := data[".dockercfg"]; ok {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("not a docker config secret")
+	}
+
+	for server, auth := range auths {
+		host := registryHost(server)
+		if _, ok := k[host]; !ok {
+			k[host] = auth
+		}
+	}
+	return nil
+}
+
+func (k dockerConfigKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	auth, ok := k[registryHost(resource.RegistryStr())]
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(auth), nil
+}
+
+// registryHost normalizes a docker config server key, which may be a URL
+// such as https://index.docker.io/v1/, to the registry host.
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "docker.io" || host == "registry-1.docker.io" {
+		return name.DefaultRegistry
+	}
+	return host
+}
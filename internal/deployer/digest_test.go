@@ -0,0 +1,127 @@
+package deployer
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPinnedImage(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := map[string]string{
+		"ghcr.io/kdex/fn:v1":             "ghcr.io/kdex/fn@" + digest,
+		"ghcr.io/kdex/fn":                "ghcr.io/kdex/fn@" + digest,
+		"localhost:5000/fn:latest":       "localhost:5000/fn@" + digest,
+		"localhost:5000/fn":              "localhost:5000/fn@" + digest,
+		"ghcr.io/kdex/fn@sha256:" + "b1": "ghcr.io/kdex/fn@" + digest,
+	}
+	for image, expected := range tests {
+		if got := pinnedImage(&EnvConfig{FunctionImage: image, FunctionImageDigest: digest}); got != expected {
+			t.Errorf("pinnedImage(%q) = %q, expected %q", image, got, expected)
+		}
+	}
+
+	if got := pinnedImage(&EnvConfig{FunctionImage: "ghcr.io/kdex/fn:v1"}); got != "ghcr.io/kdex/fn:v1" {
+		t.Errorf("Expected the image unchanged without a digest, got %q", got)
+	}
+
+	if err := validateImageDigest(&EnvConfig{FunctionImageDigest: "latest"}); err == nil {
+		t.Error("Expected an invalid digest to be rejected")
+	}
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	image := strings.TrimPrefix(server.URL, "http://") + "/kdex/fn:latest"
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeDynamicClient()
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: image}
+	keychain, err := imageKeychain(context.Background(), client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	digest, err := resolveImageDigest(context.Background(), keychain, image)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if digest != expected.String() {
+		t.Errorf("Expected digest %s, got %s", expected, digest)
+	}
+
+	cfg.FunctionImageDigest = digest
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	template := service.Object["spec"].(map[string]any)["template"].(map[string]any)
+	container := template["spec"].(map[string]any)["containers"].([]map[string]any)[0]
+	if container["image"] != strings.TrimSuffix(image, ":latest")+"@"+digest {
+		t.Errorf("Expected the digest reference, got %v", container["image"])
+	}
+	annotations := template["metadata"].(map[string]any)["annotations"].(map[string]any)
+	if annotations[imageTagAnnotation] != image || annotations[imageDigestAnnotation] != digest {
+		t.Errorf("Expected tag and digest annotations, got %v", annotations)
+	}
+}
+
+func TestPullSecretKeychain(t *testing.T) {
+	account := newObject("v1", "ServiceAccount", "myns", "builder", nil)
+	account.Object["imagePullSecrets"] = []any{
+		map[string]any{"name": "ghcr"},
+		map[string]any{"name": "missing"},
+	}
+	config := `{"auths":{"https://ghcr.io/v1/":{"username":"bot","password":"s3cret"}}}`
+	secret := newObject("v1", "Secret", "myns", "ghcr", nil)
+	secret.Object["type"] = "kubernetes.io/dockerconfigjson"
+	secret.Object["data"] = map[string]any{".dockerconfigjson": base64.StdEncoding.EncodeToString([]byte(config))}
+
+	client := newFakeDynamicClient(account, secret)
+	cfg := &EnvConfig{FunctionNamespace: "myns", FunctionServiceAccount: "builder"}
+	keychain, err := pullSecretKeychain(context.Background(), client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ref, _ := name.ParseReference("ghcr.io/kdex/fn:v1")
+	auth, err := keychain.Resolve(ref.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig, err := auth.Authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authConfig.Username != "bot" || authConfig.Password != "s3cret" {
+		t.Errorf("Expected the pull secret credentials, got %+v", authConfig)
+	}
+
+	other, _ := name.ParseReference("quay.io/kdex/fn:v1")
+	if auth, _ := keychain.Resolve(other.Context()); auth != authn.Anonymous {
+		t.Errorf("Expected anonymous access to other registries, got %v", auth)
+	}
+}
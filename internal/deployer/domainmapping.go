@@ -0,0 +1,127 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+var domainMappingGVR = schema.GroupVersionResource{
+	Group:    "serving.knative.dev",
+	Version:  "v1beta1",
+	Resource: "domainmappings",
+}
+
+// validateFunctionHost checks that FUNCTION_HOST, when given, is a host name
+// a DomainMapping can be named after.
+func validateFunctionHost(cfg *EnvConfig) error {
+	if cfg.FunctionHost == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(cfg.FunctionHost); len(errs) > 0 {
+		return fmt.Errorf("invalid FUNCTION_HOST %q: %s", cfg.FunctionHost, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// buildDomainMapping renders the DomainMapping binding FUNCTION_HOST to the
+// Knative Service, owned by the Service so it is removed along with it.
+// With FUNCTION_HOST_TLS_ISSUER it serves the certificate cert-manager
+// issued for the host.
+func buildDomainMapping(cfg *EnvConfig, service *unstructured.Unstructured) *unstructured.Unstructured {
+	mapping := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": domainMappingGVR.GroupVersion().String(),
+			"kind":       "DomainMapping",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionHost,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": map[string]any{
+				"ref": map[string]any{
+					"apiVersion": service.GetAPIVersion(),
+					"kind":       service.GetKind(),
+					"name":       service.GetName(),
+				},
+			},
+		},
+	}
+	if cfg.FunctionHostTLSIssuer != "" {
+		mapping.Object["spec"].(map[string]any)["tls"] = map[string]any{
+			"secretName": hostTLSSecretName(cfg),
+		}
+	}
+	mapping.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: service.GetAPIVersion(),
+			Kind:       service.GetKind(),
+			Name:       service.GetName(),
+			UID:        service.GetUID(),
+		},
+	})
+	return mapping
+}
+
+// mapDomain applies the DomainMapping for FUNCTION_HOST and waits for it to
+// become ready, returning the custom URL it serves the function at. The
+// certificate for the host, if any, is issued first so the mapping never
+// serves without it.
+func mapDomain(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, service *unstructured.Unstructured, timing waitTiming) (string, error) {
+	if cfg.FunctionHostTLSIssuer != "" {
+		if err := provisionCertificate(ctx, client, cfg, service, timing); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := json.Marshal(buildDomainMapping(cfg, service))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal domain mapping: %w", err)
+	}
+
+	mappings := client.Resource(domainMappingGVR).Namespace(cfg.FunctionNamespace)
+	force := true
+	_, err = mappings.Patch(ctx, cfg.FunctionHost, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to apply domain mapping: %w", err)
+	}
+
+	logf("Waiting for domain mapping %s to become ready\n", cfg.FunctionHost)
+	url, err := waitForReady(ctx, mappings, cfg.FunctionHost, timing, nil)
+	if err != nil {
+		return "", fmt.Errorf("domain mapping %s: %w", cfg.FunctionHost, err)
+	}
+	return url, nil
+}
+
+// recordCustomURL stores the URL of the DomainMapping on the KDexFunction
+// status, next to the URL of the Service.
+func recordCustomURL(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, url string) error {
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"customUrl": url,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	return err
+}
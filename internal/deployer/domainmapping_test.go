@@ -0,0 +1,59 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBuildDomainMapping(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "fn",
+		FunctionNamespace:  "default",
+		FunctionGeneration: "3",
+		FunctionHost:       "fn.example.com",
+	}
+	if err := validateFunctionHost(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := newObject("serving.knative.dev/v1", "Service", "default", "fn", nil)
+	service.SetUID(types.UID("1234"))
+
+	mapping := buildDomainMapping(cfg, service)
+	if mapping.GetName() != "fn.example.com" || mapping.GetNamespace() != "default" {
+		t.Errorf("Unexpected domain mapping %s/%s", mapping.GetNamespace(), mapping.GetName())
+	}
+	ref, _, _ := unstructured.NestedStringMap(mapping.Object, "spec", "ref")
+	if ref["name"] != "fn" || ref["kind"] != "Service" || ref["apiVersion"] != "serving.knative.dev/v1" {
+		t.Errorf("Unexpected ref: %v", ref)
+	}
+	owners := mapping.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].UID != "1234" {
+		t.Errorf("Expected the Service to own the domain mapping, got %v", owners)
+	}
+
+	if err := validateFunctionHost(&EnvConfig{FunctionHost: "https://fn.example.com"}); err == nil {
+		t.Error("Expected an error for a FUNCTION_HOST that is not a host name")
+	}
+}
+
+func TestWaitForDomainMapping(t *testing.T) {
+	mapping := newObject("serving.knative.dev/v1beta1", "DomainMapping", "default", "fn.example.com", nil)
+	mapping.Object["status"] = map[string]any{
+		"url": "https://fn.example.com",
+		"conditions": []any{
+			map[string]any{"type": "Ready", "status": "True"},
+		},
+	}
+	client := newFakeDynamicClient(mapping)
+
+	url, err := waitForReady(context.Background(), client.Resource(domainMappingGVR).Namespace("default"), "fn.example.com", defaultWaitTiming, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if url != "https://fn.example.com" {
+		t.Errorf("Expected the custom URL, got %q", url)
+	}
+}
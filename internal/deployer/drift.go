@@ -0,0 +1,99 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// Drift check modes selected by DRIFT_CHECK.
+const (
+	driftCheckOff  = "off"
+	driftCheckWarn = "warn"
+	driftCheckFail = "fail"
+)
+
+// driftFields are the KDexFunction spec fields the Job env is generated
+// from. An empty env value or a missing spec field is not compared.
+var driftFields = []struct {
+	env   string
+	path  []string
+	value func(cfg *EnvConfig) string
+}{
+	{"FUNCTION_IMAGE", []string{"spec", "image"}, func(cfg *EnvConfig) string { return cfg.FunctionImage }},
+	{"FUNCTION_BASEPATH", []string{"spec", "basePath"}, func(cfg *EnvConfig) string { return cfg.FunctionBasePath }},
+	{"FUNCTION_SOURCE_GIT", []string{"spec", "source", "git"}, func(cfg *EnvConfig) string { return cfg.FunctionSourceGit }},
+}
+
+func validateDriftCheck(cfg *EnvConfig) error {
+	switch cfg.DriftCheck {
+	case "", driftCheckOff, driftCheckWarn, driftCheckFail:
+		return nil
+	default:
+		return fmt.Errorf("unknown DRIFT_CHECK: %s", cfg.DriftCheck)
+	}
+}
+
+// findDrift lists where the Job env disagrees with the live KDexFunction.
+// A generation behind the function's means the Job was templated from an
+// older spec, whatever the individual fields say.
+func findDrift(cfg *EnvConfig, function *unstructured.Unstructured) []string {
+	drift := []string{}
+
+	if generation, err := strconv.ParseInt(cfg.FunctionGeneration, 10, 64); err == nil && generation != function.GetGeneration() {
+		drift = append(drift, fmt.Sprintf("FUNCTION_GENERATION is %d but the function is at generation %d", generation, function.GetGeneration()))
+	}
+
+	for _, f := range driftFields {
+		value := f.value(cfg)
+		if value == "" {
+			continue
+		}
+		spec, found, _ := unstructured.NestedString(function.Object, f.path...)
+		if !found || spec == value {
+			continue
+		}
+		drift = append(drift, fmt.Sprintf("%s is %q but %s is %q", f.env, value, strings.Join(f.path, "."), spec))
+	}
+
+	return drift
+}
+
+// checkDrift cross-checks the Job env against the live KDexFunction before
+// deploying. It warns by default; DRIFT_CHECK=fail refuses to deploy stale
+// parameters and DRIFT_CHECK=off skips the check.
+func checkDrift(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	mode := cfg.DriftCheck
+	if mode == "" {
+		mode = driftCheckWarn
+	}
+	if mode == driftCheckOff {
+		return nil
+	}
+
+	function, err := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		if mode == driftCheckFail {
+			return fmt.Errorf("failed to get kdex function for drift check: %w", err)
+		}
+		warnf("skipping drift check: %v\n", err)
+		return nil
+	}
+
+	drift := findDrift(cfg, function)
+	if len(drift) == 0 {
+		return nil
+	}
+	if mode == driftCheckFail {
+		return fmt.Errorf("job env drifted from the kdex function: %s", strings.Join(drift, "; "))
+	}
+	for _, d := range drift {
+		warnf("job env drifted from the kdex function: %s\n", d)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFindDrift(t *testing.T) {
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil)
+	function.SetGeneration(3)
+	function.Object["spec"] = map[string]any{
+		"image":    "registry.example.com/myfunc:3",
+		"basePath": "/api/myfunc",
+	}
+
+	cfg := &EnvConfig{
+		FunctionGeneration: "3",
+		FunctionImage:      "registry.example.com/myfunc:3",
+		FunctionBasePath:   "/api/myfunc",
+	}
+	if drift := findDrift(cfg, function); len(drift) != 0 {
+		t.Errorf("Expected no drift, got %v", drift)
+	}
+
+	cfg.FunctionGeneration = "2"
+	cfg.FunctionImage = "registry.example.com/myfunc:2"
+	cfg.FunctionSourceGit = "https://example.com/myfunc.git"
+	if drift := findDrift(cfg, function); len(drift) != 2 {
+		t.Errorf("Expected generation and image drift, got %v", drift)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil)
+	function.SetGeneration(3)
+	client := newFakeDynamicClient(function)
+
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionGeneration: "2"}
+	if err := checkDrift(context.Background(), client, cfg); err != nil {
+		t.Errorf("Expected only a warning by default, got %v", err)
+	}
+
+	cfg.DriftCheck = driftCheckFail
+	if err := checkDrift(context.Background(), client, cfg); err == nil {
+		t.Error("Expected error for a stale generation")
+	}
+
+	cfg.FunctionName = "missing"
+	if err := checkDrift(context.Background(), client, cfg); err == nil {
+		t.Error("Expected error for a missing function")
+	}
+
+	cfg.DriftCheck = driftCheckOff
+	if err := checkDrift(context.Background(), client, cfg); err != nil {
+		t.Errorf("Expected no check when off, got %v", err)
+	}
+}
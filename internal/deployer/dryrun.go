@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// dryRunClient renders the Service without contacting the cluster.
+	dryRunClient = "client"
+	// dryRunServer sends the Service through a server-side dry-run apply
+	// so admission validates it, and renders what the server returned.
+	dryRunServer = "server"
+)
+
+// dryRunFlag lets --dry-run be given bare, meaning client, or with a mode
+// as in --dry-run=server.
+type dryRunFlag string
+
+func (f *dryRunFlag) String() string { return string(*f) }
+
+func (f *dryRunFlag) Set(value string) error {
+	*f = dryRunFlag(value)
+	return nil
+}
+
+func (f *dryRunFlag) IsBoolFlag() bool { return true }
+
+// dryRunMode resolves --dry-run, falling back to DRY_RUN. It returns "" when
+// the deploy is for real.
+func dryRunMode(flagValue, env string) (string, error) {
+	value := flagValue
+	if value == "" {
+		value = env
+	}
+	switch value {
+	case "", "false":
+		return "", nil
+	case "true", dryRunClient:
+		return dryRunClient, nil
+	case dryRunServer:
+		return dryRunServer, nil
+	default:
+		return "", fmt.Errorf("invalid dry-run mode %q: expected client or server", value)
+	}
+}
+
+// runDryRun prints the Knative Service the deploy would apply. Progress goes
+// to stderr so stdout holds only the manifest.
+func runDryRun(ctx context.Context, cfg *EnvConfig, mode, output string) error {
+	if cfg.FunctionImage == "" {
+		return fmt.Errorf("FUNCTION_IMAGE is required for a dry run; source builds are not run")
+	}
+
+	resolvePreviewRuntime(ctx, cfg)
+
+	var client dynamic.Interface
+	if mode == dryRunServer {
+		c, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
+		client = c
+	}
+
+	return renderDryRun(ctx, client, cfg, mode, output, outputRedactor().Writer(os.Stdout))
+}
+
+// renderDryRun writes the Service as YAML or JSON. In server mode the
+// Service is built against the live state and dry-run applied.
+func renderDryRun(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, mode, output string, w io.Writer) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("invalid output %q: expected yaml or json", output)
+	}
+
+	state := serviceState{}
+	var resourceClient dynamic.ResourceInterface
+	if mode == dryRunServer {
+		resourceClient = client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+		if existing, err := resourceClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{}); err == nil {
+			state = serviceStateOf(existing)
+		}
+	}
+
+	service, err := buildService(cfg, state)
+	if err != nil {
+		return err
+	}
+
+	if mode == dryRunServer {
+		data, err := json.Marshal(service)
+		if err != nil {
+			return fmt.Errorf("failed to marshal service: %w", err)
+		}
+		force := true
+		service, err = resourceClient.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+			DryRun:       []string{metav1.DryRunAll},
+		})
+		if err != nil {
+			return fmt.Errorf("server-side dry run rejected the knative service: %w", err)
+		}
+		// Server bookkeeping only makes the preview noisy
+		unstructured.RemoveNestedField(service.Object, "metadata", "managedFields")
+	}
+
+	plan := outputRedactor().Object(service.Object)
+	var out []byte
+	if output == "json" {
+		out, err = json.MarshalIndent(plan, "", "  ")
+		out = append(out, '\n')
+	} else {
+		out, err = yaml.Marshal(plan)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,86 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestDryRunMode(t *testing.T) {
+	tests := []struct {
+		flag, env, want string
+	}{
+		{"", "", ""},
+		{"", "false", ""},
+		{"true", "", dryRunClient},
+		{"", "true", dryRunClient},
+		{"", "server", dryRunServer},
+		{"client", "server", dryRunClient},
+	}
+	for _, tt := range tests {
+		got, err := dryRunMode(tt.flag, tt.env)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", tt, err)
+		}
+		if got != tt.want {
+			t.Errorf("dryRunMode(%q, %q) = %q, want %q", tt.flag, tt.env, got, tt.want)
+		}
+	}
+
+	if _, err := dryRunMode("", "maybe"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestDryRunFlag(t *testing.T) {
+	for args, want := range map[string]string{
+		"--dry-run":        "true",
+		"--dry-run=server": "server",
+	} {
+		flags := flag.NewFlagSet("deploy", flag.ContinueOnError)
+		var dryRun dryRunFlag
+		flags.Var(&dryRun, "dry-run", "")
+		if err := flags.Parse([]string{args}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(dryRun) != want {
+			t.Errorf("%s: expected %q, got %q", args, want, dryRun)
+		}
+	}
+}
+
+func TestRenderDryRun(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionImage:      "registry.example.com/myfunc:1",
+		FunctionGeneration: "3",
+	}
+
+	var out bytes.Buffer
+	if err := renderDryRun(context.Background(), nil, cfg, dryRunClient, "yaml", &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "kind: Service") || !strings.Contains(out.String(), "image: registry.example.com/myfunc:1") {
+		t.Errorf("Unexpected yaml:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := renderDryRun(context.Background(), nil, cfg, dryRunClient, "json", &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var service map[string]any
+	if err := json.Unmarshal(out.Bytes(), &service); err != nil {
+		t.Fatalf("Expected json output: %v", err)
+	}
+	if service["kind"] != "Service" {
+		t.Errorf("Unexpected kind: %v", service["kind"])
+	}
+
+	if err := renderDryRun(context.Background(), nil, cfg, dryRunClient, "toml", &out); err == nil {
+		t.Error("Expected error for unknown output")
+	}
+}
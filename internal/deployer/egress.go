@@ -0,0 +1,267 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultEgressPort is the port of an EGRESS_ALLOW host given without one.
+const defaultEgressPort = 443
+
+var (
+	networkPolicyGVR = schema.GroupVersionResource{
+		Group:    "networking.k8s.io",
+		Version:  "v1",
+		Resource: "networkpolicies",
+	}
+
+	serviceEntryGVR = schema.GroupVersionResource{
+		Group:    "networking.istio.io",
+		Version:  "v1",
+		Resource: "serviceentries",
+	}
+
+	sidecarGVR = schema.GroupVersionResource{
+		Group:    "networking.istio.io",
+		Version:  "v1",
+		Resource: "sidecars",
+	}
+)
+
+// egressDestination is one entry of EGRESS_ALLOW: a CIDR, or a host name
+// that only Istio can tell apart, with an optional port.
+type egressDestination struct {
+	CIDR string
+	Host string
+	Port int64
+}
+
+// parseEgressAllow parses EGRESS_ALLOW, a comma separated list of CIDRs and
+// host names, each with an optional :port, such as
+// 10.0.0.0/8,api.stripe.com,db.example.com:5432. Hosts default to port 443;
+// CIDRs without a port allow every port.
+func parseEgressAllow(cfg *EnvConfig) ([]egressDestination, error) {
+	destinations := []egressDestination{}
+	for entry := range strings.SplitSeq(cfg.EgressAllow, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		d := egressDestination{}
+		address := entry
+		// IPv6 CIDRs have colons of their own, and no port
+		if host, port, ok := strings.Cut(entry, ":"); ok && strings.Count(entry, ":") == 1 {
+			p, err := strconv.ParseInt(port, 10, 64)
+			if err != nil || p < 1 || p > 65535 {
+				return nil, fmt.Errorf("invalid EGRESS_ALLOW %q: expected a port number after the colon", entry)
+			}
+			address, d.Port = host, p
+		}
+		if strings.Contains(address, "/") {
+			if _, network, err := net.ParseCIDR(address); err != nil {
+				return nil, fmt.Errorf("invalid EGRESS_ALLOW %q: expected a CIDR or a host name", entry)
+			} else {
+				d.CIDR = network.String()
+			}
+		} else {
+			if len(validation.IsDNS1123Subdomain(strings.TrimPrefix(address, "*."))) > 0 {
+				return nil, fmt.Errorf("invalid EGRESS_ALLOW %q: expected a CIDR or a host name", entry)
+			}
+			d.Host = address
+			if d.Port == 0 {
+				d.Port = defaultEgressPort
+			}
+		}
+		destinations = append(destinations, d)
+	}
+	return destinations, nil
+}
+
+// validateEgress checks EGRESS_ALLOW. Host names need EGRESS_ISTIO: a
+// NetworkPolicy only knows addresses.
+func validateEgress(cfg *EnvConfig) error {
+	destinations, err := parseEgressAllow(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.EgressIstio == "true" && len(destinations) == 0 {
+		return fmt.Errorf("EGRESS_ISTIO requires EGRESS_ALLOW")
+	}
+	for _, d := range destinations {
+		if d.Host != "" && cfg.EgressIstio != "true" {
+			return fmt.Errorf("EGRESS_ALLOW host %s requires EGRESS_ISTIO, as a NetworkPolicy cannot match host names", d.Host)
+		}
+	}
+	return nil
+}
+
+// buildEgressNetworkPolicy renders the NetworkPolicy limiting the egress
+// of the function's pods to DNS and the CIDRs of EGRESS_ALLOW. The ports of
+// hosts are open to any address, Istio enforcing which hosts they reach.
+func buildEgressNetworkPolicy(cfg *EnvConfig, destinations []egressDestination) *unstructured.Unstructured {
+	dnsPorts := []any{
+		map[string]any{"protocol": "UDP", "port": int64(53)},
+		map[string]any{"protocol": "TCP", "port": int64(53)},
+	}
+	egress := []any{
+		map[string]any{
+			"to": []any{map[string]any{
+				"namespaceSelector": map[string]any{},
+				"podSelector":       map[string]any{"matchLabels": map[string]any{"k8s-app": "kube-dns"}},
+			}},
+			"ports": dnsPorts,
+		},
+	}
+
+	hostPorts := map[int64]bool{}
+	for _, d := range destinations {
+		if d.Host != "" {
+			if !hostPorts[d.Port] {
+				hostPorts[d.Port] = true
+				egress = append(egress, map[string]any{
+					"ports": []any{map[string]any{"protocol": "TCP", "port": d.Port}},
+				})
+			}
+			continue
+		}
+		rule := map[string]any{
+			"to": []any{map[string]any{"ipBlock": map[string]any{"cidr": d.CIDR}}},
+		}
+		if d.Port != 0 {
+			rule["ports"] = []any{map[string]any{"protocol": "TCP", "port": d.Port}}
+		}
+		egress = append(egress, rule)
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": networkPolicyGVR.GroupVersion().String(),
+			"kind":       "NetworkPolicy",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"podSelector": functionWorkloadSelector(cfg),
+				"policyTypes": []any{"Egress"},
+				"egress":      egress,
+			},
+		},
+	}
+}
+
+// buildEgressServiceEntry registers the hosts of EGRESS_ALLOW with the
+// mesh, for the Sidecar to let the function reach them.
+func buildEgressServiceEntry(cfg *EnvConfig, destinations []egressDestination) *unstructured.Unstructured {
+	hosts := []any{}
+	ports := []any{}
+	seen := map[int64]bool{}
+	for _, d := range destinations {
+		if d.Host == "" {
+			continue
+		}
+		hosts = append(hosts, d.Host)
+		if !seen[d.Port] {
+			seen[d.Port] = true
+			protocol := "TLS"
+			if d.Port == 80 {
+				protocol = "HTTP"
+			}
+			ports = append(ports, map[string]any{
+				"number":   d.Port,
+				"name":     fmt.Sprintf("%s-%d", strings.ToLower(protocol), d.Port),
+				"protocol": protocol,
+			})
+		}
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": serviceEntryGVR.GroupVersion().String(),
+			"kind":       "ServiceEntry",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"hosts":      hosts,
+				"ports":      ports,
+				"location":   "MESH_EXTERNAL",
+				"resolution": "DNS",
+				// Only the function's Sidecar admits it
+				"exportTo": []any{"."},
+			},
+		},
+	}
+}
+
+// buildEgressSidecar renders the Sidecar limiting the function to the
+// services of the mesh it registered, its namespace's and Istio's own.
+func buildEgressSidecar(cfg *EnvConfig) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": sidecarGVR.GroupVersion().String(),
+			"kind":       "Sidecar",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"workloadSelector": map[string]any{
+					"labels": map[string]any{"serving.knative.dev/service": cfg.FunctionName},
+				},
+				"egress": []any{
+					map[string]any{"hosts": []any{"./*", "istio-system/*", "knative-serving/*"}},
+				},
+				"outboundTrafficPolicy": map[string]any{"mode": "REGISTRY_ONLY"},
+			},
+		},
+	}
+}
+
+// provisionEgress applies the egress policy of EGRESS_ALLOW before the
+// Service, so no revision runs unrestricted, or removes that of an earlier
+// deploy when it is unset.
+func provisionEgress(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	destinations, err := parseEgressAllow(cfg)
+	if err != nil {
+		return err
+	}
+	policies := []struct {
+		gvr     schema.GroupVersionResource
+		obj     *unstructured.Unstructured
+		enabled bool
+	}{
+		{networkPolicyGVR, buildEgressNetworkPolicy(cfg, destinations), len(destinations) > 0},
+		{serviceEntryGVR, buildEgressServiceEntry(cfg, destinations), cfg.EgressIstio == "true"},
+		{sidecarGVR, buildEgressSidecar(cfg), cfg.EgressIstio == "true"},
+	}
+
+	for _, p := range policies {
+		resourceClient := client.Resource(p.gvr).Namespace(cfg.FunctionNamespace)
+		if !p.enabled {
+			// The CRDs may not even be installed, which is fine
+			err := resourceClient.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s %s: %w", p.gvr.Resource, cfg.FunctionName, err)
+			}
+			continue
+		}
+
+		data, err := json.Marshal(p.obj)
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = resourceClient.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply %s: %w", p.gvr.Resource, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseEgressAllow(t *testing.T) {
+	cfg := &EnvConfig{EgressAllow: "10.0.0.1/8, api.stripe.com,db.example.com:5432,192.168.0.0/16:6379,2001:db8::/32"}
+	destinations, err := parseEgressAllow(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []egressDestination{
+		{CIDR: "10.0.0.0/8"},
+		{Host: "api.stripe.com", Port: 443},
+		{Host: "db.example.com", Port: 5432},
+		{CIDR: "192.168.0.0/16", Port: 6379},
+		{CIDR: "2001:db8::/32"},
+	}
+	if len(destinations) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, destinations)
+	}
+	for i := range want {
+		if destinations[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], destinations[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "api.stripe.com:https", "db:0", "not a host"} {
+		if _, err := parseEgressAllow(&EnvConfig{EgressAllow: value}); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestValidateEgress(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{EgressAllow: "10.0.0.0/8"}},
+		{cfg: EnvConfig{EgressAllow: "api.stripe.com"}, wantErr: true},
+		{cfg: EnvConfig{EgressAllow: "api.stripe.com", EgressIstio: "true"}},
+		{cfg: EnvConfig{EgressIstio: "true"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateEgress(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBuildEgressNetworkPolicy(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", EgressAllow: "10.0.0.0/8,api.stripe.com,hooks.stripe.com", EgressIstio: "true"}
+	destinations, _ := parseEgressAllow(cfg)
+
+	policy := buildEgressNetworkPolicy(cfg, destinations)
+	types, _, _ := unstructured.NestedStringSlice(policy.Object, "spec", "policyTypes")
+	if len(types) != 1 || types[0] != "Egress" {
+		t.Errorf("Expected an egress policy, got %v", types)
+	}
+	selector, _, _ := unstructured.NestedStringMap(policy.Object, "spec", "podSelector", "matchLabels")
+	if selector["serving.knative.dev/service"] != "fn" {
+		t.Errorf("Expected the policy scoped to the function, got %v", selector)
+	}
+	// DNS, the CIDR and port 443 once for both hosts
+	egress, _, _ := unstructured.NestedSlice(policy.Object, "spec", "egress")
+	if len(egress) != 3 {
+		t.Fatalf("Expected three egress rules, got %v", egress)
+	}
+	cidr, _, _ := unstructured.NestedString(egress[1].(map[string]any)["to"].([]any)[0].(map[string]any), "ipBlock", "cidr")
+	if cidr != "10.0.0.0/8" {
+		t.Errorf("Expected the CIDR allowed, got %v", egress[1])
+	}
+
+	entry := buildEgressServiceEntry(cfg, destinations)
+	hosts, _, _ := unstructured.NestedStringSlice(entry.Object, "spec", "hosts")
+	if len(hosts) != 2 || hosts[0] != "api.stripe.com" || hosts[1] != "hooks.stripe.com" {
+		t.Errorf("Expected the hosts registered, got %v", hosts)
+	}
+	ports, _, _ := unstructured.NestedSlice(entry.Object, "spec", "ports")
+	if len(ports) != 1 {
+		t.Errorf("Expected a single port, got %v", ports)
+	}
+
+	sidecar := buildEgressSidecar(cfg)
+	if mode, _, _ := unstructured.NestedString(sidecar.Object, "spec", "outboundTrafficPolicy", "mode"); mode != "REGISTRY_ONLY" {
+		t.Errorf("Expected registry only outbound traffic, got %s", mode)
+	}
+}
+
+func TestProvisionEgressRemoves(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(
+		newObject("networking.k8s.io/v1", "NetworkPolicy", "default", "fn", nil),
+		newObject("networking.istio.io/v1", "ServiceEntry", "default", "fn", nil),
+		newObject("networking.istio.io/v1", "Sidecar", "default", "fn", nil),
+	)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+
+	if err := provisionEgress(ctx, client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Resource(networkPolicyGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the network policy to be removed, got %v", err)
+	}
+	if _, err := client.Resource(serviceEntryGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the service entry to be removed, got %v", err)
+	}
+	if _, err := client.Resource(sidecarGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the sidecar to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// urlVar is the template variable holding the public URL of the function.
+// It is only known once the route is ready, so on first deploy it resolves
+// to an empty string and is filled in by a second apply.
+const urlVar = "FUNCTION_URL"
+
+// publicURLEnvVar carries the public URL of the function to the function
+// itself, injected according to PUBLIC_URL_INJECTION.
+const publicURLEnvVar = "KDEX_PUBLIC_URL"
+
+const (
+	publicURLInjectionNone      = "none"
+	publicURLInjectionEnv       = "env"
+	publicURLInjectionConfigMap = "configmap"
+)
+
+var configMapGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "configmaps",
+}
+
+// Sources a forwarded env var may reference instead of carrying its value.
+const (
+	envRefSecret    = "secret"
+	envRefConfigMap = "configmap"
+)
+
+// forwardedEnvVar is one entry of FORWARDED_ENV_VARS: either a NAME whose
+// value is copied from the Job env, or NAME=secret:name:key or
+// NAME=configmap:name:key, which the function reads from the referenced
+// object so secrets never appear in the Service spec.
+type forwardedEnvVar struct {
+	Name string
+	// Source is envRefSecret or envRefConfigMap for references, empty for
+	// copied values.
+	Source string
+	Object string
+	Key    string
+}
+
+// buildContainerEnv renders the env of the function container. Values may
+// reference ${FUNCTION_NAME}, ${FUNCTION_NAMESPACE}, ${FUNCTION_GENERATION},
+// ${FUNCTION_BASEPATH} and ${FUNCTION_URL}. FORWARDED_ENV_VARS must have
+// been validated.
+func buildContainerEnv(cfg *EnvConfig, url string) []map[string]any {
+	vars := templateVars(cfg, url)
+	containerEnv := []map[string]any{}
+
+	// Add forwarded env vars
+	forwarded, _ := parseForwardedEnvVars(cfg)
+	for _, v := range forwarded {
+		switch v.Source {
+		case envRefSecret:
+			containerEnv = append(containerEnv, map[string]any{
+				"name": v.Name,
+				"valueFrom": map[string]any{
+					"secretKeyRef": map[string]any{"name": v.Object, "key": v.Key},
+				},
+			})
+		case envRefConfigMap:
+			containerEnv = append(containerEnv, map[string]any{
+				"name": v.Name,
+				"valueFrom": map[string]any{
+					"configMapKeyRef": map[string]any{"name": v.Object, "key": v.Key},
+				},
+			})
+		default:
+			containerEnv = append(containerEnv, map[string]any{
+				"name":  v.Name,
+				"value": expandTemplate(forwardedValue(cfg, v.Name), vars),
+			})
+		}
+	}
+
+	if cfg.PublicURLInjection == publicURLInjectionEnv && url != "" {
+		containerEnv = append(containerEnv, map[string]any{
+			"name":  publicURLEnvVar,
+			"value": url,
+		})
+	}
+
+	containerEnv = append(containerEnv, tracingEnv(cfg)...)
+	containerEnv = append(containerEnv, logLevelEnv(cfg)...)
+	containerEnv = append(containerEnv, logSinkEnv(cfg)...)
+	containerEnv = append(containerEnv, messageLimitsEnv(cfg)...)
+
+	return normalizeEnv(containerEnv)
+}
+
+// normalizeEnv sorts env vars by name and drops repeated names, keeping the
+// first, so equivalent configs render identical specs and apply without
+// churn. Order carries no meaning: templates are expanded here rather than
+// through Kubernetes $(VAR) references.
+func normalizeEnv(env []map[string]any) []map[string]any {
+	seen := map[string]bool{}
+	out := make([]map[string]any, 0, len(env))
+	for _, e := range env {
+		name, _ := e["name"].(string)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, e)
+	}
+	slices.SortStableFunc(out, func(a, b map[string]any) int {
+		return strings.Compare(a["name"].(string), b["name"].(string))
+	})
+	return out
+}
+
+// buildEnvFrom renders the envFrom of the function container from
+// ENV_FROM_CONFIGMAPS and ENV_FROM_SECRETS, comma separated lists of object
+// names, each optionally given as PREFIX=name to prefix the keys it
+// exports, e.g. "app-config,DB_=db-credentials".
+func buildEnvFrom(cfg *EnvConfig) ([]map[string]any, error) {
+	envFrom := []map[string]any{}
+	for _, source := range []struct {
+		env   string
+		value string
+		field string
+	}{
+		{"ENV_FROM_CONFIGMAPS", cfg.EnvFromConfigMaps, "configMapRef"},
+		{"ENV_FROM_SECRETS", cfg.EnvFromSecrets, "secretRef"},
+	} {
+		seen := map[string]bool{}
+		for entry := range strings.SplitSeq(source.value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			prefix, name, hasPrefix := strings.Cut(entry, "=")
+			if !hasPrefix {
+				prefix, name = "", entry
+			}
+			prefix, name = strings.TrimSpace(prefix), strings.TrimSpace(name)
+			if name == "" || (hasPrefix && !envPrefixPattern.MatchString(prefix)) {
+				return nil, fmt.Errorf("invalid %s entry %q: expected name or PREFIX=name", source.env, entry)
+			}
+			if seen[prefix+"="+name] {
+				continue
+			}
+			seen[prefix+"="+name] = true
+
+			ref := map[string]any{
+				source.field: map[string]any{"name": name},
+			}
+			if prefix != "" {
+				ref["prefix"] = prefix
+			}
+			envFrom = append(envFrom, ref)
+		}
+	}
+	return envFrom, nil
+}
+
+var envPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// forwardedEnvVars lists the names of the forwarded env vars, whether
+// copied or referenced.
+func forwardedEnvVars(cfg *EnvConfig) []string {
+	names := []string{}
+	if cfg.ForwardedEnvVars == "" {
+		return names
+	}
+	for v := range strings.SplitSeq(cfg.ForwardedEnvVars, ",") {
+		name, _, _ := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseForwardedEnvVars parses FORWARDED_ENV_VARS, a comma separated list
+// of NAME or NAME=secret:name:key and NAME=configmap:name:key references.
+// Entries that fail to parse are left out of the result.
+func parseForwardedEnvVars(cfg *EnvConfig) ([]forwardedEnvVar, error) {
+	vars := []forwardedEnvVar{}
+	var errs []string
+	for entry := range strings.SplitSeq(cfg.ForwardedEnvVars, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, ref, isRef := strings.Cut(entry, "=")
+		v := forwardedEnvVar{Name: strings.TrimSpace(name)}
+		if isRef {
+			parts := strings.Split(strings.TrimSpace(ref), ":")
+			if len(parts) != 3 || (parts[0] != envRefSecret && parts[0] != envRefConfigMap) || parts[1] == "" || parts[2] == "" {
+				errs = append(errs, fmt.Sprintf("%q: expected NAME, NAME=secret:name:key or NAME=configmap:name:key", entry))
+				continue
+			}
+			v.Source, v.Object, v.Key = parts[0], parts[1], parts[2]
+		}
+		if v.Name == "" {
+			errs = append(errs, fmt.Sprintf("%q: missing name", entry))
+			continue
+		}
+		vars = append(vars, v)
+	}
+	if len(errs) > 0 {
+		return vars, fmt.Errorf("invalid FORWARDED_ENV_VARS entries %s", strings.Join(errs, ", "))
+	}
+	return vars, nil
+}
+
+// forwardedValue is the value of the forwarded env var name: the one the
+// KDexFunction gives, or else the one in the env of the deployer.
+func forwardedValue(cfg *EnvConfig, name string) string {
+	if value, ok := cfg.FunctionEnv[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func validateForwardedEnvVars(cfg *EnvConfig) error {
+	_, err := parseForwardedEnvVars(cfg)
+	return err
+}
+
+func templateVars(cfg *EnvConfig, url string) map[string]string {
+	return map[string]string{
+		"FUNCTION_BASEPATH":   cfg.FunctionBasePath,
+		"FUNCTION_GENERATION": cfg.FunctionGeneration,
+		"FUNCTION_NAME":       cfg.FunctionName,
+		"FUNCTION_NAMESPACE":  cfg.FunctionNamespace,
+		urlVar:                url,
+	}
+}
+
+var templateRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandTemplate replaces ${VAR} references with their values. Unknown
+// references and bare $VAR are left untouched so values meant for the
+// function's own shell expansion survive.
+func expandTemplate(value string, vars map[string]string) string {
+	return templateRef.ReplaceAllStringFunc(value, func(ref string) string {
+		if v, ok := vars[ref[2:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// envReferencesURL reports whether the container env depends on the
+// function URL, which requires a second apply once the URL is known.
+func envReferencesURL(cfg *EnvConfig) bool {
+	if cfg.PublicURLInjection == publicURLInjectionEnv {
+		return true
+	}
+	forwarded, _ := parseForwardedEnvVars(cfg)
+	for _, v := range forwarded {
+		if v.Source == "" && strings.Contains(forwardedValue(cfg, v.Name), "${"+urlVar+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePublicURLInjection(cfg *EnvConfig) error {
+	switch cfg.PublicURLInjection {
+	case "", publicURLInjectionNone, publicURLInjectionEnv, publicURLInjectionConfigMap:
+		return nil
+	default:
+		return fmt.Errorf("unknown PUBLIC_URL_INJECTION: %s", cfg.PublicURLInjection)
+	}
+}
+
+// publicURLConfigMapName is the ConfigMap holding the public URL when
+// PUBLIC_URL_INJECTION=configmap. Functions watch it instead of receiving
+// the URL in their env, which avoids a second revision.
+func publicURLConfigMapName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-public-url"
+}
+
+// writePublicURLConfigMap applies the public URL ConfigMap, owned by the
+// Knative Service so it is removed along with it.
+func writePublicURLConfigMap(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, service *unstructured.Unstructured, url string) error {
+	configMap := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":      publicURLConfigMapName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"data": map[string]any{
+				publicURLEnvVar: url,
+			},
+		},
+	}
+	configMap.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: service.GetAPIVersion(),
+			Kind:       service.GetKind(),
+			Name:       service.GetName(),
+			UID:        service.GetUID(),
+		},
+	})
+
+	data, err := json.Marshal(configMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal public url config map: %w", err)
+	}
+
+	force := true
+	_, err = client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, configMap.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply public url config map: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package deployer
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := map[string]string{
+		"FUNCTION_NAME": "myfunc",
+		"FUNCTION_URL":  "http://myfunc.myns.example.com",
+	}
+
+	tests := map[string]string{
+		"plain":                       "plain",
+		"${FUNCTION_NAME}":            "myfunc",
+		"${FUNCTION_URL}/callback":    "http://myfunc.myns.example.com/callback",
+		"${UNKNOWN}-${FUNCTION_NAME}": "${UNKNOWN}-myfunc",
+		"pa$FUNCTION_NAME":            "pa$FUNCTION_NAME",
+	}
+
+	for value, want := range tests {
+		if got := expandTemplate(value, vars); got != want {
+			t.Errorf("expandTemplate(%q): expected %q, got %q", value, want, got)
+		}
+	}
+}
+
+func TestBuildContainerEnv(t *testing.T) {
+	t.Cleanup(func() {
+		os.Clearenv()
+	})
+
+	os.Clearenv()
+	_ = os.Setenv("CALLBACK", "${FUNCTION_URL}/hook")
+	_ = os.Setenv("SERVICE", "${FUNCTION_NAME}.${FUNCTION_NAMESPACE}")
+
+	cfg := &EnvConfig{
+		ForwardedEnvVars:  "CALLBACK, SERVICE,",
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+	}
+
+	if !envReferencesURL(cfg) {
+		t.Error("Expected env to reference the function URL")
+	}
+
+	env := buildContainerEnv(cfg, "")
+	if len(env) != 2 {
+		t.Fatalf("Expected 2 env vars, got %d", len(env))
+	}
+	if env[0]["value"] != "/hook" {
+		t.Errorf("Expected unresolved URL to be empty, got %v", env[0]["value"])
+	}
+	if env[1]["value"] != "myfunc.myns" {
+		t.Errorf("Unexpected value: %v", env[1]["value"])
+	}
+
+	env = buildContainerEnv(cfg, "http://myfunc.myns.example.com")
+	if env[0]["value"] != "http://myfunc.myns.example.com/hook" {
+		t.Errorf("Unexpected value: %v", env[0]["value"])
+	}
+
+	cfg.ForwardedEnvVars = "SERVICE"
+	if envReferencesURL(cfg) {
+		t.Error("Expected env not to reference the function URL")
+	}
+}
+
+func TestPublicURLInjection(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		PublicURLInjection: publicURLInjectionEnv,
+	}
+
+	if err := validatePublicURLInjection(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !envReferencesURL(cfg) {
+		t.Error("Expected env strategy to require the function URL")
+	}
+
+	if env := buildContainerEnv(cfg, ""); len(env) != 0 {
+		t.Errorf("Expected no %s before the URL is known, got %v", publicURLEnvVar, env)
+	}
+
+	env := buildContainerEnv(cfg, "http://myfunc.myns.example.com")
+	if len(env) != 1 || env[0]["name"] != publicURLEnvVar || env[0]["value"] != "http://myfunc.myns.example.com" {
+		t.Errorf("Unexpected env: %v", env)
+	}
+
+	cfg.PublicURLInjection = publicURLInjectionConfigMap
+	if envReferencesURL(cfg) {
+		t.Error("Expected configmap strategy not to require a second apply")
+	}
+	if env := buildContainerEnv(cfg, "http://myfunc.myns.example.com"); len(env) != 0 {
+		t.Errorf("Expected no env for configmap strategy, got %v", env)
+	}
+
+	cfg.PublicURLInjection = "carrier-pigeon"
+	if err := validatePublicURLInjection(cfg); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestNormalizeEnv(t *testing.T) {
+	env := normalizeEnv([]map[string]any{
+		{"name": "ZETA", "value": "1"},
+		{"name": "ALPHA", "value": "forwarded"},
+		{"name": "ALPHA", "value": "injected"},
+	})
+	if len(env) != 2 || env[0]["name"] != "ALPHA" || env[0]["value"] != "forwarded" || env[1]["name"] != "ZETA" {
+		t.Errorf("Expected sorted env keeping the first value, got %v", env)
+	}
+}
+
+func TestBuildServiceStableSerialization(t *testing.T) {
+	t.Cleanup(func() {
+		os.Clearenv()
+	})
+	os.Clearenv()
+	_ = os.Setenv("B", "2")
+	_ = os.Setenv("A", "1")
+
+	render := func(forwarded string) string {
+		cfg := &EnvConfig{
+			FunctionName:      "myfunc",
+			FunctionNamespace: "myns",
+			FunctionImage:     "myimage",
+			ForwardedEnvVars:  forwarded,
+			ScalingMaxScale:   "5",
+			ScalingMinScale:   "1",
+		}
+		service, err := buildService(cfg, serviceState{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		data, err := json.Marshal(service)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return string(data)
+	}
+
+	if render("A,B") != render("B,A") {
+		t.Error("Expected the same spec regardless of FORWARDED_ENV_VARS order")
+	}
+}
+
+func TestForwardedEnvVarReferences(t *testing.T) {
+	t.Cleanup(func() {
+		os.Clearenv()
+	})
+
+	os.Clearenv()
+	_ = os.Setenv("DB_PASSWORD", "plaintext")
+	_ = os.Setenv("MODE", "fast")
+
+	cfg := &EnvConfig{
+		ForwardedEnvVars:  "MODE, DB_PASSWORD=secret:db:password, CFG=configmap:app-config:settings",
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+	}
+	if err := validateForwardedEnvVars(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	env := buildContainerEnv(cfg, "")
+	want := []map[string]any{
+		{"name": "CFG", "valueFrom": map[string]any{"configMapKeyRef": map[string]any{"name": "app-config", "key": "settings"}}},
+		{"name": "DB_PASSWORD", "valueFrom": map[string]any{"secretKeyRef": map[string]any{"name": "db", "key": "password"}}},
+		{"name": "MODE", "value": "fast"},
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("Expected %v, got %v", want, env)
+	}
+
+	for _, forwarded := range []string{
+		"DB_PASSWORD=vault:db:password",
+		"DB_PASSWORD=secret:db",
+		"DB_PASSWORD=secret::password",
+		"=secret:db:password",
+	} {
+		if err := validateForwardedEnvVars(&EnvConfig{ForwardedEnvVars: forwarded}); err == nil {
+			t.Errorf("Expected error for %q", forwarded)
+		}
+	}
+}
+
+func TestBuildEnvFrom(t *testing.T) {
+	envFrom, err := buildEnvFrom(&EnvConfig{
+		EnvFromConfigMaps: "app-config, app-config",
+		EnvFromSecrets:    "DB_=db-credentials,",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []map[string]any{
+		{"configMapRef": map[string]any{"name": "app-config"}},
+		{"secretRef": map[string]any{"name": "db-credentials"}, "prefix": "DB_"},
+	}
+	if !reflect.DeepEqual(envFrom, want) {
+		t.Errorf("Expected %v, got %v", want, envFrom)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{EnvFromSecrets: "DB_="},
+		{EnvFromConfigMaps: "1X=app-config"},
+	} {
+		if _, err := buildEnvFrom(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
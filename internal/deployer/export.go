@@ -0,0 +1,139 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// runExport prints the resources the deploy would apply from the env
+// config, for review in a GitOps repository or applying elsewhere. Unlike
+// a dry run, it covers the resources deployed alongside the Service too.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("output", "yaml", "output format: yaml or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "yaml" && *output != "json" {
+		return fmt.Errorf("invalid output %q: expected yaml or json", *output)
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	if cfg.FunctionImage == "" {
+		return fmt.Errorf("FUNCTION_IMAGE is required for an export; source builds are not run")
+	}
+	resolvePreviewRuntime(context.Background(), cfg)
+
+	objects, err := exportResources(cfg)
+	if err != nil {
+		return err
+	}
+	return writeExport(os.Stdout, objects, *output)
+}
+
+// exportResources renders the Knative Service followed by the resources
+// the deploy applies with it for cfg: the request authentication policies,
+// the SinkBinding, the egress policies, the DomainMapping and its
+// Certificate, and the PingSource. Those the deploy only provisions for
+// monitoring or scaling, which are tied to the cluster it runs in, are
+// left out. Owner references are dropped: they need the UID of a live
+// Service.
+func exportResources(cfg *EnvConfig) ([]*unstructured.Unstructured, error) {
+	for _, validate := range []func(*EnvConfig) error{
+		validateRequestAuthentication,
+		validateEventSink,
+		validateEgress,
+		validateFunctionHost,
+		validateHostTLS,
+		validateSchedule,
+	} {
+		if err := validate(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		return nil, err
+	}
+	objects := []*unstructured.Unstructured{service}
+
+	if cfg.RequestAuthentication != "" {
+		objects = append(objects, buildRequestAuthentication(cfg), buildAuthorizationPolicy(cfg))
+	}
+	if cfg.EventSink != "" {
+		binding, err := buildSinkBinding(cfg)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, binding)
+	}
+	destinations, err := parseEgressAllow(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(destinations) > 0 {
+		objects = append(objects, buildEgressNetworkPolicy(cfg, destinations))
+	}
+	if cfg.EgressIstio == "true" {
+		objects = append(objects, buildEgressServiceEntry(cfg, destinations), buildEgressSidecar(cfg))
+	}
+	if cfg.FunctionHost != "" {
+		if cfg.FunctionHostTLSIssuer != "" {
+			objects = append(objects, buildCertificate(cfg, service))
+		}
+		objects = append(objects, buildDomainMapping(cfg, service))
+	}
+	if cfg.ScheduleCron != "" {
+		objects = append(objects, buildPingSource(cfg, service))
+	}
+
+	for _, obj := range objects {
+		obj.SetOwnerReferences(nil)
+	}
+	return objects, nil
+}
+
+// writeExport writes the objects as YAML documents, or as a JSON List.
+func writeExport(w io.Writer, objects []*unstructured.Unstructured, output string) error {
+	switch output {
+	case "json":
+		items := make([]any, 0, len(objects))
+		for _, obj := range objects {
+			items = append(items, obj.Object)
+		}
+		out, err := json.MarshalIndent(map[string]any{"apiVersion": "v1", "kind": "List", "items": items}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(out, '\n'))
+		return err
+	case "yaml":
+		var out bytes.Buffer
+		for i, obj := range objects {
+			if i > 0 {
+				out.WriteString("---\n")
+			}
+			doc, err := yaml.Marshal(obj.Object)
+			if err != nil {
+				return err
+			}
+			out.Write(doc)
+		}
+		_, err := w.Write(out.Bytes())
+		return err
+	default:
+		return fmt.Errorf("invalid output %q: expected yaml or json", output)
+	}
+}
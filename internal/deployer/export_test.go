@@ -0,0 +1,72 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportResources(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionImage:      "registry.example.com/myfunc:1",
+		FunctionGeneration: "3",
+	}
+	objects, err := exportResources(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(objects) != 1 || objects[0].GetKind() != "Service" {
+		t.Fatalf("Expected only the service, got %d objects", len(objects))
+	}
+
+	cfg.FunctionHost = "myfunc.example.com"
+	cfg.ScheduleCron = "@hourly"
+	objects, err = exportResources(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var kinds []string
+	for _, obj := range objects {
+		kinds = append(kinds, obj.GetKind())
+		if obj.GetOwnerReferences() != nil {
+			t.Errorf("Expected no owner references on the %s", obj.GetKind())
+		}
+	}
+	if strings.Join(kinds, ",") != "Service,DomainMapping,PingSource" {
+		t.Errorf("Unexpected kinds %v", kinds)
+	}
+
+	var out bytes.Buffer
+	if err := writeExport(&out, objects, "yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if docs := strings.Split(out.String(), "---\n"); len(docs) != 3 || !strings.Contains(docs[1], "kind: DomainMapping") {
+		t.Errorf("Expected three YAML documents, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writeExport(&out, objects, "json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var list struct {
+		Kind  string           `json:"kind"`
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil || list.Kind != "List" || len(list.Items) != 3 {
+		t.Errorf("Expected a JSON list of three items, got %s (%v)", out.String(), err)
+	}
+
+	if err := writeExport(&out, objects, "toml"); err == nil {
+		t.Error("Expected error for unknown output")
+	}
+}
+
+func TestExportResourcesValidates(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "registry.example.com/myfunc:1", ScheduleCron: "* * * *"}
+	if _, err := exportResources(cfg); err == nil {
+		t.Error("Expected an invalid SCHEDULE_CRON to be rejected")
+	}
+}
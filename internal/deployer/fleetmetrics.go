@@ -0,0 +1,107 @@
+package deployer
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The metrics of each function the observer watches, by namespace and
+// function, so dashboards show the health of the fleet without the
+// functions being instrumented. They are served with the others by the
+// observer daemon.
+var (
+	functionReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kdex_function_ready",
+		Help: "Whether the Knative Service of the function is ready: 1 or 0.",
+	}, []string{"namespace", "function"})
+	functionGenerationLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kdex_function_generation_lag",
+		Help: "Generations of the KDexFunction spec not deployed yet.",
+	}, []string{"namespace", "function"})
+	functionDeployDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kdex_function_last_deploy_duration_seconds",
+		Help: "Time the latest ready revision of the function took from its creation to becoming ready.",
+	}, []string{"namespace", "function"})
+	functionObserveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kdex_function_observe_errors_total",
+		Help: "Observations of the function that failed.",
+	}, []string{"namespace", "function"})
+
+	fleetVectors = []*prometheus.MetricVec{functionReady.MetricVec, functionGenerationLag.MetricVec, functionDeployDuration.MetricVec, functionObserveErrors.MetricVec}
+
+	// fleetFunctions are the functions observed with --all, by namespace,
+	// to tell those that are gone.
+	fleetFunctions = map[string]map[string]bool{}
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(functionReady, functionGenerationLag, functionDeployDuration, functionObserveErrors)
+}
+
+// recordFunctionMetrics sets the gauges of the function from its Service,
+// its KDexFunction and the latest ready revision, which may be nil when it
+// is not known.
+func recordFunctionMetrics(ksObj, kfObj, revision *unstructured.Unstructured) {
+	namespace, function := kfObj.GetNamespace(), kfObj.GetName()
+	ready := 0.0
+	if readyConditionStatus(ksObj) == "True" {
+		ready = 1
+	}
+	functionReady.WithLabelValues(namespace, function).Set(ready)
+
+	lag := kfObj.GetGeneration() - reportedGeneration(ksObj, kfObj)
+	functionGenerationLag.WithLabelValues(namespace, function).Set(float64(max(lag, 0)))
+
+	if revision != nil {
+		if duration, ok := revisionReadyDuration(revision); ok {
+			functionDeployDuration.WithLabelValues(namespace, function).Set(duration.Seconds())
+		}
+	}
+}
+
+// recordObserveError counts a failed observation of the function.
+func recordObserveError(namespace, function string) {
+	functionObserveErrors.WithLabelValues(namespace, function).Inc()
+}
+
+// forgetFunctionMetrics drops the series of a function that is gone, so it
+// does not linger on dashboards at its last values.
+func forgetFunctionMetrics(namespace, function string) {
+	labels := prometheus.Labels{"namespace": namespace, "function": function}
+	for _, vec := range fleetVectors {
+		vec.Delete(labels)
+	}
+}
+
+// forgetVanishedFunctions drops the series of the functions of namespace
+// observed before but not among those seen now.
+func forgetVanishedFunctions(namespace string, seen map[string]bool) {
+	for function := range fleetFunctions[namespace] {
+		if !seen[function] {
+			forgetFunctionMetrics(namespace, function)
+		}
+	}
+	fleetFunctions[namespace] = seen
+}
+
+// revisionReadyDuration is how long the revision took from its creation to
+// becoming ready, false while it is not.
+func revisionReadyDuration(revision *unstructured.Unstructured) (time.Duration, bool) {
+	conditions, _, _ := unstructured.NestedSlice(revision.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != "Ready" || cond["status"] != "True" {
+			continue
+		}
+		value, _ := cond["lastTransitionTime"].(string)
+		ready, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return 0, false
+		}
+		return ready.Sub(revision.GetCreationTimestamp().Time), true
+	}
+	return 0, false
+}
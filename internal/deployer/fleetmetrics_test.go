@@ -0,0 +1,57 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRecordFunctionMetrics(t *testing.T) {
+	created := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "fleet", "fn", nil)
+	function.SetGeneration(4)
+	service := newObject("serving.knative.dev/v1", "Service", "fleet", "fn", map[string]string{"kdex.dev/generation": "3"})
+	_ = unstructured.SetNestedSlice(service.Object, []any{map[string]any{"type": "Ready", "status": "True"}}, "status", "conditions")
+	revision := newObject("serving.knative.dev/v1", "Revision", "fleet", "fn-00003", nil)
+	revision.SetCreationTimestamp(metav1.NewTime(created))
+	_ = unstructured.SetNestedSlice(revision.Object, []any{map[string]any{
+		"type": "Ready", "status": "True", "lastTransitionTime": created.Add(42 * time.Second).Format(time.RFC3339),
+	}}, "status", "conditions")
+	defer forgetFunctionMetrics("fleet", "fn")
+
+	recordFunctionMetrics(service, function, revision)
+	recordObserveError("fleet", "fn")
+
+	if got := testutil.ToFloat64(functionReady.WithLabelValues("fleet", "fn")); got != 1 {
+		t.Errorf("Expected ready 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(functionGenerationLag.WithLabelValues("fleet", "fn")); got != 1 {
+		t.Errorf("Expected a generation lag of 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(functionDeployDuration.WithLabelValues("fleet", "fn")); got != 42 {
+		t.Errorf("Expected a deploy duration of 42s, got %v", got)
+	}
+	if got := testutil.ToFloat64(functionObserveErrors.WithLabelValues("fleet", "fn")); got != 1 {
+		t.Errorf("Expected one observe error, got %v", got)
+	}
+}
+
+func TestForgetVanishedFunctions(t *testing.T) {
+	defer delete(fleetFunctions, "vanish")
+	functionReady.WithLabelValues("vanish", "kept").Set(1)
+	functionReady.WithLabelValues("vanish", "gone").Set(1)
+	defer forgetFunctionMetrics("vanish", "kept")
+
+	forgetVanishedFunctions("vanish", map[string]bool{"kept": true, "gone": true})
+	forgetVanishedFunctions("vanish", map[string]bool{"kept": true})
+
+	if functionReady.DeleteLabelValues("vanish", "gone") {
+		t.Error("Expected the series of a vanished function to be dropped")
+	}
+	if got := testutil.ToFloat64(functionReady.WithLabelValues("vanish", "kept")); got != 1 {
+		t.Errorf("Expected the series of a function still there to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// observeHealth reports the restarts of the active revision's containers
+// in the last hour and why one last crashed, as recorded in the
+// KDexFunction status. It returns nil when that cannot be told.
+func observeHealth(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, ksObj *unstructured.Unstructured) map[string]any {
+	revision, _, _ := unstructured.NestedString(ksObj.Object, "status", "latestReadyRevisionName")
+	if revision == "" {
+		return nil
+	}
+	list, err := client.Resource(podGVR).Namespace(cfg.FunctionNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "serving.knative.dev/revision=" + revision,
+	})
+	if err != nil {
+		warnf("failed to list pods: %v\n", err)
+		return nil
+	}
+	return revisionHealth(list.Items, time.Now())
+}
+
+// revisionHealth summarizes the container statuses of the pods. Kubernetes
+// keeps only the last termination of a container, so restarts of a pod
+// older than an hour count once if the last one is recent: a lower bound.
+// lastCrashReason is only set once a crash was seen, leaving the one
+// recorded earlier in place after the crashed pod is gone.
+func revisionHealth(pods []unstructured.Unstructured, now time.Time) map[string]any {
+	hourAgo := now.Add(-time.Hour)
+	var restarts int64
+	var lastCrash time.Time
+	lastCrashReason := ""
+
+	for _, pod := range pods {
+		for _, status := range containerStatuses(&pod) {
+			terminated, found, _ := unstructured.NestedMap(status, "lastState", "terminated")
+			if !found {
+				continue
+			}
+			finishedAt, _ := terminated["finishedAt"].(string)
+			finished, err := time.Parse(time.RFC3339, finishedAt)
+			if err != nil {
+				continue
+			}
+
+			if finished.After(hourAgo) {
+				count, _, _ := unstructured.NestedInt64(status, "restartCount")
+				if pod.GetCreationTimestamp().Time.Before(hourAgo) {
+					count = 1
+				}
+				restarts += count
+			}
+
+			if finished.After(lastCrash) {
+				lastCrash = finished
+				reason, _ := terminated["reason"].(string)
+				exitCode, _, _ := unstructured.NestedInt64(terminated, "exitCode")
+				lastCrashReason = fmt.Sprintf("%s (exit code %d)", reason, exitCode)
+			}
+		}
+	}
+
+	health := map[string]any{"restartsLastHour": restarts}
+	if lastCrashReason != "" {
+		health["lastCrashReason"] = lastCrashReason
+	}
+	return health
+}
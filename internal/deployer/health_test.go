@@ -0,0 +1,79 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func crashedPod(name string, created time.Time, restarts int64, reason string, finished time.Time) unstructured.Unstructured {
+	pod := newObject("v1", "Pod", "default", name, map[string]string{"serving.knative.dev/revision": "fn-00001"})
+	pod.SetCreationTimestamp(metav1.NewTime(created))
+	_ = unstructured.SetNestedSlice(pod.Object, []any{
+		map[string]any{
+			"name":         "user-container",
+			"restartCount": restarts,
+			"lastState": map[string]any{
+				"terminated": map[string]any{
+					"reason":     reason,
+					"exitCode":   int64(137),
+					"finishedAt": finished.Format(time.RFC3339),
+				},
+			},
+		},
+	}, "status", "containerStatuses")
+	return *pod
+}
+
+func TestRevisionHealth(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	health := revisionHealth(nil, now)
+	if health["restartsLastHour"] != int64(0) || health["lastCrashReason"] != nil {
+		t.Errorf("Expected a healthy revision, got %v", health)
+	}
+
+	health = revisionHealth([]unstructured.Unstructured{
+		// Every restart of a young pod happened within the hour
+		crashedPod("young", now.Add(-10*time.Minute), 3, "OOMKilled", now.Add(-time.Minute)),
+		// Only the last restart of an old pod is known to be recent
+		crashedPod("old", now.Add(-24*time.Hour), 7, "Error", now.Add(-5*time.Minute)),
+		// Crashed long ago
+		crashedPod("stale", now.Add(-24*time.Hour), 2, "Error", now.Add(-3*time.Hour)),
+	}, now)
+	if health["restartsLastHour"] != int64(4) {
+		t.Errorf("Expected 4 restarts in the last hour, got %v", health["restartsLastHour"])
+	}
+	if health["lastCrashReason"] != "OOMKilled (exit code 137)" {
+		t.Errorf("Expected the most recent crash reason, got %v", health["lastCrashReason"])
+	}
+}
+
+func TestObserveHealth(t *testing.T) {
+	ctx := context.Background()
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+	pod := crashedPod("fn-00001-pod", time.Now().Add(-time.Minute), 1, "Error", time.Now())
+	client := newFakeDynamicClient(&pod)
+
+	ksObj := knativeServiceWithReady("True")
+	if health := observeHealth(ctx, client, cfg, ksObj); health != nil {
+		t.Errorf("Expected no health without a ready revision, got %v", health)
+	}
+
+	_ = unstructured.SetNestedField(ksObj.Object, "fn-00001", "status", "latestReadyRevisionName")
+	health := observeHealth(ctx, client, cfg, ksObj)
+	if health["restartsLastHour"] != int64(1) {
+		t.Errorf("Expected one restart, got %v", health)
+	}
+
+	// A crash reason recorded before is kept once the pod is gone
+	function := kdexFunctionInState("Ready")
+	_ = unstructured.SetNestedMap(function.Object, map[string]any{"restartsLastHour": int64(0), "lastCrashReason": "Error (exit code 1)"}, "status", "health")
+	blocks := map[string]map[string]any{"health": {"restartsLastHour": int64(0)}}
+	if update := observeStatus(cfg, knativeServiceWithReady("True"), function, blocks); update != nil {
+		t.Errorf("Expected no update for unchanged health, got %+v", update)
+	}
+}
@@ -0,0 +1,34 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendJSON sends body to url and returns the response status code. A nil
+// body sends no payload. Non-2xx responses are left to the caller.
+func sendJSON(ctx context.Context, method, url, token string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s failed: %w", method, url, err)
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,138 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	buildpackMetadataLabel = "io.buildpacks.build.metadata"
+	buildpackStackLabel    = "io.buildpacks.stack.id"
+)
+
+// buildpackLanguages maps fragments of buildpack ids to the language they
+// provide. Order matters: the first match wins.
+var buildpackLanguages = []struct {
+	fragment string
+	language string
+}{
+	{"java", "java"},
+	{"jvm", "java"},
+	{"liberica", "java"},
+	{"node", "nodejs"},
+	{"python", "python"},
+	{"dotnet", "dotnet"},
+	{"ruby", "ruby"},
+	{"php", "php"},
+	{"go", "go"},
+}
+
+type buildpackMetadata struct {
+	Buildpacks []struct {
+		ID      string `json:"id"`
+		Version string `json:"version"`
+	} `json:"buildpacks"`
+}
+
+func fetchImageConfig(ctx context.Context, image string) (*v1.ConfigFile, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %w", image, err)
+	}
+
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image %s: %w", image, err)
+	}
+
+	return img.ConfigFile()
+}
+
+// parseBuildpackMetadata turns the buildpacks labels of an image into the
+// status.build block of a KDexFunction. It returns nil when the image was not
+// built with buildpacks.
+func parseBuildpackMetadata(labels map[string]string) (map[string]any, error) {
+	raw, ok := labels[buildpackMetadataLabel]
+	if !ok {
+		return nil, nil
+	}
+
+	var md buildpackMetadata
+	if err := json.Unmarshal([]byte(raw), &md); err != nil {
+		return nil, fmt.Errorf("invalid %s label: %w", buildpackMetadataLabel, err)
+	}
+
+	buildpacks := []any{}
+	language := ""
+	for _, bp := range md.Buildpacks {
+		buildpacks = append(buildpacks, map[string]any{
+			"id":      bp.ID,
+			"version": bp.Version,
+		})
+		if language == "" {
+			language = buildpackLanguage(bp.ID)
+		}
+	}
+
+	build := map[string]any{
+		"buildpacks": buildpacks,
+	}
+	if language != "" {
+		build["language"] = language
+	}
+	if stack := labels[buildpackStackLabel]; stack != "" {
+		build["stack"] = stack
+	}
+
+	return build, nil
+}
+
+func buildpackLanguage(id string) string {
+	// Only look at the buildpack name, not the publisher
+	_, bpName, _ := strings.Cut(id, "/")
+	if bpName == "" {
+		bpName = id
+	}
+	for _, l := range buildpackLanguages {
+		if strings.Contains(bpName, l.fragment) {
+			return l.language
+		}
+	}
+	return ""
+}
+
+// recordBuildMetadata writes the buildpacks metadata of the deployed image
+// into the KDexFunction status for inventory purposes.
+func recordBuildMetadata(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, labels map[string]string) error {
+	build, err := parseBuildpackMetadata(labels)
+	if err != nil {
+		return err
+	}
+	if build == nil {
+		return nil
+	}
+
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"build": build,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	return err
+}
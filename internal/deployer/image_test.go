@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"testing"
+)
+
+func TestParseBuildpackMetadata(t *testing.T) {
+	build, err := parseBuildpackMetadata(map[string]string{})
+	if err != nil || build != nil {
+		t.Errorf("Expected no metadata for non-buildpacks image. Got %v, %v", build, err)
+	}
+
+	_, err = parseBuildpackMetadata(map[string]string{buildpackMetadataLabel: "{"})
+	if err == nil {
+		t.Error("Expected error for malformed metadata label")
+	}
+
+	build, err = parseBuildpackMetadata(map[string]string{
+		buildpackMetadataLabel: `{"buildpacks":[` +
+			`{"id":"paketo-buildpacks/ca-certificates","version":"3.6.3"},` +
+			`{"id":"paketo-buildpacks/node-engine","version":"3.0.1"}]}`,
+		buildpackStackLabel: "io.buildpacks.stacks.jammy",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if build["language"] != "nodejs" {
+		t.Errorf("Expected nodejs language, got %v", build["language"])
+	}
+	if build["stack"] != "io.buildpacks.stacks.jammy" {
+		t.Errorf("Unexpected stack: %v", build["stack"])
+	}
+	buildpacks, _ := build["buildpacks"].([]any)
+	if len(buildpacks) != 2 {
+		t.Fatalf("Expected 2 buildpacks, got %d", len(buildpacks))
+	}
+	if bp := buildpacks[1].(map[string]any); bp["id"] != "paketo-buildpacks/node-engine" || bp["version"] != "3.0.1" {
+		t.Errorf("Unexpected buildpack: %v", bp)
+	}
+}
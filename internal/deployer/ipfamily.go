@@ -0,0 +1,149 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var coreServiceGVR = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+
+// The IP families and family policies of a Kubernetes Service.
+const (
+	ipFamilyIPv4 = "IPv4"
+	ipFamilyIPv6 = "IPv6"
+
+	ipFamilyPolicySingleStack      = "SingleStack"
+	ipFamilyPolicyPreferDualStack  = "PreferDualStack"
+	ipFamilyPolicyRequireDualStack = "RequireDualStack"
+)
+
+// parseIPFamilies reads IP_FAMILIES, a comma separated list of IPv4 and
+// IPv6, the primary family first.
+func parseIPFamilies(cfg *EnvConfig) ([]string, error) {
+	families := []string{}
+	for family := range strings.SplitSeq(cfg.IPFamilies, ",") {
+		family = strings.TrimSpace(family)
+		if family == "" {
+			continue
+		}
+		if family != ipFamilyIPv4 && family != ipFamilyIPv6 {
+			return nil, fmt.Errorf("invalid IP_FAMILIES entry %q: expected %s or %s", family, ipFamilyIPv4, ipFamilyIPv6)
+		}
+		if slices.Contains(families, family) {
+			return nil, fmt.Errorf("invalid IP_FAMILIES: %s is listed twice", family)
+		}
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+func validateIPFamilies(cfg *EnvConfig) error {
+	switch cfg.IPFamilyPolicy {
+	case "", ipFamilyPolicySingleStack, ipFamilyPolicyPreferDualStack, ipFamilyPolicyRequireDualStack:
+	default:
+		return fmt.Errorf("invalid IP_FAMILY_POLICY %q: expected %s, %s or %s", cfg.IPFamilyPolicy,
+			ipFamilyPolicySingleStack, ipFamilyPolicyPreferDualStack, ipFamilyPolicyRequireDualStack)
+	}
+	families, err := parseIPFamilies(cfg)
+	if err != nil {
+		return err
+	}
+	if len(families) == 2 && (cfg.IPFamilyPolicy == "" || cfg.IPFamilyPolicy == ipFamilyPolicySingleStack) {
+		return fmt.Errorf("IP_FAMILIES %s needs IP_FAMILY_POLICY %s or %s", cfg.IPFamilies, ipFamilyPolicyPreferDualStack, ipFamilyPolicyRequireDualStack)
+	}
+	return nil
+}
+
+// revisionServices are the ClusterIP Services Knative routes a revision
+// through. The Service named after the function is an ExternalName to the
+// ingress and has no addresses of its own.
+func revisionServices(revision string) []string {
+	return []string{revision, revision + "-private"}
+}
+
+// applyIPFamilies sets the IP family preferences of IP_FAMILY_POLICY and
+// IP_FAMILIES on the Services of the revision. Knative does not expose
+// them, but leaves them alone once set. Kubernetes only lets a Service
+// gain or drop its secondary family, so the primary family must match the
+// one the Service got.
+func applyIPFamilies(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, revision string) error {
+	if (cfg.IPFamilyPolicy == "" && cfg.IPFamilies == "") || revision == "" {
+		return nil
+	}
+	families, err := parseIPFamilies(cfg)
+	if err != nil {
+		return err
+	}
+
+	spec := map[string]any{}
+	if cfg.IPFamilyPolicy != "" {
+		spec["ipFamilyPolicy"] = cfg.IPFamilyPolicy
+	}
+	if len(families) > 0 {
+		spec["ipFamilies"] = families
+	}
+	services := client.Resource(coreServiceGVR).Namespace(cfg.FunctionNamespace)
+	for _, name := range revisionServices(revision) {
+		data, err := json.Marshal(map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]any{"name": name},
+			"spec":       spec,
+		})
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = services.Patch(ctx, name, types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set ip families of service %s: %w", name, err)
+		}
+	}
+	logf("Revision %s served on %s %s\n", revision, cfg.IPFamilyPolicy, strings.Join(families, ","))
+	return nil
+}
+
+// observeNetwork reports the addresses the active revision is served on
+// per IP family, as recorded in the KDexFunction status. It returns nil
+// when that cannot be told.
+func observeNetwork(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, ksObj *unstructured.Unstructured) map[string]any {
+	revision, _, _ := unstructured.NestedString(ksObj.Object, "status", "latestReadyRevisionName")
+	if revision == "" {
+		return nil
+	}
+	service, err := client.Resource(coreServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, revision, metav1.GetOptions{})
+	if err != nil {
+		warnf("failed to get revision service: %v\n", err)
+		return nil
+	}
+	return serviceAddresses(service)
+}
+
+// serviceAddresses pairs the cluster IPs of the Service with their
+// families, both listed primary first.
+func serviceAddresses(service *unstructured.Unstructured) map[string]any {
+	families, _, _ := unstructured.NestedStringSlice(service.Object, "spec", "ipFamilies")
+	ips, _, _ := unstructured.NestedStringSlice(service.Object, "spec", "clusterIPs")
+	addresses := map[string]any{}
+	for i, family := range families {
+		if i < len(ips) {
+			addresses[strings.ToLower(family)] = ips[i]
+		}
+	}
+	if policy, _, _ := unstructured.NestedString(service.Object, "spec", "ipFamilyPolicy"); policy != "" {
+		addresses["ipFamilyPolicy"] = policy
+	}
+	return addresses
+}
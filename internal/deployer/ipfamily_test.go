@@ -0,0 +1,53 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateIPFamilies(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{IPFamilyPolicy: "PreferDualStack"}},
+		{cfg: EnvConfig{IPFamilies: "IPv6"}},
+		{cfg: EnvConfig{IPFamilyPolicy: "RequireDualStack", IPFamilies: "IPv6,IPv4"}},
+		{cfg: EnvConfig{IPFamilies: "IPv4,IPv6"}, wantErr: true},
+		{cfg: EnvConfig{IPFamilyPolicy: "SingleStack", IPFamilies: "IPv4,IPv6"}, wantErr: true},
+		{cfg: EnvConfig{IPFamilyPolicy: "PreferDualStack", IPFamilies: "IPv4,IPv4"}, wantErr: true},
+		{cfg: EnvConfig{IPFamilies: "ipv4"}, wantErr: true},
+		{cfg: EnvConfig{IPFamilyPolicy: "DualStack"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateIPFamilies(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestServiceAddresses(t *testing.T) {
+	service := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"ipFamilyPolicy": "PreferDualStack",
+			"ipFamilies":     []any{"IPv6", "IPv4"},
+			"clusterIPs":     []any{"fd00::10", "10.96.0.10"},
+		},
+	}}
+	addresses := serviceAddresses(service)
+	if addresses["ipv6"] != "fd00::10" || addresses["ipv4"] != "10.96.0.10" || addresses["ipFamilyPolicy"] != "PreferDualStack" {
+		t.Errorf("Unexpected addresses %v", addresses)
+	}
+
+	// A headless Service has families but no cluster IPs
+	headless := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{"ipFamilies": []any{"IPv4"}, "clusterIPs": []any{}},
+	}}
+	if addresses := serviceAddresses(headless); len(addresses) != 0 {
+		t.Errorf("Expected no addresses, got %v", addresses)
+	}
+}
@@ -0,0 +1,225 @@
+package deployer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// jobSettings configure the Job itself, or the reconciler launching it,
+// rather than the deploy it runs, so they are not passed on to it.
+// DEPLOY_ID is left out too: every Job gets its own.
+var jobSettings = []string{
+	"ISOLATED_NAMESPACES", "JOB_BACKOFF_LIMIT", "JOB_HISTORY_LIMIT", "JOB_IMAGE", "JOB_SECRET", "JOB_SERVICE_ACCOUNT", "JOB_TTL", "DEPLOY_ID",
+}
+
+// runJobManifest prints the Job that runs the deployer with the current
+// configuration, so the controller and people launch deploys the same way.
+func runJobManifest(args []string) error {
+	flags := flag.NewFlagSet("job-manifest", flag.ContinueOnError)
+	image := flags.String("image", "", "deployer image, overriding JOB_IMAGE")
+	serviceAccount := flags.String("service-account", "", "service account the Job runs as, overriding JOB_SERVICE_ACCOUNT")
+	ttl := flags.String("ttl", "", "how long to keep the finished Job, overriding JOB_TTL")
+	backoffLimit := flags.String("backoff-limit", "", "retries of a failed deploy, overriding JOB_BACKOFF_LIMIT")
+	secret := flags.String("secret", "", "Secret holding the token settings, overriding JOB_SECRET")
+	output := flags.String("output", "yaml", "output format: yaml or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	if *image != "" {
+		cfg.JobImage = *image
+	}
+	if *serviceAccount != "" {
+		cfg.JobServiceAccount = *serviceAccount
+	}
+	if *ttl != "" {
+		cfg.JobTTL = *ttl
+	}
+	if *secret != "" {
+		cfg.JobSecret = *secret
+	}
+	if *backoffLimit != "" {
+		cfg.JobBackoffLimit = *backoffLimit
+	}
+
+	job, err := buildJobManifest(cfg, flags.Args())
+	if err != nil {
+		return err
+	}
+	return writeManifest(os.Stdout, job, *output)
+}
+
+// buildJobManifest renders the deploy Job for cfg. The deploy settings and
+// the forwarded env vars are passed as env vars, except the tokens: those
+// come from the JOB_SECRET Secret, under their env var names, and are left
+// out without one.
+func buildJobManifest(cfg *EnvConfig, deployArgs []string) (map[string]any, error) {
+	if cfg.JobImage == "" {
+		return nil, fmt.Errorf("JOB_IMAGE is required")
+	}
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" {
+		return nil, fmt.Errorf("FUNCTION_IMAGE or FUNCTION_SOURCE_GIT is required for deploy")
+	}
+
+	env := []any{}
+	config := reflect.ValueOf(cfg).Elem()
+	for i, field := range reflect.VisibleFields(config.Type()) {
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value := config.Field(i).String()
+		if value == "" || slices.Contains(jobSettings, name) {
+			continue
+		}
+		env = appendJobEnv(env, cfg, name, value)
+	}
+	// The values of the forwarded env vars come from the env of the
+	// deployer, which the Job does not inherit. Those referencing a Secret
+	// or ConfigMap are resolved in the function's pods instead.
+	forwarded, _ := parseForwardedEnvVars(cfg)
+	for _, v := range forwarded {
+		if v.Source != "" || slices.ContainsFunc(env, func(e any) bool { return e.(map[string]any)["name"] == v.Name }) {
+			continue
+		}
+		if value := forwardedValue(cfg, v.Name); value != "" {
+			env = appendJobEnv(env, cfg, v.Name, value)
+		}
+	}
+
+	labels := map[string]any{
+		"kdex.dev/function": cfg.FunctionName,
+		"kdex.dev/job":      "deploy",
+	}
+	metadata := map[string]any{
+		"namespace": cfg.FunctionNamespace,
+		"labels":    labels,
+	}
+	// Named after the generation so a deploy is launched once; without one
+	// every manifest is a new Job
+	if cfg.FunctionGeneration != "" {
+		labels["kdex.dev/generation"] = cfg.FunctionGeneration
+		metadata["name"] = jobName(cfg.FunctionName, "-deploy-"+cfg.FunctionGeneration)
+	} else {
+		// The API server shortens the prefix to fit the random suffix
+		metadata["generateName"] = cfg.FunctionName + "-deploy-"
+	}
+
+	podSpec := map[string]any{
+		"restartPolicy": "Never",
+		"containers": []any{
+			map[string]any{
+				"name":  "deployer",
+				"image": cfg.JobImage,
+				"args":  toAnySlice(append([]string{"deploy"}, deployArgs...)),
+				"env":   env,
+			},
+		},
+	}
+	if cfg.JobServiceAccount != "" {
+		if errs := validation.IsDNS1123Subdomain(cfg.JobServiceAccount); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid JOB_SERVICE_ACCOUNT %q: %s", cfg.JobServiceAccount, strings.Join(errs, "; "))
+		}
+		podSpec["serviceAccountName"] = cfg.JobServiceAccount
+	}
+
+	jobSpec := map[string]any{
+		"template": map[string]any{
+			"metadata": map[string]any{"labels": labels},
+			"spec":     podSpec,
+		},
+	}
+	// A retried deploy resumes from its checkpoint, so retries are cheap;
+	// without a limit the Job controller retries six times
+	if cfg.JobBackoffLimit != "" {
+		limit, err := strconv.ParseInt(cfg.JobBackoffLimit, 10, 32)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid JOB_BACKOFF_LIMIT %q: expected a non-negative integer", cfg.JobBackoffLimit)
+		}
+		jobSpec["backoffLimit"] = limit
+	}
+	if cfg.JobTTL != "" {
+		ttl, err := time.ParseDuration(cfg.JobTTL)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid JOB_TTL %q: expected a non-negative duration", cfg.JobTTL)
+		}
+		jobSpec["ttlSecondsAfterFinished"] = int64(ttl / time.Second)
+	}
+
+	return map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   metadata,
+		"spec":       jobSpec,
+	}, nil
+}
+
+// appendJobEnv adds the env var name to the Job env, tokens as a reference
+// to their key in the JOB_SECRET Secret.
+func appendJobEnv(env []any, cfg *EnvConfig, name, value string) []any {
+	if !strings.HasSuffix(name, "_TOKEN") {
+		return append(env, map[string]any{"name": name, "value": value})
+	}
+	if cfg.JobSecret == "" {
+		warnf("leaving %s out of the Job; set JOB_SECRET to take it from a Secret\n", name)
+		return env
+	}
+	return append(env, map[string]any{
+		"name": name,
+		"valueFrom": map[string]any{
+			"secretKeyRef": map[string]any{"name": cfg.JobSecret, "key": name},
+		},
+	})
+}
+
+// jobName appends suffix to the function name, shortening the name so the
+// result stays a valid label for the Job's pods.
+func jobName(function, suffix string) string {
+	if excess := len(function) + len(suffix) - validation.DNS1123LabelMaxLength; excess > 0 {
+		function = strings.TrimRight(function[:len(function)-excess], "-")
+	}
+	return function + suffix
+}
+
+func toAnySlice(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+// writeManifest writes obj as YAML or JSON.
+func writeManifest(w io.Writer, obj map[string]any, output string) error {
+	var out []byte
+	var err error
+	switch output {
+	case "json":
+		out, err = json.MarshalIndent(obj, "", "  ")
+		out = append(out, '\n')
+	case "yaml":
+		out, err = yaml.Marshal(obj)
+	default:
+		return fmt.Errorf("invalid output %q: expected yaml or json", output)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
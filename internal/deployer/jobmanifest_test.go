@@ -0,0 +1,177 @@
+package deployer
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestBuildJobManifest(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionGeneration: "7",
+		FunctionImage:      "ghcr.io/kdex/fn:v1",
+		RegistryToken:      "s3cret",
+		DeployID:           "20261016-000000-abcdef",
+		JobImage:           "ghcr.io/kdex/knative-deployer:v1",
+		JobServiceAccount:  "kdex-deployer",
+		JobSecret:          "myfunc-deploy",
+		JobTTL:             "1h",
+		JobBackoffLimit:    "2",
+	}
+
+	job, err := buildJobManifest(cfg, []string{"--progressive"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeManifest(&out, job, "yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `apiVersion: batch/v1
+kind: Job
+metadata:
+  labels:
+    kdex.dev/function: myfunc
+    kdex.dev/generation: "7"
+    kdex.dev/job: deploy
+  name: myfunc-deploy-7
+  namespace: myns
+spec:
+  backoffLimit: 2
+  template:
+    metadata:
+      labels:
+        kdex.dev/function: myfunc
+        kdex.dev/generation: "7"
+        kdex.dev/job: deploy
+    spec:
+      containers:
+      - args:
+        - deploy
+        - --progressive
+        env:
+        - name: FUNCTION_GENERATION
+          value: "7"
+        - name: FUNCTION_IMAGE
+          value: ghcr.io/kdex/fn:v1
+        - name: FUNCTION_NAME
+          value: myfunc
+        - name: FUNCTION_NAMESPACE
+          value: myns
+        - name: REGISTRY_TOKEN
+          valueFrom:
+            secretKeyRef:
+              key: REGISTRY_TOKEN
+              name: myfunc-deploy
+        image: ghcr.io/kdex/knative-deployer:v1
+        name: deployer
+      restartPolicy: Never
+      serviceAccountName: kdex-deployer
+  ttlSecondsAfterFinished: 3600
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected manifest:\n%s", out.String())
+	}
+
+	// Tokens are never rendered in the clear
+	cfg.JobSecret = ""
+	cfg.FunctionGeneration = ""
+	job, err = buildJobManifest(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := yaml.Marshal(job)
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "REGISTRY_TOKEN") {
+		t.Errorf("Expected the token to be left out:\n%s", data)
+	}
+	if !strings.Contains(string(data), "generateName: myfunc-deploy-") {
+		t.Errorf("Expected a generated name without a generation:\n%s", data)
+	}
+}
+
+func TestBuildJobManifestErrors(t *testing.T) {
+	base := EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "img", JobImage: "deployer"}
+
+	cfg := base
+	cfg.JobImage = ""
+	if _, err := buildJobManifest(&cfg, nil); err == nil {
+		t.Error("Expected an error without JOB_IMAGE")
+	}
+
+	cfg = base
+	cfg.JobTTL = "soon"
+	if _, err := buildJobManifest(&cfg, nil); err == nil {
+		t.Error("Expected an error for an invalid JOB_TTL")
+	}
+
+	cfg = base
+	cfg.JobBackoffLimit = "-1"
+	if _, err := buildJobManifest(&cfg, nil); err == nil {
+		t.Error("Expected an error for a negative JOB_BACKOFF_LIMIT")
+	}
+
+	cfg = base
+	cfg.FunctionImage = ""
+	if _, err := buildJobManifest(&cfg, nil); err == nil {
+		t.Error("Expected an error without an image or source")
+	}
+}
+
+func TestBuildJobManifestForwardedEnv(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("API_TOKEN", "s3cret")
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "img",
+		FunctionEnv:       map[string]string{"GREETING": "hello"},
+		ForwardedEnvVars:  "GREETING,LOG_FORMAT,API_TOKEN,DB_PASSWORD=secret:db:password,UNSET",
+		JobImage:          "deployer",
+		JobSecret:         "myfunc-deploy",
+	}
+
+	job, err := buildJobManifest(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	containers, _, _ := unstructured.NestedSlice(job, "spec", "template", "spec", "containers")
+	env := map[string]any{}
+	for _, e := range containers[0].(map[string]any)["env"].([]any) {
+		e := e.(map[string]any)
+		if value, ok := e["value"]; ok {
+			env[e["name"].(string)] = value
+		} else {
+			env[e["name"].(string)] = e["valueFrom"]
+		}
+	}
+
+	if env["GREETING"] != "hello" || env["LOG_FORMAT"] != "json" {
+		t.Errorf("Expected the forwarded values in the Job env, got %v", env)
+	}
+	ref := map[string]any{"secretKeyRef": map[string]any{"name": "myfunc-deploy", "key": "API_TOKEN"}}
+	if !reflect.DeepEqual(env["API_TOKEN"], ref) {
+		t.Errorf("Expected API_TOKEN from the Job secret, got %v", env["API_TOKEN"])
+	}
+	for _, name := range []string{"DB_PASSWORD", "UNSET"} {
+		if _, ok := env[name]; ok {
+			t.Errorf("Expected %s to be left out of the Job env", name)
+		}
+	}
+	if env["FORWARDED_ENV_VARS"] != cfg.ForwardedEnvVars {
+		t.Errorf("Expected FORWARDED_ENV_VARS in the Job env, got %v", env["FORWARDED_ENV_VARS"])
+	}
+}
+
+func TestJobName(t *testing.T) {
+	name := jobName(strings.Repeat("a", 60), "-deploy-12")
+	if len(name) != 63 || !strings.HasSuffix(name, "-deploy-12") {
+		t.Errorf("Expected a 63 character name, got %q", name)
+	}
+}
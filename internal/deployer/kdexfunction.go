@@ -0,0 +1,190 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// kdexFunctionEnv maps KDexFunction spec fields to the env vars the Job
+// would otherwise be templated with.
+var kdexFunctionEnv = []struct {
+	path []string
+	env  string
+}{
+	{[]string{"spec", "image"}, "FUNCTION_IMAGE"},
+	{[]string{"spec", "basePath"}, "FUNCTION_BASEPATH"},
+	{[]string{"spec", "host"}, "FUNCTION_HOST"},
+	{[]string{"spec", "runtime"}, "FUNCTION_RUNTIME"},
+	{[]string{"spec", "priority"}, "FUNCTION_PRIORITY"},
+	{[]string{"spec", "serviceAccountName"}, "FUNCTION_SERVICE_ACCOUNT"},
+	{[]string{"spec", "source", "git"}, "FUNCTION_SOURCE_GIT"},
+	{[]string{"spec", "source", "revision"}, "FUNCTION_SOURCE_REVISION"},
+	{[]string{"spec", "requestTimeout"}, "REQUEST_TIMEOUT"},
+	{[]string{"spec", "containerConcurrency"}, "CONTAINER_CONCURRENCY"},
+	{[]string{"spec", "resources", "requests", "cpu"}, "FUNCTION_CPU_REQUEST"},
+	{[]string{"spec", "resources", "limits", "cpu"}, "FUNCTION_CPU_LIMIT"},
+	{[]string{"spec", "resources", "requests", "memory"}, "FUNCTION_MEMORY_REQUEST"},
+	{[]string{"spec", "resources", "limits", "memory"}, "FUNCTION_MEMORY_LIMIT"},
+	{[]string{"spec", "traffic"}, "TRAFFIC"},
+	{[]string{"spec", "scaling", "activationScale"}, "SCALING_ACTIVATION_SCALE"},
+	{[]string{"spec", "scaling", "initialScale"}, "SCALING_INITIAL_SCALE"},
+	{[]string{"spec", "scaling", "maxScale"}, "SCALING_MAX_SCALE"},
+	{[]string{"spec", "scaling", "metric"}, "SCALING_METRIC"},
+	{[]string{"spec", "scaling", "minScale"}, "SCALING_MIN_SCALE"},
+	{[]string{"spec", "scaling", "panicThresholdPercentage"}, "SCALING_PANIC_THRESHOLD_PERCENTAGE"},
+	{[]string{"spec", "scaling", "panicWindowPercentage"}, "SCALING_PANIC_WINDOW_PERCENTAGE"},
+	{[]string{"spec", "scaling", "scaleDownDelay"}, "SCALING_SCALE_DOWN_DELAY"},
+	{[]string{"spec", "scaling", "scaleToZeroPodRetentionPeriod"}, "SCALING_SCALE_TO_ZERO_POD_RETENTION_PERIOD"},
+	{[]string{"spec", "scaling", "window"}, "SCALING_STABLE_WINDOW"},
+	{[]string{"spec", "scaling", "target"}, "SCALING_TARGET"},
+	{[]string{"spec", "scaling", "targetUtilizationPercentage"}, "SCALING_TARGET_UTILIZATION_PERCENTAGE"},
+}
+
+// readKDexFunction loads a KDexFunction from a file, or from the cluster when
+// source is not a file. A name without a namespace is looked up in
+// FUNCTION_NAMESPACE.
+func readKDexFunction(ctx context.Context, source string) (*unstructured.Unstructured, error) {
+	if _, err := os.Stat(source); err == nil {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		// Decoding through JSON keeps integers as int64
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse kdex function %s: %w", source, err)
+		}
+		function := &unstructured.Unstructured{}
+		if err := function.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("failed to parse kdex function %s: %w", source, err)
+		}
+		if function.GetKind() != "KDexFunction" {
+			return nil, fmt.Errorf("%s holds a %q, not a KDexFunction", source, function.GetKind())
+		}
+		return function, nil
+	}
+
+	namespace, name, ok := strings.Cut(source, "/")
+	if !ok {
+		namespace, name = os.Getenv("FUNCTION_NAMESPACE"), source
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("no namespace for kdex function %s: use namespace/name or set FUNCTION_NAMESPACE", source)
+	}
+
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	function, err := client.Resource(kdexFunctionGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kdex function: %w", err)
+	}
+	return function, nil
+}
+
+// kdexFunctionConfig loads the configuration of the function: base, the
+// platform settings of the Job or controller, with the deployment
+// parameters of the function on top. spec.env replaces FORWARDED_ENV_VARS;
+// entries taking their value from a Secret or ConfigMap key are forwarded
+// as references, the others carry their value in FunctionEnv. They only
+// ever reach the function container, never the settings of the deployer,
+// so a function cannot turn off the checks its platform enforces.
+func kdexFunctionConfig(function *unstructured.Unstructured, base *EnvConfig) (*EnvConfig, error) {
+	cfg := *base
+	cfg.FunctionName = function.GetName()
+	cfg.FunctionNamespace = function.GetNamespace()
+	if function.GetGeneration() != 0 {
+		cfg.FunctionGeneration = fmt.Sprint(function.GetGeneration())
+	}
+
+	for _, f := range kdexFunctionEnv {
+		value, found, err := unstructured.NestedFieldNoCopy(function.Object, f.path...)
+		if err != nil {
+			return nil, err
+		}
+		if !found || value == nil {
+			continue
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("invalid kdex function: %s must be a scalar", strings.Join(f.path, "."))
+		}
+		setSetting(&cfg, f.env, fmt.Sprint(value))
+	}
+
+	env, _, err := unstructured.NestedSlice(function.Object, "spec", "env")
+	if err != nil {
+		return nil, fmt.Errorf("invalid kdex function: spec.env: %w", err)
+	}
+	forwarded := []string{}
+	cfg.FunctionEnv = map[string]string{}
+	for _, e := range env {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid kdex function: spec.env entries must be objects")
+		}
+		name, _ := entry["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("invalid kdex function: spec.env entry without a name")
+		}
+		if valueFrom, ok := entry["valueFrom"].(map[string]any); ok {
+			ref, err := kdexFunctionEnvRef(name, valueFrom)
+			if err != nil {
+				return nil, err
+			}
+			forwarded = append(forwarded, ref)
+			continue
+		}
+		value := ""
+		if entry["value"] != nil {
+			value = fmt.Sprint(entry["value"])
+		}
+		cfg.FunctionEnv[name] = value
+		forwarded = append(forwarded, name)
+	}
+	cfg.ForwardedEnvVars = strings.Join(forwarded, ",")
+
+	logFunction(&cfg)
+	redactConfig(&cfg)
+	return &cfg, nil
+}
+
+// setSetting sets the setting read from the env var name.
+func setSetting(cfg *EnvConfig, name, value string) {
+	config := reflect.ValueOf(cfg).Elem()
+	for i, field := range reflect.VisibleFields(config.Type()) {
+		if field.Tag.Get("env") == name {
+			config.Field(i).SetString(value)
+			return
+		}
+	}
+}
+
+// kdexFunctionEnvRef renders a spec.env valueFrom as a FORWARDED_ENV_VARS
+// reference.
+func kdexFunctionEnvRef(name string, valueFrom map[string]any) (string, error) {
+	for _, r := range []struct{ source, field string }{
+		{envRefSecret, "secretKeyRef"},
+		{envRefConfigMap, "configMapKeyRef"},
+	} {
+		ref, ok := valueFrom[r.field].(map[string]any)
+		if !ok {
+			continue
+		}
+		object, _ := ref["name"].(string)
+		key, _ := ref["key"].(string)
+		if object == "" || key == "" {
+			return "", fmt.Errorf("invalid kdex function: spec.env %s: %s needs a name and a key", name, r.field)
+		}
+		return fmt.Sprintf("%s=%s:%s:%s", name, r.source, object, key), nil
+	}
+	return "", fmt.Errorf("invalid kdex function: spec.env %s: only secretKeyRef and configMapKeyRef are supported", name)
+}
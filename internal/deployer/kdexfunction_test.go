@@ -0,0 +1,92 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKDexFunction = `apiVersion: kdex.dev/v1alpha1
+kind: KDexFunction
+metadata:
+  name: myfunc
+  namespace: myns
+  generation: 7
+spec:
+  image: registry.example.com/myfunc:7
+  basePath: /api/myfunc
+  requestTimeout: 30s
+  scaling:
+    maxScale: 5
+    metric: rps
+  env:
+  - name: GREETING
+    value: hello ${FUNCTION_NAME}
+  - name: VERIFY_IMAGE_SIGNATURE
+    value: "false"
+  - name: DB_PASSWORD
+    valueFrom:
+      secretKeyRef:
+        name: db
+        key: password
+`
+
+func TestKDexFunctionConfig(t *testing.T) {
+	t.Setenv("GREETING", "")
+	t.Setenv("VERIFY_IMAGE_SIGNATURE", "")
+	base := &EnvConfig{ForwardedEnvVars: "STALE", RegistryURL: "http://registry", VerifyImageSignature: "true"}
+
+	path := filepath.Join(t.TempDir(), "function.yaml")
+	if err := os.WriteFile(path, []byte(testKDexFunction), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	function, err := readKDexFunction(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg, err := kdexFunctionConfig(function, base)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.FunctionName != "myfunc" || cfg.FunctionNamespace != "myns" || cfg.FunctionGeneration != "7" {
+		t.Errorf("Unexpected identity: %s/%s@%s", cfg.FunctionNamespace, cfg.FunctionName, cfg.FunctionGeneration)
+	}
+	if cfg.FunctionImage != "registry.example.com/myfunc:7" || cfg.FunctionBasePath != "/api/myfunc" || cfg.RequestTimeout != "30s" {
+		t.Errorf("Unexpected spec fields: %+v", cfg)
+	}
+	if cfg.ScalingMaxScale != "5" || cfg.ScalingMetric != "rps" {
+		t.Errorf("Unexpected scaling: %s %s", cfg.ScalingMaxScale, cfg.ScalingMetric)
+	}
+	if cfg.RegistryURL != "http://registry" {
+		t.Errorf("Expected platform settings to be kept, got %q", cfg.RegistryURL)
+	}
+	if cfg.VerifyImageSignature != "true" || os.Getenv("VERIFY_IMAGE_SIGNATURE") != "" || os.Getenv("GREETING") != "" {
+		t.Error("Expected spec.env to stay out of the settings and the process env")
+	}
+	if base.ForwardedEnvVars != "STALE" || base.FunctionName != "" {
+		t.Errorf("Expected the base settings to be left untouched, got %+v", base)
+	}
+
+	env := buildContainerEnv(cfg, "")
+	if len(env) != 3 || env[1]["name"] != "GREETING" || env[1]["value"] != "hello myfunc" {
+		t.Errorf("Expected spec.env to replace forwarded vars, got %v", env)
+	}
+	if env[2]["name"] != "VERIFY_IMAGE_SIGNATURE" || env[2]["value"] != "false" {
+		t.Errorf("Expected spec.env to reach the function container, got %v", env[2])
+	}
+	if env[0]["name"] != "DB_PASSWORD" || env[0]["valueFrom"] == nil {
+		t.Errorf("Expected spec.env valueFrom to be forwarded as a reference, got %v", env[0])
+	}
+}
+
+func TestReadKDexFunctionRejectsOtherKinds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.yaml")
+	if err := os.WriteFile(path, []byte("apiVersion: v1\nkind: ConfigMap\n"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := readKDexFunction(context.Background(), path); err == nil {
+		t.Error("Expected error for a ConfigMap")
+	}
+}
@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clientOptions select the cluster to talk to. They are global flags,
+// accepted before or after the command.
+var clientOptions struct {
+	Kubeconfig string
+	Context    string
+}
+
+// extractClientFlags removes --kubeconfig and --context from args, in both
+// the "--flag value" and "--flag=value" forms, and returns the rest.
+func extractClientFlags(args []string) (kubeconfig, kubeContext string, rest []string, err error) {
+	rest = []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || (name != "kubeconfig" && name != "context") {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", "", nil, fmt.Errorf("flag needs an argument: --%s", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "kubeconfig" {
+			kubeconfig = value
+		} else {
+			kubeContext = value
+		}
+	}
+	return kubeconfig, kubeContext, rest, nil
+}
+
+// restConfig resolves the cluster config from, in order, the explicit
+// kubeconfig path, the KUBECONFIG env var and the in-cluster service
+// account. The context, from --context or KUBE_CONTEXT, only applies to
+// kubeconfig files.
+func restConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	if kubeContext == "" {
+		kubeContext = os.Getenv("KUBE_CONTEXT")
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{}
+	switch {
+	case kubeconfig != "":
+		rules.ExplicitPath = kubeconfig
+	case os.Getenv("KUBECONFIG") != "":
+		rules.Precedence = filepath.SplitList(os.Getenv("KUBECONFIG"))
+	default:
+		if kubeContext != "" {
+			return nil, fmt.Errorf("a context was selected but no kubeconfig was given")
+		}
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+		}
+		return instrumentConfig(config), nil
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return instrumentConfig(config), nil
+}
+
+// instrumentConfig counts the API errors of every client made from config.
+func instrumentConfig(config *rest.Config) *rest.Config {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return apiErrorCounter{next: rt}
+	})
+	return config
+}
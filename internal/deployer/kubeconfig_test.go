@@ -0,0 +1,90 @@
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExtractClientFlags(t *testing.T) {
+	kubeconfig, kubeContext, rest, err := extractClientFlags([]string{"--kubeconfig", "/tmp/config", "deploy", "--context=staging", "--progressive"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if kubeconfig != "/tmp/config" || kubeContext != "staging" {
+		t.Errorf("Unexpected flags: %q %q", kubeconfig, kubeContext)
+	}
+	if !slices.Equal(rest, []string{"deploy", "--progressive"}) {
+		t.Errorf("Unexpected rest: %v", rest)
+	}
+
+	if _, _, _, err := extractClientFlags([]string{"deploy", "--context"}); err == nil {
+		t.Error("Expected error for missing value")
+	}
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: me
+- name: prod
+  context:
+    cluster: prod
+    user: me
+current-context: dev
+users:
+- name: me
+  user:
+    token: secret
+`
+
+func TestRestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Setenv("KUBE_CONTEXT", "")
+	t.Setenv("KUBECONFIG", "")
+
+	config, err := restConfig(path, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Host != "https://dev.example.com" {
+		t.Errorf("Expected current context, got %s", config.Host)
+	}
+
+	config, err = restConfig(path, "prod")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Host != "https://prod.example.com" {
+		t.Errorf("Expected selected context, got %s", config.Host)
+	}
+
+	t.Setenv("KUBECONFIG", path)
+	t.Setenv("KUBE_CONTEXT", "prod")
+	config, err = restConfig("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Host != "https://prod.example.com" {
+		t.Errorf("Expected KUBECONFIG with KUBE_CONTEXT, got %s", config.Host)
+	}
+
+	t.Setenv("KUBECONFIG", "")
+	if _, err := restConfig("", "prod"); err == nil {
+		t.Error("Expected error for a context without kubeconfig")
+	}
+}
@@ -0,0 +1,98 @@
+package deployer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaseTiming is how long a Lease is held, how long the leader keeps
+// trying to renew it, and how often candidates try to take it.
+type leaseTiming struct {
+	Duration      time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+var defaultLeaseTiming = leaseTiming{
+	Duration:      15 * time.Second,
+	RenewDeadline: 10 * time.Second,
+	RetryPeriod:   2 * time.Second,
+}
+
+// observerLeaseName is the Lease the observer replicas of a function
+// elect their leader with.
+func observerLeaseName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-observer"
+}
+
+// allObserverLeaseName is the Lease of the observer replicas of all the
+// functions of a namespace.
+const allObserverLeaseName = "kdex-observer"
+
+// leaderIdentity names this replica in the Lease: its pod, with a suffix
+// telling restarts of the same pod apart.
+func leaderIdentity() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname: %w", err)
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return host + "-" + hex.EncodeToString(suffix), nil
+}
+
+// newLeaseLock returns the coordination.k8s.io Lease lock candidates
+// compete for.
+func newLeaseLock(client kubernetes.Interface, namespace, name, identity string) *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+}
+
+// runLeaderElected runs run while this replica holds the lock, until ctx is
+// done. A replica losing the lease stops running and campaigns again; the
+// lease is released on shutdown so another replica takes over right away.
+func runLeaderElected(ctx context.Context, lock resourcelock.Interface, timing leaseTiming, run func(context.Context)) error {
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   timing.Duration,
+		RenewDeadline:   timing.RenewDeadline,
+		RetryPeriod:     timing.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logf("Elected leader as %s\n", lock.Identity())
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				logf("No longer the leader\n")
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					logf("Following leader %s\n", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election: %w", err)
+	}
+
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
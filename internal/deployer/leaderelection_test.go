@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunLeaderElected(t *testing.T) {
+	clientset := fake.NewClientset()
+	lock := newLeaseLock(clientset, "myns", observerLeaseName(&EnvConfig{FunctionName: "myfunc"}), "observer-a")
+	timing := leaseTiming{Duration: time.Second, RenewDeadline: 500 * time.Millisecond, RetryPeriod: 100 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var holder string
+	err := runLeaderElected(ctx, lock, timing, func(ctx context.Context) {
+		lease, err := clientset.CoordinationV1().Leases("myns").Get(ctx, "myfunc-observer", metav1.GetOptions{})
+		if err == nil && lease.Spec.HolderIdentity != nil {
+			holder = *lease.Spec.HolderIdentity
+		}
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if holder != "observer-a" {
+		t.Errorf("Expected to observe while holding the lease, got holder %q", holder)
+	}
+
+	lease, err := clientset.CoordinationV1().Leases("myns").Get(context.Background(), "myfunc-observer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("Expected the lease to be released on shutdown, held by %q", *lease.Spec.HolderIdentity)
+	}
+}
@@ -0,0 +1,106 @@
+package deployer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	preStopSleep = "sleep:"
+	preStopExec  = "exec:"
+)
+
+// preStopHook is the parsed PRE_STOP: a pause before the container is
+// stopped, or a command run first.
+type preStopHook struct {
+	Sleep   time.Duration
+	Command string
+}
+
+// parsePreStop parses PRE_STOP, sleep:<duration> or exec:<command>. The
+// sleep lets the endpoints drop the pod while it still serves the
+// requests in flight; the command, run with /bin/sh, drains them itself.
+func parsePreStop(cfg *EnvConfig) (*preStopHook, error) {
+	value := strings.TrimSpace(cfg.PreStop)
+	if value == "" {
+		return nil, nil
+	}
+	if d, ok := strings.CutPrefix(value, preStopSleep); ok {
+		sleep, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || sleep < time.Second || sleep%time.Second != 0 {
+			return nil, fmt.Errorf("invalid PRE_STOP %q: expected a sleep of whole seconds, such as sleep:10s", cfg.PreStop)
+		}
+		return &preStopHook{Sleep: sleep}, nil
+	}
+	if command, ok := strings.CutPrefix(value, preStopExec); ok && strings.TrimSpace(command) != "" {
+		return &preStopHook{Command: strings.TrimSpace(command)}, nil
+	}
+	return nil, fmt.Errorf("invalid PRE_STOP %q: expected sleep:<duration> or exec:<command>", cfg.PreStop)
+}
+
+// terminationGracePeriod parses TERMINATION_GRACE_PERIOD, a duration or a
+// number of seconds. Zero means the Knative default.
+func terminationGracePeriod(cfg *EnvConfig) (time.Duration, error) {
+	value := strings.TrimSpace(cfg.TerminationGracePeriod)
+	if value == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period < time.Second || period%time.Second != 0 {
+		return 0, fmt.Errorf("invalid TERMINATION_GRACE_PERIOD %q: expected a positive number of seconds", cfg.TerminationGracePeriod)
+	}
+	return period, nil
+}
+
+// validateLifecycle checks PRE_STOP and TERMINATION_GRACE_PERIOD, and that
+// the grace period leaves the preStop sleep time to finish: the kubelet
+// kills the container once it runs out, hook or not.
+func validateLifecycle(cfg *EnvConfig) error {
+	hook, err := parsePreStop(cfg)
+	if err != nil {
+		return err
+	}
+	grace, err := terminationGracePeriod(cfg)
+	if err != nil {
+		return err
+	}
+	if hook != nil && hook.Sleep > 0 && grace > 0 && grace <= hook.Sleep {
+		return fmt.Errorf("TERMINATION_GRACE_PERIOD %s must be longer than the PRE_STOP sleep of %s", grace, hook.Sleep)
+	}
+	return nil
+}
+
+// applyLifecycle sets the preStop hook on the container and the grace
+// period on the revision.
+func applyLifecycle(container, revisionSpec map[string]any, cfg *EnvConfig) error {
+	if err := validateLifecycle(cfg); err != nil {
+		return err
+	}
+	hook, _ := parsePreStop(cfg)
+	grace, _ := terminationGracePeriod(cfg)
+
+	switch {
+	case hook == nil:
+	case hook.Sleep > 0:
+		container["lifecycle"] = map[string]any{
+			"preStop": map[string]any{
+				"sleep": map[string]any{"seconds": int64(hook.Sleep / time.Second)},
+			},
+		}
+	default:
+		container["lifecycle"] = map[string]any{
+			"preStop": map[string]any{
+				"exec": map[string]any{"command": []any{"/bin/sh", "-c", hook.Command}},
+			},
+		}
+	}
+	if grace > 0 {
+		revisionSpec["terminationGracePeriodSeconds"] = int64(grace / time.Second)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateLifecycle(t *testing.T) {
+	for _, tc := range []struct {
+		preStop, grace string
+		valid          bool
+	}{
+		{"", "", true},
+		{"sleep:10s", "", true},
+		{"sleep:10s", "30", true},
+		{"sleep:10s", "45s", true},
+		{"exec:/app/drain --wait", "60", true},
+		{"sleep:30s", "30", false},
+		{"sleep:500ms", "", false},
+		{"sleep:", "", false},
+		{"exec:", "", false},
+		{"wait:10s", "", false},
+		{"", "-5", false},
+		{"", "1.5s", false},
+	} {
+		err := validateLifecycle(&EnvConfig{PreStop: tc.preStop, TerminationGracePeriod: tc.grace})
+		if (err == nil) != tc.valid {
+			t.Errorf("PRE_STOP %q TERMINATION_GRACE_PERIOD %q: expected valid=%v, got %v", tc.preStop, tc.grace, tc.valid, err)
+		}
+	}
+}
+
+func TestBuildServiceLifecycle(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimg", PreStop: "sleep:10s", TerminationGracePeriod: "30s"}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	containers, _, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "containers")
+	container := containers.([]map[string]any)[0]
+	if seconds, _, _ := unstructured.NestedInt64(container, "lifecycle", "preStop", "sleep", "seconds"); seconds != 10 {
+		t.Errorf("Expected a 10 second preStop sleep, got %v", container["lifecycle"])
+	}
+	if grace, _, _ := unstructured.NestedInt64(service.Object, "spec", "template", "spec", "terminationGracePeriodSeconds"); grace != 30 {
+		t.Errorf("Expected a 30 second grace period, got %d", grace)
+	}
+
+	cfg.PreStop = "exec:/app/drain"
+	service, err = buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	containers, _, _ = unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "containers")
+	container = containers.([]map[string]any)[0]
+	command, _, _ := unstructured.NestedSlice(container, "lifecycle", "preStop", "exec", "command")
+	if len(command) != 3 || command[2] != "/app/drain" {
+		t.Errorf("Expected the drain command to run through the shell, got %v", command)
+	}
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// Formats of LOG_FORMAT.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logger writes the progress of every command. It logs to stderr so the
+// commands printing manifests or reports keep stdout machine readable.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// logFields are attached to every record: the function the process works
+// on and the deploy phase it is in, if any.
+var logFields struct {
+	function, namespace, generation string
+	phase                           report.Phase
+}
+
+// setupLogging configures the logger from LOG_FORMAT, text or json, and
+// LOG_LEVEL, debug, info, warn or error. It is read ahead of the other
+// settings, which need the logger to report on them.
+func setupLogging(w io.Writer, format, level string) error {
+	options := &slog.HandlerOptions{}
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn or error", level)
+		}
+		options.Level = l
+	}
+	switch strings.ToLower(format) {
+	case "", logFormatText:
+		logger = slog.New(slog.NewTextHandler(w, options))
+	case logFormatJSON:
+		logger = slog.New(slog.NewJSONHandler(w, options))
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q: expected %s or %s", format, logFormatText, logFormatJSON)
+	}
+	return nil
+}
+
+// logFunction tags the records that follow with the function of cfg.
+// Processes working on several functions load the config of each in turn,
+// so the tag follows the one being worked on.
+func logFunction(cfg *EnvConfig) {
+	logFields.function = cfg.FunctionName
+	logFields.namespace = cfg.FunctionNamespace
+	logFields.generation = cfg.FunctionGeneration
+}
+
+// logPhase tags the records that follow with the deploy phase, or none.
+func logPhase(phase report.Phase) {
+	logFields.phase = phase
+}
+
+// logf logs a progress line, with its secrets masked.
+func logf(format string, args ...any) {
+	logMessage(slog.LevelInfo, format, args...)
+}
+
+// warnf logs a line about something the deploy went on without, at warn
+// level.
+func warnf(format string, args ...any) {
+	logMessage(slog.LevelWarn, format, args...)
+}
+
+func logMessage(level slog.Level, format string, args ...any) {
+	msg := outputRedactor().String(strings.TrimSpace(fmt.Sprintf(format, args...)))
+	logger.LogAttrs(context.Background(), level, msg, logAttrs()...)
+}
+
+// logError logs the error a command failed with.
+func logError(err error) {
+	logger.LogAttrs(context.Background(), slog.LevelError, outputRedactor().String(err.Error()), logAttrs()...)
+}
+
+func logAttrs() []slog.Attr {
+	attrs := []slog.Attr{}
+	for _, field := range []struct{ key, value string }{
+		{"function", logFields.function},
+		{"namespace", logFields.namespace},
+		{"generation", logFields.generation},
+		{"phase", string(logFields.phase)},
+		{"deploy_id", deployID},
+	} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.key, field.value))
+		}
+	}
+	return attrs
+}
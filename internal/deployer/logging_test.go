@@ -0,0 +1,65 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestLogfJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := setupLogging(&out, "json", "info"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer func() {
+		_ = setupLogging(os.Stderr, "", "")
+		logFunction(&EnvConfig{})
+		logPhase("")
+	}()
+
+	logFunction(&EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionGeneration: "3"})
+	logPhase(report.PhaseApply)
+	warnf("failed to record conditions: %v\n", "boom")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q: %v", out.String(), err)
+	}
+	for key, want := range map[string]string{
+		"level":      "WARN",
+		"msg":        "failed to record conditions: boom",
+		"function":   "fn",
+		"namespace":  "ns",
+		"generation": "3",
+		"phase":      "Apply",
+	} {
+		if record[key] != want {
+			t.Errorf("Expected %s %q, got %v", key, want, record[key])
+		}
+	}
+}
+
+func TestSetupLoggingLevel(t *testing.T) {
+	var out bytes.Buffer
+	if err := setupLogging(&out, "text", "warn"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer func() { _ = setupLogging(os.Stderr, "", "") }()
+
+	logf("Service is Ready\n")
+	warnf("slow\n")
+	if strings.Contains(out.String(), "Service is Ready") || !strings.Contains(out.String(), "msg=slow") {
+		t.Errorf("Expected only the warning, got %q", out.String())
+	}
+
+	if err := setupLogging(&out, "xml", ""); err == nil {
+		t.Error("Expected an invalid LOG_FORMAT to be rejected")
+	}
+	if err := setupLogging(&out, "", "verbose"); err == nil {
+		t.Error("Expected an invalid LOG_LEVEL to be rejected")
+	}
+}
@@ -0,0 +1,34 @@
+package deployer
+
+import (
+	"fmt"
+	"slices"
+)
+
+// functionLogLevels are the levels FUNCTION_LOG_LEVEL takes, the ones the
+// deployer's own LOG_LEVEL takes.
+var functionLogLevels = []string{"debug", "info", "warn", "error"}
+
+// functionLogLevelEnvVar is the env var the function reads its log level
+// from.
+const functionLogLevelEnvVar = "LOG_LEVEL"
+
+func validateFunctionLogLevel(cfg *EnvConfig) error {
+	if cfg.FunctionLogLevel != "" && !slices.Contains(functionLogLevels, cfg.FunctionLogLevel) {
+		return fmt.Errorf("invalid FUNCTION_LOG_LEVEL %q: expected debug, info, warn or error", cfg.FunctionLogLevel)
+	}
+	return nil
+}
+
+// logLevelEnv sets LOG_LEVEL in the function container to
+// FUNCTION_LOG_LEVEL, so debugging one function is a redeploy away rather
+// than a change of cluster-wide config. A LOG_LEVEL the function forwards
+// itself wins. The queue-proxy sidecar is left alone: Knative takes its
+// level from the config-logging ConfigMap only, with no per-revision
+// override.
+func logLevelEnv(cfg *EnvConfig) []map[string]any {
+	if cfg.FunctionLogLevel == "" || slices.Contains(forwardedEnvVars(cfg), functionLogLevelEnvVar) {
+		return nil
+	}
+	return []map[string]any{{"name": functionLogLevelEnvVar, "value": cfg.FunctionLogLevel}}
+}
@@ -0,0 +1,34 @@
+package deployer
+
+import (
+	"testing"
+)
+
+func TestValidateFunctionLogLevel(t *testing.T) {
+	for _, level := range []string{"", "debug", "error"} {
+		if err := validateFunctionLogLevel(&EnvConfig{FunctionLogLevel: level}); err != nil {
+			t.Errorf("Unexpected error for %q: %v", level, err)
+		}
+	}
+	for _, level := range []string{"trace", "DEBUG"} {
+		if err := validateFunctionLogLevel(&EnvConfig{FunctionLogLevel: level}); err == nil {
+			t.Errorf("Expected error for %q", level)
+		}
+	}
+}
+
+func TestLogLevelEnv(t *testing.T) {
+	if env := logLevelEnv(&EnvConfig{}); len(env) != 0 {
+		t.Errorf("Expected no env without FUNCTION_LOG_LEVEL, got %v", env)
+	}
+
+	env := logLevelEnv(&EnvConfig{FunctionLogLevel: "debug"})
+	if len(env) != 1 || env[0]["name"] != "LOG_LEVEL" || env[0]["value"] != "debug" {
+		t.Errorf("Unexpected env %v", env)
+	}
+
+	t.Setenv("LOG_LEVEL", "warn")
+	if env := logLevelEnv(&EnvConfig{FunctionLogLevel: "debug", ForwardedEnvVars: "LOG_LEVEL"}); len(env) != 0 {
+		t.Errorf("Expected the forwarded LOG_LEVEL to win, got %v", env)
+	}
+}
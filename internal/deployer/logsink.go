@@ -0,0 +1,150 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var otelCollectorGVR = schema.GroupVersionResource{
+	Group:    "opentelemetry.io",
+	Version:  "v1beta1",
+	Resource: "opentelemetrycollectors",
+}
+
+// Log sinks selected by LOG_SINK.
+const (
+	// logSinkFluentBit annotates the pods for the Fluent Bit kubernetes
+	// filter, picking the parser from LOG_SINK_PARSER.
+	logSinkFluentBit = "fluentbit"
+	// logSinkOTel runs an OpenTelemetry Collector for the function that
+	// forwards the logs it receives over OTLP to LOG_SINK_ENDPOINT.
+	logSinkOTel = "otel"
+	// logSinkDatadog annotates the pods for Datadog log collection.
+	logSinkDatadog = "datadog"
+)
+
+// userContainer is the name Knative gives the function container.
+const userContainer = "user-container"
+
+func validateLogSink(cfg *EnvConfig) error {
+	switch cfg.LogSink {
+	case "", logSinkFluentBit, logSinkDatadog:
+		return nil
+	case logSinkOTel:
+		if cfg.LogSinkEndpoint == "" {
+			return fmt.Errorf("LOG_SINK_ENDPOINT is required for the otel log sink")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown LOG_SINK: %s", cfg.LogSink)
+	}
+}
+
+func logCollectorName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-logs"
+}
+
+// logSinkAnnotations are the revision template annotations routing the
+// function logs to the sink.
+func logSinkAnnotations(cfg *EnvConfig) map[string]any {
+	switch cfg.LogSink {
+	case logSinkFluentBit:
+		annotations := map[string]any{"fluentbit.io/exclude": "false"}
+		if cfg.LogSinkParser != "" {
+			annotations["fluentbit.io/parser"] = cfg.LogSinkParser
+		}
+		return annotations
+	case logSinkDatadog:
+		source := functionRuntime(cfg)
+		if source == "" {
+			source = "kdex"
+		}
+		logs, _ := json.Marshal([]map[string]string{{"source": source, "service": cfg.FunctionName}})
+		return map[string]any{"ad.datadoghq.com/" + userContainer + ".logs": string(logs)}
+	default:
+		return nil
+	}
+}
+
+// logSinkEnv points the OTLP log exporter of the function at its collector.
+func logSinkEnv(cfg *EnvConfig) []map[string]any {
+	if cfg.LogSink != logSinkOTel {
+		return nil
+	}
+	// The operator exposes the collector as <name>-collector
+	endpoint := fmt.Sprintf("http://%s-collector.%s.svc:4318/v1/logs", logCollectorName(cfg), cfg.FunctionNamespace)
+	return []map[string]any{
+		{"name": "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", "value": endpoint},
+	}
+}
+
+func buildLogCollector(cfg *EnvConfig) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "opentelemetry.io/v1beta1",
+			"kind":       "OpenTelemetryCollector",
+			"metadata": map[string]any{
+				"name":      logCollectorName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": map[string]any{
+				"mode": "deployment",
+				"config": map[string]any{
+					"receivers": map[string]any{
+						"otlp": map[string]any{
+							"protocols": map[string]any{
+								"http": map[string]any{"endpoint": "0.0.0.0:4318"},
+							},
+						},
+					},
+					"exporters": map[string]any{
+						"otlphttp": map[string]any{"endpoint": cfg.LogSinkEndpoint},
+					},
+					"service": map[string]any{
+						"pipelines": map[string]any{
+							"logs": map[string]any{
+								"receivers": []any{"otlp"},
+								"exporters": []any{"otlphttp"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// provisionLogSink applies the resources the sink needs beyond the
+// annotations and env of the revision.
+func provisionLogSink(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	if cfg.LogSink != logSinkOTel {
+		return nil
+	}
+
+	collector := buildLogCollector(cfg)
+	data, err := json.Marshal(collector)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	_, err = client.Resource(otelCollectorGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, collector.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply opentelemetry collector: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateLogSink(t *testing.T) {
+	for _, cfg := range []*EnvConfig{
+		{},
+		{LogSink: logSinkFluentBit},
+		{LogSink: logSinkDatadog},
+		{LogSink: logSinkOTel, LogSinkEndpoint: "http://collector:4318"},
+	} {
+		if err := validateLogSink(cfg); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", cfg, err)
+		}
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{LogSink: logSinkOTel},
+		{LogSink: "syslog"},
+	} {
+		if err := validateLogSink(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestBuildServiceLogSink(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "myimage",
+		FunctionRuntime:   "go",
+		LogSink:           logSinkDatadog,
+	}
+
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations, _, _ := unstructured.NestedStringMap(service.Object, "spec", "template", "metadata", "annotations")
+	if got := annotations["ad.datadoghq.com/user-container.logs"]; got != `[{"service":"myfunc","source":"go"}]` {
+		t.Errorf("Unexpected datadog annotation: %s", got)
+	}
+
+	cfg.LogSink = logSinkOTel
+	cfg.LogSinkEndpoint = "https://logs.example.com"
+	service, err = buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found, _ := unstructured.NestedMap(service.Object, "spec", "template", "metadata", "annotations"); found {
+		t.Error("Expected no annotations for the otel sink")
+	}
+	env := buildContainerEnv(cfg, "")
+	if len(env) != 1 || env[0]["name"] != "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT" || env[0]["value"] != "http://myfunc-logs-collector.myns.svc:4318/v1/logs" {
+		t.Errorf("Unexpected env: %v", env)
+	}
+}
@@ -0,0 +1,120 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxLogTailPods bounds how many pods of the failing revision have their
+// logs printed; their pods usually fail alike.
+const maxLogTailPods = 2
+
+// podLogReader reads the last lines a container of a pod logged, those of
+// its previous run when previous is set.
+type podLogReader func(ctx context.Context, namespace, pod, container string, lines int64, previous bool) (string, error)
+
+// parseLogTail reads LOG_TAIL_ON_FAILURE, the number of log lines to print
+// of the revision that does not become ready, zero when unset.
+func parseLogTail(cfg *EnvConfig) (int64, error) {
+	if cfg.LogTailOnFailure == "" {
+		return 0, nil
+	}
+	lines, err := strconv.ParseInt(cfg.LogTailOnFailure, 10, 64)
+	if err != nil || lines <= 0 {
+		return 0, fmt.Errorf("invalid LOG_TAIL_ON_FAILURE %q: expected a positive number of lines", cfg.LogTailOnFailure)
+	}
+	return lines, nil
+}
+
+// clusterPodLogs reads pod logs from the cluster. Logs are plain text, which
+// the dynamic client cannot decode.
+func clusterPodLogs() (podLogReader, error) {
+	config, err := restConfig(clientOptions.Kubeconfig, clientOptions.Context)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return func(ctx context.Context, namespace, pod, container string, lines int64, previous bool) (string, error) {
+		data, err := clientset.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("pods").
+			Name(pod).
+			SubResource("log").
+			Param("container", container).
+			Param("tailLines", strconv.FormatInt(lines, 10)).
+			Param("previous", strconv.FormatBool(previous)).
+			DoRaw(ctx)
+		return string(data), err
+	}, nil
+}
+
+// tailRevisionLogs prints the last lines the function logged in the pods of
+// the revision that did not become ready, so the job logs tell why it
+// crashed or never answered its probes.
+func tailRevisionLogs(ctx context.Context, client dynamic.Interface, readLogs podLogReader, cfg *EnvConfig, lines int64) {
+	service, err := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	revision, _, _ := unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+	if revision == "" {
+		return
+	}
+	pods, err := client.Resource(podGVR).Namespace(cfg.FunctionNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "serving.knative.dev/revision=" + revision,
+	})
+	if err != nil {
+		warnf("failed to list pods of revision %s: %v\n", revision, err)
+		return
+	}
+
+	for i, pod := range pods.Items {
+		if i == maxLogTailPods {
+			logf("Logs of %d more pods of revision %s left out\n", len(pods.Items)-i, revision)
+			break
+		}
+		previous := restartedContainer(&pod, userContainer)
+		logs, err := readLogs(ctx, cfg.FunctionNamespace, pod.GetName(), userContainer, lines, previous)
+		if err != nil {
+			warnf("failed to read logs of pod %s: %v\n", pod.GetName(), err)
+			continue
+		}
+		logs = strings.TrimRight(logs, "\n")
+		if logs == "" {
+			logf("Pod %s logged nothing\n", pod.GetName())
+			continue
+		}
+		run := "current"
+		if previous {
+			run = "previous"
+		}
+		logf("Last %d log lines of pod %s (%s run):\n", lines, pod.GetName(), run)
+		for line := range strings.SplitSeq(logs, "\n") {
+			logf("  %s\n", line)
+		}
+	}
+}
+
+// restartedContainer tells whether the container is waiting to run again
+// after it terminated, in which case its current run has no logs yet.
+func restartedContainer(pod *unstructured.Unstructured, container string) bool {
+	for _, status := range containerStatuses(pod) {
+		if name, _, _ := unstructured.NestedString(status, "name"); name != container {
+			continue
+		}
+		_, waiting, _ := unstructured.NestedMap(status, "state", "waiting")
+		_, terminated, _ := unstructured.NestedMap(status, "lastState", "terminated")
+		return waiting && terminated
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseLogTail(t *testing.T) {
+	if lines, err := parseLogTail(&EnvConfig{}); err != nil || lines != 0 {
+		t.Errorf("Expected no tail when unset, got %d, %v", lines, err)
+	}
+	if lines, err := parseLogTail(&EnvConfig{LogTailOnFailure: "200"}); err != nil || lines != 200 {
+		t.Errorf("Expected 200 lines, got %d, %v", lines, err)
+	}
+	for _, value := range []string{"0", "-5", "all"} {
+		if _, err := parseLogTail(&EnvConfig{LogTailOnFailure: value}); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestTailRevisionLogs(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "fn", nil)
+	service.Object["status"] = map[string]any{"latestCreatedRevisionName": "fn-00002"}
+	labels := map[string]string{"serving.knative.dev/revision": "fn-00002"}
+	crashing := newObject("v1", "Pod", "myns", "fn-00002-deployment-abc", labels)
+	crashing.Object["status"] = map[string]any{
+		"containerStatuses": []any{
+			map[string]any{
+				"name":      "user-container",
+				"state":     map[string]any{"waiting": map[string]any{"reason": "CrashLoopBackOff"}},
+				"lastState": map[string]any{"terminated": map[string]any{"reason": "Error", "exitCode": int64(1)}},
+			},
+		},
+	}
+	other := newObject("v1", "Pod", "myns", "other", nil)
+	client := newFakeDynamicClient(service, crashing, other)
+
+	type call struct {
+		pod, container string
+		lines          int64
+		previous       bool
+	}
+	calls := []call{}
+	readLogs := func(_ context.Context, namespace, pod, container string, lines int64, previous bool) (string, error) {
+		calls = append(calls, call{pod, container, lines, previous})
+		return "panic: missing DATABASE_URL\n", nil
+	}
+
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "myns"}
+	tailRevisionLogs(context.Background(), client, readLogs, cfg, 50)
+
+	if len(calls) != 1 {
+		t.Fatalf("Expected the logs of the revision pod only, got %+v", calls)
+	}
+	if want := (call{"fn-00002-deployment-abc", userContainer, 50, true}); calls[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, calls[0])
+	}
+}
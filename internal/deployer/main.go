@@ -0,0 +1,711 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+var (
+	knativeServiceGVR = schema.GroupVersionResource{
+		Group:    "serving.knative.dev",
+		Version:  "v1",
+		Resource: "services",
+	}
+
+	knativeRevisionGVR = schema.GroupVersionResource{
+		Group:    "serving.knative.dev",
+		Version:  "v1",
+		Resource: "revisions",
+	}
+
+	kdexFunctionGVR = schema.GroupVersionResource{
+		Group:    "kdex.dev",
+		Version:  "v1alpha1",
+		Resource: "kdexfunctions",
+	}
+)
+
+// EnvConfig is the deployer configuration. Each field is read from the env
+// var named by its env tag.
+type EnvConfig struct {
+	AlertColdStartThreshold              string `env:"ALERT_COLD_START_THRESHOLD"`
+	AlertErrorRateThreshold              string `env:"ALERT_ERROR_RATE_THRESHOLD"`
+	AlertSaturationThreshold             string `env:"ALERT_SATURATION_THRESHOLD"`
+	AlertTier                            string `env:"ALERT_TIER"`
+	AlertsEnabled                        string `env:"ALERTS_ENABLED"`
+	Audience                             string `env:"AUDIENCE"`
+	BuildBuilder                         string `env:"BUILD_BUILDER"`
+	BuildImage                           string `env:"BUILD_IMAGE"`
+	BuildPipeline                        string `env:"BUILD_PIPELINE"`
+	BuildServiceAccount                  string `env:"BUILD_SERVICE_ACCOUNT"`
+	BuildStrategy                        string `env:"BUILD_STRATEGY"`
+	CatalogMethod                        string `env:"CATALOG_METHOD"`
+	CatalogToken                         string `env:"CATALOG_TOKEN"`
+	CatalogURL                           string `env:"CATALOG_URL"`
+	ContainerConcurrency                 string `env:"CONTAINER_CONCURRENCY"`
+	CosignCertificateIdentity            string `env:"COSIGN_CERTIFICATE_IDENTITY"`
+	CosignCertificateOIDCIssuer          string `env:"COSIGN_CERTIFICATE_OIDC_ISSUER"`
+	CosignPublicKey                      string `env:"COSIGN_PUBLIC_KEY"`
+	CosignRekorPublicKey                 string `env:"COSIGN_REKOR_PUBLIC_KEY"`
+	CosignRoots                          string `env:"COSIGN_ROOTS"`
+	DashboardProvisioning                string `env:"DASHBOARD_PROVISIONING"`
+	DeployID                             string `env:"DEPLOY_ID"`
+	DeployPayloadPublicKey               string `env:"DEPLOY_PAYLOAD_PUBLIC_KEY"`
+	DeployPayloadSignature               string `env:"DEPLOY_PAYLOAD_SIGNATURE"`
+	DeployQuota                          string `env:"DEPLOY_QUOTA"`
+	DeployQuotaNamespace                 string `env:"DEPLOY_QUOTA_NAMESPACE"`
+	DeployQuotaTenantLabel               string `env:"DEPLOY_QUOTA_TENANT_LABEL"`
+	DeployResultConfigMap                string `env:"DEPLOY_RESULT_CONFIGMAP"`
+	DeployThrottle                       string `env:"DEPLOY_THROTTLE"`
+	DeployThrottleWindow                 string `env:"DEPLOY_THROTTLE_WINDOW"`
+	DeployTimeout                        string `env:"DEPLOY_TIMEOUT"`
+	DriftCheck                           string `env:"DRIFT_CHECK"`
+	DryRun                               string `env:"DRY_RUN"`
+	EgressAllow                          string `env:"EGRESS_ALLOW"`
+	EgressIstio                          string `env:"EGRESS_ISTIO"`
+	EnvFromConfigMaps                    string `env:"ENV_FROM_CONFIGMAPS"`
+	EnvFromSecrets                       string `env:"ENV_FROM_SECRETS"`
+	EventSink                            string `env:"EVENT_SINK"`
+	EventSinkExtensions                  string `env:"EVENT_SINK_EXTENSIONS"`
+	EventsSinkURL                        string `env:"EVENTS_SINK_URL"`
+	ExtraAnnotations                     string `env:"EXTRA_ANNOTATIONS"`
+	ExtraLabels                          string `env:"EXTRA_LABELS"`
+	ExtraRevisionAnnotations             string `env:"EXTRA_REVISION_ANNOTATIONS"`
+	ExtraRevisionLabels                  string `env:"EXTRA_REVISION_LABELS"`
+	ForwardedEnvVars                     string `env:"FORWARDED_ENV_VARS"`
+	FunctionBasePath                     string `env:"FUNCTION_BASEPATH"`
+	FunctionCPULimit                     string `env:"FUNCTION_CPU_LIMIT"`
+	FunctionCPURequest                   string `env:"FUNCTION_CPU_REQUEST"`
+	FunctionGeneration                   string `env:"FUNCTION_GENERATION"`
+	FunctionHost                         string `env:"FUNCTION_HOST"`
+	FunctionHostTLSIssuer                string `env:"FUNCTION_HOST_TLS_ISSUER"`
+	FunctionHostTLSIssuerKind            string `env:"FUNCTION_HOST_TLS_ISSUER_KIND"`
+	FunctionImage                        string `env:"FUNCTION_IMAGE"`
+	FunctionImageDigest                  string `env:"FUNCTION_IMAGE_DIGEST"`
+	FunctionLogLevel                     string `env:"FUNCTION_LOG_LEVEL"`
+	FunctionMemoryLimit                  string `env:"FUNCTION_MEMORY_LIMIT"`
+	FunctionMemoryRequest                string `env:"FUNCTION_MEMORY_REQUEST"`
+	FunctionName                         string `env:"FUNCTION_NAME"`
+	FunctionNamespace                    string `env:"FUNCTION_NAMESPACE"`
+	FunctionPriority                     string `env:"FUNCTION_PRIORITY"`
+	FunctionRuntime                      string `env:"FUNCTION_RUNTIME"`
+	FunctionServiceAccount               string `env:"FUNCTION_SERVICE_ACCOUNT"`
+	FunctionSourceGit                    string `env:"FUNCTION_SOURCE_GIT"`
+	FunctionSourceRevision               string `env:"FUNCTION_SOURCE_REVISION"`
+	FunctionVisibility                   string `env:"FUNCTION_VISIBILITY"`
+	GRPCMaxMessageSize                   string `env:"GRPC_MAX_MESSAGE_SIZE"`
+	GrafanaInstanceSelector              string `env:"GRAFANA_INSTANCE_SELECTOR"`
+	GrafanaToken                         string `env:"GRAFANA_TOKEN"`
+	GrafanaURL                           string `env:"GRAFANA_URL"`
+	HTTP2InitialConnectionWindowSize     string `env:"HTTP2_INITIAL_CONNECTION_WINDOW_SIZE"`
+	HTTP2InitialStreamWindowSize         string `env:"HTTP2_INITIAL_STREAM_WINDOW_SIZE"`
+	IPFamilies                           string `env:"IP_FAMILIES"`
+	IPFamilyPolicy                       string `env:"IP_FAMILY_POLICY"`
+	IsolatedNamespaces                   string `env:"ISOLATED_NAMESPACES"`
+	Issuer                               string `env:"ISSUER"`
+	JWKSURL                              string `env:"JWKS_URL"`
+	JobBackoffLimit                      string `env:"JOB_BACKOFF_LIMIT"`
+	JobHistoryLimit                      string `env:"JOB_HISTORY_LIMIT"`
+	JobImage                             string `env:"JOB_IMAGE"`
+	JobSecret                            string `env:"JOB_SECRET"`
+	JobServiceAccount                    string `env:"JOB_SERVICE_ACCOUNT"`
+	JobTTL                               string `env:"JOB_TTL"`
+	LogFormat                            string `env:"LOG_FORMAT"`
+	LogLevel                             string `env:"LOG_LEVEL"`
+	LogSink                              string `env:"LOG_SINK"`
+	LogSinkEndpoint                      string `env:"LOG_SINK_ENDPOINT"`
+	LogSinkParser                        string `env:"LOG_SINK_PARSER"`
+	LogTailOnFailure                     string `env:"LOG_TAIL_ON_FAILURE"`
+	MaxRequestBodySize                   string `env:"MAX_REQUEST_BODY_SIZE"`
+	MessageTemplates                     string `env:"MESSAGE_TEMPLATES"`
+	NotifiersConfig                      string `env:"NOTIFIERS_CONFIG"`
+	NotifyFormat                         string `env:"NOTIFY_FORMAT"`
+	NotifyTemplate                       string `env:"NOTIFY_TEMPLATE"`
+	NotifyURL                            string `env:"NOTIFY_URL"`
+	ProgressiveHealthPath                string `env:"PROGRESSIVE_HEALTH_PATH"`
+	ProgressiveInterval                  string `env:"PROGRESSIVE_INTERVAL"`
+	ProgressiveSteps                     string `env:"PROGRESSIVE_STEPS"`
+	PollInterval                         string `env:"POLL_INTERVAL"`
+	PreStop                              string `env:"PRE_STOP"`
+	PreviewBranch                        string `env:"PREVIEW_BRANCH"`
+	PreviewOf                            string `env:"PREVIEW_OF"`
+	PreviewTTL                           string `env:"PREVIEW_TTL"`
+	Probes                               string `env:"PROBES"`
+	PublicURLInjection                   string `env:"PUBLIC_URL_INJECTION"`
+	ReadOnly                             string `env:"READ_ONLY"`
+	ReadinessRegressionFactor            string `env:"READINESS_REGRESSION_FACTOR"`
+	RedactPatterns                       string `env:"REDACT_PATTERNS"`
+	RegistryToken                        string `env:"REGISTRY_TOKEN"`
+	RegistryURL                          string `env:"REGISTRY_URL"`
+	RequestAuthentication                string `env:"REQUEST_AUTHENTICATION"`
+	RequestTimeout                       string `env:"REQUEST_TIMEOUT"`
+	ResolveImageDigest                   string `env:"RESOLVE_IMAGE_DIGEST"`
+	SLOAvailabilityTarget                string `env:"SLO_AVAILABILITY_TARGET"`
+	SLOLatencyThreshold                  string `env:"SLO_LATENCY_THRESHOLD"`
+	ScalingActivationScale               string `env:"SCALING_ACTIVATION_SCALE"`
+	ScalingInitialScale                  string `env:"SCALING_INITIAL_SCALE"`
+	ScalingMaxScale                      string `env:"SCALING_MAX_SCALE"`
+	ScalingMetric                        string `env:"SCALING_METRIC"`
+	ScalingMetricPath                    string `env:"SCALING_METRIC_PATH"`
+	ScalingMetricPort                    string `env:"SCALING_METRIC_PORT"`
+	ScalingMinScale                      string `env:"SCALING_MIN_SCALE"`
+	ScalingPanicThresholdPercentage      string `env:"SCALING_PANIC_THRESHOLD_PERCENTAGE"`
+	ScalingPanicWindowPercentage         string `env:"SCALING_PANIC_WINDOW_PERCENTAGE"`
+	ScalingScaleDownDelay                string `env:"SCALING_SCALE_DOWN_DELAY"`
+	ScalingScaleToZeroPodRetentionPeriod string `env:"SCALING_SCALE_TO_ZERO_POD_RETENTION_PERIOD"`
+	ScalingScheduleJSON                  string `env:"SCALING_SCHEDULE_JSON"`
+	ScalingStableWindow                  string `env:"SCALING_STABLE_WINDOW"`
+	ScalingTarget                        string `env:"SCALING_TARGET"`
+	ScalingTargetUtilizationPercentage   string `env:"SCALING_TARGET_UTILIZATION_PERCENTAGE"`
+	ScheduleCron                         string `env:"SCHEDULE_CRON"`
+	ScheduleData                         string `env:"SCHEDULE_DATA"`
+	SessionAffinity                      string `env:"SESSION_AFFINITY"`
+	SkipStatusUpdate                     string `env:"SKIP_STATUS_UPDATE"`
+	SkipTrafficShift                     string `env:"SKIP_TRAFFIC_SHIFT"`
+	SkipVerify                           string `env:"SKIP_VERIFY"`
+	SoakDuration                         string `env:"SOAK_DURATION"`
+	StatusBatchWindow                    string `env:"STATUS_BATCH_WINDOW"`
+	TerminationGracePeriod               string `env:"TERMINATION_GRACE_PERIOD"`
+	Traffic                              string `env:"TRAFFIC"`
+	TracingEnabled                       string `env:"TRACING_ENABLED"`
+	TracingEndpoint                      string `env:"TRACING_ENDPOINT"`
+	TracingSampleRatio                   string `env:"TRACING_SAMPLE_RATIO"`
+	VerifyImageSignature                 string `env:"VERIFY_IMAGE_SIGNATURE"`
+	Volumes                              string `env:"VOLUMES"`
+
+	// FunctionEnv holds the values of forwarded env vars given by a
+	// KDexFunction rather than copied from the env. It is not a setting.
+	FunctionEnv map[string]string
+	// DetectedRuntime is the runtime detected from the image labels when
+	// FUNCTION_RUNTIME is not set. It is not a setting either.
+	DetectedRuntime string
+}
+
+// readEnv reads every setting from its env var, leaving the checks to the
+// caller.
+func readEnv() *EnvConfig {
+	return envConfig(os.Getenv)
+}
+
+// envConfig reads the settings with lookup, which returns the value of the
+// env var it is given.
+func envConfig(lookup func(string) string) *EnvConfig {
+	cfg := &EnvConfig{}
+	config := reflect.ValueOf(cfg).Elem()
+	for i, field := range reflect.VisibleFields(config.Type()) {
+		if name := field.Tag.Get("env"); name != "" {
+			config.Field(i).SetString(lookup(name))
+		}
+	}
+	return cfg
+}
+
+func LoadEnv() (*EnvConfig, error) {
+	cfg := readEnv()
+	if cfg.FunctionName == "" {
+		return nil, fmt.Errorf("FUNCTION_NAME is required")
+	}
+	if cfg.FunctionNamespace == "" {
+		return nil, fmt.Errorf("FUNCTION_NAMESPACE is required")
+	}
+	// Image might not be required for observe?
+	// But let's keep it strict if deployer job provides it.
+	// For observer cronjob, deployer might pass it too.
+	// Let's make it optional for observe if needed, but for now strict.
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" && len(os.Args) > 1 && os.Args[1] == "deploy" {
+		return nil, fmt.Errorf("FUNCTION_IMAGE or FUNCTION_SOURCE_GIT is required for deploy")
+	}
+	logFunction(cfg)
+	redactConfig(cfg)
+
+	return cfg, nil
+}
+
+// parseKeyValues parses a comma separated list of key=value pairs.
+func parseKeyValues(value string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for pair := range strings.SplitSeq(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid pair %q: expected key=value", pair)
+		}
+		pairs[k] = strings.TrimSpace(v)
+	}
+	return pairs, nil
+}
+
+// Main runs the command named by the first argument, deploy by default,
+// exiting with a non-zero status when it fails.
+func Main() {
+	if err := setupLogging(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	kubeconfig, kubeContext, args, err := extractClientFlags(os.Args[1:])
+	if err != nil {
+		logError(err)
+		os.Exit(1)
+	}
+	clientOptions.Kubeconfig = kubeconfig
+	clientOptions.Context = kubeContext
+	// LoadEnv looks at the command in os.Args
+	os.Args = append(os.Args[:1], args...)
+
+	cmd := "deploy"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	flushSpans := setupTracing(context.Background())
+
+	switch cmd {
+	case "deploy":
+		err = runDeploy(args)
+	case "observe":
+		err = runObserve(args)
+	case "delete":
+		err = runDelete()
+	case "rollback":
+		err = runRollback()
+	case "diff":
+		err = runDiff()
+	case "catalog-info":
+		err = runCatalogInfo(args)
+	case "job-manifest":
+		err = runJobManifest(args)
+	case "scale-test":
+		err = runScaleTest(args)
+	case "controller":
+		err = runController(args)
+	case "deploy-payload":
+		err = runDeployPayload()
+	case "scale":
+		err = runScale()
+	case "preview":
+		err = runPreview(args)
+	case "report":
+		err = runReport(args)
+	case "status":
+		err = runStatus(args)
+	case "export":
+		err = runExport(args)
+	case "validate":
+		err = runValidate(args)
+	default:
+		err = fmt.Errorf("unknown command: %s", cmd)
+	}
+
+	flushSpans()
+	if err != nil {
+		logError(err)
+		os.Exit(1)
+	}
+}
+
+// dynamicClient is shared by everything the process does, so its reads
+// are cached across steps.
+var dynamicClient dynamic.Interface
+
+func getDynamicClient() (dynamic.Interface, error) {
+	if dynamicClient != nil {
+		return dynamicClient, nil
+	}
+	config, err := restConfig(clientOptions.Kubeconfig, clientOptions.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	var client dynamic.Interface = cluster
+	// Enforced here rather than by each command, so none can write
+	if os.Getenv("READ_ONLY") == "true" {
+		logf("Read-only: writes to the cluster are logged, not made\n")
+		client = readOnlyClient{cluster}
+	}
+	dynamicClient = newReadCache(client, readCacheTTL, cachedResources...)
+	return dynamicClient, nil
+}
+
+func runDeploy(args []string) error {
+	flags := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	progressive := flags.Bool("progressive", false, "shift traffic to the new revision in steps, rolling back on failure")
+	var dryRun dryRunFlag
+	flags.Var(&dryRun, "dry-run", "print the knative service instead of deploying it: client (default) or server")
+	output := flags.String("output", "yaml", "dry run output format: yaml or json")
+	fromFunction := flags.String("from-kdexfunction", "", "take the deployment parameters from a KDexFunction file or [namespace/]name")
+	timeout := flags.String("timeout", "", "how long to wait for the service to become ready, overriding DEPLOY_TIMEOUT")
+	pollInterval := flags.String("poll-interval", "", "how often to check readiness when the service cannot be watched, overriding POLL_INTERVAL")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var cfg *EnvConfig
+	if *fromFunction != "" {
+		function, err := readKDexFunction(context.Background(), *fromFunction)
+		if err != nil {
+			return err
+		}
+		if cfg, err = kdexFunctionConfig(function, readEnv()); err != nil {
+			return err
+		}
+		if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" {
+			return fmt.Errorf("spec.image or spec.source.git is required for deploy")
+		}
+	} else {
+		var err error
+		if cfg, err = LoadEnv(); err != nil {
+			return err
+		}
+	}
+
+	if *timeout != "" {
+		cfg.DeployTimeout = *timeout
+	}
+	if *pollInterval != "" {
+		cfg.PollInterval = *pollInterval
+	}
+
+	d := &deployment{cfg: cfg, progressive: *progressive}
+
+	mode, err := dryRunMode(string(dryRun), cfg.DryRun)
+	if err != nil {
+		return err
+	}
+	if mode != "" {
+		if err := validateDeploy(context.Background(), d); err != nil {
+			return err
+		}
+		return runDryRun(context.Background(), cfg, mode, *output)
+	}
+
+	return runDeployment(context.Background(), d)
+}
+
+// runDeployment runs the deploy pipeline, in the trace of whatever started
+// the Job, and writes the termination message of its outcome, and with
+// DEPLOY_RESULT_CONFIGMAP the result ConfigMap.
+func runDeployment(ctx context.Context, d *deployment) error {
+	ctx = traceContextFromEnv(ctx)
+	d.started = time.Now()
+	if err := newDeployPipeline().run(ctx, d); err != nil {
+		msg := d.failureMessage(err)
+		d.saveResult(ctx, msg)
+		if err := d.writeTerminationMessage(ctx, msg); err != nil {
+			warnf("failed to write termination message: %v\n", err)
+		}
+		return err
+	}
+
+	msg := d.terminationMessage()
+	d.saveResult(ctx, msg)
+	if err := d.writeTerminationMessage(ctx, msg); err != nil {
+		return fmt.Errorf("failed to write termination message: %w", err)
+	}
+
+	return nil
+}
+
+// applyService applies the Knative Service rendered for the given state.
+func applyService(ctx context.Context, resourceClient dynamic.ResourceInterface, cfg *EnvConfig, state serviceState) error {
+	service, err := buildService(cfg, state)
+	if err != nil {
+		return err
+	}
+
+	// We'll use Server-Side Apply
+	data, err := json.Marshal(service)
+	if err != nil {
+		return fmt.Errorf("failed to marshal service: %w", err)
+	}
+
+	// Force ownership to allow overwriting
+	force := true
+	_, err = resourceClient.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply knative service: %w", err)
+	}
+
+	logf("Knative Service %s/%s applied successfully\n", cfg.FunctionNamespace, cfg.FunctionName)
+	return nil
+}
+
+// applyAndWait applies the Knative Service rendered for the given state and
+// waits for it to become ready, returning its URL. diagnose, when given,
+// tells why it does not.
+func applyAndWait(ctx context.Context, resourceClient dynamic.ResourceInterface, cfg *EnvConfig, state serviceState, diagnose readyDiagnoser) (string, error) {
+	if err := applyService(ctx, resourceClient, cfg, state); err != nil {
+		return "", err
+	}
+
+	timing, err := parseWaitTiming(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	// Wait for Readiness
+	logf("Waiting for service to be Ready...\n")
+	url, err := waitForReady(ctx, resourceClient, cfg.FunctionName, timing, diagnose)
+	if err != nil {
+		return "", fmt.Errorf("failed to wait for service readiness: %w", err)
+	}
+
+	logf("Service is Ready. URL: %s\n", url)
+
+	return url, nil
+}
+
+func parseKnativeStatus(obj *unstructured.Unstructured) (bool, string, string) {
+	status, found, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil || !found {
+		return false, "No status", ""
+	}
+
+	url := serviceURL(obj)
+
+	conditions, found, err := unstructured.NestedSlice(status, "conditions")
+	if err != nil || !found {
+		return false, "No conditions", url
+	}
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if cond["type"] == "Ready" {
+			if cond["status"] == "True" {
+				return true, "", url
+			}
+			return false, fmt.Sprintf("%v", cond["message"]), url
+		}
+	}
+
+	return false, "Ready condition not found", url
+}
+
+// waitTiming bounds the wait for readiness and sets how often to check when
+// the Service cannot be watched.
+type waitTiming struct {
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+var defaultWaitTiming = waitTiming{
+	Timeout:      5 * time.Minute,
+	PollInterval: 2 * time.Second,
+}
+
+// parseWaitTiming reads DEPLOY_TIMEOUT and POLL_INTERVAL as durations,
+// defaulting to 5m and 2s.
+func parseWaitTiming(cfg *EnvConfig) (waitTiming, error) {
+	timing := defaultWaitTiming
+	if cfg.DeployTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.DeployTimeout)
+		if err != nil || timeout <= 0 {
+			return waitTiming{}, fmt.Errorf("invalid DEPLOY_TIMEOUT %q: expected a positive duration", cfg.DeployTimeout)
+		}
+		timing.Timeout = timeout
+	}
+	if cfg.PollInterval != "" {
+		interval, err := time.ParseDuration(cfg.PollInterval)
+		if err != nil || interval <= 0 {
+			return waitTiming{}, fmt.Errorf("invalid POLL_INTERVAL %q: expected a positive duration", cfg.PollInterval)
+		}
+		timing.PollInterval = interval
+	}
+	if timing.PollInterval > timing.Timeout {
+		return waitTiming{}, fmt.Errorf("POLL_INTERVAL %s is longer than DEPLOY_TIMEOUT %s", timing.PollInterval, timing.Timeout)
+	}
+	return timing, nil
+}
+
+// waitForReady watches the Service until it reports Ready for its latest
+// spec, re-watching whenever the watch closes or its resource version
+// expires. It falls back to polling when the watch cannot be established.
+// While the Service is not ready, diagnose, when given, tells why: the
+// wait fails at once on a cause that will not clear, and reports the last
+// cause seen when it times out.
+func waitForReady(ctx context.Context, client dynamic.ResourceInterface, name string, timing waitTiming, diagnose readyDiagnoser) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timing.Timeout)
+	defer cancel()
+
+	var cause *report.RevisionFailure
+	check := func(obj *unstructured.Unstructured) (string, bool, error) {
+		if url, ready := checkReady(obj); ready || diagnose == nil {
+			return url, ready, nil
+		}
+		failure := diagnose(ctx, obj)
+		if failure != nil && (cause == nil || *failure != *cause) {
+			logf("Waiting... (Revision: %s)\n", failure)
+		}
+		cause = failure
+		if failure != nil && failure.Terminal() {
+			return "", false, failure
+		}
+		return "", false, nil
+	}
+	waitError := func(err error) error {
+		return readyWaitError(ctx, err, cause)
+	}
+
+	for {
+		resourceVersion := ""
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			url, ready, err := check(obj)
+			if err != nil || ready {
+				return url, err
+			}
+			resourceVersion = obj.GetResourceVersion()
+		} else if !errors.IsNotFound(err) {
+			return "", waitError(err)
+		}
+
+		w, err := client.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", waitError(ctx.Err())
+			}
+			logf("Watch unavailable, polling instead: %v\n", err)
+			url, err := pollForReady(ctx, client, name, timing.PollInterval, check)
+			if err != nil {
+				return "", waitError(err)
+			}
+			return url, nil
+		}
+
+		url, ready, err := watchForReady(ctx, w, check)
+		w.Stop()
+		if err != nil {
+			return "", waitError(err)
+		}
+		if ready {
+			return url, nil
+		}
+	}
+}
+
+// readyCheck tells whether an observed object is ready, or failed for good.
+type readyCheck func(obj *unstructured.Unstructured) (string, bool, error)
+
+// watchForReady consumes watch events until the Service is ready. It
+// reports not ready without error when the watch must be restarted.
+func watchForReady(ctx context.Context, w watch.Interface, check readyCheck) (string, bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return "", false, nil
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				obj, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				url, ready, err := check(obj)
+				if err != nil || ready {
+					return url, ready, err
+				}
+			case watch.Error:
+				err := errors.FromObject(event.Object)
+				if errors.IsGone(err) || errors.IsResourceExpired(err) {
+					return "", false, nil
+				}
+				return "", false, err
+			}
+		}
+	}
+}
+
+// pollForReady checks the Service every interval until it is ready.
+func pollForReady(ctx context.Context, client dynamic.ResourceInterface, name string, interval time.Duration, check readyCheck) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+			obj, err := client.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return "", err
+			}
+			url, ready, err := check(obj)
+			if err != nil || ready {
+				return url, err
+			}
+		}
+	}
+}
+
+// checkReady tells whether the Service is ready for its latest spec,
+// logging why not.
+func checkReady(obj *unstructured.Unstructured) (string, bool) {
+	// Ready reported before the controller saw the latest spec
+	// belongs to the previous revision
+	observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observedGeneration < obj.GetGeneration() {
+		return "", false
+	}
+
+	isReady, msg, url := parseKnativeStatus(obj)
+	if isReady {
+		return url, true
+	}
+
+	if msg != "" {
+		logf("Waiting... (Reason: %s)\n", msg)
+	}
+	return "", false
+}
+
+// readyWaitError reports running out of time as a timeout rather than as
+// whatever call the deadline interrupted, along with the last known cause.
+func readyWaitError(ctx context.Context, err error, cause *report.RevisionFailure) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		if cause != nil {
+			return fmt.Errorf("timeout waiting for service readiness: %w", cause)
+		}
+		return fmt.Errorf("timeout waiting for service readiness")
+	}
+	return err
+}
@@ -1,4 +1,4 @@
-package main
+package deployer
 
 import (
 	"os"
@@ -46,6 +46,13 @@ func TestLoadEnv(t *testing.T) {
 		t.Fatal("Expected error when FUNCTION_IMAGE is missing for deploy")
 	}
 
+	_ = os.Setenv("FUNCTION_SOURCE_GIT", "https://example.com/repo.git")
+	_, err = LoadEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error when building from source: %v", err)
+	}
+	_ = os.Unsetenv("FUNCTION_SOURCE_GIT")
+
 	_ = os.Setenv("FUNCTION_IMAGE", "myimg")
 	cfg, err = LoadEnv()
 	if err != nil {
@@ -115,40 +122,14 @@ func TestRunDeployAndObserve(t *testing.T) {
 	_ = os.Setenv("KUBERNETES_SERVICE_HOST", "localhost")
 	_ = os.Setenv("KUBERNETES_SERVICE_PORT", "6443")
 
-	err := runDeploy()
+	err := runDeploy(nil)
 	if err == nil {
 		t.Fatal("Expected error because cluster is not reachable")
 	}
 
-	err = runObserve()
+	err = runObserve(nil)
 	if err == nil {
 		t.Log("BUG: runObserve unexpectedly succeeded when given a mock KUBERNETES_SERVICE_HOST")
 		t.Fatal("Expected error because cluster is not reachable")
 	}
 }
-
-func TestWriteTerminationMessage(t *testing.T) {
-	f, err := os.CreateTemp("", "term-log")
-	if err != nil {
-		t.Fatal(err)
-	}
-	_ = f.Close()
-	defer func() {
-		_ = os.Remove(f.Name())
-	}()
-
-	_ = os.Setenv("TERMINATION_LOG_PATH", f.Name())
-	defer func() {
-		_ = os.Unsetenv("TERMINATION_LOG_PATH")
-	}()
-
-	err = writeTerminationMessage("http://foo.bar")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	b, _ := os.ReadFile(f.Name())
-	if string(b) != `{"url":"http://foo.bar"}` {
-		t.Errorf("Unexpected output: %s", string(b))
-	}
-}
@@ -0,0 +1,238 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var envoyFilterGVR = schema.GroupVersionResource{
+	Group:    "networking.istio.io",
+	Version:  "v1alpha3",
+	Resource: "envoyfilters",
+}
+
+// grpcMaxMessageSizeEnvVar carries GRPC_MAX_MESSAGE_SIZE to the function,
+// for its gRPC server to accept messages as large as the mesh lets through.
+const grpcMaxMessageSizeEnvVar = "KDEX_GRPC_MAX_MESSAGE_SIZE"
+
+// HTTP/2 flow control windows Envoy accepts.
+const (
+	minHTTP2WindowSize = 65535
+	maxHTTP2WindowSize = math.MaxInt32
+)
+
+// messageLimits are the sizes of MAX_REQUEST_BODY_SIZE,
+// HTTP2_INITIAL_STREAM_WINDOW_SIZE, HTTP2_INITIAL_CONNECTION_WINDOW_SIZE
+// and GRPC_MAX_MESSAGE_SIZE in bytes, zero when unset.
+type messageLimits struct {
+	MaxRequestBody   int64
+	StreamWindow     int64
+	ConnectionWindow int64
+	GRPCMaxMessage   int64
+}
+
+func (l messageLimits) empty() bool {
+	return l == messageLimits{}
+}
+
+// parseMessageLimits reads the message size settings as quantities such as
+// 8Mi.
+func parseMessageLimits(cfg *EnvConfig) (messageLimits, error) {
+	var limits messageLimits
+	for _, s := range []struct {
+		name     string
+		value    string
+		min, max int64
+		size     *int64
+	}{
+		{"MAX_REQUEST_BODY_SIZE", cfg.MaxRequestBodySize, 1, math.MaxUint32, &limits.MaxRequestBody},
+		{"HTTP2_INITIAL_STREAM_WINDOW_SIZE", cfg.HTTP2InitialStreamWindowSize, minHTTP2WindowSize, maxHTTP2WindowSize, &limits.StreamWindow},
+		{"HTTP2_INITIAL_CONNECTION_WINDOW_SIZE", cfg.HTTP2InitialConnectionWindowSize, minHTTP2WindowSize, maxHTTP2WindowSize, &limits.ConnectionWindow},
+		{"GRPC_MAX_MESSAGE_SIZE", cfg.GRPCMaxMessageSize, 1, math.MaxUint32, &limits.GRPCMaxMessage},
+	} {
+		if s.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(s.value)
+		if err != nil || quantity.Value() < s.min || quantity.Value() > s.max {
+			return messageLimits{}, fmt.Errorf("invalid %s %q: expected a size between %d and %d bytes", s.name, s.value, s.min, s.max)
+		}
+		*s.size = quantity.Value()
+	}
+	if limits.StreamWindow > 0 && limits.ConnectionWindow > 0 && limits.StreamWindow > limits.ConnectionWindow {
+		return messageLimits{}, fmt.Errorf("HTTP2_INITIAL_STREAM_WINDOW_SIZE %s exceeds HTTP2_INITIAL_CONNECTION_WINDOW_SIZE %s",
+			cfg.HTTP2InitialStreamWindowSize, cfg.HTTP2InitialConnectionWindowSize)
+	}
+	return limits, nil
+}
+
+func validateMessageLimits(cfg *EnvConfig) error {
+	_, err := parseMessageLimits(cfg)
+	return err
+}
+
+// messageLimitsEnv tells the function the gRPC message size the mesh lets
+// through. Variables the function forwards itself win.
+func messageLimitsEnv(cfg *EnvConfig) []map[string]any {
+	limits, err := parseMessageLimits(cfg)
+	if err != nil || limits.GRPCMaxMessage == 0 {
+		return nil
+	}
+	for _, name := range forwardedEnvVars(cfg) {
+		if name == grpcMaxMessageSizeEnvVar {
+			return nil
+		}
+	}
+	return []map[string]any{{
+		"name":  grpcMaxMessageSizeEnvVar,
+		"value": strconv.FormatInt(limits.GRPCMaxMessage, 10),
+	}}
+}
+
+// buildEnvoyFilter renders the EnvoyFilter applying the limits to the
+// inbound traffic of the function's sidecar. The request body limit
+// buffers whole requests, so it does not suit streaming functions; Envoy
+// answers 413 to larger ones. A gRPC message must fit the connection
+// buffers to be forwarded whole. A non-zero timeout, REQUEST_TIMEOUT,
+// replaces the request and stream idle timeouts of the sidecar, whose 5
+// minute default would otherwise cut longer requests short.
+func buildEnvoyFilter(cfg *EnvConfig, limits messageLimits, timeout time.Duration) *unstructured.Unstructured {
+	patches := []any{}
+	if limits.MaxRequestBody > 0 {
+		patches = append(patches, map[string]any{
+			"applyTo": "HTTP_FILTER",
+			"match": map[string]any{
+				"context": "SIDECAR_INBOUND",
+				"listener": map[string]any{
+					"filterChain": map[string]any{
+						"filter": map[string]any{
+							"name":      "envoy.filters.network.http_connection_manager",
+							"subFilter": map[string]any{"name": "envoy.filters.http.router"},
+						},
+					},
+				},
+			},
+			"patch": map[string]any{
+				"operation": "INSERT_BEFORE",
+				"value": map[string]any{
+					"name": "envoy.filters.http.buffer",
+					"typed_config": map[string]any{
+						"@type":             "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer",
+						"max_request_bytes": limits.MaxRequestBody,
+					},
+				},
+			},
+		})
+	}
+
+	connectionManager := map[string]any{
+		"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+	}
+	if limits.StreamWindow > 0 || limits.ConnectionWindow > 0 {
+		http2 := map[string]any{}
+		if limits.StreamWindow > 0 {
+			http2["initial_stream_window_size"] = limits.StreamWindow
+		}
+		if limits.ConnectionWindow > 0 {
+			http2["initial_connection_window_size"] = limits.ConnectionWindow
+		}
+		connectionManager["http2_protocol_options"] = http2
+	}
+	if timeout > 0 {
+		connectionManager["request_timeout"] = fmt.Sprintf("%ds", int64(timeout.Seconds()))
+		connectionManager["stream_idle_timeout"] = fmt.Sprintf("%ds", int64(timeout.Seconds()))
+	}
+	if len(connectionManager) > 1 {
+		patches = append(patches, map[string]any{
+			"applyTo": "NETWORK_FILTER",
+			"match": map[string]any{
+				"context": "SIDECAR_INBOUND",
+				"listener": map[string]any{
+					"filterChain": map[string]any{
+						"filter": map[string]any{"name": "envoy.filters.network.http_connection_manager"},
+					},
+				},
+			},
+			"patch": map[string]any{
+				"operation": "MERGE",
+				"value":     map[string]any{"typed_config": connectionManager},
+			},
+		})
+	}
+
+	if limits.GRPCMaxMessage > 0 {
+		for _, applyTo := range []string{"LISTENER", "CLUSTER"} {
+			patches = append(patches, map[string]any{
+				"applyTo": applyTo,
+				"match":   map[string]any{"context": "SIDECAR_INBOUND"},
+				"patch": map[string]any{
+					"operation": "MERGE",
+					"value":     map[string]any{"per_connection_buffer_limit_bytes": limits.GRPCMaxMessage},
+				},
+			})
+		}
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": envoyFilterGVR.GroupVersion().String(),
+			"kind":       "EnvoyFilter",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"workloadSelector": map[string]any{
+					"labels": map[string]any{"serving.knative.dev/service": cfg.FunctionName},
+				},
+				"configPatches": patches,
+			},
+		},
+	}
+}
+
+// provisionMessageLimits applies the EnvoyFilter of the message size
+// settings before the Service, so no revision serves with the mesh
+// defaults, or removes that of an earlier deploy when none is set.
+func provisionMessageLimits(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	limits, err := parseMessageLimits(cfg)
+	if err != nil {
+		return err
+	}
+	filters := client.Resource(envoyFilterGVR).Namespace(cfg.FunctionNamespace)
+	if limits.empty() {
+		// The CRD may not even be installed, which is fine
+		err := filters.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete envoyfilters %s: %w", cfg.FunctionName, err)
+		}
+		return nil
+	}
+
+	timeout, err := requestTimeout(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(buildEnvoyFilter(cfg, limits, timeout))
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = filters.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply envoyfilters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseMessageLimits(t *testing.T) {
+	cfg := &EnvConfig{
+		MaxRequestBodySize:               "10Mi",
+		HTTP2InitialStreamWindowSize:     "1Mi",
+		HTTP2InitialConnectionWindowSize: "4Mi",
+		GRPCMaxMessageSize:               "16Mi",
+	}
+	limits, err := parseMessageLimits(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := messageLimits{MaxRequestBody: 10 << 20, StreamWindow: 1 << 20, ConnectionWindow: 4 << 20, GRPCMaxMessage: 16 << 20}
+	if limits != want {
+		t.Errorf("Expected %+v, got %+v", want, limits)
+	}
+
+	for _, cfg := range []EnvConfig{
+		{MaxRequestBodySize: "big"},
+		{MaxRequestBodySize: "0"},
+		{GRPCMaxMessageSize: "5Gi"},
+		{HTTP2InitialStreamWindowSize: "1Ki"},
+		{HTTP2InitialStreamWindowSize: "4Mi", HTTP2InitialConnectionWindowSize: "1Mi"},
+	} {
+		if _, err := parseMessageLimits(&cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestBuildEnvoyFilter(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+	filter := buildEnvoyFilter(cfg, messageLimits{MaxRequestBody: 1024, GRPCMaxMessage: 2048}, 0)
+
+	patches, _, _ := unstructured.NestedSlice(filter.Object, "spec", "configPatches")
+	applied := []string{}
+	for _, p := range patches {
+		applied = append(applied, p.(map[string]any)["applyTo"].(string))
+	}
+	if len(applied) != 3 || applied[0] != "HTTP_FILTER" || applied[1] != "LISTENER" || applied[2] != "CLUSTER" {
+		t.Errorf("Expected the buffer filter and connection buffer limits, got %v", applied)
+	}
+	selector, _, _ := unstructured.NestedString(filter.Object, "spec", "workloadSelector", "labels", "serving.knative.dev/service")
+	if selector != "fn" {
+		t.Errorf("Expected the filter to select the function pods, got %q", selector)
+	}
+}
+
+func TestBuildEnvoyFilterRequestTimeout(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+	filter := buildEnvoyFilter(cfg, messageLimits{StreamWindow: 1 << 20}, 10*time.Minute)
+
+	patches, _, _ := unstructured.NestedSlice(filter.Object, "spec", "configPatches")
+	if len(patches) != 1 {
+		t.Fatalf("Expected a single connection manager patch, got %v", patches)
+	}
+	manager, _, _ := unstructured.NestedMap(patches[0].(map[string]any), "patch", "value", "typed_config")
+	if manager["request_timeout"] != "600s" || manager["stream_idle_timeout"] != "600s" {
+		t.Errorf("Expected REQUEST_TIMEOUT to replace the sidecar timeouts, got %v", manager)
+	}
+	if window, _, _ := unstructured.NestedInt64(manager, "http2_protocol_options", "initial_stream_window_size"); window != 1<<20 {
+		t.Errorf("Expected the stream window next to the timeouts, got %v", manager)
+	}
+}
+
+func TestMessageLimitsEnv(t *testing.T) {
+	env := messageLimitsEnv(&EnvConfig{GRPCMaxMessageSize: "4Mi"})
+	if len(env) != 1 || env[0]["name"] != grpcMaxMessageSizeEnvVar || env[0]["value"] != "4194304" {
+		t.Errorf("Unexpected env %v", env)
+	}
+	if env := messageLimitsEnv(&EnvConfig{GRPCMaxMessageSize: "4Mi", ForwardedEnvVars: grpcMaxMessageSizeEnvVar}); env != nil {
+		t.Errorf("Expected the forwarded variable to win, got %v", env)
+	}
+	if env := messageLimitsEnv(&EnvConfig{}); env != nil {
+		t.Errorf("Expected no env without GRPC_MAX_MESSAGE_SIZE, got %v", env)
+	}
+}
@@ -0,0 +1,173 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Status details the observer and the TTL write, which MESSAGE_TEMPLATES
+// may reword.
+const (
+	detailReady    = "Ready"
+	detailNotReady = "NotReady"
+	detailExpired  = stateExpired
+)
+
+var statusDetails = []string{detailReady, detailNotReady, detailExpired}
+
+// messageTemplatesFile is the YAML or JSON file at MESSAGE_TEMPLATES. Its
+// Go templates, keyed by notification type or status detail, render the
+// messages people read in place of the built-in wording, so platform teams
+// can add runbook links or translate them.
+type messageTemplatesFile struct {
+	Notifications map[string]string `json:"notifications,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+}
+
+// messageData is what message templates are executed with.
+type messageData struct {
+	// Type is the notification type or the status detail.
+	Type       string
+	Function   string
+	Namespace  string
+	Generation string
+	DeployID   string
+	URL        string
+	BasePath   string
+	Revision   string
+	Message    string
+	Time       time.Time
+	// Text is the built-in wording of the message.
+	Text string
+}
+
+// messageTemplates renders the user-facing messages. Without a template
+// for a message it keeps the built-in wording.
+type messageTemplates struct {
+	notifications map[string]*template.Template
+	details       map[string]*template.Template
+}
+
+// loadMessageTemplates parses the file at path, which may be empty for the
+// built-in wording only.
+func loadMessageTemplates(path string) (*messageTemplates, error) {
+	m := &messageTemplates{notifications: map[string]*template.Template{}, details: map[string]*template.Template{}}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MESSAGE_TEMPLATES: %w", err)
+	}
+	file := messageTemplatesFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid MESSAGE_TEMPLATES %s: %w", path, err)
+	}
+
+	for key, text := range file.Notifications {
+		if !slices.Contains(outcomeNotifications, key) && !slices.Contains(milestoneNotifications, key) {
+			return nil, fmt.Errorf("invalid MESSAGE_TEMPLATES %s: unknown notification %q", path, key)
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MESSAGE_TEMPLATES %s: notifications.%s: %w", path, key, err)
+		}
+		m.notifications[key] = tmpl
+	}
+	for key, text := range file.Details {
+		if !slices.Contains(statusDetails, key) {
+			return nil, fmt.Errorf("invalid MESSAGE_TEMPLATES %s: unknown detail %q: expected one of %s", path, key, strings.Join(statusDetails, ", "))
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MESSAGE_TEMPLATES %s: details.%s: %w", path, key, err)
+		}
+		m.details[key] = tmpl
+	}
+	return m, nil
+}
+
+// loadedMessages caches the message templates by MESSAGE_TEMPLATES path.
+var loadedMessages sync.Map
+
+// userMessages are the message templates at path, MESSAGE_TEMPLATES,
+// loaded on first use. Messages are not worth failing a command over, so
+// templates that cannot be loaded leave the built-in wording.
+func userMessages(path string) *messageTemplates {
+	if m, ok := loadedMessages.Load(path); ok {
+		return m.(*messageTemplates)
+	}
+	m, err := loadMessageTemplates(path)
+	if err != nil {
+		warnf("%v; using the built-in messages\n", err)
+		m, _ = loadMessageTemplates("")
+	}
+	loaded, _ := loadedMessages.LoadOrStore(path, m)
+	return loaded.(*messageTemplates)
+}
+
+// notificationText renders the human readable form of n.
+func (m *messageTemplates) notificationText(n notification) string {
+	return renderMessage(m.notifications[n.Type], messageData{
+		Type:       n.Type,
+		Function:   n.Function,
+		Namespace:  n.Namespace,
+		Generation: n.Generation,
+		DeployID:   n.DeployID,
+		URL:        n.URL,
+		Revision:   n.Revision,
+		Message:    n.Message,
+		Time:       n.Time,
+		Text:       defaultNotificationText(n),
+	})
+}
+
+// detail renders the status detail of the given type, text being its
+// built-in wording.
+func (m *messageTemplates) detail(cfg *EnvConfig, detailType, url, message, text string) string {
+	return renderMessage(m.details[detailType], messageData{
+		Type:       detailType,
+		Function:   cfg.FunctionName,
+		Namespace:  cfg.FunctionNamespace,
+		Generation: cfg.FunctionGeneration,
+		DeployID:   cfg.DeployID,
+		URL:        url,
+		BasePath:   cfg.FunctionBasePath,
+		Message:    message,
+		Time:       time.Now().UTC(),
+		Text:       text,
+	})
+}
+
+// renderMessage executes tmpl, falling back to the built-in wording without
+// one or when it fails.
+func renderMessage(tmpl *template.Template, data messageData) string {
+	if tmpl == nil {
+		return data.Text
+	}
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		warnf("failed to render %s message: %v\n", data.Type, err)
+		return data.Text
+	}
+	return text.String()
+}
+
+// notificationText is the human readable form of n.
+func notificationText(n notification) string {
+	return userMessages(n.messageTemplates).notificationText(n)
+}
+
+// statusDetail is the detail written next to the state of the function.
+func statusDetail(cfg *EnvConfig, detailType, url, message, text string) string {
+	return userMessages(cfg.MessageTemplates).detail(cfg, detailType, url, message, text)
+}
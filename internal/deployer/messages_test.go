@@ -0,0 +1,94 @@
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMessageTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.yaml")
+	config := `
+notifications:
+  Deployed: "{{.Function}} est en ligne : {{.URL}}"
+  DeployFailed: "{{.Text}}. Runbook: https://runbooks.example.com/deploys?deploy={{.DeployID}}"
+details:
+  NotReady: "{{.Text}} ({{.Message}})"
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMessageTemplates(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	n := notification{Type: notificationDeployed, Function: "fn", Namespace: "ns", URL: "https://fn.example.com"}
+	if got := m.notificationText(n); got != "fn est en ligne : https://fn.example.com" {
+		t.Errorf("Unexpected text %q", got)
+	}
+	n = notification{Type: notificationDeployFailed, Function: "fn", Namespace: "ns", DeployID: "abc", Message: "boom"}
+	if got := m.notificationText(n); got != "Function ns/fn failed to deploy: boom. Runbook: https://runbooks.example.com/deploys?deploy=abc" {
+		t.Errorf("Unexpected text %q", got)
+	}
+	n = notification{Type: notificationDeleted, Function: "fn", Namespace: "ns"}
+	if got := m.notificationText(n); got != "Function ns/fn deleted" {
+		t.Errorf("Expected the built-in wording without a template, got %q", got)
+	}
+
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionBasePath: "/api"}
+	if got := m.detail(cfg, detailNotReady, "https://fn.example.com", "RevisionFailed", "NotReady: https://fn.example.com/api"); got != "NotReady: https://fn.example.com/api (RevisionFailed)" {
+		t.Errorf("Unexpected detail %q", got)
+	}
+	if got := m.detail(cfg, detailReady, "https://fn.example.com", "", "Ready: https://fn.example.com/api"); got != "Ready: https://fn.example.com/api" {
+		t.Errorf("Expected the built-in detail without a template, got %q", got)
+	}
+}
+
+func TestUserMessagesFollowConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.yaml")
+	if err := os.WriteFile(path, []byte(`notifications: {Deleted: "{{.Function}} est supprimée"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", MessageTemplates: path}
+	if got := notificationText(newNotification(cfg, notificationDeleted)); got != "fn est supprimée" {
+		t.Errorf("Expected the MESSAGE_TEMPLATES of the config, got %q", got)
+	}
+	cfg.MessageTemplates = ""
+	if got := notificationText(newNotification(cfg, notificationDeleted)); got != "Function ns/fn deleted" {
+		t.Errorf("Expected the built-in wording without MESSAGE_TEMPLATES, got %q", got)
+	}
+}
+
+func TestMessageTemplatesFallBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.yaml")
+	if err := os.WriteFile(path, []byte(`notifications: {Deployed: "{{.Missing}}"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMessageTemplates(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	n := notification{Type: notificationDeployed, Function: "fn", Namespace: "ns", URL: "https://fn.example.com"}
+	if got := m.notificationText(n); got != "Function ns/fn deployed, serving at https://fn.example.com" {
+		t.Errorf("Expected the built-in wording when the template fails, got %q", got)
+	}
+}
+
+func TestLoadMessageTemplatesErrors(t *testing.T) {
+	for name, config := range map[string]string{
+		"unknown notification": `notifications: {Deploy: "x"}`,
+		"unknown detail":       `details: {Failed: "x"}`,
+		"invalid template":     `details: {Ready: "{{.URL"}`,
+		"unknown section":      `messages: {}`,
+	} {
+		path := filepath.Join(t.TempDir(), "messages.yaml")
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadMessageTemplates(path); err == nil || !strings.Contains(err.Error(), "MESSAGE_TEMPLATES") {
+			t.Errorf("%s: expected an error, got %v", name, err)
+		}
+	}
+}
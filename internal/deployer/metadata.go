@@ -0,0 +1,134 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// reservedMetadataPrefix marks the labels and annotations the deployer owns.
+const reservedMetadataPrefix = "kdex.dev/"
+
+// parseMetadataMap parses labels or annotations given as comma separated
+// key=value pairs or, for values holding commas, as a JSON object.
+func parseMetadataMap(setting, value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var entries map[string]string
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", setting, err)
+		}
+	} else {
+		pairs, err := parseKeyValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", setting, err)
+		}
+		entries = pairs
+	}
+
+	for k := range entries {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s key %q: %s", setting, k, strings.Join(errs, "; "))
+		}
+		if strings.HasPrefix(k, reservedMetadataPrefix) {
+			return nil, fmt.Errorf("invalid %s key %q: the %s prefix is reserved for the deployer", setting, k, reservedMetadataPrefix)
+		}
+	}
+	return entries, nil
+}
+
+func parseLabels(setting, value string) (map[string]string, error) {
+	labels, err := parseMetadataMap(setting, value)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range labels {
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s value %q for %s: %s", setting, v, k, strings.Join(errs, "; "))
+		}
+	}
+	return labels, nil
+}
+
+// validateExtraMetadata checks the EXTRA_* labels and annotations parse.
+func validateExtraMetadata(cfg *EnvConfig) error {
+	return applyExtraMetadata(&unstructured.Unstructured{Object: map[string]any{}}, cfg)
+}
+
+// applyExtraMetadata adds EXTRA_LABELS and EXTRA_ANNOTATIONS to the Service
+// and its revision template, and EXTRA_REVISION_LABELS and
+// EXTRA_REVISION_ANNOTATIONS to the revision template only, where they take
+// precedence. None may change what the deployer itself sets.
+func applyExtraMetadata(service *unstructured.Unstructured, cfg *EnvConfig) error {
+	labels, err := parseLabels("EXTRA_LABELS", cfg.ExtraLabels)
+	if err != nil {
+		return err
+	}
+	annotations, err := parseMetadataMap("EXTRA_ANNOTATIONS", cfg.ExtraAnnotations)
+	if err != nil {
+		return err
+	}
+	revisionLabels, err := parseLabels("EXTRA_REVISION_LABELS", cfg.ExtraRevisionLabels)
+	if err != nil {
+		return err
+	}
+	revisionAnnotations, err := parseMetadataMap("EXTRA_REVISION_ANNOTATIONS", cfg.ExtraRevisionAnnotations)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range []struct {
+		setting string
+		path    []string
+		extra   []map[string]string
+	}{
+		{"EXTRA_LABELS", []string{"metadata", "labels"}, []map[string]string{labels}},
+		{"EXTRA_ANNOTATIONS", []string{"metadata", "annotations"}, []map[string]string{annotations}},
+		{"EXTRA_REVISION_LABELS", []string{"spec", "template", "metadata", "labels"}, []map[string]string{labels, revisionLabels}},
+		{"EXTRA_REVISION_ANNOTATIONS", []string{"spec", "template", "metadata", "annotations"}, []map[string]string{annotations, revisionAnnotations}},
+	} {
+		if err := mergeMetadata(service.Object, m.path, m.setting, m.extra...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeMetadata merges the extra maps, later ones winning, into the map at
+// path of obj.
+func mergeMetadata(obj map[string]any, path []string, setting string, extra ...map[string]string) error {
+	merged := map[string]string{}
+	for _, e := range extra {
+		for k, v := range e {
+			merged[k] = v
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	existing, _, _ := unstructured.NestedMap(obj, path...)
+	if existing == nil {
+		existing = map[string]any{}
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if current, ok := existing[k]; ok && current != merged[k] {
+			return fmt.Errorf("invalid %s: %s is set by the deployer to %v", setting, k, current)
+		}
+		existing[k] = merged[k]
+	}
+	return unstructured.SetNestedMap(obj, existing, path...)
+}
@@ -0,0 +1,101 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseMetadataMap(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "team=payments, tier=backend", want: map[string]string{"team": "payments", "tier": "backend"}},
+		{value: `{"example.com/owners": "a,b"}`, want: map[string]string{"example.com/owners": "a,b"}},
+		{value: "team", wantErr: true},
+		{value: `{"team": 1}`, wantErr: true},
+		{value: "not a key=x", wantErr: true},
+		{value: "kdex.dev/function=other", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMetadataMap("EXTRA_ANNOTATIONS", tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("EXTRA_ANNOTATIONS=%q: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("EXTRA_ANNOTATIONS=%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("EXTRA_ANNOTATIONS=%q: expected %v, got %v", tt.value, tt.want, got)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("EXTRA_ANNOTATIONS=%q: expected %s=%q, got %q", tt.value, k, v, got[k])
+			}
+		}
+	}
+
+	if _, err := parseLabels("EXTRA_LABELS", `{"owners": "a,b"}`); err == nil {
+		t.Error("Expected a label value with a comma to be rejected")
+	}
+}
+
+func TestBuildServiceExtraMetadata(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:             "fn",
+		FunctionNamespace:        "default",
+		FunctionImage:            "registry.example.com/fn:v1",
+		ExtraLabels:              "team=payments,tier=backend",
+		ExtraAnnotations:         "example.com/owner=payments",
+		ExtraRevisionLabels:      "tier=canary",
+		ExtraRevisionAnnotations: `{"example.com/note": "a, b"}`,
+	}
+	if err := validateExtraMetadata(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	labels := service.GetLabels()
+	if labels["team"] != "payments" || labels["tier"] != "backend" || labels["kdex.dev/function"] != "fn" {
+		t.Errorf("Unexpected Service labels: %v", labels)
+	}
+	if service.GetAnnotations()["example.com/owner"] != "payments" || service.GetAnnotations()["example.com/note"] != "" {
+		t.Errorf("Unexpected Service annotations: %v", service.GetAnnotations())
+	}
+
+	template, _, _ := unstructured.NestedStringMap(service.Object, "spec", "template", "metadata", "labels")
+	if template["team"] != "payments" || template["tier"] != "canary" {
+		t.Errorf("Expected the revision labels to override the extra labels, got %v", template)
+	}
+	templateAnnotations, _, _ := unstructured.NestedStringMap(service.Object, "spec", "template", "metadata", "annotations")
+	if templateAnnotations["example.com/owner"] != "payments" || templateAnnotations["example.com/note"] != "a, b" {
+		t.Errorf("Unexpected revision annotations: %v", templateAnnotations)
+	}
+}
+
+func TestBuildServiceExtraMetadataConflict(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "fn",
+		FunctionNamespace: "default",
+		FunctionImage:     "registry.example.com/fn:v1",
+		ExtraAnnotations:  "autoscaling.knative.dev/min-scale=5",
+		ScalingMinScale:   "1",
+	}
+	_, err := buildService(cfg, serviceState{})
+	if err == nil || !strings.Contains(err.Error(), "set by the deployer") {
+		t.Errorf("Expected a conflict with a managed annotation, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// The metrics of the deployer, registered with the controller-runtime
+// registry the controller serves, next to its own and those of client-go.
+var (
+	deploysStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kdex_deployer_deploys_started_total",
+		Help: "Deploys started.",
+	})
+	deploysFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kdex_deployer_deploys_total",
+		Help: "Deploys finished, by outcome: succeeded, failed or throttled.",
+	}, []string{"outcome"})
+	timeToReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "kdex_deployer_time_to_ready_seconds",
+		Help: "Time from applying the Knative Service to it becoming ready.",
+		// From a warm image to a slow cold start
+		Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
+	})
+	observeReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kdex_deployer_observe_reconciles_total",
+		Help: "Status syncs of a function, by outcome: success or error.",
+	}, []string{"outcome"})
+	statusPatchConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kdex_deployer_status_patch_conflicts_total",
+		Help: "Status patches rejected because the object changed since it was read.",
+	})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kdex_deployer_api_errors_total",
+		Help: "Kubernetes API responses with an error status, by status code.",
+	}, []string{"code"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(deploysStarted, deploysFinished, timeToReady, observeReconciles, statusPatchConflicts, apiErrors)
+}
+
+// recordDeployOutcome counts a finished deploy.
+func recordDeployOutcome(err error) {
+	outcome := report.PhaseSucceeded
+	switch {
+	case err == nil:
+	case deployThrottled(err):
+		outcome = report.OutcomeThrottled
+	default:
+		outcome = report.PhaseFailed
+	}
+	deploysFinished.WithLabelValues(strings.ToLower(outcome)).Inc()
+}
+
+// recordObserveOutcome counts a status sync.
+func recordObserveOutcome(err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	observeReconciles.WithLabelValues(outcome).Inc()
+}
+
+// apiErrorCounter counts the error responses of the Kubernetes API, and
+// among them the conflicts of status patches, whatever client made them.
+type apiErrorCounter struct {
+	next http.RoundTripper
+}
+
+func (c apiErrorCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+	apiErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode == http.StatusConflict && req.Method == http.MethodPatch && strings.HasSuffix(req.URL.Path, "/status") {
+		statusPatchConflicts.Inc()
+	}
+	return resp, nil
+}
+
+// serveMetrics serves /metrics on address until ctx is done. An address of
+// 0 disables it, as for the controller.
+func serveMetrics(ctx context.Context, address string) {
+	if address == "" || address == "0" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+	go func() {
+		logf("Serving metrics on %s\n", address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			warnf("failed to serve metrics: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package deployer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type statusRoundTripper int
+
+func (s statusRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: int(s), Request: req}, nil
+}
+
+func TestAPIErrorCounter(t *testing.T) {
+	conflicts := testutil.ToFloat64(statusPatchConflicts)
+	notFound := testutil.ToFloat64(apiErrors.WithLabelValues("404"))
+	conflictErrors := testutil.ToFloat64(apiErrors.WithLabelValues("409"))
+
+	for _, tt := range []struct {
+		status       int
+		method, path string
+	}{
+		{http.StatusOK, http.MethodGet, "/apis/kdex.dev/v1alpha1/namespaces/ns/kdexfunctions/fn"},
+		{http.StatusNotFound, http.MethodGet, "/apis/serving.knative.dev/v1/namespaces/ns/services/fn"},
+		{http.StatusConflict, http.MethodPatch, "/apis/kdex.dev/v1alpha1/namespaces/ns/kdexfunctions/fn/status"},
+		{http.StatusConflict, http.MethodPatch, "/apis/kdex.dev/v1alpha1/namespaces/ns/kdexfunctions/fn"},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if _, err := (apiErrorCounter{next: statusRoundTripper(tt.status)}).RoundTrip(req); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(apiErrors.WithLabelValues("404")) - notFound; got != 1 {
+		t.Errorf("Expected one 404, got %v", got)
+	}
+	if got := testutil.ToFloat64(apiErrors.WithLabelValues("409")) - conflictErrors; got != 2 {
+		t.Errorf("Expected two 409s, got %v", got)
+	}
+	if got := testutil.ToFloat64(statusPatchConflicts) - conflicts; got != 1 {
+		t.Errorf("Expected one status patch conflict, got %v", got)
+	}
+}
+
+func TestRecordDeployOutcome(t *testing.T) {
+	before := map[string]float64{}
+	for _, outcome := range []string{"succeeded", "failed"} {
+		before[outcome] = testutil.ToFloat64(deploysFinished.WithLabelValues(outcome))
+	}
+
+	recordDeployOutcome(nil)
+	recordDeployOutcome(fmt.Errorf("apply failed: boom"))
+
+	for outcome, count := range before {
+		if got := testutil.ToFloat64(deploysFinished.WithLabelValues(outcome)) - count; got != 1 {
+			t.Errorf("Expected one %s deploy, got %v", outcome, got)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// Notification types, one per outcome of a command.
+const (
+	notificationDeployed     = "Deployed"
+	notificationDeployFailed = "DeployFailed"
+	notificationDeleted      = "Deleted"
+	notificationRolledBack   = "RolledBack"
+	notificationExpired      = "Expired"
+)
+
+// Notification types for the milestones on the way to an outcome. Only
+// notifiers listing them in their events, and EVENTS_SINK_URL, get them.
+const (
+	notificationDeployStarted  = "DeployStarted"
+	notificationServiceApplied = "ServiceApplied"
+	notificationReady          = "Ready"
+	notificationStateChanged   = "StateChanged"
+)
+
+var outcomeNotifications = []string{notificationDeployed, notificationDeployFailed, notificationDeleted, notificationRolledBack, notificationExpired}
+
+var milestoneNotifications = []string{notificationDeployStarted, notificationServiceApplied, notificationReady, notificationStateChanged}
+
+// Notifier types selectable in the notifiers section of NOTIFIERS_CONFIG.
+const (
+	notifierEvent       = "event"
+	notifierWebhook     = "webhook"
+	notifierCloudEvents = "cloudevents"
+	notifierSlack       = "slack"
+)
+
+// notifyTimeout bounds each notifier, retries included, so a slow channel
+// cannot hold up the Job.
+const notifyTimeout = 10 * time.Second
+
+// notifyAttempts is how many times a notification is sent before giving
+// up, waiting notifyBackoff and then twice as long between attempts.
+const notifyAttempts = 3
+
+var notifyBackoff = time.Second
+
+// NOTIFY_FORMAT values.
+const (
+	notifyFormatJSON  = "json"
+	notifyFormatSlack = "slack"
+)
+
+var eventGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "events",
+}
+
+// notification is what every notifier is told about a command's outcome.
+type notification struct {
+	Type       string    `json:"type"`
+	Function   string    `json:"function"`
+	Namespace  string    `json:"namespace"`
+	Generation string    `json:"generation,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Revision   string    `json:"revision,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	DeployID   string    `json:"deployId,omitempty"`
+	Time       time.Time `json:"time"`
+
+	// messageTemplates is the MESSAGE_TEMPLATES its text is rendered with.
+	messageTemplates string
+}
+
+func newNotification(cfg *EnvConfig, notificationType string) notification {
+	return notification{
+		Type:       notificationType,
+		Function:   cfg.FunctionName,
+		Namespace:  cfg.FunctionNamespace,
+		Generation: cfg.FunctionGeneration,
+		DeployID:   cfg.DeployID,
+		Time:       time.Now().UTC(),
+
+		messageTemplates: cfg.MessageTemplates,
+	}
+}
+
+// notifier delivers notifications to one channel.
+type notifier interface {
+	Name() string
+	Notify(ctx context.Context, n notification) error
+}
+
+// notifierConfig is one entry of the notifiers section.
+type notifierConfig struct {
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	// TokenEnv names the env var holding the bearer token for webhooks,
+	// keeping the secret out of the config file.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// Source is the CloudEvents source, defaulting to the function.
+	Source string `json:"source,omitempty"`
+	// Template renders the JSON body of webhooks as a Go template of the
+	// notification, with .Text its human readable form.
+	Template string `json:"template,omitempty"`
+	// Events limits the notifier to these notification types.
+	Events []string `json:"events,omitempty"`
+}
+
+type notifiersFile struct {
+	Notifiers []notifierConfig `json:"notifiers"`
+}
+
+// notifierBus fans a notification out to every configured notifier. Each
+// runs on its own with its own timeout; one failing is logged and does not
+// affect the others or the command.
+type notifierBus struct {
+	notifiers []notifier
+}
+
+// loadNotifiers builds the bus from the notifiers section of the YAML or
+// JSON file at NOTIFIERS_CONFIG, and the CloudEvents sink at
+// EVENTS_SINK_URL. Without either the bus notifies nobody.
+func loadNotifiers(cfg *EnvConfig, client dynamic.Interface) (*notifierBus, error) {
+	bus := &notifierBus{}
+	if cfg.NotifiersConfig == "" && cfg.EventsSinkURL == "" && cfg.NotifyURL == "" {
+		return bus, nil
+	}
+	if cfg.ReadOnly == "true" {
+		logf("Read-only: not sending notifications\n")
+		return bus, nil
+	}
+
+	// The sink gets every notification, milestones included
+	if cfg.EventsSinkURL != "" {
+		if u, err := url.Parse(cfg.EventsSinkURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid EVENTS_SINK_URL %q: expected an http(s) URL", cfg.EventsSinkURL)
+		}
+		bus.notifiers = append(bus.notifiers, cloudEventsNotifier{url: cfg.EventsSinkURL})
+	}
+
+	// NOTIFY_URL is for people: outcomes and state changes only
+	if cfg.NotifyURL != "" {
+		c := notifierConfig{Type: notifierWebhook, URL: cfg.NotifyURL, Template: cfg.NotifyTemplate}
+		switch cfg.NotifyFormat {
+		case "", notifyFormatJSON:
+		case notifyFormatSlack:
+			c.Type = notifierSlack
+		default:
+			return nil, fmt.Errorf("unknown NOTIFY_FORMAT: %s", cfg.NotifyFormat)
+		}
+		if c.Type == notifierSlack && c.Template != "" {
+			return nil, fmt.Errorf("NOTIFY_TEMPLATE cannot be combined with NOTIFY_FORMAT=slack")
+		}
+		n, err := newNotifier(c, client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NOTIFY_URL: %w", err)
+		}
+		bus.notifiers = append(bus.notifiers, filteredNotifier{notifier: n, events: append(slices.Clone(outcomeNotifications), notificationStateChanged)})
+	}
+	if cfg.NotifiersConfig == "" {
+		return bus, nil
+	}
+
+	data, err := os.ReadFile(cfg.NotifiersConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NOTIFIERS_CONFIG: %w", err)
+	}
+	file := notifiersFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid NOTIFIERS_CONFIG %s: %w", cfg.NotifiersConfig, err)
+	}
+
+	for i, c := range file.Notifiers {
+		n, err := newNotifier(c, client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NOTIFIERS_CONFIG %s: notifiers[%d]: %w", cfg.NotifiersConfig, i, err)
+		}
+		events := c.Events
+		if len(events) == 0 {
+			events = outcomeNotifications
+		}
+		bus.notifiers = append(bus.notifiers, filteredNotifier{notifier: n, events: events})
+	}
+	return bus, nil
+}
+
+func newNotifier(c notifierConfig, client dynamic.Interface) (notifier, error) {
+	if c.Type != notifierEvent && c.URL == "" {
+		return nil, fmt.Errorf("%s notifier needs a url", c.Type)
+	}
+	if c.Template != "" && c.Type != notifierWebhook {
+		return nil, fmt.Errorf("%s notifier takes no template", c.Type)
+	}
+	for _, e := range c.Events {
+		if !slices.Contains(outcomeNotifications, e) && !slices.Contains(milestoneNotifications, e) {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+	}
+
+	switch c.Type {
+	case notifierEvent:
+		if client == nil {
+			return nil, fmt.Errorf("event notifier needs a cluster connection")
+		}
+		return eventNotifier{client: client}, nil
+	case notifierWebhook:
+		w := webhookNotifier{url: c.URL, token: os.Getenv(c.TokenEnv)}
+		if c.Template != "" {
+			tmpl, err := template.New("notification").Option("missingkey=error").Parse(c.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template: %w", err)
+			}
+			w.template = tmpl
+		}
+		return w, nil
+	case notifierCloudEvents:
+		return cloudEventsNotifier{url: c.URL, source: c.Source}, nil
+	case notifierSlack:
+		return slackNotifier{url: c.URL}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", c.Type)
+	}
+}
+
+// notify delivers n to every notifier and waits for them to finish.
+func (b *notifierBus) notify(ctx context.Context, n notification) {
+	var wg sync.WaitGroup
+	for _, nt := range b.notifiers {
+		wg.Go(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					warnf("%s notifier panicked: %v\n", nt.Name(), r)
+				}
+			}()
+			ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			defer cancel()
+			if err := notifyWithRetry(ctx, nt, n); err != nil {
+				warnf("failed to notify %s: %v\n", nt.Name(), err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// notifyWithRetry sends n, backing off between attempts while the channel
+// fails in a way that may pass.
+func notifyWithRetry(ctx context.Context, nt notifier, n notification) error {
+	backoff := notifyBackoff
+	for attempt := 1; ; attempt++ {
+		err := nt.Notify(ctx, n)
+		if err == nil || attempt == notifyAttempts || !retryableNotifyError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// notifyStatusError is a notification endpoint answering with an error.
+type notifyStatusError struct {
+	status int
+}
+
+func (e notifyStatusError) Error() string {
+	return fmt.Sprintf("notification endpoint returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryableNotifyError tells whether sending again may succeed: not when
+// the endpoint rejected the notification itself.
+func retryableNotifyError(err error) bool {
+	var statusErr notifyStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= 500 || statusErr.status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// filteredNotifier passes on only the notification types listed for it.
+type filteredNotifier struct {
+	notifier
+	events []string
+}
+
+func (f filteredNotifier) Notify(ctx context.Context, n notification) error {
+	if !slices.Contains(f.events, n.Type) {
+		return nil
+	}
+	return f.notifier.Notify(ctx, n)
+}
+
+// eventNotifier records a Kubernetes Event on the KDexFunction.
+type eventNotifier struct {
+	client dynamic.Interface
+}
+
+func (eventNotifier) Name() string { return notifierEvent }
+
+func (e eventNotifier) Notify(ctx context.Context, n notification) error {
+	eventType := "Normal"
+	if n.Type == notificationDeployFailed {
+		eventType = "Warning"
+	}
+	event := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Event",
+			"metadata": map[string]any{
+				"name":      fmt.Sprintf("%s.%x", n.Function, n.Time.UnixNano()),
+				"namespace": n.Namespace,
+			},
+			"involvedObject": map[string]any{
+				"apiVersion": kdexFunctionGVR.GroupVersion().String(),
+				"kind":       "KDexFunction",
+				"name":       n.Function,
+				"namespace":  n.Namespace,
+			},
+			"reason":         n.Type,
+			"message":        notificationText(n),
+			"type":           eventType,
+			"firstTimestamp": n.Time.Format(time.RFC3339),
+			"lastTimestamp":  n.Time.Format(time.RFC3339),
+			"count":          int64(1),
+			"source": map[string]any{
+				"component": "kdex-knative-deployer",
+			},
+		},
+	}
+	if n.DeployID != "" {
+		event.SetAnnotations(map[string]string{deployIDAnnotation: n.DeployID})
+	}
+	_, err := e.client.Resource(eventGVR).Namespace(n.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
+
+// webhookNotifier posts the notification as JSON, or as rendered by its
+// template.
+type webhookNotifier struct {
+	url      string
+	token    string
+	template *template.Template
+}
+
+func (webhookNotifier) Name() string { return notifierWebhook }
+
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if w.template != nil {
+		if body, err = renderNotification(w.template, n); err != nil {
+			return err
+		}
+	}
+	status, err := sendJSON(ctx, http.MethodPost, w.url, w.token, body)
+	if err != nil {
+		return err
+	}
+	return notifyStatus(status)
+}
+
+// cloudEventsNotifier posts the notification as a binary mode CloudEvent
+// of type dev.kdex.function.<type>.
+type cloudEventsNotifier struct {
+	url    string
+	source string
+}
+
+func (cloudEventsNotifier) Name() string { return notifierCloudEvents }
+
+func (c cloudEventsNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	source := c.source
+	if source == "" {
+		source = fmt.Sprintf("/apis/%s/namespaces/%s/kdexfunctions/%s", kdexFunctionGVR.GroupVersion(), n.Namespace, n.Function)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", hex.EncodeToString(id))
+	req.Header.Set("Ce-Type", "dev.kdex.function."+n.Type)
+	req.Header.Set("Ce-Source", source)
+	req.Header.Set("Ce-Subject", n.Function)
+	req.Header.Set("Ce-Time", n.Time.Format(time.RFC3339))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s failed: %w", c.url, err)
+	}
+	_ = resp.Body.Close()
+	return notifyStatus(resp.StatusCode)
+}
+
+// slackNotifier posts a message to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+func (slackNotifier) Name() string { return notifierSlack }
+
+func (s slackNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(map[string]string{"text": notificationText(n)})
+	if err != nil {
+		return err
+	}
+	status, err := sendJSON(ctx, http.MethodPost, s.url, "", body)
+	if err != nil {
+		return err
+	}
+	return notifyStatus(status)
+}
+
+// renderNotification renders the JSON body of n with tmpl.
+func renderNotification(tmpl *template.Template, n notification) ([]byte, error) {
+	data := struct {
+		notification
+		Text string
+	}{n, notificationText(n)}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("notification template rendered invalid JSON: %s", body.String())
+	}
+	return body.Bytes(), nil
+}
+
+func notifyStatus(status int) error {
+	if status < 200 || status > 299 {
+		return notifyStatusError{status: status}
+	}
+	return nil
+}
+
+// defaultNotificationText is the built-in human readable form of n.
+func defaultNotificationText(n notification) string {
+	function := n.Namespace + "/" + n.Function
+	var text string
+	switch n.Type {
+	case notificationDeployed:
+		text = fmt.Sprintf("Function %s deployed, serving at %s", function, n.URL)
+	case notificationDeployFailed:
+		text = fmt.Sprintf("Function %s failed to deploy", function)
+	case notificationDeleted:
+		text = fmt.Sprintf("Function %s deleted", function)
+	case notificationRolledBack:
+		text = fmt.Sprintf("Function %s rolled back to %s", function, n.Revision)
+	case notificationExpired:
+		text = fmt.Sprintf("Function %s expired and was torn down", function)
+	case notificationDeployStarted:
+		text = fmt.Sprintf("Function %s deploy started", function)
+	case notificationServiceApplied:
+		text = fmt.Sprintf("Function %s service applied", function)
+	case notificationReady:
+		text = fmt.Sprintf("Function %s ready at %s", function, n.URL)
+	case notificationStateChanged:
+		text = fmt.Sprintf("Function %s changed state", function)
+		if n.URL != "" {
+			text += fmt.Sprintf(" (%s)", n.URL)
+		}
+	default:
+		text = fmt.Sprintf("Function %s: %s", function, n.Type)
+	}
+	if n.Message != "" {
+		text += ": " + n.Message
+	}
+	return text
+}
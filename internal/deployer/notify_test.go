@@ -0,0 +1,288 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestLoadNotifiers(t *testing.T) {
+	bus, err := loadNotifiers(&EnvConfig{}, nil)
+	if err != nil || len(bus.notifiers) != 0 {
+		t.Errorf("Expected no notifiers, got %v %v", bus, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+notifiers:
+- type: webhook
+  url: https://hooks.example.com/deploys
+  tokenEnv: HOOK_TOKEN
+- type: slack
+  url: https://hooks.slack.com/services/x
+  events: [DeployFailed]
+- type: event
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOOK_TOKEN", "secret")
+	bus, err = loadNotifiers(&EnvConfig{NotifiersConfig: path}, newFakeDynamicClient())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bus.notifiers) != 3 {
+		t.Fatalf("Expected 3 notifiers, got %d", len(bus.notifiers))
+	}
+	if w, ok := bus.notifiers[0].(filteredNotifier).notifier.(webhookNotifier); !ok || w.token != "secret" {
+		t.Errorf("Expected webhook notifier with token, got %#v", bus.notifiers[0])
+	}
+
+	for _, invalid := range []string{
+		"notifiers:\n- type: pager\n  url: https://example.com\n",
+		"notifiers:\n- type: webhook\n",
+		"notifiers:\n- type: slack\n  url: https://example.com\n  events: [Exploded]\n",
+		"notifier:\n- type: event\n",
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadNotifiers(&EnvConfig{NotifiersConfig: path}, newFakeDynamicClient()); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestLoadNotifiersEventsSink(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Ce-Type"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "notifiers:\n- type: webhook\n  url: " + server.URL + "/outcomes\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bus, err := loadNotifiers(&EnvConfig{EventsSinkURL: server.URL, NotifiersConfig: path}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Milestones reach the sink only; the webhook did not ask for them
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+	bus.notify(context.Background(), newNotification(cfg, notificationDeployStarted))
+	if len(received) != 1 || received[0] != "dev.kdex.function.DeployStarted" {
+		t.Errorf("Expected the sink alone to receive the milestone, got %v", received)
+	}
+
+	if _, err := loadNotifiers(&EnvConfig{EventsSinkURL: "events.example.com"}, nil); err == nil {
+		t.Error("Expected error for an EVENTS_SINK_URL without scheme")
+	}
+}
+
+func TestNotifyPhaseMilestone(t *testing.T) {
+	var received []notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := notification{}
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	d := &deployment{
+		cfg:       &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"},
+		notifiers: &notifierBus{notifiers: []notifier{webhookNotifier{url: server.URL}}},
+		url:       "https://myfunc.example.com",
+		candidate: "myfunc-00002",
+	}
+	notifyPhaseMilestone(context.Background(), d, report.PhaseReport{Phase: report.PhaseApply, Outcome: report.PhaseSucceeded})
+	notifyPhaseMilestone(context.Background(), d, report.PhaseReport{Phase: report.PhaseAwaitRevision, Outcome: report.PhaseFailed})
+	notifyPhaseMilestone(context.Background(), d, report.PhaseReport{Phase: report.PhaseAwaitRevision, Outcome: report.PhaseSucceeded})
+
+	if len(received) != 2 || received[0].Type != notificationServiceApplied || received[1].Type != notificationReady || received[1].URL != d.url {
+		t.Errorf("Expected ServiceApplied then Ready, got %+v", received)
+	}
+}
+
+func TestLoadNotifiersNotifyURL(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		NotifyURL:         server.URL,
+		NotifyTemplate:    `{"summary": {{printf "%q" .Text}}, "fn": "{{.Function}}"}`,
+	}
+	bus, err := loadNotifiers(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	n := newNotification(cfg, notificationStateChanged)
+	n.Message = "FunctionDeployed -> Ready"
+	bus.notify(context.Background(), n)
+	if body["fn"] != "myfunc" || body["summary"] != "Function myns/myfunc changed state: FunctionDeployed -> Ready" {
+		t.Errorf("Unexpected templated payload: %v", body)
+	}
+
+	body = nil
+	bus.notify(context.Background(), newNotification(cfg, notificationServiceApplied))
+	if body != nil {
+		t.Errorf("Expected no milestone for NOTIFY_URL, got %v", body)
+	}
+
+	cfg.NotifyFormat, cfg.NotifyTemplate = notifyFormatSlack, ""
+	bus, err = loadNotifiers(cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bus.notify(context.Background(), newNotification(cfg, notificationDeployed))
+	if _, ok := body["text"]; !ok {
+		t.Errorf("Expected a Slack message, got %v", body)
+	}
+
+	for _, invalid := range []*EnvConfig{
+		{NotifyURL: server.URL, NotifyFormat: "teams"},
+		{NotifyURL: server.URL, NotifyFormat: notifyFormatSlack, NotifyTemplate: "{}"},
+		{NotifyURL: server.URL, NotifyTemplate: "{{.Nope"},
+	} {
+		if _, err := loadNotifiers(invalid, nil); err == nil {
+			t.Errorf("Expected error for %+v", invalid)
+		}
+	}
+}
+
+type flakyNotifier struct {
+	failures *int
+	status   int
+}
+
+func (flakyNotifier) Name() string { return "flaky" }
+
+func (f flakyNotifier) Notify(ctx context.Context, n notification) error {
+	if *f.failures > 0 {
+		*f.failures--
+		return notifyStatus(f.status)
+	}
+	return nil
+}
+
+func TestNotifyWithRetry(t *testing.T) {
+	defer func(backoff time.Duration) { notifyBackoff = backoff }(notifyBackoff)
+	notifyBackoff = time.Millisecond
+
+	failures := 2
+	if err := notifyWithRetry(context.Background(), flakyNotifier{&failures, http.StatusServiceUnavailable}, notification{}); err != nil {
+		t.Errorf("Expected the notification to go through on the last attempt, got %v", err)
+	}
+
+	failures = notifyAttempts
+	if err := notifyWithRetry(context.Background(), flakyNotifier{&failures, http.StatusBadGateway}, notification{}); err == nil {
+		t.Error("Expected an error once the attempts ran out")
+	}
+
+	failures = 1
+	if err := notifyWithRetry(context.Background(), flakyNotifier{&failures, http.StatusBadRequest}, notification{}); err == nil || failures != 0 {
+		t.Errorf("Expected a rejected notification not to be retried, got %v", err)
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Name() string { return "failing" }
+
+func (failingNotifier) Notify(ctx context.Context, n notification) error {
+	return errors.New("unreachable")
+}
+
+type panickingNotifier struct{}
+
+func (panickingNotifier) Name() string { return "panicking" }
+
+func (panickingNotifier) Notify(ctx context.Context, n notification) error {
+	panic("boom")
+}
+
+func TestNotifierBusIsolatesFailures(t *testing.T) {
+	defer func(backoff time.Duration) { notifyBackoff = backoff }(notifyBackoff)
+	notifyBackoff = time.Millisecond
+
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := notification{}
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		received.Store(n)
+	}))
+	defer server.Close()
+
+	bus := &notifierBus{notifiers: []notifier{
+		failingNotifier{},
+		panickingNotifier{},
+		webhookNotifier{url: server.URL},
+		filteredNotifier{notifier: panickingNotifier{}, events: []string{notificationDeleted}},
+	}}
+	n := newNotification(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}, notificationDeployed)
+	n.URL = "https://myfunc.example.com"
+	bus.notify(context.Background(), n)
+
+	got, _ := received.Load().(notification)
+	if got.Type != notificationDeployed || got.URL != n.URL {
+		t.Errorf("Expected the webhook to receive the notification, got %+v", got)
+	}
+}
+
+func TestCloudEventsNotifier(t *testing.T) {
+	var ceType, ceSource string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ceType = r.Header.Get("Ce-Type")
+		ceSource = r.Header.Get("Ce-Source")
+	}))
+	defer server.Close()
+
+	n := newNotification(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}, notificationDeleted)
+	if err := (cloudEventsNotifier{url: server.URL}).Notify(context.Background(), n); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ceType != "dev.kdex.function.Deleted" || ceSource != "/apis/kdex.dev/v1alpha1/namespaces/myns/kdexfunctions/myfunc" {
+		t.Errorf("Unexpected CloudEvent attributes: type %q source %q", ceType, ceSource)
+	}
+}
+
+func TestEventNotifier(t *testing.T) {
+	client := newFakeDynamicClient()
+	n := newNotification(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}, notificationDeployFailed)
+	n.Message = "timeout waiting for service readiness"
+	if err := (eventNotifier{client: client}).Notify(context.Background(), n); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	list, err := client.Resource(eventGVR).Namespace("myns").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(list.Items))
+	}
+	event := list.Items[0].Object
+	if event["type"] != "Warning" || event["reason"] != notificationDeployFailed {
+		t.Errorf("Unexpected event %v", event)
+	}
+}
@@ -0,0 +1,503 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// defaultStatusBatchWindow is how long the observer holds a status change
+// to coalesce it with the ones following it.
+const defaultStatusBatchWindow = 2 * time.Second
+
+// statusBatchWindow parses STATUS_BATCH_WINDOW. Zero writes every change
+// as soon as it is observed.
+func statusBatchWindow(cfg *EnvConfig) (time.Duration, error) {
+	if cfg.StatusBatchWindow == "" {
+		return defaultStatusBatchWindow, nil
+	}
+	window, err := time.ParseDuration(cfg.StatusBatchWindow)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("invalid STATUS_BATCH_WINDOW %q: expected a non-negative duration", cfg.StatusBatchWindow)
+	}
+	return window, nil
+}
+
+// statusUpdate is a change of the KDexFunction status the observer found.
+type statusUpdate struct {
+	from, state, url, detail string
+	// blocks are the status blocks that changed, such as authentication,
+	// keyed by their status field.
+	blocks map[string]map[string]any
+	// conditions are the status conditions, set when one of the Service
+	// conditions changed.
+	conditions []any
+	// resourceVersion guards the write of the conditions, which replaces
+	// them all.
+	resourceVersion string
+	// observedGeneration is the function generation the status reports
+	// on, set when it changed.
+	observedGeneration int64
+	// failed is set when Knative reports the Service failed, which is
+	// written without waiting out the batch window.
+	failed bool
+}
+
+// defaultDaemonInterval is how long the observer daemon waits between
+// observations.
+const defaultDaemonInterval = 30 * time.Second
+
+// observeJitter is the fraction of the interval each wait is randomly
+// lengthened or shortened by, so the observers of many functions spread
+// out.
+const observeJitter = 0.1
+
+func runObserve(args []string) error {
+	flags := flag.NewFlagSet("observe", flag.ContinueOnError)
+	daemon := flags.Bool("daemon", false, "observe in a loop until terminated, instead of once")
+	interval := flags.Duration("interval", defaultDaemonInterval, "how long the daemon waits between observations")
+	leaderElect := flags.Bool("leader-elect", false, "only observe while holding the Lease of the function, so replicas of the daemon take turns")
+	leaseNamespace := flags.String("lease-namespace", "", "namespace of the Lease, FUNCTION_NAMESPACE by default")
+	all := flags.Bool("all", false, "observe every KDexFunction in FUNCTION_NAMESPACE instead of FUNCTION_NAME")
+	selector := flags.String("selector", "", "with --all, only observe the KDexFunctions matching this label selector")
+	metricsAddress := flags.String("metrics-bind-address", "0", "with --daemon, address the metrics are served on, 0 to disable them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid --interval %s: expected a positive duration", *interval)
+	}
+	if *leaderElect && !*daemon {
+		return fmt.Errorf("--leader-elect requires --daemon")
+	}
+	if *selector != "" && !*all {
+		return fmt.Errorf("--selector requires --all")
+	}
+
+	var (
+		target    string
+		leaseName string
+		observe   func(ctx context.Context) error
+	)
+	if *all {
+		namespace := os.Getenv("FUNCTION_NAMESPACE")
+		if namespace == "" {
+			return fmt.Errorf("FUNCTION_NAMESPACE is required")
+		}
+		client, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
+		base := readEnv()
+		target, leaseName = "the functions of "+namespace, allObserverLeaseName
+		observe = func(ctx context.Context) error {
+			return observeAll(ctx, client, namespace, *selector, base)
+		}
+	} else {
+		cfg, err := LoadEnv()
+		if err != nil {
+			return err
+		}
+		window, err := statusBatchWindow(cfg)
+		if err != nil {
+			return err
+		}
+		client, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
+		target, leaseName = cfg.FunctionNamespace+"/"+cfg.FunctionName, observerLeaseName(cfg)
+		observe = func(ctx context.Context) error {
+			return observeFunction(ctx, client, cfg, window)
+		}
+	}
+
+	if !*daemon {
+		return observe(context.Background())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveMetrics(ctx, *metricsAddress)
+	loop := func(ctx context.Context) {
+		logf("Observing %s every %s\n", target, *interval)
+		observeLoop(ctx, *interval, observe)
+	}
+	if !*leaderElect {
+		loop(ctx)
+		logf("Observer stopped\n")
+		return nil
+	}
+
+	if *leaseNamespace == "" {
+		*leaseNamespace = os.Getenv("FUNCTION_NAMESPACE")
+	}
+	lock, err := observerLock(*leaseNamespace, leaseName)
+	if err != nil {
+		return err
+	}
+	if err := runLeaderElected(ctx, lock, defaultLeaseTiming, loop); err != nil {
+		return err
+	}
+	logf("Observer stopped\n")
+	return nil
+}
+
+// observerLock is the Lease lock the observer replicas compete for.
+func observerLock(namespace, name string) (*resourcelock.LeaseLock, error) {
+	config, err := restConfig(clientOptions.Kubeconfig, clientOptions.Context)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	identity, err := leaderIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return newLeaseLock(clientset, namespace, name, identity), nil
+}
+
+// observeAll syncs the status of every KDexFunction in the namespace
+// matching the selector with its Knative Service, listing each kind once.
+// Each function is observed with the settings of the process and its own
+// on top. Changes are written as soon as they are observed, as
+// holding each for the batch window would hold up the others. A function
+// failing to sync does not stop the others.
+func observeAll(ctx context.Context, client dynamic.Interface, namespace, selector string, base *EnvConfig) error {
+	functions, err := client.Resource(kdexFunctionGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list kdex functions: %w", err)
+	}
+	services, err := client.Resource(knativeServiceGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: "kdex.dev/function"})
+	if err != nil {
+		return fmt.Errorf("failed to list knative services: %w", err)
+	}
+	revisions, err := client.Resource(knativeRevisionGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: "serving.knative.dev/service"})
+	if err != nil {
+		return fmt.Errorf("failed to list knative revisions: %w", err)
+	}
+	byFunction := map[string]*unstructured.Unstructured{}
+	for i := range services.Items {
+		byFunction[services.Items[i].GetLabels()["kdex.dev/function"]] = &services.Items[i]
+	}
+	byName := map[string]*unstructured.Unstructured{}
+	for i := range revisions.Items {
+		byName[revisions.Items[i].GetName()] = &revisions.Items[i]
+	}
+
+	failed := 0
+	seen := map[string]bool{}
+	for i := range functions.Items {
+		function := &functions.Items[i]
+		seen[function.GetName()] = true
+		service, ok := byFunction[function.GetName()]
+		if !ok {
+			logf("Knative Service %s/%s not found\n", namespace, function.GetName())
+			functionReady.WithLabelValues(namespace, function.GetName()).Set(0)
+			continue
+		}
+		latest, _, _ := unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+		recordFunctionMetrics(service, function, byName[latest])
+		cfg, err := kdexFunctionConfig(function, base)
+		if err == nil {
+			err = syncFunctionStatus(ctx, client, cfg, service, function, 0)
+		}
+		if err != nil {
+			warnf("failed to observe %s/%s: %v\n", namespace, function.GetName(), err)
+			recordObserveError(namespace, function.GetName())
+			failed++
+		}
+	}
+	forgetVanishedFunctions(namespace, seen)
+	logf("Observed %d functions in %s\n", len(functions.Items), namespace)
+	if failed > 0 {
+		return fmt.Errorf("failed to observe %d of %d functions", failed, len(functions.Items))
+	}
+	return nil
+}
+
+// observeLoop observes until ctx is done, waiting about interval in
+// between. A failed observation is logged and does not stop the loop.
+func observeLoop(ctx context.Context, interval time.Duration, observe func(context.Context) error) {
+	for {
+		if err := observeOnce(ctx, observe); err != nil && ctx.Err() == nil {
+			warnf("observation failed: %v\n", err)
+		}
+
+		wait := time.Duration(float64(interval) * (1 + observeJitter*(2*rand.Float64()-1)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// observeOnce runs one observation, turning a panic into an error.
+func observeOnce(ctx context.Context, observe func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return observe(ctx)
+}
+
+// observeFunction syncs the KDexFunction status with its Knative Service,
+// holding changes short of a failure for the batch window. A function past
+// its kdex.dev/ttl is torn down instead.
+func observeFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, window time.Duration) (err error) {
+	defer func() {
+		recordObserveOutcome(err)
+		if err != nil {
+			recordObserveError(cfg.FunctionNamespace, cfg.FunctionName)
+		}
+	}()
+	ctx, span := startSpan(ctx, "observe", cfg)
+	defer func() { endSpan(span, err) }()
+
+	kfClient := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace)
+	kfObj, kfErr := kfClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if kfErr == nil {
+		if expired, err := expireFunction(ctx, client, cfg, kfObj, time.Now()); err != nil || expired {
+			if expired {
+				forgetFunctionMetrics(cfg.FunctionNamespace, cfg.FunctionName)
+			}
+			return err
+		}
+	}
+
+	// 1. Get Knative Service Status
+	ksClient := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+	ksObj, err := ksClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Service deleted? Should probably report this.
+			logf("Knative Service %s/%s not found\n", cfg.FunctionNamespace, cfg.FunctionName)
+			// TODO: Update KDexFunction to failure/unknown?
+			return nil
+		}
+		return fmt.Errorf("failed to get knative service: %w", err)
+	}
+
+	// 2. Get KDexFunction
+	if kfErr != nil {
+		return fmt.Errorf("failed to get kdex function: %w", kfErr)
+	}
+
+	var revision *unstructured.Unstructured
+	if latest, _, _ := unstructured.NestedString(ksObj.Object, "status", "latestReadyRevisionName"); latest != "" {
+		revision, _ = client.Resource(knativeRevisionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, latest, metav1.GetOptions{})
+	}
+	recordFunctionMetrics(ksObj, kfObj, revision)
+
+	return syncFunctionStatus(ctx, client, cfg, ksObj, kfObj, window)
+}
+
+// syncFunctionStatus writes what changed between the Knative Service and
+// the KDexFunction status, notifying a change of state.
+func syncFunctionStatus(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, ksObj, kfObj *unstructured.Unstructured, window time.Duration) error {
+	ksClient := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+	kfClient := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace)
+	blocks := observeBlocks(ctx, client, cfg, ksObj)
+
+	// 3. Update Status if needed
+	update := observeStatus(cfg, ksObj, kfObj, blocks)
+
+	// Hold anything short of a failure for the batch window and look again,
+	// so a Service settling through several states costs one write, or none
+	// when it settles back where it was
+	if update != nil && !update.failed && window > 0 {
+		logf("Holding status update for %s\n", window)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(window):
+		}
+		latest, err := ksClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get knative service: %w", err)
+		}
+		update = observeStatus(cfg, latest, kfObj, blocks)
+	}
+
+	if update == nil {
+		logf("No status update needed\n")
+		return nil
+	}
+
+	logf("Updating KDexFunction status: State=%s -> %s\n", update.from, update.state)
+	status := map[string]any{
+		"state": update.state,
+		"url":   update.url,
+	}
+	if update.detail != "" {
+		status["detail"] = update.detail
+	}
+	for field, block := range update.blocks {
+		status[field] = block
+	}
+	if update.observedGeneration != 0 {
+		status["observedGeneration"] = update.observedGeneration
+	}
+	patch := map[string]any{"status": status}
+	if update.conditions != nil {
+		status["conditions"] = update.conditions
+		patch["metadata"] = map[string]any{"resourceVersion": update.resourceVersion}
+	}
+	patchBytes, _ := json.Marshal(patch)
+
+	patchCtx, span := startSpan(ctx, "status.patch", cfg, attribute.String("kdex.function.state", update.state))
+	_, err := kfClient.Patch(patchCtx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-observer",
+	}, "status")
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to patch kdex function status: %w", err)
+	}
+
+	if update.state != update.from {
+		// The status is written; notifying is best effort
+		notifiers, err := loadNotifiers(cfg, client)
+		if err != nil {
+			warnf("failed to load notifiers: %v\n", err)
+			return nil
+		}
+		n := newNotification(cfg, notificationStateChanged)
+		n.URL = update.url
+		n.Message = fmt.Sprintf("%s -> %s", update.from, update.state)
+		notifiers.notify(ctx, n)
+	}
+	return nil
+}
+
+// observeStatus compares the KDexFunction status with the Knative Service
+// and the observed status blocks, returning the update to make, if any.
+// We only sync URL and State if it diverged or isn't set.
+func observeStatus(cfg *EnvConfig, ksObj, kfObj *unstructured.Unstructured, blocks map[string]map[string]any) *statusUpdate {
+	isReady, msg, url := parseKnativeStatus(ksObj)
+	logf("Observation: Ready=%v, Msg=%s, URL=%s\n", isReady, msg, url)
+
+	// Check current state
+	status, _, _ := unstructured.NestedMap(kfObj.Object, "status")
+	currentState, _, _ := unstructured.NestedString(status, "state")
+	currentURL, _, _ := unstructured.NestedString(status, "url")
+
+	update := &statusUpdate{from: currentState, state: currentState, url: url}
+	needsUpdate := false
+
+	// Status transition logic
+	if isReady {
+		if currentState != "Ready" {
+			update.state = "Ready"
+			update.detail = statusDetail(cfg, detailReady, url, msg, fmt.Sprintf("Ready: %s%s", url, cfg.FunctionBasePath))
+			needsUpdate = true
+		}
+		if currentURL != url {
+			needsUpdate = true
+		}
+	} else {
+		// If not ready, we might want to reflect that, but avoid flapping during transient issues.
+		// But Knative scales to zero, so it might be "Ready" but not running.
+		// "Ready" condition in Knative Service usually means configuration is valid and routes are set up.
+		// Scale to zero doesn't clear Ready condition usually.
+		if currentState == "Ready" {
+			// It was ready, now it's not.
+			update.state = "FunctionDeployed" // Fallback? Or keep Ready but Degraded condition?
+			update.detail = statusDetail(cfg, detailNotReady, url, msg, fmt.Sprintf("NotReady: %s%s", url, cfg.FunctionBasePath))
+			update.failed = readyConditionStatus(ksObj) == "False"
+			needsUpdate = true
+		}
+	}
+
+	for field, block := range blocks {
+		current, _, _ := unstructured.NestedMap(status, field)
+		if block != nil && blockChanged(current, block) {
+			if update.blocks == nil {
+				update.blocks = map[string]map[string]any{}
+			}
+			update.blocks[field] = block
+			needsUpdate = true
+		}
+	}
+
+	// A status reporting on an older generation than the spec is stale
+	generation := reportedGeneration(ksObj, kfObj)
+	if generation < kfObj.GetGeneration() {
+		logf("Generation %d is not deployed yet; the status reports on generation %d\n", kfObj.GetGeneration(), generation)
+	}
+	recorded, _, _ := unstructured.NestedInt64(status, "observedGeneration")
+	if generation != recorded {
+		update.observedGeneration = generation
+		needsUpdate = true
+	}
+
+	currentConditions, _, _ := unstructured.NestedSlice(status, "conditions")
+	observed := serviceConditions(ksObj, generation)
+	if conditionsChanged(currentConditions, observed) {
+		update.conditions = mergeServiceConditions(currentConditions, observed)
+		update.resourceVersion = kfObj.GetResourceVersion()
+		needsUpdate = true
+	}
+
+	if !needsUpdate {
+		return nil
+	}
+	return update
+}
+
+// observeBlocks observes the status blocks the observer keeps next to the
+// state. A block that cannot be told is nil and left as it is.
+func observeBlocks(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, ksObj *unstructured.Unstructured) map[string]map[string]any {
+	return map[string]map[string]any{
+		"authentication": observeAuthentication(ctx, client, cfg),
+		"schedule":       observeSchedule(ctx, client, cfg),
+		"health":         observeHealth(ctx, client, cfg, ksObj),
+		"network":        observeNetwork(ctx, client, cfg, ksObj),
+	}
+}
+
+// blockChanged tells whether any field of the observed block differs from
+// the status. Fields missing from the observation are left alone, as the
+// merge patch writing the block would.
+func blockChanged(current, observed map[string]any) bool {
+	for key, value := range observed {
+		if !reflect.DeepEqual(current[key], value) {
+			return true
+		}
+	}
+	return false
+}
+
+// readyConditionStatus is the status of the Service's Ready condition:
+// True, False once reconciling failed, or Unknown while it is in progress.
+func readyConditionStatus(obj *unstructured.Unstructured) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if ok && cond["type"] == "Ready" {
+			status, _ := cond["status"].(string)
+			return status
+		}
+	}
+	return "Unknown"
+}
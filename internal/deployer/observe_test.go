@@ -0,0 +1,140 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func knativeServiceWithReady(status string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"status": map[string]any{
+			"url": "http://fn.default.example.com",
+			"conditions": []any{
+				map[string]any{"type": "Ready", "status": status, "message": "revision failed"},
+				map[string]any{"type": "ConfigurationReady", "status": status},
+				map[string]any{"type": "RoutesReady", "status": status},
+			},
+		},
+	}}
+}
+
+// kdexFunctionInState is a function in the state, with the conditions of
+// a ready Service once it is Ready.
+func kdexFunctionInState(state string) *unstructured.Unstructured {
+	status := map[string]any{"state": state, "url": "http://fn.default.example.com"}
+	if state == "Ready" {
+		status["conditions"] = mergeServiceConditions(nil, serviceConditions(knativeServiceWithReady("True"), 0))
+	}
+	return &unstructured.Unstructured{Object: map[string]any{"status": status}}
+}
+
+func TestObserveStatus(t *testing.T) {
+	cfg := &EnvConfig{}
+
+	if update := observeStatus(cfg, knativeServiceWithReady("True"), kdexFunctionInState("Ready"), nil); update != nil {
+		t.Errorf("Expected no update for an unchanged function, got %+v", update)
+	}
+
+	update := observeStatus(cfg, knativeServiceWithReady("True"), kdexFunctionInState("FunctionDeployed"), nil)
+	if update == nil || update.state != "Ready" || update.failed {
+		t.Errorf("Expected a Ready update, got %+v", update)
+	}
+
+	update = observeStatus(cfg, knativeServiceWithReady("Unknown"), kdexFunctionInState("Ready"), nil)
+	if update == nil || update.state != "FunctionDeployed" || update.failed {
+		t.Errorf("Expected a held degrade while reconciling, got %+v", update)
+	}
+
+	update = observeStatus(cfg, knativeServiceWithReady("False"), kdexFunctionInState("Ready"), nil)
+	if update == nil || !update.failed {
+		t.Errorf("Expected a failure to be written at once, got %+v", update)
+	}
+	ready, _, _ := findCondition(&unstructured.Unstructured{Object: map[string]any{"status": map[string]any{"conditions": update.conditions}}}, conditionReady)
+	if ready != "False" {
+		t.Errorf("Expected the Ready condition to turn False, got %v", update.conditions)
+	}
+}
+
+func TestStatusBatchWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultStatusBatchWindow},
+		{value: "0s", want: 0},
+		{value: "5s", want: 5 * time.Second},
+		{value: "-1s", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := statusBatchWindow(&EnvConfig{StatusBatchWindow: tt.value})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("STATUS_BATCH_WINDOW=%q: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("STATUS_BATCH_WINDOW=%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("STATUS_BATCH_WINDOW=%q: expected %v, got %v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestObserveLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	observeLoop(ctx, time.Millisecond, func(context.Context) error {
+		calls++
+		switch calls {
+		case 1:
+			return fmt.Errorf("api unavailable")
+		case 2:
+			panic("unexpected status")
+		case 3:
+			cancel()
+		}
+		return nil
+	})
+	if calls != 3 {
+		t.Errorf("Expected failed observations not to stop the loop, got %d calls", calls)
+	}
+}
+
+func TestObserveAll(t *testing.T) {
+	ready := func(name string) *unstructured.Unstructured {
+		service := newObject("serving.knative.dev/v1", "Service", "myns", name, map[string]string{"kdex.dev/function": name})
+		_ = unstructured.SetNestedField(service.Object, map[string]any{
+			"url":        "http://" + name + ".myns.example.com",
+			"conditions": []any{map[string]any{"type": "Ready", "status": "True"}},
+		}, "status")
+		return service
+	}
+	client := newFakeDynamicClient(
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "fn-a", map[string]string{"team": "a"}),
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "fn-b", map[string]string{"team": "b"}),
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "fn-c", map[string]string{"team": "a"}),
+		ready("fn-a"), ready("fn-b"),
+	)
+
+	if err := observeAll(context.Background(), client, "myns", "team=a", readEnv()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{"fn-a": "Ready", "fn-b": "", "fn-c": ""} {
+		function, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), name, metav1.GetOptions{})
+		if state, _, _ := unstructured.NestedString(function.Object, "status", "state"); state != want {
+			t.Errorf("Expected %s to be in state %q, got %q", name, want, state)
+		}
+	}
+}
@@ -0,0 +1,919 @@
+package deployer
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// phaseStep is a phase and how to run it.
+type phaseStep struct {
+	phase report.Phase
+	run   func(ctx context.Context, d *deployment) error
+	// skip tells whether the phase has nothing to do.
+	skip func(d *deployment) bool
+	// retries is how many more times the phase runs after failing with a
+	// transient API error.
+	retries int
+	// abort undoes the phase once it failed for good, returning the error
+	// to report.
+	abort func(ctx context.Context, d *deployment, cause error) error
+}
+
+// phaseHook runs around phases. Hooks before a phase get a report with only
+// the phase set.
+type phaseHook func(ctx context.Context, d *deployment, r report.PhaseReport)
+
+// deployPipeline runs phases in order, stopping at the first that fails.
+type deployPipeline struct {
+	phases []phaseStep
+	before []phaseHook
+	after  []phaseHook
+}
+
+// deployment is the state threaded through the deploy phases.
+type deployment struct {
+	cfg         *EnvConfig
+	progressive bool
+	started     time.Time
+
+	// Set by Validate
+	timing   waitTiming
+	throttle deployThrottle
+	quota    deployQuota
+	// soak is how long the new revision must stay stable once ready.
+	soak time.Duration
+	// logTail is how many log lines of a revision that does not become
+	// ready are printed.
+	logTail int64
+	// readinessFactor is the multiple of the median time to ready past
+	// which the deploy is flagged, 0 to never flag it.
+	readinessFactor float64
+
+	// Set by Preflight
+	client      dynamic.Interface
+	services    dynamic.ResourceInterface
+	checkpoint  *deployCheckpoint
+	notifiers   *notifierBus
+	imageLabels map[string]string
+	// podLogs is set when LOG_TAIL_ON_FAILURE is.
+	podLogs podLogReader
+	// state is the Service as it was before the deploy.
+	state serviceState
+	// resumed means a previous attempt already rolled the Service out.
+	resumed bool
+
+	// Set by the rollout phases
+	release func()
+	// pinned means traffic stays on the previous revision until
+	// ShiftTraffic moves it, or the caller does with SKIP_TRAFFIC_SHIFT.
+	pinned bool
+	// metadataOnly means only the Service metadata changed, so no new
+	// revision rolls out.
+	metadataOnly bool
+	// plan is set when traffic shifts to the new revision progressively.
+	plan      *progressivePlan
+	candidate string
+	// imageDigest is the digest Knative resolved the image of the
+	// candidate to.
+	imageDigest string
+	timeToReady time.Duration
+	url         string
+	// readinessRegression is why the time to ready was flagged.
+	readinessRegression string
+	// customURL is where the DomainMapping for FUNCTION_HOST serves the
+	// function.
+	customURL string
+	tags      map[string]string
+
+	reports []report.PhaseReport
+}
+
+// newDeployPipeline returns the deploy phases: Validate, Preflight, Apply,
+// AwaitRevision, Verify, ShiftTraffic, Soak and Finalize. The rollout phases
+// are skipped when resuming a deploy whose rollout completed, Verify and
+// ShiftTraffic when SKIP_VERIFY and SKIP_TRAFFIC_SHIFT leave them to the
+// caller, and Soak without a SOAK_DURATION or a new revision. Under
+// READ_ONLY the deploy ends with the apply it logged.
+func newDeployPipeline() *deployPipeline {
+	rolledOut := func(d *deployment) bool { return d.resumed }
+	// READ_ONLY dropped the apply, so nothing after it would happen
+	readOnly := func(d *deployment) bool { return d.cfg.ReadOnly == "true" }
+	return &deployPipeline{
+		phases: []phaseStep{
+			{phase: report.PhaseValidate, run: validateDeploy},
+			{phase: report.PhasePreflight, run: preflightDeploy},
+			{phase: report.PhaseApply, run: applyDeploy, skip: rolledOut, retries: 2, abort: abortPinned},
+			{
+				phase: report.PhaseAwaitRevision,
+				run:   awaitRevision,
+				skip:  func(d *deployment) bool { return rolledOut(d) || readOnly(d) },
+				abort: abortPinned,
+			},
+			{
+				phase:   report.PhaseVerify,
+				run:     verifyRevision,
+				skip:    func(d *deployment) bool { return d.resumed || readOnly(d) || d.cfg.SkipVerify == "true" },
+				retries: 2,
+				abort:   abortPinned,
+			},
+			{
+				phase: report.PhaseShiftTraffic,
+				run:   shiftDeployTraffic,
+				// Without a progressive rollout the apply already routed
+				// the traffic, or SKIP_TRAFFIC_SHIFT leaves it pinned
+				skip:  func(d *deployment) bool { return d.resumed || readOnly(d) || d.plan == nil },
+				abort: abortPinned,
+			},
+			{
+				phase: report.PhaseSoak,
+				run:   soakRevision,
+				skip:  func(d *deployment) bool { return d.resumed || readOnly(d) || d.soak == 0 || d.metadataOnly },
+				abort: abortSoak,
+			},
+			{phase: report.PhaseFinalize, run: finalizeDeploy, skip: readOnly},
+		},
+		before: []phaseHook{logPhaseStart},
+		after:  []phaseHook{logPhaseReport, notifyPhaseFailure, notifyPhaseMilestone},
+	}
+}
+
+// run runs the phases of p, recording a report for each.
+func (p *deployPipeline) run(ctx context.Context, d *deployment) (err error) {
+	defer d.releaseSlot()
+	defer logPhase("")
+	deploysStarted.Inc()
+	defer func() { recordDeployOutcome(err) }()
+	ctx, span := startSpan(ctx, "deploy", d.cfg)
+	defer func() { endSpan(span, err) }()
+
+	for _, step := range p.phases {
+		logPhase(step.phase)
+		r := report.PhaseReport{Phase: step.phase}
+		var err error
+		if step.skip != nil && step.skip(d) {
+			r.Outcome = report.PhaseSkipped
+		} else {
+			for _, hook := range p.before {
+				hook(ctx, d, r)
+			}
+			phaseCtx, span := startSpan(ctx, "deploy."+string(step.phase), d.cfg)
+			err = runPhase(phaseCtx, d, step, &r)
+			endSpan(span, err)
+		}
+
+		d.reports = append(d.reports, r)
+		for _, hook := range p.after {
+			hook(ctx, d, r)
+		}
+		if err != nil {
+			return fmt.Errorf("%s phase failed: %w", step.phase, err)
+		}
+	}
+	return nil
+}
+
+func runPhase(ctx context.Context, d *deployment, step phaseStep, r *report.PhaseReport) error {
+	start := time.Now()
+	var err error
+	for {
+		r.Attempts++
+		err = step.run(ctx, d)
+		if err == nil || r.Attempts > step.retries || !retryablePhaseError(err) {
+			break
+		}
+		logf("%s phase failed, retrying: %v\n", step.phase, err)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(d.timing.PollInterval):
+			continue
+		}
+		break
+	}
+	if err != nil && step.abort != nil {
+		err = step.abort(ctx, d, err)
+	}
+
+	r.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		r.Outcome = report.PhaseFailed
+		r.Error = err.Error()
+		return err
+	}
+	r.Outcome = report.PhaseSucceeded
+	return nil
+}
+
+// retryablePhaseError tells whether err is a transient API error worth
+// running the phase again for.
+func retryablePhaseError(err error) bool {
+	return errors.IsConflict(err) || errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) || errors.IsServiceUnavailable(err) || errors.IsInternalError(err)
+}
+
+func logPhaseStart(_ context.Context, _ *deployment, r report.PhaseReport) {
+	logf("Phase %s started\n", r.Phase)
+}
+
+func logPhaseReport(_ context.Context, _ *deployment, r report.PhaseReport) {
+	switch r.Outcome {
+	case report.PhaseSkipped:
+		logf("Phase %s skipped\n", r.Phase)
+	case report.PhaseFailed:
+		logf("Phase %s failed after %d attempt(s) in %s: %s\n", r.Phase, r.Attempts, r.Duration, r.Error)
+	default:
+		logf("Phase %s succeeded in %s\n", r.Phase, r.Duration)
+	}
+}
+
+// notifyPhaseFailure sends the DeployFailed notification, once the
+// notifiers are loaded.
+func notifyPhaseFailure(ctx context.Context, d *deployment, r report.PhaseReport) {
+	if r.Outcome != report.PhaseFailed || d.notifiers == nil {
+		return
+	}
+	n := newNotification(d.cfg, notificationDeployFailed)
+	n.Message = fmt.Sprintf("%s phase: %s", r.Phase, r.Error)
+	d.notifiers.notify(ctx, n)
+}
+
+// notifyPhaseMilestone sends the ServiceApplied and Ready notifications as
+// the rollout gets there.
+func notifyPhaseMilestone(ctx context.Context, d *deployment, r report.PhaseReport) {
+	if r.Outcome != report.PhaseSucceeded || d.notifiers == nil {
+		return
+	}
+	switch r.Phase {
+	case report.PhaseApply:
+		d.notifiers.notify(ctx, newNotification(d.cfg, notificationServiceApplied))
+	case report.PhaseAwaitRevision:
+		n := newNotification(d.cfg, notificationReady)
+		n.URL = d.url
+		n.Revision = d.candidate
+		d.notifiers.notify(ctx, n)
+	}
+}
+
+// releaseSlot gives back the deploy throttle slot, if one is held.
+func (d *deployment) releaseSlot() {
+	if d.release != nil {
+		d.release()
+		d.release = nil
+	}
+}
+
+// terminationMessage is what a deploy that succeeded tells the controller
+// recording it. A resumed deploy reports the revision and digest the
+// attempt that rolled out recorded in the checkpoint, and no time to ready.
+func (d *deployment) terminationMessage() report.TerminationMessage {
+	cfg := d.cfg
+	msg := report.TerminationMessage{
+		URL:                 d.url,
+		CustomURL:           d.customURL,
+		LatestReadyRevision: d.candidate,
+		Generation:          cfg.FunctionGeneration,
+		ImageDigest:         cfg.FunctionImageDigest,
+		ReadinessRegression: d.readinessRegression,
+		Tags:                d.tags,
+		DeployID:            cfg.DeployID,
+		Phases:              d.reports,
+	}
+	if d.url != "" {
+		msg.FunctionURL = d.url + cfg.FunctionBasePath
+	}
+	if d.checkpoint != nil && d.checkpoint.Revision != "" {
+		msg.LatestReadyRevision = d.checkpoint.Revision
+	}
+	if msg.ImageDigest == "" {
+		msg.ImageDigest = d.imageDigest
+	}
+	if d.timeToReady > 0 {
+		msg.TimeToReady = d.timeToReady.Round(time.Millisecond).String()
+	}
+	return msg
+}
+
+// failureMessage is what a deploy that failed with err tells the
+// controller: the phase it stopped at, why, and how far it got.
+func (d *deployment) failureMessage(err error) report.TerminationMessage {
+	cfg := d.cfg
+	msg := report.TerminationMessage{
+		URL:        d.url,
+		Outcome:    report.OutcomeFailed,
+		Error:      err.Error(),
+		Generation: cfg.FunctionGeneration,
+		DeployID:   cfg.DeployID,
+		Phases:     d.reports,
+	}
+	for _, r := range d.reports {
+		if r.Outcome == report.PhaseFailed {
+			msg.FailedPhase = r.Phase
+		}
+	}
+
+	var failure *report.RevisionFailure
+	switch {
+	case deployThrottled(err):
+		msg.Outcome = report.OutcomeThrottled
+	case stderrors.As(err, &failure):
+		msg.Reason = failure.Reason
+		msg.Diagnosis = failure
+	default:
+		if reason := errors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+			msg.Reason = string(reason)
+		}
+	}
+	return msg
+}
+
+// writeTerminationMessage writes the message, keeping it whole in a
+// ConfigMap when it is too large for the termination log. A deploy that
+// failed before it had a client only has the termination log.
+func (d *deployment) writeTerminationMessage(ctx context.Context, msg report.TerminationMessage) error {
+	if d.client != nil && d.cfg.ReadOnly != "true" {
+		if err := saveFullTerminationMessage(ctx, d.client, d.cfg, &msg); err != nil {
+			warnf("failed to save the full termination message: %v\n", err)
+		}
+	}
+	return writeTerminationMessage(msg)
+}
+
+// configValidators check the settings of the deploy that stand on their
+// own, in the order they are reported.
+var configValidators = []func(*EnvConfig) error{
+	validatePublicURLInjection,
+	validateLogSink,
+	validateTracing,
+	validateFunctionLogLevel,
+	validateDriftCheck,
+	validateImageDigest,
+	validateSignatureVerification,
+	validateRequestAuthentication,
+	validateFunctionHost,
+	validateHostTLS,
+	validateSchedule,
+	validateEventSink,
+	validateCustomMetric,
+	validateVisibility,
+	validateExtraMetadata,
+	validateLifecycle,
+	validateEgress,
+	validateIPFamilies,
+	validateMessageLimits,
+	validateSessionAffinity,
+	validateScaleSchedule,
+	validateForwardedEnvVars,
+}
+
+// validateDeploy checks the configuration without touching the cluster.
+func validateDeploy(_ context.Context, d *deployment) error {
+	cfg := d.cfg
+	timing, err := parseWaitTiming(cfg)
+	if err != nil {
+		return err
+	}
+	d.timing = timing
+
+	throttle, err := parseDeployThrottle(cfg)
+	if err != nil {
+		return err
+	}
+	d.throttle = throttle
+
+	quota, err := parseDeployQuota(cfg)
+	if err != nil {
+		return err
+	}
+	d.quota = quota
+
+	soak, err := parseSoakDuration(cfg)
+	if err != nil {
+		return err
+	}
+	d.soak = soak
+
+	logTail, err := parseLogTail(cfg)
+	if err != nil {
+		return err
+	}
+	d.logTail = logTail
+
+	readinessFactor, err := parseReadinessRegressionFactor(cfg)
+	if err != nil {
+		return err
+	}
+	d.readinessFactor = readinessFactor
+
+	if d.progressive {
+		if cfg.Traffic != "" {
+			return fmt.Errorf("TRAFFIC cannot be combined with --progressive")
+		}
+		if cfg.SkipTrafficShift == "true" {
+			return fmt.Errorf("SKIP_TRAFFIC_SHIFT cannot be combined with --progressive")
+		}
+		if _, err := parseProgressivePlan(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.SkipTrafficShift == "true" && cfg.Traffic != "" {
+		return fmt.Errorf("TRAFFIC cannot be combined with SKIP_TRAFFIC_SHIFT")
+	}
+
+	for _, validate := range configValidators {
+		if err := validate(cfg); err != nil {
+			return err
+		}
+	}
+
+	// Checked before any step can change the settings it covers
+	return verifyDeployPayload(cfg)
+}
+
+// preflightDeploy connects to the cluster, picks up the checkpoint of an
+// earlier attempt and settles the image to deploy, building it when
+// deploying from source.
+func preflightDeploy(ctx context.Context, d *deployment) error {
+	cfg := d.cfg
+	if d.client == nil {
+		client, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
+		d.client = client
+	}
+	d.services = d.client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+	if d.logTail > 0 && d.podLogs == nil {
+		podLogs, err := clusterPodLogs()
+		if err != nil {
+			return err
+		}
+		d.podLogs = podLogs
+	}
+
+	// A retried Job picks up the checkpoint of the attempt that failed,
+	// along with its deploy ID
+	d.checkpoint = resumeCheckpoint(ctx, d.client, cfg)
+	if cfg.DeployID == "" {
+		cfg.DeployID = d.checkpoint.DeployID
+	}
+	if err := resolveDeployID(cfg); err != nil {
+		return err
+	}
+	d.checkpoint.DeployID = cfg.DeployID
+	logf("Deploying %s/%s\n", cfg.FunctionNamespace, cfg.FunctionName)
+
+	if cfg.SkipStatusUpdate != "true" {
+		if err := recordDeployID(ctx, d.client, cfg); err != nil {
+			warnf("failed to record deploy id: %v\n", err)
+		}
+	}
+
+	notifiers, err := loadNotifiers(cfg, d.client)
+	if err != nil {
+		return err
+	}
+	d.notifiers = notifiers
+	d.notifiers.notify(ctx, newNotification(cfg, notificationDeployStarted))
+
+	if err := checkDrift(ctx, d.client, cfg); err != nil {
+		return err
+	}
+	warnClusterDefaults(ctx, d.client, cfg)
+
+	// Reuse the URL of an existing Service so env templates referencing it
+	// resolve on the first apply, and its serving revision for TRAFFIC
+	existing, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err == nil {
+		d.state = serviceStateOf(existing)
+	} else {
+		existing = nil
+	}
+
+	d.resumed = d.checkpoint.resumable(existing)
+	if d.resumed {
+		logf("Resuming %s\n", d.checkpoint)
+		cfg.FunctionImage = d.checkpoint.Image
+		cfg.FunctionImageDigest = d.checkpoint.ImageDigest
+		d.url = d.checkpoint.URL
+	}
+
+	// A resumed deploy was charged by the attempt that started it
+	if d.quota.Limit > 0 && !d.resumed && cfg.ReadOnly != "true" {
+		if err := chargeTenant(ctx, d.client, cfg, d.quota); err != nil {
+			return err
+		}
+	}
+
+	// Build the image first when deploying from source. A read-only
+	// deploy cannot wait for a build it did not start, so it goes on with
+	// the image the build would have pushed
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit != "" && cfg.ReadOnly == "true" {
+		logf("Read-only: would have built %s from %s\n", cfg.BuildImage, cfg.FunctionSourceGit)
+		cfg.FunctionImage = cfg.BuildImage
+	} else if cfg.FunctionImage == "" && cfg.FunctionSourceGit != "" {
+		image, err := runBuild(ctx, d.client, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to build function image: %w", err)
+		}
+		logf("Built image %s\n", image)
+		cfg.FunctionImage = image
+	}
+
+	if cfg.ResolveImageDigest == "true" || cfg.VerifyImageSignature == "true" {
+		keychain, err := imageKeychain(ctx, d.client, cfg)
+		if err != nil {
+			return err
+		}
+
+		// Pin the revision to the digest the tag points to now, so it
+		// stays the image that was verified even if the tag moves
+		if cfg.FunctionImageDigest == "" {
+			digest, err := resolveImageDigest(ctx, keychain, cfg.FunctionImage)
+			if err != nil {
+				return err
+			}
+			logf("Resolved image %s to %s\n", cfg.FunctionImage, digest)
+			cfg.FunctionImageDigest = digest
+		}
+
+		if cfg.VerifyImageSignature == "true" {
+			policy, err := loadSignaturePolicy(cfg)
+			if err != nil {
+				return err
+			}
+			if err := verifyImageSignature(ctx, keychain, pinnedImage(cfg), cfg.FunctionImageDigest, policy); err != nil {
+				return fmt.Errorf("refusing to deploy unsigned image: %w", err)
+			}
+			logf("Verified signature of %s\n", pinnedImage(cfg))
+		}
+	}
+
+	// Image labels drive runtime detection and the recorded build metadata
+	imageConfig, err := fetchImageConfig(ctx, pinnedImage(cfg))
+	if err != nil {
+		warnf("failed to inspect image: %v\n", err)
+	} else {
+		d.imageLabels = imageConfig.Config.Labels
+	}
+
+	if cfg.FunctionRuntime == "" {
+		cfg.DetectedRuntime = detectRuntime(d.imageLabels)
+		if cfg.DetectedRuntime != "" {
+			logf("Detected runtime %s\n", cfg.DetectedRuntime)
+		}
+	}
+	return nil
+}
+
+// applyDeploy applies the Service within the namespace deploy throttle. A
+// progressive rollout, or SKIP_TRAFFIC_SHIFT, applies the new revision
+// without traffic, tagged as the candidate.
+func applyDeploy(ctx context.Context, d *deployment) error {
+	cfg := d.cfg
+	// Limit concurrent rollouts per namespace before touching anything
+	// that reaches the ingress or the autoscaler
+	if d.release == nil {
+		release, err := acquireDeploySlot(ctx, d.client, cfg, d.throttle, d.timing)
+		if err != nil {
+			return err
+		}
+		d.release = release
+
+		// Start the collector before the revision so no early logs are lost
+		if err := provisionLogSink(ctx, d.client, cfg); err != nil {
+			warnf("failed to provision log sink: %v\n", err)
+		}
+	}
+
+	// Before the Service, so no revision ever serves without the checks
+	if err := provisionRequestAuthentication(ctx, d.client, cfg); err != nil {
+		return err
+	}
+
+	if err := provisionSinkBinding(ctx, d.client, cfg); err != nil {
+		return err
+	}
+
+	if err := provisionEgress(ctx, d.client, cfg); err != nil {
+		return err
+	}
+
+	if err := provisionMessageLimits(ctx, d.client, cfg); err != nil {
+		return err
+	}
+
+	if !d.pinned {
+		service, err := buildService(cfg, d.state)
+		if err != nil {
+			return err
+		}
+		d.metadataOnly = d.state.Live != nil && revisionTemplateUnchanged(service, d.state.Live)
+		if d.metadataOnly {
+			logf("Only the Service metadata changed; no new revision rolls out\n")
+		}
+	}
+
+	// Without a new revision there is no traffic to shift to it
+	if (d.progressive || cfg.SkipTrafficShift == "true") && !d.pinned && !d.metadataOnly {
+		if d.state.LatestReadyRevision == "" {
+			logf("No serving revision yet; routing all traffic to the new revision\n")
+		} else {
+			if d.progressive {
+				plan, err := parseProgressivePlan(cfg)
+				if err != nil {
+					return err
+				}
+				d.plan = plan
+				logf("Progressive rollout from %s in steps %v\n", d.state.LatestReadyRevision, plan.Steps)
+			} else {
+				logf("Keeping traffic on %s; SKIP_TRAFFIC_SHIFT leaves shifting it to the caller\n", d.state.LatestReadyRevision)
+			}
+			d.pinned = true
+			cfg.Traffic = fmt.Sprintf("current=100,latest@%s=0", candidateTag)
+		}
+	}
+
+	return applyService(ctx, d.services, cfg, d.state)
+}
+
+// awaitRevision waits for the Service to become ready, reporting the pods
+// of the revision and printing their logs when it does not. The URL was
+// not known before the first apply; the Service is applied again so env
+// templates referencing it pick it up.
+func awaitRevision(ctx context.Context, d *deployment) error {
+	diagnose := diagnoseRevisions(d.client.Resource(knativeRevisionGVR).Namespace(d.cfg.FunctionNamespace))
+	logf("Waiting for service to be Ready...\n")
+	start := time.Now()
+	url, err := waitForReady(ctx, d.services, d.cfg.FunctionName, d.timing, diagnose)
+	if err != nil {
+		return d.revisionNotReady(ctx, fmt.Errorf("failed to wait for service readiness: %w", err))
+	}
+	d.timeToReady = time.Since(start)
+	timeToReady.Observe(d.timeToReady.Seconds())
+	logf("Service is Ready. URL: %s\n", url)
+
+	if url != d.state.URL && envReferencesURL(d.cfg) {
+		logf("Re-applying service with resolved function URL...\n")
+		d.state.URL = url
+		url, err = applyAndWait(ctx, d.services, d.cfg, d.state, diagnose)
+		if err != nil {
+			return d.revisionNotReady(ctx, err)
+		}
+	}
+	d.url = url
+
+	service, err := d.services.Get(ctx, d.cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get knative service: %w", err)
+	}
+	d.candidate, _, _ = unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+	if revision, err := d.client.Resource(knativeRevisionGVR).Namespace(d.cfg.FunctionNamespace).Get(ctx, d.candidate, metav1.GetOptions{}); err == nil {
+		d.imageDigest = revisionImageDigest(revision)
+	}
+	return nil
+}
+
+// revisionNotReady explains cause with what the pods of the revision show
+// and, with LOG_TAIL_ON_FAILURE, what they logged.
+func (d *deployment) revisionNotReady(ctx context.Context, cause error) error {
+	if d.logTail > 0 && d.podLogs != nil {
+		tailRevisionLogs(ctx, d.client, d.podLogs, d.cfg, d.logTail)
+	}
+	return withPodReport(ctx, d.client, d.cfg, cause)
+}
+
+// verifyRevision checks that the revision created for this deploy is the
+// one ready to serve and, scaling on a custom metric, that the metric is
+// reported for it.
+func verifyRevision(ctx context.Context, d *deployment) error {
+	service, err := d.services.Get(ctx, d.cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get knative service: %w", err)
+	}
+	if ready, msg, _ := parseKnativeStatus(service); !ready {
+		return fmt.Errorf("service is not ready: %s", msg)
+	}
+
+	latestReady, _, _ := unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+	latestCreated, _, _ := unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+	if latestCreated != "" && latestCreated != latestReady {
+		return fmt.Errorf("revision %s is not ready", latestCreated)
+	}
+	if d.pinned && (latestReady == "" || latestReady == d.state.LatestReadyRevision) {
+		return fmt.Errorf("new revision did not become ready")
+	}
+	if customMetric(d.cfg) != "" {
+		if err := waitForCustomMetric(ctx, d.client, d.cfg, latestReady, d.timing); err != nil {
+			return err
+		}
+	}
+	logf("Revision %s verified\n", latestReady)
+	return nil
+}
+
+// shiftDeployTraffic moves traffic to the new revision in the steps of the
+// progressive rollout.
+func shiftDeployTraffic(ctx context.Context, d *deployment) error {
+	url, err := shiftTraffic(ctx, d.services, d.cfg, d.state, d.plan, d.candidate)
+	if err != nil {
+		return err
+	}
+	d.url = url
+	return nil
+}
+
+// abortPinned pins all traffic back to the previous revision when a
+// rollout that held traffic on it fails.
+func abortPinned(ctx context.Context, d *deployment, cause error) error {
+	if !d.pinned {
+		return cause
+	}
+	return abortRollout(ctx, d.services, d.cfg, d.state, cause)
+}
+
+// finalizeDeploy checkpoints the rollout, then runs the steps after it,
+// each at most once across retried Jobs.
+func finalizeDeploy(ctx context.Context, d *deployment) error {
+	cfg, client, checkpoint, url := d.cfg, d.client, d.checkpoint, d.url
+	d.releaseSlot()
+
+	if !d.resumed {
+		checkpoint.Phase = phaseApplied
+		checkpoint.Image = cfg.FunctionImage
+		checkpoint.ImageDigest = cfg.FunctionImageDigest
+		checkpoint.Revision = d.candidate
+		checkpoint.URL = url
+		checkpoint.Completed = nil
+		checkpoint.save(ctx, client, cfg)
+	}
+
+	if err := applyIPFamilies(ctx, client, cfg, checkpoint.Revision); err != nil {
+		return err
+	}
+
+	if err := applySessionAffinity(ctx, client, cfg, checkpoint.Revision); err != nil {
+		return err
+	}
+
+	if cfg.SkipStatusUpdate != "true" {
+		service, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err == nil {
+			err = recordServiceConditions(ctx, client, cfg, service)
+		}
+		if err != nil {
+			warnf("failed to record conditions: %v\n", err)
+		}
+
+		// A resumed deploy did not time the rollout
+		if d.timeToReady > 0 {
+			regression, err := recordReadiness(ctx, client, cfg, d.timeToReady, d.readinessFactor)
+			if err != nil {
+				warnf("failed to record time to ready: %v\n", err)
+			} else if regression != "" {
+				warnf("readiness regressed: %s\n", regression)
+				d.readinessRegression = regression
+			}
+		}
+	}
+
+	if cfg.PublicURLInjection == publicURLInjectionConfigMap && !checkpoint.done(stepPublicURL) {
+		service, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get knative service: %w", err)
+		}
+		if err := writePublicURLConfigMap(ctx, client, cfg, service, url); err != nil {
+			return err
+		}
+		checkpoint.complete(ctx, client, cfg, stepPublicURL)
+	}
+
+	if cfg.FunctionHost != "" {
+		if !checkpoint.done(stepDomainMapping) {
+			service, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to get knative service: %w", err)
+			}
+			customURL, err := mapDomain(ctx, client, cfg, service, d.timing)
+			if err != nil {
+				return err
+			}
+			logf("Function mapped to %s\n", customURL)
+			if cfg.SkipStatusUpdate != "true" {
+				if err := recordCustomURL(ctx, client, cfg, customURL); err != nil {
+					warnf("failed to record custom url: %v\n", err)
+				}
+			}
+			checkpoint.CustomURL = customURL
+			checkpoint.complete(ctx, client, cfg, stepDomainMapping)
+		}
+		d.customURL = checkpoint.CustomURL
+	}
+
+	if cfg.ScheduleCron == "" {
+		if err := unscheduleFunction(ctx, client, cfg); err != nil {
+			return err
+		}
+	} else if !checkpoint.done(stepSchedule) {
+		service, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get knative service: %w", err)
+		}
+		if err := scheduleFunction(ctx, client, cfg, service); err != nil {
+			return err
+		}
+		logf("Function scheduled on %s\n", cfg.ScheduleCron)
+		checkpoint.complete(ctx, client, cfg, stepSchedule)
+	}
+
+	if err := provisionScaleSchedule(ctx, client, cfg); err != nil {
+		return err
+	}
+
+	if cfg.RegistryURL != "" && !checkpoint.done(stepRegister) {
+		if err := registerFunction(ctx, cfg, url); err != nil {
+			return fmt.Errorf("failed to register function: %w", err)
+		}
+		logf("Function registered with %s\n", cfg.RegistryURL)
+		checkpoint.complete(ctx, client, cfg, stepRegister)
+	}
+
+	if cfg.AlertsEnabled == "true" && !checkpoint.done(stepAlerts) {
+		if err := provisionAlerts(ctx, client, cfg); err != nil {
+			warnf("failed to provision alerts: %v\n", err)
+		} else {
+			checkpoint.complete(ctx, client, cfg, stepAlerts)
+		}
+	}
+
+	if cfg.SLOAvailabilityTarget != "" && !checkpoint.done(stepSLO) {
+		if err := provisionSLO(ctx, client, cfg); err != nil {
+			warnf("failed to provision slo: %v\n", err)
+		} else {
+			checkpoint.complete(ctx, client, cfg, stepSLO)
+		}
+	}
+
+	// Dashboards are a convenience; this must not fail the deploy
+	if cfg.DashboardProvisioning != "" && !checkpoint.done(stepDashboard) {
+		if err := provisionDashboard(ctx, client, cfg); err != nil {
+			warnf("failed to provision dashboard: %v\n", err)
+		} else {
+			checkpoint.complete(ctx, client, cfg, stepDashboard)
+		}
+	}
+
+	// Feed the developer portal; this must not fail the deploy
+	if cfg.CatalogURL != "" && !checkpoint.done(stepCatalog) {
+		function, err := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			warnf("failed to get kdex function for catalog entry: %v\n", err)
+			function = nil
+		}
+		entry := buildCatalogEntry(cfg, function, url, time.Now())
+		if err := emitCatalogEntry(ctx, cfg, entry); err != nil {
+			warnf("failed to emit catalog entry: %v\n", err)
+		} else {
+			checkpoint.complete(ctx, client, cfg, stepCatalog)
+		}
+	}
+
+	// Record buildpacks metadata for inventory; this must not fail the deploy
+	if cfg.SkipStatusUpdate != "true" && !checkpoint.done(stepBuildMetadata) {
+		if err := recordBuildMetadata(ctx, client, cfg, d.imageLabels); err != nil {
+			warnf("failed to record build metadata: %v\n", err)
+		} else {
+			checkpoint.complete(ctx, client, cfg, stepBuildMetadata)
+		}
+	}
+
+	if !checkpoint.done(stepNotify) {
+		n := newNotification(cfg, notificationDeployed)
+		n.URL = url
+		d.notifiers.notify(ctx, n)
+		checkpoint.complete(ctx, client, cfg, stepNotify)
+	}
+
+	checkpoint.Phase = phaseFinalized
+	checkpoint.save(ctx, client, cfg)
+
+	if cfg.Traffic != "" {
+		service, err := d.services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get knative service: %w", err)
+		}
+		d.tags = taggedURLs(service)
+		for tag, tagURL := range d.tags {
+			logf("Tag %s: %s\n", tag, tagURL)
+		}
+	}
+	return nil
+}
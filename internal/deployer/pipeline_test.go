@@ -0,0 +1,209 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestDeployPipelineRun(t *testing.T) {
+	d := &deployment{timing: waitTiming{Timeout: time.Second, PollInterval: time.Millisecond}}
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "services"}, "myfunc", fmt.Errorf("modified"))
+
+	calls := 0
+	var started []report.Phase
+	p := &deployPipeline{
+		phases: []phaseStep{
+			{phase: report.PhaseValidate, run: func(context.Context, *deployment) error { return nil }},
+			{phase: report.PhaseApply, retries: 2, run: func(context.Context, *deployment) error {
+				calls++
+				if calls == 1 {
+					return conflict
+				}
+				return nil
+			}},
+			{
+				phase: report.PhaseShiftTraffic,
+				run:   func(context.Context, *deployment) error { return fmt.Errorf("not skipped") },
+				skip:  func(*deployment) bool { return true },
+			},
+		},
+		before: []phaseHook{func(_ context.Context, _ *deployment, r report.PhaseReport) { started = append(started, r.Phase) }},
+	}
+
+	if err := p.run(context.Background(), d); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the conflict to be retried once, got %d calls", calls)
+	}
+	if len(started) != 2 || started[0] != report.PhaseValidate || started[1] != report.PhaseApply {
+		t.Errorf("Expected before hooks for the phases that ran, got %v", started)
+	}
+
+	outcomes := []string{}
+	for _, r := range d.reports {
+		outcomes = append(outcomes, fmt.Sprintf("%s=%s/%d", r.Phase, r.Outcome, r.Attempts))
+	}
+	if got := strings.Join(outcomes, ","); got != "Validate=Succeeded/1,Apply=Succeeded/2,ShiftTraffic=Skipped/0" {
+		t.Errorf("Unexpected reports: %s", got)
+	}
+}
+
+func TestDeployPipelineFailure(t *testing.T) {
+	d := &deployment{timing: waitTiming{Timeout: time.Second, PollInterval: time.Millisecond}}
+
+	calls := 0
+	aborted := false
+	var failed []report.PhaseReport
+	p := &deployPipeline{
+		phases: []phaseStep{
+			{
+				phase:   report.PhaseVerify,
+				retries: 2,
+				run: func(context.Context, *deployment) error {
+					calls++
+					return fmt.Errorf("revision broken")
+				},
+				abort: func(_ context.Context, _ *deployment, cause error) error {
+					aborted = true
+					return fmt.Errorf("rolled back: %w", cause)
+				},
+			},
+			{phase: report.PhaseFinalize, run: func(context.Context, *deployment) error {
+				t.Error("Expected no phase after a failure")
+				return nil
+			}},
+		},
+		after: []phaseHook{func(_ context.Context, _ *deployment, r report.PhaseReport) {
+			if r.Outcome == report.PhaseFailed {
+				failed = append(failed, r)
+			}
+		}},
+	}
+
+	err := p.run(context.Background(), d)
+	if err == nil || err.Error() != "Verify phase failed: rolled back: revision broken" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no retry of a non transient error, got %d calls", calls)
+	}
+	if !aborted {
+		t.Error("Expected the phase to be aborted")
+	}
+	if len(failed) != 1 || failed[0].Error != "rolled back: revision broken" {
+		t.Errorf("Expected the failure in the report, got %+v", failed)
+	}
+}
+
+func TestVerifyRevision(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.Object["status"] = map[string]any{
+		"latestCreatedRevisionName": "myfunc-00003",
+		"latestReadyRevisionName":   "myfunc-00002",
+		"conditions":                []any{map[string]any{"type": "Ready", "status": "True"}},
+	}
+	client := newFakeDynamicClient(service)
+	d := &deployment{
+		cfg:      &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"},
+		services: client.Resource(knativeServiceGVR).Namespace("myns"),
+	}
+
+	err := verifyRevision(context.Background(), d)
+	if err == nil || !strings.Contains(err.Error(), "myfunc-00003 is not ready") {
+		t.Fatalf("Expected the failed revision to be reported, got %v", err)
+	}
+
+	_ = unstructured.SetNestedField(service.Object, "myfunc-00003", "status", "latestReadyRevisionName")
+	client = newFakeDynamicClient(service)
+	d.services = client.Resource(knativeServiceGVR).Namespace("myns")
+	if err := verifyRevision(context.Background(), d); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A rollout holding traffic needs a revision other than the one serving
+	d.pinned = true
+	d.state = serviceState{LatestReadyRevision: "myfunc-00003"}
+	if err := verifyRevision(context.Background(), d); err == nil {
+		t.Error("Expected an error without a new revision")
+	}
+}
+
+func TestValidateDeploy(t *testing.T) {
+	d := &deployment{cfg: &EnvConfig{Traffic: "latest=100"}, progressive: true}
+	if err := validateDeploy(context.Background(), d); err == nil {
+		t.Error("Expected TRAFFIC to be rejected with --progressive")
+	}
+
+	d = &deployment{cfg: &EnvConfig{SkipTrafficShift: "true"}, progressive: true}
+	if err := validateDeploy(context.Background(), d); err == nil {
+		t.Error("Expected SKIP_TRAFFIC_SHIFT to be rejected with --progressive")
+	}
+
+	d = &deployment{cfg: &EnvConfig{SkipTrafficShift: "true", Traffic: "latest=100"}}
+	if err := validateDeploy(context.Background(), d); err == nil {
+		t.Error("Expected TRAFFIC to be rejected with SKIP_TRAFFIC_SHIFT")
+	}
+
+	d = &deployment{cfg: &EnvConfig{PollInterval: "1s"}}
+	if err := validateDeploy(context.Background(), d); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.timing.PollInterval != time.Second {
+		t.Errorf("Expected the wait timing to be set, got %+v", d.timing)
+	}
+}
+
+func TestDeployPipelineOptOuts(t *testing.T) {
+	skipped := func(d *deployment) []report.Phase {
+		phases := []report.Phase{}
+		for _, step := range newDeployPipeline().phases {
+			if step.skip != nil && step.skip(d) {
+				phases = append(phases, step.phase)
+			}
+		}
+		return phases
+	}
+
+	d := &deployment{cfg: &EnvConfig{}, plan: &progressivePlan{}, soak: time.Minute}
+	if got := skipped(d); len(got) != 0 {
+		t.Errorf("Expected no phase skipped, got %v", got)
+	}
+
+	d = &deployment{cfg: &EnvConfig{SkipVerify: "true", SkipTrafficShift: "true"}, pinned: true}
+	if got := fmt.Sprint(skipped(d)); got != "[Verify ShiftTraffic Soak]" {
+		t.Errorf("Expected Verify, ShiftTraffic and Soak skipped, got %s", got)
+	}
+
+	d = &deployment{cfg: &EnvConfig{}, resumed: true, soak: time.Minute}
+	if got := fmt.Sprint(skipped(d)); got != "[Apply AwaitRevision Verify ShiftTraffic Soak]" {
+		t.Errorf("Expected the rollout phases skipped when resuming, got %s", got)
+	}
+
+	d = &deployment{cfg: &EnvConfig{ReadOnly: "true"}, plan: &progressivePlan{}, soak: time.Minute}
+	if got := fmt.Sprint(skipped(d)); got != "[AwaitRevision Verify ShiftTraffic Soak Finalize]" {
+		t.Errorf("Expected the phases after Apply skipped when read-only, got %s", got)
+	}
+}
+
+func TestRetryablePhaseError(t *testing.T) {
+	gr := schema.GroupResource{Resource: "services"}
+	if !retryablePhaseError(fmt.Errorf("apply: %w", errors.NewConflict(gr, "myfunc", fmt.Errorf("modified")))) {
+		t.Error("Expected a wrapped conflict to be retryable")
+	}
+	if !retryablePhaseError(errors.NewServiceUnavailable("busy")) {
+		t.Error("Expected service unavailable to be retryable")
+	}
+	if retryablePhaseError(errors.NewNotFound(gr, "myfunc")) {
+		t.Error("Expected not found not to be retryable")
+	}
+}
@@ -0,0 +1,184 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// maxPodReportLines bounds the pod report, which ends up in the error and
+// the termination message.
+const maxPodReportLines = 10
+
+// maxPodReportMessage bounds each message quoted in the pod report.
+const maxPodReportMessage = 200
+
+// withPodReport adds to the error of a revision that did not become ready
+// what its pods and their Warning events tell, so the job logs explain the
+// failure after the pods are gone.
+func withPodReport(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, cause error) error {
+	service, err := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return cause
+	}
+	revision, _, _ := unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+	if revision == "" {
+		return cause
+	}
+	lines, err := revisionPodReport(ctx, client, cfg.FunctionNamespace, revision)
+	if err != nil {
+		warnf("failed to report pods of revision %s: %v\n", revision, err)
+		return cause
+	}
+	if len(lines) == 0 {
+		return cause
+	}
+	logf("Pods of revision %s:\n", revision)
+	for _, line := range lines {
+		logf("  %s\n", line)
+	}
+	return fmt.Errorf("%w; %s", cause, strings.Join(lines, "; "))
+}
+
+// revisionPodReport condenses the state of the revision's pods: why they
+// are unschedulable, why their containers wait or last terminated, and the
+// Warning events about them.
+func revisionPodReport(ctx context.Context, client dynamic.Interface, namespace, revision string) ([]string, error) {
+	list, err := client.Resource(podGVR).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "serving.knative.dev/revision=" + revision,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	lines := []string{}
+	pods := map[string]bool{}
+	for _, pod := range list.Items {
+		pods[pod.GetName()] = true
+		lines = append(lines, podStatusReport(&pod)...)
+	}
+	if len(pods) == 0 {
+		return lines, nil
+	}
+
+	events, err := client.Resource(eventGVR).Namespace(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,type=Warning",
+	})
+	if err != nil {
+		warnf("failed to list events: %v\n", err)
+	} else {
+		lines = append(lines, podEventReport(events.Items, pods)...)
+	}
+
+	if len(lines) > maxPodReportLines {
+		lines = append(lines[:maxPodReportLines], fmt.Sprintf("%d more", len(lines)-maxPodReportLines))
+	}
+	return lines, nil
+}
+
+// podStatusReport tells why the pod is not running its containers.
+func podStatusReport(pod *unstructured.Unstructured) []string {
+	lines := []string{}
+	conditions, _, _ := unstructured.NestedSlice(pod.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if ok && cond["type"] == "PodScheduled" && cond["status"] == "False" {
+			reason, _ := cond["reason"].(string)
+			message, _ := cond["message"].(string)
+			lines = append(lines, fmt.Sprintf("pod %s: %s", pod.GetName(), reportMessage(reason, message)))
+		}
+	}
+
+	for _, status := range containerStatuses(pod) {
+		name, _, _ := unstructured.NestedString(status, "name")
+		states := []string{}
+		if waiting, found, _ := unstructured.NestedMap(status, "state", "waiting"); found {
+			reason, _ := waiting["reason"].(string)
+			message, _ := waiting["message"].(string)
+			states = append(states, "waiting "+reportMessage(reason, message))
+		}
+		if terminated, found, _ := unstructured.NestedMap(status, "state", "terminated"); found {
+			states = append(states, "terminated "+terminationReport(terminated))
+		}
+		if terminated, found, _ := unstructured.NestedMap(status, "lastState", "terminated"); found {
+			states = append(states, "last terminated "+terminationReport(terminated))
+		}
+		if len(states) > 0 {
+			lines = append(lines, fmt.Sprintf("pod %s container %s: %s", pod.GetName(), name, strings.Join(states, ", ")))
+		}
+	}
+	return lines
+}
+
+// podEventReport reports the Warning events about the pods, once per pod
+// and reason with the latest message, oldest first.
+func podEventReport(events []unstructured.Unstructured, pods map[string]bool) []string {
+	type eventKey struct{ pod, reason string }
+	type eventSummary struct {
+		message string
+		count   int64
+		last    string
+	}
+	summaries := map[eventKey]*eventSummary{}
+	keys := []eventKey{}
+	for _, event := range events {
+		pod, _, _ := unstructured.NestedString(event.Object, "involvedObject", "name")
+		kind, _, _ := unstructured.NestedString(event.Object, "involvedObject", "kind")
+		eventType, _, _ := unstructured.NestedString(event.Object, "type")
+		if kind != "Pod" || !pods[pod] || eventType != "Warning" {
+			continue
+		}
+		reason, _, _ := unstructured.NestedString(event.Object, "reason")
+		message, _, _ := unstructured.NestedString(event.Object, "message")
+		count, _, _ := unstructured.NestedInt64(event.Object, "count")
+		last, _, _ := unstructured.NestedString(event.Object, "lastTimestamp")
+
+		key := eventKey{pod, reason}
+		summary, seen := summaries[key]
+		if !seen {
+			summary = &eventSummary{}
+			summaries[key] = summary
+			keys = append(keys, key)
+		}
+		summary.count += max(count, 1)
+		if last >= summary.last {
+			summary.message, summary.last = message, last
+		}
+	}
+
+	slices.SortStableFunc(keys, func(a, b eventKey) int {
+		return strings.Compare(summaries[a].last, summaries[b].last)
+	})
+	lines := []string{}
+	for _, key := range keys {
+		summary := summaries[key]
+		lines = append(lines, fmt.Sprintf("pod %s event %s (x%d): %s", key.pod, key.reason, summary.count, truncateMessage(summary.message)))
+	}
+	return lines
+}
+
+func terminationReport(terminated map[string]any) string {
+	reason, _ := terminated["reason"].(string)
+	exitCode, _, _ := unstructured.NestedInt64(terminated, "exitCode")
+	return fmt.Sprintf("%s (exit code %d)", reason, exitCode)
+}
+
+func reportMessage(reason, message string) string {
+	if message == "" {
+		return reason
+	}
+	return reason + ": " + truncateMessage(message)
+}
+
+func truncateMessage(message string) string {
+	message = strings.Join(strings.Fields(message), " ")
+	if len(message) > maxPodReportMessage {
+		return message[:maxPodReportMessage] + "..."
+	}
+	return message
+}
@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestRevisionPodReport(t *testing.T) {
+	labels := map[string]string{"serving.knative.dev/revision": "fn-00002"}
+	crashing := newObject("v1", "Pod", "myns", "fn-00002-deployment-abc", labels)
+	crashing.Object["status"] = map[string]any{
+		"containerStatuses": []any{
+			map[string]any{
+				"name":      "user-container",
+				"state":     map[string]any{"waiting": map[string]any{"reason": "CrashLoopBackOff", "message": "back-off 40s restarting failed container"}},
+				"lastState": map[string]any{"terminated": map[string]any{"reason": "OOMKilled", "exitCode": int64(137)}},
+			},
+		},
+	}
+	pending := newObject("v1", "Pod", "myns", "fn-00002-deployment-def", labels)
+	pending.Object["status"] = map[string]any{
+		"conditions": []any{
+			map[string]any{"type": "PodScheduled", "status": "False", "reason": "Unschedulable", "message": "0/3 nodes are available"},
+		},
+	}
+	event := func(name, pod, reason, message, last string) *unstructured.Unstructured {
+		obj := newObject("v1", "Event", "myns", name, nil)
+		obj.Object["involvedObject"] = map[string]any{"kind": "Pod", "name": pod}
+		obj.Object["type"] = "Warning"
+		obj.Object["reason"] = reason
+		obj.Object["message"] = message
+		obj.Object["count"] = int64(2)
+		obj.Object["lastTimestamp"] = last
+		return obj
+	}
+	other := newObject("v1", "Pod", "myns", "other", nil)
+
+	client := newFakeDynamicClient(crashing, pending, other,
+		event("e1", "fn-00002-deployment-abc", "BackOff", "Back-off restarting failed container", "2026-01-01T00:00:02Z"),
+		event("e2", "other", "BackOff", "unrelated", "2026-01-01T00:00:01Z"),
+	)
+	lines, err := revisionPodReport(context.Background(), client, "myns", "fn-00002")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	report := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"container user-container: waiting CrashLoopBackOff: back-off 40s restarting failed container, last terminated OOMKilled (exit code 137)",
+		"pod fn-00002-deployment-def: Unschedulable: 0/3 nodes are available",
+		"pod fn-00002-deployment-abc event BackOff (x2): Back-off restarting failed container",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "unrelated") {
+		t.Errorf("Expected events of other pods to be left out, got:\n%s", report)
+	}
+}
+
+func TestWithPodReport(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "fn", nil)
+	service.Object["status"] = map[string]any{"latestCreatedRevisionName": "fn-00002"}
+	pod := newObject("v1", "Pod", "myns", "fn-00002-deployment-abc", map[string]string{"serving.knative.dev/revision": "fn-00002"})
+	pod.Object["status"] = map[string]any{
+		"containerStatuses": []any{
+			map[string]any{"name": "user-container", "state": map[string]any{"waiting": map[string]any{"reason": "ImagePullBackOff"}}},
+		},
+	}
+	client := newFakeDynamicClient(service, pod)
+
+	cause := &report.RevisionFailure{Revision: "fn-00002", Reason: "ImagePullBackOff"}
+	err := withPodReport(context.Background(), client, &EnvConfig{FunctionName: "fn", FunctionNamespace: "myns"}, cause)
+	if !strings.Contains(err.Error(), "container user-container: waiting ImagePullBackOff") {
+		t.Errorf("Expected the pod report in the error, got %v", err)
+	}
+	var failure *report.RevisionFailure
+	if !errors.As(err, &failure) {
+		t.Error("Expected the cause to be kept")
+	}
+}
@@ -0,0 +1,206 @@
+package deployer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// The label naming the function a preview Service stands in for, and the
+// annotation telling when the reaper removes it.
+const (
+	previewOfLabel           = "kdex.dev/preview-of"
+	previewExpiresAnnotation = "kdex.dev/expires-at"
+)
+
+// defaultPreviewTTL is how long a preview lives without PREVIEW_TTL.
+const defaultPreviewTTL = 72 * time.Hour
+
+// maxPreviewName keeps the names Knative derives from a preview, such as
+// <name>-00001-private, within the 63 characters of a Kubernetes name.
+const maxPreviewName = 49
+
+var previewNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// runPreview deploys the function under a name of its own, derived from the
+// branch or the generation, for a pull request to try it out before it is
+// merged. With --reap it removes the expired previews instead.
+func runPreview(args []string) error {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	branch := flags.String("branch", "", "branch the preview is named after, overriding PREVIEW_BRANCH")
+	ttl := flags.String("ttl", "", "how long the preview lives, overriding PREVIEW_TTL")
+	reap := flags.Bool("reap", false, "remove the expired previews in FUNCTION_NAMESPACE instead, of FUNCTION_NAME only when set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *reap {
+		namespace := os.Getenv("FUNCTION_NAMESPACE")
+		if namespace == "" {
+			return fmt.Errorf("FUNCTION_NAMESPACE is required")
+		}
+		client, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
+		_, err = reapPreviews(context.Background(), client, namespace, os.Getenv("FUNCTION_NAME"), time.Now())
+		return err
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" {
+		return fmt.Errorf("FUNCTION_IMAGE or FUNCTION_SOURCE_GIT is required for preview")
+	}
+	if *branch != "" {
+		cfg.PreviewBranch = *branch
+	}
+	if *ttl != "" {
+		cfg.PreviewTTL = *ttl
+	}
+	if err := previewConfig(cfg); err != nil {
+		return err
+	}
+	logFunction(cfg)
+	logf("Previewing %s as %s\n", cfg.PreviewOf, cfg.FunctionName)
+	return runDeployment(context.Background(), &deployment{cfg: cfg})
+}
+
+// previewConfig turns cfg into that of a preview of the function. The
+// preview serves under its own name and URL; what belongs to the function
+// itself, such as its KDexFunction status, custom domain, registrations,
+// schedules and alerting, is left to the function.
+func previewConfig(cfg *EnvConfig) error {
+	if _, err := parsePreviewTTL(cfg); err != nil {
+		return err
+	}
+	suffix := cfg.PreviewBranch
+	if suffix == "" {
+		suffix = cfg.FunctionGeneration
+	}
+	if suffix == "" {
+		return fmt.Errorf("preview requires --branch, PREVIEW_BRANCH or FUNCTION_GENERATION to name it")
+	}
+	name, err := previewName(cfg.FunctionName, suffix)
+	if err != nil {
+		return err
+	}
+
+	cfg.PreviewOf = cfg.FunctionName
+	cfg.FunctionName = name
+	cfg.SkipStatusUpdate = "true"
+	for _, setting := range []*string{
+		&cfg.FunctionHost,
+		&cfg.RegistryURL,
+		&cfg.CatalogURL,
+		&cfg.ScheduleCron,
+		&cfg.ScheduleData,
+		&cfg.ScalingScheduleJSON,
+		&cfg.AlertsEnabled,
+		&cfg.SLOAvailabilityTarget,
+		&cfg.DashboardProvisioning,
+		&cfg.Traffic,
+	} {
+		*setting = ""
+	}
+	return nil
+}
+
+// previewName derives the name of a preview from the function name and a
+// branch or generation. A suffix too long to fit is shortened and keeps a
+// hash of itself, so long branch names sharing a prefix stay apart.
+func previewName(function, suffix string) (string, error) {
+	clean := strings.Trim(previewNameInvalid.ReplaceAllString(strings.ToLower(suffix), "-"), "-")
+	if clean == "" {
+		return "", fmt.Errorf("invalid preview name %q: expected letters or digits", suffix)
+	}
+	room := maxPreviewName - len(function) - 1
+	if room < 8 {
+		return "", fmt.Errorf("function name %s leaves no room for a preview name", function)
+	}
+	if len(clean) > room {
+		sum := sha256.Sum256([]byte(suffix))
+		hash := hex.EncodeToString(sum[:])[:6]
+		clean = strings.TrimRight(clean[:room-len(hash)-1], "-") + "-" + hash
+	}
+	return function + "-" + clean, nil
+}
+
+func parsePreviewTTL(cfg *EnvConfig) (time.Duration, error) {
+	if cfg.PreviewTTL == "" {
+		return defaultPreviewTTL, nil
+	}
+	ttl, err := time.ParseDuration(cfg.PreviewTTL)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid PREVIEW_TTL %q: expected a positive duration such as 72h", cfg.PreviewTTL)
+	}
+	return ttl, nil
+}
+
+// applyPreview marks the Service of a preview with the function it stands in
+// for and when it expires. Each deploy of the preview pushes the expiry
+// back.
+func applyPreview(service *unstructured.Unstructured, cfg *EnvConfig, now time.Time) error {
+	if cfg.PreviewOf == "" {
+		return nil
+	}
+	ttl, err := parsePreviewTTL(cfg)
+	if err != nil {
+		return err
+	}
+	labels := service.GetLabels()
+	labels[previewOfLabel] = cfg.PreviewOf
+	service.SetLabels(labels)
+	annotations := service.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[previewExpiresAnnotation] = now.Add(ttl).UTC().Format(time.RFC3339)
+	service.SetAnnotations(annotations)
+	return nil
+}
+
+// reapPreviews deletes the previews in the namespace that expired by now,
+// with everything deployed alongside them, and returns their names. Only
+// the previews of function are considered when it is set.
+func reapPreviews(ctx context.Context, client dynamic.Interface, namespace, function string, now time.Time) ([]string, error) {
+	selector := previewOfLabel
+	if function != "" {
+		selector += "=" + function
+	}
+	list, err := client.Resource(knativeServiceGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list previews: %w", err)
+	}
+
+	reaped := []string{}
+	for _, service := range list.Items {
+		expires, err := time.Parse(time.RFC3339, service.GetAnnotations()[previewExpiresAnnotation])
+		if err != nil {
+			warnf("preview %s has no valid %s annotation; keeping it\n", service.GetName(), previewExpiresAnnotation)
+			continue
+		}
+		if now.Before(expires) {
+			continue
+		}
+		cfg := &EnvConfig{FunctionName: service.GetName(), FunctionNamespace: namespace}
+		if _, err := deleteFunction(ctx, client, cfg); err != nil {
+			return reaped, fmt.Errorf("failed to reap preview %s: %w", service.GetName(), err)
+		}
+		logf("Reaped preview %s, expired at %s\n", service.GetName(), expires.Format(time.RFC3339))
+		reaped = append(reaped, service.GetName())
+	}
+	return reaped, nil
+}
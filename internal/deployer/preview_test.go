@@ -0,0 +1,117 @@
+package deployer
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPreviewName(t *testing.T) {
+	tests := []struct {
+		function, suffix string
+		want             string
+		wantErr          bool
+	}{
+		{function: "fn", suffix: "feature/Add-Login", want: "fn-feature-add-login"},
+		{function: "fn", suffix: "42", want: "fn-42"},
+		{function: "fn", suffix: "///", wantErr: true},
+		{function: strings.Repeat("f", 45), suffix: "main", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := previewName(tt.function, tt.suffix)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s: expected an error", tt.function, tt.suffix)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s/%s: expected %s, got %s (%v)", tt.function, tt.suffix, tt.want, got, err)
+		}
+	}
+
+	a, _ := previewName("fn", "feature/a-very-long-branch-name-for-the-first-change")
+	b, _ := previewName("fn", "feature/a-very-long-branch-name-for-the-second-change")
+	if len(a) > maxPreviewName || a == b {
+		t.Errorf("Expected long branches to be shortened apart, got %s and %s", a, b)
+	}
+}
+
+func TestPreviewConfig(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "fn",
+		FunctionGeneration: "7",
+		FunctionHost:       "fn.example.com",
+		ScheduleCron:       "@hourly",
+		RegistryURL:        "http://registry",
+	}
+	if err := previewConfig(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.FunctionName != "fn-7" || cfg.PreviewOf != "fn" {
+		t.Errorf("Expected a preview of fn named after the generation, got %s of %s", cfg.FunctionName, cfg.PreviewOf)
+	}
+	if cfg.FunctionHost != "" || cfg.ScheduleCron != "" || cfg.RegistryURL != "" || cfg.SkipStatusUpdate != "true" {
+		t.Errorf("Expected the settings of the function itself to be left out, got %+v", cfg)
+	}
+
+	if err := previewConfig(&EnvConfig{FunctionName: "fn"}); err == nil {
+		t.Error("Expected a preview without branch or generation to be rejected")
+	}
+	if err := previewConfig(&EnvConfig{FunctionName: "fn", PreviewBranch: "main", PreviewTTL: "soon"}); err == nil {
+		t.Error("Expected an invalid PREVIEW_TTL to be rejected")
+	}
+}
+
+func TestBuildServicePreview(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn-main", FunctionNamespace: "ns", FunctionImage: "img", PreviewOf: "fn", PreviewTTL: "2h"}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if service.GetLabels()[previewOfLabel] != "fn" {
+		t.Errorf("Expected the preview label, got %v", service.GetLabels())
+	}
+	expires, err := time.Parse(time.RFC3339, service.GetAnnotations()[previewExpiresAnnotation])
+	if err != nil || time.Until(expires) < time.Hour {
+		t.Errorf("Expected the preview to expire in 2h, got %v (%v)", service.GetAnnotations(), err)
+	}
+}
+
+func TestReapPreviews(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	preview := func(name, expires string) *unstructured.Unstructured {
+		obj := newObject("serving.knative.dev/v1", "Service", "ns", name, map[string]string{previewOfLabel: "fn"})
+		obj.SetAnnotations(map[string]string{previewExpiresAnnotation: expires})
+		return obj
+	}
+	client := newFakeDynamicClient(
+		preview("fn-old", "2026-10-16T11:00:00Z"),
+		preview("fn-new", "2026-10-17T11:00:00Z"),
+		newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil),
+	)
+
+	reaped, err := reapPreviews(context.Background(), client, "ns", "fn", now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(reaped, []string{"fn-old"}) {
+		t.Errorf("Expected the expired preview only, got %v", reaped)
+	}
+	services := client.Resource(knativeServiceGVR).Namespace("ns")
+	if _, err := services.Get(context.Background(), "fn-old", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the expired preview to be deleted, got %v", err)
+	}
+	for _, name := range []string{"fn-new", "fn"} {
+		if _, err := services.Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// probeFields maps the keys of PROBES to the container fields they set.
+var probeFields = map[string]string{
+	"liveness":  "livenessProbe",
+	"readiness": "readinessProbe",
+	"startup":   "startupProbe",
+}
+
+// probeHandlers are the ways a probe can check the container; a probe
+// uses exactly one of them.
+var probeHandlers = []string{"httpGet", "tcpSocket", "exec", "grpc"}
+
+// probeIntFields are the probe timing fields, all non-negative integers.
+var probeIntFields = []string{"initialDelaySeconds", "periodSeconds", "timeoutSeconds", "successThreshold", "failureThreshold", "terminationGracePeriodSeconds"}
+
+// parseProbes parses PROBES, a JSON object with optional liveness,
+// readiness and startup probes in the Kubernetes Probe format, e.g.
+// {"readiness":{"httpGet":{"path":"/ready"},"periodSeconds":5}}. A probe
+// given as a string is taken as an HTTP GET path, so {"readiness":"/ready"}
+// is the same probe. It returns the probes keyed by container field.
+func parseProbes(value string) (map[string]any, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid PROBES: %w", err)
+	}
+
+	probes := map[string]any{}
+	for kind, v := range raw {
+		field, ok := probeFields[kind]
+		if !ok {
+			return nil, fmt.Errorf("invalid PROBES: unknown probe %q: expected liveness, readiness or startup", kind)
+		}
+		probe, err := normalizeProbe(kind, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROBES %s probe: %w", kind, err)
+		}
+		probes[field] = probe
+	}
+	return probes, nil
+}
+
+func normalizeProbe(kind string, value any) (map[string]any, error) {
+	if path, ok := value.(string); ok {
+		value = map[string]any{"httpGet": map[string]any{"path": path}}
+	}
+	probe, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an object or an HTTP path")
+	}
+
+	handlers := []string{}
+	for _, h := range probeHandlers {
+		if _, ok := probe[h]; ok {
+			handlers = append(handlers, h)
+		}
+	}
+	if len(handlers) != 1 {
+		return nil, fmt.Errorf("expected exactly one of %s", strings.Join(probeHandlers, ", "))
+	}
+	handler, ok := probe[handlers[0]].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", handlers[0])
+	}
+	switch handlers[0] {
+	case "httpGet":
+		if path, _ := handler["path"].(string); path != "" && !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("httpGet path %q must start with /", path)
+		}
+	case "exec":
+		if command, _ := handler["command"].([]any); len(command) == 0 {
+			return nil, fmt.Errorf("exec needs a command")
+		}
+	}
+
+	for _, f := range probeIntFields {
+		v, ok := probe[f]
+		if !ok {
+			continue
+		}
+		n, err := probeInt(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		probe[f] = n
+	}
+	// Kubernetes only lets readiness probes require several successes
+	if threshold, ok := probe["successThreshold"].(int64); ok && kind != "readiness" && threshold > 1 {
+		return nil, fmt.Errorf("successThreshold must be 1 for a %s probe", kind)
+	}
+
+	return intJSONValues(probe).(map[string]any), nil
+}
+
+func probeInt(value any) (int64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a non-negative integer")
+	}
+	n, err := number.Int64()
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative integer")
+	}
+	return n, nil
+}
+
+// intJSONValues turns the JSON numbers left in a decoded value, such as
+// ports, into int64 like the rest of the rendered Service.
+func intJSONValues(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = intJSONValues(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = intJSONValues(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// applyProbes sets the probes configured in PROBES on the function
+// container, replacing any the runtime defaults put there.
+func applyProbes(container map[string]any, cfg *EnvConfig) error {
+	probes, err := parseProbes(cfg.Probes)
+	if err != nil {
+		return err
+	}
+	for field, probe := range probes {
+		container[field] = probe
+	}
+	return nil
+}
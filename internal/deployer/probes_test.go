@@ -0,0 +1,56 @@
+package deployer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProbes(t *testing.T) {
+	probes, err := parseProbes(`{"readiness":"/ready","liveness":{"tcpSocket":{"port":8080},"periodSeconds":10}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]any{
+		"readinessProbe": map[string]any{"httpGet": map[string]any{"path": "/ready"}},
+		"livenessProbe": map[string]any{
+			"tcpSocket":     map[string]any{"port": int64(8080)},
+			"periodSeconds": int64(10),
+		},
+	}
+	if !reflect.DeepEqual(probes, want) {
+		t.Errorf("Expected %v, got %v", want, probes)
+	}
+
+	for _, value := range []string{
+		`not json`,
+		`{"warmup":"/ready"}`,
+		`{"readiness":{}}`,
+		`{"readiness":{"httpGet":{"path":"/ready"},"tcpSocket":{"port":8080}}}`,
+		`{"readiness":"ready"}`,
+		`{"liveness":{"exec":{"command":[]}}}`,
+		`{"startup":{"httpGet":{},"periodSeconds":-1}}`,
+		`{"liveness":{"httpGet":{},"successThreshold":2}}`,
+	} {
+		if _, err := parseProbes(value); err == nil {
+			t.Errorf("Expected error for %s", value)
+		}
+	}
+}
+
+func TestApplyProbesOverridesRuntimeDefaults(t *testing.T) {
+	container := map[string]any{}
+	if err := applyRuntimeDefaults(container, "java"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := applyProbes(container, &EnvConfig{Probes: `{"readiness":"/ready"}`}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	readiness := container["readinessProbe"].(map[string]any)
+	if path := readiness["httpGet"].(map[string]any)["path"]; path != "/ready" {
+		t.Errorf("Expected readiness path /ready, got %v", path)
+	}
+	if _, ok := container["startupProbe"]; !ok {
+		t.Error("Expected the runtime startup probe to remain")
+	}
+}
@@ -0,0 +1,148 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+// candidateTag exposes the new revision under its own URL during a
+// progressive rollout so it can be health checked before taking traffic.
+const candidateTag = "candidate"
+
+var (
+	defaultProgressiveSteps    = []int64{10, 25, 50, 100}
+	defaultProgressiveInterval = time.Minute
+)
+
+// progressivePlan is the validated PROGRESSIVE_* configuration.
+type progressivePlan struct {
+	Steps      []int64
+	Interval   time.Duration
+	HealthPath string
+}
+
+func parseProgressivePlan(cfg *EnvConfig) (*progressivePlan, error) {
+	plan := &progressivePlan{
+		Steps:      defaultProgressiveSteps,
+		Interval:   defaultProgressiveInterval,
+		HealthPath: cfg.ProgressiveHealthPath,
+	}
+
+	if cfg.ProgressiveSteps != "" {
+		plan.Steps = []int64{}
+		var last int64
+		for step := range strings.SplitSeq(cfg.ProgressiveSteps, ",") {
+			p, err := strconv.ParseInt(strings.TrimSpace(step), 10, 64)
+			if err != nil || p <= last || p > 100 {
+				return nil, fmt.Errorf("invalid PROGRESSIVE_STEPS %q: expected increasing percentages up to 100", cfg.ProgressiveSteps)
+			}
+			plan.Steps = append(plan.Steps, p)
+			last = p
+		}
+		if last != 100 {
+			return nil, fmt.Errorf("invalid PROGRESSIVE_STEPS %q: the last step must be 100", cfg.ProgressiveSteps)
+		}
+	}
+
+	if cfg.ProgressiveInterval != "" {
+		interval, err := time.ParseDuration(cfg.ProgressiveInterval)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid PROGRESSIVE_INTERVAL %q", cfg.ProgressiveInterval)
+		}
+		plan.Interval = interval
+	}
+
+	return plan, nil
+}
+
+// progressiveTraffic is the TRAFFIC value for a step of the rollout:
+// percent goes to the candidate revision and the rest stays on the
+// revision that was serving before the deploy.
+func progressiveTraffic(candidate string, percent int64) string {
+	if percent >= 100 {
+		return "latest=100"
+	}
+	return fmt.Sprintf("current=%d,%s@%s=%d", 100-percent, candidate, candidateTag, percent)
+}
+
+// shiftTraffic moves traffic from the revision serving before the deploy
+// to the candidate in the steps of plan, verifying the candidate between
+// steps. It returns the URL of the Service once all traffic reached it.
+func shiftTraffic(ctx context.Context, resourceClient dynamic.ResourceInterface, cfg *EnvConfig, state serviceState, plan *progressivePlan, candidate string) (string, error) {
+	var url string
+	for _, percent := range plan.Steps {
+		logf("Shifting %d%% of traffic to %s\n", percent, candidate)
+		cfg.Traffic = progressiveTraffic(candidate, percent)
+		var err error
+		// The candidate is ready; only its route changes
+		url, err = applyAndWait(ctx, resourceClient, cfg, state, nil)
+		if err != nil {
+			return "", err
+		}
+		if percent == 100 {
+			break
+		}
+
+		if err := verifyCandidate(ctx, resourceClient, cfg, plan); err != nil {
+			return "", err
+		}
+	}
+
+	logf("Progressive rollout of %s complete\n", candidate)
+	return url, nil
+}
+
+// verifyCandidate waits for the verification window, then checks that the
+// service is still ready and, when PROGRESSIVE_HEALTH_PATH is set, that
+// the candidate answers its health check.
+func verifyCandidate(ctx context.Context, resourceClient dynamic.ResourceInterface, cfg *EnvConfig, plan *progressivePlan) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(plan.Interval):
+	}
+
+	service, err := resourceClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if ready, msg, _ := parseKnativeStatus(service); !ready {
+		return fmt.Errorf("service became unready: %s", msg)
+	}
+
+	if plan.HealthPath == "" {
+		return nil
+	}
+	candidateURL := taggedURLs(service)[candidateTag]
+	if candidateURL == "" {
+		return fmt.Errorf("no URL for the %s tag", candidateTag)
+	}
+	status, err := sendJSON(ctx, http.MethodGet, candidateURL+plan.HealthPath, "", nil)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("health check returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
+
+// abortRollout pins all traffic back to the revision that was serving before
+// the deploy and returns the error that caused it.
+func abortRollout(ctx context.Context, resourceClient dynamic.ResourceInterface, cfg *EnvConfig, state serviceState, cause error) error {
+	logf("Rolling back to %s: %v\n", state.LatestReadyRevision, cause)
+	// Only the route matters here: a broken candidate keeps the service
+	// from becoming ready even once it no longer takes traffic
+	cfg.Traffic = "current=100"
+	if err := applyService(ctx, resourceClient, cfg, state); err != nil {
+		return fmt.Errorf("progressive rollout failed: %w; rollback to %s failed: %w", cause, state.LatestReadyRevision, err)
+	}
+	return fmt.Errorf("progressive rollout failed, rolled back to %s: %w", state.LatestReadyRevision, cause)
+}
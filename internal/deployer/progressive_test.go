@@ -0,0 +1,56 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProgressivePlan(t *testing.T) {
+	plan, err := parseProgressivePlan(&EnvConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(plan.Steps) != 4 || plan.Interval != time.Minute {
+		t.Errorf("Expected default plan, got %+v", plan)
+	}
+
+	plan, err = parseProgressivePlan(&EnvConfig{
+		ProgressiveSteps:    "20, 60,100",
+		ProgressiveInterval: "30s",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(plan.Steps) != 3 || plan.Steps[1] != 60 || plan.Interval != 30*time.Second {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{ProgressiveSteps: "50,25,100"},
+		{ProgressiveSteps: "10,50"},
+		{ProgressiveSteps: "10,x,100"},
+		{ProgressiveSteps: "0,100"},
+		{ProgressiveInterval: "soon"},
+	} {
+		if _, err := parseProgressivePlan(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestProgressiveTraffic(t *testing.T) {
+	targets, err := parseTraffic(progressiveTraffic("myfunc-00002", 25))
+	if err != nil {
+		t.Fatalf("Expected valid TRAFFIC, got error: %v", err)
+	}
+	if targets[0] != (trafficTarget{Target: trafficCurrent, Percent: 75}) {
+		t.Errorf("Unexpected current target: %+v", targets[0])
+	}
+	if targets[1] != (trafficTarget{Target: "myfunc-00002", Tag: candidateTag, Percent: 25}) {
+		t.Errorf("Unexpected candidate target: %+v", targets[1])
+	}
+
+	if got := progressiveTraffic("myfunc-00002", 100); got != "latest=100" {
+		t.Errorf("Expected final step to route to latest, got %s", got)
+	}
+}
@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// deployQuotaConfigMapName holds the recent deploys of each tenant, one
+// tenantKeyPrefix key per tenant listing deployID@time entries.
+const deployQuotaConfigMapName = "kdex-deploy-quota"
+
+const tenantKeyPrefix = "tenant."
+
+var errDeployThrottled = errors.New("deploy quota exceeded")
+
+// deployThrottled tells whether err is the rejection of a deploy over the
+// tenant quota.
+func deployThrottled(err error) bool {
+	return errors.Is(err, errDeployThrottled)
+}
+
+// deployQuota limits how many deploys a tenant may start within Window. A
+// zero Limit disables it.
+type deployQuota struct {
+	Limit  int
+	Window time.Duration
+}
+
+// parseDeployQuota reads DEPLOY_QUOTA as count/window, such as 20/1h.
+func parseDeployQuota(cfg *EnvConfig) (deployQuota, error) {
+	if cfg.DeployQuota == "" {
+		return deployQuota{}, nil
+	}
+	count, window, ok := strings.Cut(cfg.DeployQuota, "/")
+	limit, err := strconv.Atoi(count)
+	if !ok || err != nil || limit <= 0 {
+		return deployQuota{}, fmt.Errorf("invalid DEPLOY_QUOTA %q: expected count/window, such as 20/1h", cfg.DeployQuota)
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return deployQuota{}, fmt.Errorf("invalid DEPLOY_QUOTA %q: expected count/window, such as 20/1h", cfg.DeployQuota)
+	}
+	return deployQuota{Limit: limit, Window: duration}, nil
+}
+
+// deployTenant is who a deploy is charged to: the value of the
+// DEPLOY_QUOTA_TENANT_LABEL label of the function, or its namespace.
+func deployTenant(cfg *EnvConfig, function *unstructured.Unstructured) (string, error) {
+	if cfg.DeployQuotaTenantLabel == "" {
+		return cfg.FunctionNamespace, nil
+	}
+	if function == nil || function.GetLabels()[cfg.DeployQuotaTenantLabel] == "" {
+		return "", fmt.Errorf("kdex function %s has no %s label naming its tenant", cfg.FunctionName, cfg.DeployQuotaTenantLabel)
+	}
+	return function.GetLabels()[cfg.DeployQuotaTenantLabel], nil
+}
+
+// chargeTenant charges the deploy to the quota of its tenant.
+func chargeTenant(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, quota deployQuota) error {
+	var function *unstructured.Unstructured
+	if cfg.DeployQuotaTenantLabel != "" {
+		var err error
+		function, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get kdex function: %w", err)
+		}
+	}
+	tenant, err := deployTenant(cfg, function)
+	if err != nil {
+		return err
+	}
+	return chargeDeployQuota(ctx, client, cfg, quota, tenant)
+}
+
+// chargeDeploy drops the entries older than the quota window and records
+// the deploy, unless the tenant used up its quota. A deploy already
+// recorded, by an earlier attempt of the same Job, is not charged again.
+func chargeDeploy(entries []string, deployID string, now time.Time, quota deployQuota) ([]string, bool) {
+	recent := []string{}
+	charged := false
+	for _, entry := range entries {
+		id, at, _ := strings.Cut(entry, "@")
+		started, err := time.Parse(time.RFC3339, at)
+		if err != nil || !started.After(now.Add(-quota.Window)) {
+			continue
+		}
+		charged = charged || id == deployID
+		recent = append(recent, entry)
+	}
+	if charged {
+		return recent, true
+	}
+	if len(recent) >= quota.Limit {
+		return recent, false
+	}
+	return append(recent, deployID+"@"+now.UTC().Format(time.RFC3339)), true
+}
+
+// chargeDeployQuota charges the deploy to the tenant's quota, kept in a
+// ConfigMap updated with optimistic concurrency. Tenants spanning
+// namespaces need DEPLOY_QUOTA_NAMESPACE to share one.
+func chargeDeployQuota(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, quota deployQuota, tenant string) error {
+	namespace := cfg.DeployQuotaNamespace
+	if namespace == "" {
+		namespace = cfg.FunctionNamespace
+	}
+	configMaps := client.Resource(configMapGVR).Namespace(namespace)
+	key := tenantKeyPrefix + tenant
+
+	for {
+		configMap, err := configMaps.Get(ctx, deployQuotaConfigMapName, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			configMap = &unstructured.Unstructured{
+				Object: map[string]any{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata": map[string]any{
+						"name":      deployQuotaConfigMapName,
+						"namespace": namespace,
+					},
+				},
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get deploy quota: %w", err)
+		}
+
+		data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+		if data == nil {
+			data = map[string]string{}
+		}
+		entries, ok := chargeDeploy(strings.Fields(data[key]), cfg.DeployID, time.Now(), quota)
+		if !ok {
+			return fmt.Errorf("%w: tenant %s started %d deploys in the last %s", errDeployThrottled, tenant, len(entries), quota.Window)
+		}
+		data[key] = strings.Join(entries, " ")
+		if err := unstructured.SetNestedStringMap(configMap.Object, data, "data"); err != nil {
+			return err
+		}
+
+		if create {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{FieldManager: "kdex-knative-deployer"})
+		} else {
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{FieldManager: "kdex-knative-deployer"})
+		}
+		switch {
+		case err == nil:
+			logf("Charged deploy to tenant %s (%d of %d in %s)\n", tenant, len(entries), quota.Limit, quota.Window)
+			return nil
+		case apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err):
+			// Another Job charged its deploy first; count again
+			continue
+		default:
+			return fmt.Errorf("failed to update deploy quota: %w", err)
+		}
+	}
+}
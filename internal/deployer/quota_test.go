@@ -0,0 +1,101 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseDeployQuota(t *testing.T) {
+	quota, err := parseDeployQuota(&EnvConfig{})
+	if err != nil || quota.Limit != 0 {
+		t.Errorf("Expected disabled quota, got %+v %v", quota, err)
+	}
+
+	quota, err = parseDeployQuota(&EnvConfig{DeployQuota: "20/1h"})
+	if err != nil || quota.Limit != 20 || quota.Window != time.Hour {
+		t.Errorf("Expected 20 deploys per hour, got %+v %v", quota, err)
+	}
+
+	for _, value := range []string{"20", "0/1h", "many/1h", "20/-1h", "20/hour"} {
+		if _, err := parseDeployQuota(&EnvConfig{DeployQuota: value}); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestChargeDeploy(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	quota := deployQuota{Limit: 2, Window: time.Hour}
+	entries := []string{
+		"old@" + now.Add(-2*time.Hour).Format(time.RFC3339),
+		"a@" + now.Add(-10*time.Minute).Format(time.RFC3339),
+	}
+
+	entries, ok := chargeDeploy(entries, "b", now, quota)
+	if !ok || len(entries) != 2 || !strings.HasPrefix(entries[1], "b@") {
+		t.Fatalf("Expected b charged and the old deploy dropped, got %v %v", entries, ok)
+	}
+
+	if _, ok := chargeDeploy(entries, "c", now, quota); ok {
+		t.Errorf("Expected c to be rejected over the quota")
+	}
+
+	// A retried Job is not charged twice
+	if again, ok := chargeDeploy(entries, "b", now, quota); !ok || len(again) != 2 {
+		t.Errorf("Expected b to be charged once, got %v %v", again, ok)
+	}
+
+	if _, ok := chargeDeploy(entries, "c", now.Add(time.Hour), quota); !ok {
+		t.Errorf("Expected c to be admitted once the window passed")
+	}
+}
+
+func TestChargeDeployQuota(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient()
+	quota := deployQuota{Limit: 1, Window: time.Hour}
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", DeployID: "a"}
+
+	if err := chargeDeployQuota(ctx, client, cfg, quota, "team-a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg.DeployID = "b"
+	if err := chargeDeployQuota(ctx, client, cfg, quota, "team-a"); !deployThrottled(err) {
+		t.Errorf("Expected deploy b to be throttled, got %v", err)
+	}
+	if err := chargeDeployQuota(ctx, client, cfg, quota, "team-b"); err != nil {
+		t.Errorf("Expected other tenants unaffected, got %v", err)
+	}
+
+	configMap, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, deployQuotaConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the quota ConfigMap, got %v", err)
+	}
+	data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+	if !strings.HasPrefix(data["tenant.team-a"], "a@") || !strings.HasPrefix(data["tenant.team-b"], "b@") {
+		t.Errorf("Unexpected quota data: %v", data)
+	}
+}
+
+func TestDeployTenant(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+	if tenant, err := deployTenant(cfg, nil); err != nil || tenant != "default" {
+		t.Errorf("Expected the namespace as tenant, got %q %v", tenant, err)
+	}
+
+	cfg.DeployQuotaTenantLabel = "kdex.dev/tenant"
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "default", "fn", nil)
+	if _, err := deployTenant(cfg, function); err == nil {
+		t.Errorf("Expected error for a function without the tenant label")
+	}
+	function.SetLabels(map[string]string{"kdex.dev/tenant": "team-a"})
+	if tenant, err := deployTenant(cfg, function); err != nil || tenant != "team-a" {
+		t.Errorf("Expected the labelled tenant, got %q %v", tenant, err)
+	}
+}
@@ -0,0 +1,119 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// readinessHistorySize is how many of the latest times to ready the
+	// status.readiness block of a KDexFunction keeps.
+	readinessHistorySize = 10
+	// minReadinessSamples is how many times to ready it takes before a
+	// regression is flagged, so a few slow first deploys are not.
+	minReadinessSamples = 3
+	// defaultReadinessRegressionFactor is the multiple of the median time
+	// to ready past which a deploy is flagged.
+	defaultReadinessRegressionFactor = 2.0
+)
+
+// parseReadinessRegressionFactor parses READINESS_REGRESSION_FACTOR. Zero
+// keeps the history without flagging regressions.
+func parseReadinessRegressionFactor(cfg *EnvConfig) (float64, error) {
+	if cfg.ReadinessRegressionFactor == "" {
+		return defaultReadinessRegressionFactor, nil
+	}
+	factor, err := strconv.ParseFloat(cfg.ReadinessRegressionFactor, 64)
+	if err != nil || (factor != 0 && factor <= 1) {
+		return 0, fmt.Errorf("invalid READINESS_REGRESSION_FACTOR %q: expected a multiple greater than 1, or 0", cfg.ReadinessRegressionFactor)
+	}
+	return factor, nil
+}
+
+// recordReadiness adds the time to ready of the deploy to the history in
+// the KDexFunction status, flagging it when it is more than factor times
+// the median of the history before it. It returns why the deploy was
+// flagged, empty when it was not.
+func recordReadiness(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, duration time.Duration, factor float64) (string, error) {
+	functions := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace)
+	function, err := functions.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get kdex function: %w", err)
+	}
+
+	history := readinessHistory(function)
+	median := medianMillis(history)
+	last := duration.Milliseconds()
+	regression := ""
+	if factor > 0 && len(history) >= minReadinessSamples && float64(last) > factor*float64(median) {
+		regression = fmt.Sprintf("ready in %s, more than %g times the median of %s over the last %d deploys",
+			duration.Round(time.Millisecond), factor, time.Duration(median)*time.Millisecond, len(history))
+	}
+
+	history = append(history, last)
+	if len(history) > readinessHistorySize {
+		history = history[len(history)-readinessHistorySize:]
+	}
+	samples := make([]any, len(history))
+	for i, ms := range history {
+		samples[i] = ms
+	}
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"readiness": map[string]any{
+				"historyMillis": samples,
+				"lastMillis":    last,
+				"medianMillis":  medianMillis(history),
+				"regressed":     regression != "",
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = functions.Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	if err != nil {
+		return "", fmt.Errorf("failed to patch kdex function status: %w", err)
+	}
+	return regression, nil
+}
+
+// readinessHistory reads the times to ready recorded in the status, in
+// milliseconds, oldest first.
+func readinessHistory(function *unstructured.Unstructured) []int64 {
+	samples, _, _ := unstructured.NestedSlice(function.Object, "status", "readiness", "historyMillis")
+	history := []int64{}
+	for _, sample := range samples {
+		switch ms := sample.(type) {
+		case int64:
+			history = append(history, ms)
+		case float64:
+			history = append(history, int64(ms))
+		}
+	}
+	return history
+}
+
+func medianMillis(history []int64) int64 {
+	if len(history) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(history)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,71 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseReadinessRegressionFactor(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"", defaultReadinessRegressionFactor, false},
+		{"0", 0, false},
+		{"1.5", 1.5, false},
+		{"1", 0, true},
+		{"-2", 0, true},
+		{"twice", 0, true},
+	} {
+		got, err := parseReadinessRegressionFactor(&EnvConfig{ReadinessRegressionFactor: tt.value})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("READINESS_REGRESSION_FACTOR=%q: expected %v (error %v), got %v (%v)", tt.value, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestRecordReadiness(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil))
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+
+	// Too few deploys to tell a regression
+	for _, seconds := range []time.Duration{10, 12, 40} {
+		regression, err := recordReadiness(ctx, client, cfg, seconds*time.Second, 2)
+		if err != nil || regression != "" {
+			t.Fatalf("Expected no regression yet, got %q (%v)", regression, err)
+		}
+	}
+
+	regression, err := recordReadiness(ctx, client, cfg, 11*time.Second, 2)
+	if err != nil || regression != "" {
+		t.Fatalf("Expected no regression within the factor, got %q (%v)", regression, err)
+	}
+	regression, err = recordReadiness(ctx, client, cfg, 30*time.Second, 2)
+	if err != nil || !strings.Contains(regression, "median of 11.5s") {
+		t.Fatalf("Expected a regression against the median of 11.5s, got %q (%v)", regression, err)
+	}
+
+	function, _ := client.Resource(kdexFunctionGVR).Namespace("ns").Get(ctx, "fn", metav1.GetOptions{})
+	if got := readinessHistory(function); len(got) != 5 || got[4] != 30000 {
+		t.Errorf("Unexpected history %v", got)
+	}
+	if regressed, _, _ := unstructured.NestedBool(function.Object, "status", "readiness", "regressed"); !regressed {
+		t.Error("Expected the status to flag the regression")
+	}
+}
+
+func TestMedianMillis(t *testing.T) {
+	if got := medianMillis([]int64{30, 10, 20}); got != 20 {
+		t.Errorf("Expected 20, got %d", got)
+	}
+	if got := medianMillis([]int64{40, 10, 20, 30}); got != 25 {
+		t.Errorf("Expected 25, got %d", got)
+	}
+}
@@ -0,0 +1,129 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// readOnlyClient is a dynamic client for READ_ONLY=true: reads go to the
+// cluster, writes are logged with what they would have sent and dropped.
+// Writes answer with the object as the caller meant it to be, so the
+// command carries on as far as it can without the cluster reacting.
+type readOnlyClient struct {
+	dynamic.Interface
+}
+
+func (c readOnlyClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+	return readOnlyNamespaceableResource{
+		readOnlyResource: readOnlyResource{ResourceInterface: resource, gvr: gvr},
+		namespaceable:    resource,
+	}
+}
+
+type readOnlyNamespaceableResource struct {
+	readOnlyResource
+	namespaceable dynamic.NamespaceableResourceInterface
+}
+
+func (r readOnlyNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return readOnlyResource{ResourceInterface: r.namespaceable.Namespace(namespace), gvr: r.gvr, namespace: namespace}
+}
+
+type readOnlyResource struct {
+	dynamic.ResourceInterface
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+// logWrite logs the write that was not made, with its payload as YAML.
+func (r readOnlyResource) logWrite(verb, name string, payload []byte, subresources ...string) {
+	target := r.gvr.Resource
+	if len(subresources) > 0 {
+		target += "/" + strings.Join(subresources, "/")
+	}
+	if r.namespace != "" {
+		name = r.namespace + "/" + name
+	}
+	if payload == nil {
+		logf("Read-only: would have %s %s %s\n", verb, target, name)
+		return
+	}
+	if rendered, err := yaml.JSONToYAML(payload); err == nil {
+		payload = rendered
+	}
+	logf("Read-only: would have %s %s %s:\n%s", verb, target, name, payload)
+}
+
+func (r readOnlyResource) logObject(verb string, obj *unstructured.Unstructured, subresources ...string) *unstructured.Unstructured {
+	payload, err := json.Marshal(obj)
+	if err != nil {
+		payload = fmt.Appendf(nil, "%v", obj.Object)
+	}
+	r.logWrite(verb, obj.GetName(), payload, subresources...)
+	return obj.DeepCopy()
+}
+
+func (r readOnlyResource) Create(_ context.Context, obj *unstructured.Unstructured, _ metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return r.logObject("created", obj, subresources...), nil
+}
+
+func (r readOnlyResource) Update(_ context.Context, obj *unstructured.Unstructured, _ metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return r.logObject("updated", obj, subresources...), nil
+}
+
+func (r readOnlyResource) UpdateStatus(_ context.Context, obj *unstructured.Unstructured, _ metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return r.logObject("updated", obj, "status"), nil
+}
+
+func (r readOnlyResource) Delete(_ context.Context, name string, _ metav1.DeleteOptions, subresources ...string) error {
+	r.logWrite("deleted", name, nil, subresources...)
+	return nil
+}
+
+func (r readOnlyResource) DeleteCollection(_ context.Context, _ metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	r.logWrite("deleted", "all matching "+listOptions.LabelSelector, nil)
+	return nil
+}
+
+// Patch answers with the object as it is; an apply of an object that does
+// not exist yet answers with the applied object. Server dry runs write
+// nothing, so they still go to the cluster.
+func (r readOnlyResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(options.DryRun) > 0 {
+		return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+	}
+	verb := "patched"
+	if pt == types.ApplyPatchType {
+		verb = "applied"
+	}
+	r.logWrite(verb, name, data, subresources...)
+
+	current, err := r.ResourceInterface.Get(ctx, name, metav1.GetOptions{}, subresources...)
+	if err == nil || pt != types.ApplyPatchType || !errors.IsNotFound(err) {
+		return current, err
+	}
+	applied := &unstructured.Unstructured{}
+	if err := applied.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func (r readOnlyResource) Apply(_ context.Context, _ string, obj *unstructured.Unstructured, _ metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return r.logObject("applied", obj, subresources...), nil
+}
+
+func (r readOnlyResource) ApplyStatus(_ context.Context, _ string, obj *unstructured.Unstructured, _ metav1.ApplyOptions) (*unstructured.Unstructured, error) {
+	return r.logObject("applied", obj, "status"), nil
+}
@@ -0,0 +1,50 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReadOnlyClient(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDynamicClient(newObject("v1", "ConfigMap", "default", "cm", map[string]string{"team": "a"}))
+	configMaps := readOnlyClient{fake}.Resource(configMapGVR).Namespace("default")
+
+	patched, err := configMaps.Patch(ctx, "cm", types.MergePatchType, []byte(`{"metadata":{"labels":{"team":"b"}}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if patched.GetLabels()["team"] != "a" {
+		t.Errorf("Expected the patch to be dropped, got %v", patched.GetLabels())
+	}
+
+	applied, err := configMaps.Patch(ctx, "new", types.ApplyPatchType, []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"new"}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if applied.GetName() != "new" {
+		t.Errorf("Expected the applied object back, got %v", applied.Object)
+	}
+
+	if err := configMaps.Delete(ctx, "cm", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := configMaps.Create(ctx, newObject("v1", "ConfigMap", "default", "created", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, a := range fake.Actions() {
+		switch a.GetVerb() {
+		case "get", "list", "watch":
+		default:
+			t.Errorf("Expected no writes, got %s", a.GetVerb())
+		}
+	}
+	if _, err := fake.Resource(configMapGVR).Namespace("default").Get(ctx, "new", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the applied config map not to exist, got %v", err)
+	}
+}
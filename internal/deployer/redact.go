@@ -0,0 +1,158 @@
+package deployer
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultRedactPatterns are the fragments of env var names whose values
+// are masked in the output when REDACT_PATTERNS is not set.
+const defaultRedactPatterns = "TOKEN,SECRET,PASSWORD,KEY"
+
+// redactedValue replaces a masked value.
+const redactedValue = "***"
+
+// minRedactedLength is the length under which values are not masked where
+// they appear in text, as short ones such as true or 1 would mask
+// everything else equal to them.
+const minRedactedLength = 6
+
+// redactor masks secrets in what the deployer outputs: the values of the
+// env vars whose names contain one of its patterns, wherever they appear,
+// and the value of any env var entry so named in the objects it prints.
+type redactor struct {
+	patterns []string
+	values   []string
+}
+
+// newRedactor masks the env vars of environ, as KEY=value pairs, whose
+// names contain one of the comma separated patterns, regardless of case,
+// and the secrets given whatever their names.
+func newRedactor(patterns string, environ []string, secrets ...string) *redactor {
+	if strings.TrimSpace(patterns) == "" {
+		patterns = defaultRedactPatterns
+	}
+	r := &redactor{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern = strings.ToUpper(strings.TrimSpace(pattern)); pattern != "" {
+			r.patterns = append(r.patterns, pattern)
+		}
+	}
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if r.secretName(name) && len(value) >= minRedactedLength {
+			r.values = append(r.values, value)
+		}
+	}
+	for _, secret := range secrets {
+		if len(secret) >= minRedactedLength {
+			r.values = append(r.values, secret)
+		}
+	}
+	// Longest first, so a secret containing another is masked whole
+	slices.SortFunc(r.values, func(a, b string) int { return len(b) - len(a) })
+	return r
+}
+
+// redaction is the redactor of the process, see outputRedactor.
+var redaction atomic.Pointer[redactor]
+
+// outputRedactor is the redactor of the process: set up from
+// REDACT_PATTERNS and its env on first use, until redactConfig replaces
+// it with the configuration a command resolved.
+func outputRedactor() *redactor {
+	if r := redaction.Load(); r != nil {
+		return r
+	}
+	redaction.CompareAndSwap(nil, newRedactor(os.Getenv("REDACT_PATTERNS"), os.Environ()))
+	return redaction.Load()
+}
+
+// redactConfig masks the secrets of cfg in the output from now on, on top
+// of those of the process env: its settings and the spec.env values of
+// its function whose names match REDACT_PATTERNS, and its tokens whatever
+// their names. Processes working on several functions load the config of
+// each in turn, so the masks follow the one being worked on.
+func redactConfig(cfg *EnvConfig) {
+	environ := os.Environ()
+	config := reflect.ValueOf(cfg).Elem()
+	for i, field := range reflect.VisibleFields(config.Type()) {
+		if name := field.Tag.Get("env"); name != "" {
+			environ = append(environ, name+"="+config.Field(i).String())
+		}
+	}
+	for name, value := range cfg.FunctionEnv {
+		environ = append(environ, name+"="+value)
+	}
+	redaction.Store(newRedactor(cfg.RedactPatterns, environ, cfg.CatalogToken, cfg.GrafanaToken, cfg.RegistryToken))
+}
+
+// secretName tells whether an env var so named holds a secret.
+func (r *redactor) secretName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range r.patterns {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// String masks the secret values in s.
+func (r *redactor) String(s string) string {
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, redactedValue)
+	}
+	return s
+}
+
+// Object returns a copy of the decoded JSON value v with its secrets
+// masked: the value of env var entries with a secret name, and secret
+// values in any string.
+func (r *redactor) Object(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(v))
+		for key, value := range v {
+			masked[key] = r.Object(value)
+		}
+		if name, ok := v["name"].(string); ok && r.secretName(name) {
+			if _, ok := v["value"]; ok {
+				masked["value"] = redactedValue
+			}
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, value := range v {
+			masked[i] = r.Object(value)
+		}
+		return masked
+	case string:
+		return r.String(v)
+	default:
+		return v
+	}
+}
+
+// Writer masks the secret values in what is written to w. Each write is
+// masked on its own, so a secret split across writes is not.
+func (r *redactor) Writer(w io.Writer) io.Writer {
+	return redactingWriter{r: r, w: w}
+}
+
+type redactingWriter struct {
+	r *redactor
+	w io.Writer
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,80 @@
+package deployer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor("", []string{
+		"CATALOG_TOKEN=s3cr3t-catalog",
+		"DB_PASSWORD=hunter2hunter2",
+		"API_KEY=short",
+		"FUNCTION_NAME=s3cr3t-catalog-fn",
+		"FUNCTION_IMAGE=registry.example.com/fn:v1",
+	})
+
+	if got := r.String("calling https://catalog?token=s3cr3t-catalog as hunter2hunter2"); got != "calling https://catalog?token=*** as ***" {
+		t.Errorf("Unexpected redaction: %q", got)
+	}
+	if got := r.String("deploying registry.example.com/fn:v1 with short"); got != "deploying registry.example.com/fn:v1 with short" {
+		t.Errorf("Expected values of other env vars and short ones to be kept, got %q", got)
+	}
+
+	service := map[string]any{
+		"metadata": map[string]any{"name": "fn"},
+		"spec": map[string]any{"containers": []any{map[string]any{
+			"env": []any{
+				map[string]any{"name": "STRIPE_SECRET", "value": "sk_live_123456"},
+				map[string]any{"name": "GREETING", "value": "hello"},
+				map[string]any{"name": "OAUTH_TOKEN", "valueFrom": map[string]any{"secretKeyRef": map[string]any{"name": "oauth"}}},
+			},
+		}}},
+	}
+	want := map[string]any{
+		"metadata": map[string]any{"name": "fn"},
+		"spec": map[string]any{"containers": []any{map[string]any{
+			"env": []any{
+				map[string]any{"name": "STRIPE_SECRET", "value": "***"},
+				map[string]any{"name": "GREETING", "value": "hello"},
+				map[string]any{"name": "OAUTH_TOKEN", "valueFrom": map[string]any{"secretKeyRef": map[string]any{"name": "oauth"}}},
+			},
+		}}},
+	}
+	if got := r.Object(service); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected redacted object: %v", got)
+	}
+	if service["spec"].(map[string]any)["containers"].([]any)[0].(map[string]any)["env"].([]any)[0].(map[string]any)["value"] != "sk_live_123456" {
+		t.Error("Expected the object to be left as it was")
+	}
+
+	var out bytes.Buffer
+	if _, err := r.Writer(&out).Write([]byte("token s3cr3t-catalog\n")); err != nil || out.String() != "token ***\n" {
+		t.Errorf("Unexpected output %q (%v)", out.String(), err)
+	}
+}
+
+func TestRedactorPatterns(t *testing.T) {
+	r := newRedactor(" credential ,", []string{"DB_CREDENTIAL=abcdefgh", "CATALOG_TOKEN=s3cr3t-catalog"})
+	if got := r.String("abcdefgh s3cr3t-catalog"); got != "*** s3cr3t-catalog" {
+		t.Errorf("Expected only REDACT_PATTERNS to be masked, got %q", got)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	t.Cleanup(func() { redaction.Store(nil) })
+	function := newKDexFunction(1, map[string]any{
+		"image": "myimg",
+		"env": []any{
+			map[string]any{"name": "STRIPE_SECRET", "value": "sk_live_123456"},
+			map[string]any{"name": "GREETING", "value": "hello world"},
+		},
+	})
+	if _, err := kdexFunctionConfig(function, &EnvConfig{RedactPatterns: "SECRET", RegistryToken: "registry-pass"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := outputRedactor().String("pushing with registry-pass, calling with sk_live_123456: hello world"); got != "pushing with ***, calling with ***: hello world" {
+		t.Errorf("Expected the spec.env secret and the token of the config to be masked, got %q", got)
+	}
+}
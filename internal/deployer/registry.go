@@ -0,0 +1,77 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// registryEntry is what the KDex registry service knows about a function.
+// The platform's API gateway discovers functions from it.
+type registryEntry struct {
+	Name       string        `json:"name"`
+	Namespace  string        `json:"namespace"`
+	URL        string        `json:"url"`
+	BasePath   string        `json:"basePath,omitempty"`
+	Generation string        `json:"generation,omitempty"`
+	Auth       *registryAuth `json:"auth,omitempty"`
+}
+
+type registryAuth struct {
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	JWKSURL  string `json:"jwksUrl,omitempty"`
+}
+
+func registryFunctionURL(cfg *EnvConfig) string {
+	return fmt.Sprintf("%s/functions/%s/%s", cfg.RegistryURL, url.PathEscape(cfg.FunctionNamespace), url.PathEscape(cfg.FunctionName))
+}
+
+// registerFunction records the deployed function with the registry
+// service. Registration is idempotent so redeploys just refresh the entry.
+func registerFunction(ctx context.Context, cfg *EnvConfig, functionURL string) error {
+	entry := registryEntry{
+		Name:       cfg.FunctionName,
+		Namespace:  cfg.FunctionNamespace,
+		URL:        functionURL,
+		BasePath:   cfg.FunctionBasePath,
+		Generation: cfg.FunctionGeneration,
+	}
+	if cfg.Issuer != "" || cfg.Audience != "" || cfg.JWKSURL != "" {
+		entry.Auth = &registryAuth{
+			Issuer:   cfg.Issuer,
+			Audience: cfg.Audience,
+			JWKSURL:  cfg.JWKSURL,
+		}
+	}
+
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return registryRequest(ctx, cfg, http.MethodPut, body)
+}
+
+// deregisterFunction removes the function from the registry service. A
+// function that was never registered is not an error.
+func deregisterFunction(ctx context.Context, cfg *EnvConfig) error {
+	return registryRequest(ctx, cfg, http.MethodDelete, nil)
+}
+
+func registryRequest(ctx context.Context, cfg *EnvConfig, method string, body []byte) error {
+	status, err := sendJSON(ctx, method, registryFunctionURL(cfg), cfg.RegistryToken, body)
+	if err != nil {
+		return fmt.Errorf("registry request failed: %w", err)
+	}
+
+	if status == http.StatusNotFound && method == http.MethodDelete {
+		return nil
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("registry returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
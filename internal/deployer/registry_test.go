@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterFunction(t *testing.T) {
+	var method, path, auth string
+	var entry registryEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		if r.Method == http.MethodPut {
+			_ = json.NewDecoder(r.Body).Decode(&entry)
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionBasePath:  "/api",
+		Issuer:            "https://issuer.example.com",
+		RegistryURL:       server.URL,
+		RegistryToken:     "secret",
+	}
+
+	if err := registerFunction(context.Background(), cfg, "http://myfunc.myns.example.com"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if method != http.MethodPut || path != "/functions/myns/myfunc" || auth != "Bearer secret" {
+		t.Errorf("Unexpected request: %s %s (%s)", method, path, auth)
+	}
+	if entry.URL != "http://myfunc.myns.example.com" || entry.BasePath != "/api" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.Auth == nil || entry.Auth.Issuer != "https://issuer.example.com" {
+		t.Errorf("Expected auth requirements in entry: %+v", entry.Auth)
+	}
+
+	if err := deregisterFunction(context.Background(), cfg); err != nil {
+		t.Errorf("Expected deregistering an unknown function to succeed: %v", err)
+	}
+	if method != http.MethodDelete {
+		t.Errorf("Expected DELETE, got %s", method)
+	}
+}
+
+func TestRegisterFunctionError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		RegistryURL:       server.URL,
+	}
+
+	if err := registerFunction(context.Background(), cfg, "http://myfunc"); err == nil {
+		t.Error("Expected error when registry fails")
+	}
+}
@@ -0,0 +1,257 @@
+package deployer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// reportRow is what the report tells about one KDexFunction.
+type reportRow struct {
+	Namespace     string     `json:"namespace"`
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	URL           string     `json:"url,omitempty"`
+	Image         string     `json:"image,omitempty"`
+	ImageCreated  *time.Time `json:"imageCreated,omitempty"`
+	Generation    int64      `json:"generation"`
+	GenerationLag int64      `json:"generationLag"`
+	MinScale      string     `json:"minScale,omitempty"`
+	MaxScale      string     `json:"maxScale,omitempty"`
+	Target        string     `json:"target,omitempty"`
+	LastDeploy    *time.Time `json:"lastDeploy,omitempty"`
+}
+
+// imageCreatedFunc looks up when an image was built.
+type imageCreatedFunc func(ctx context.Context, image string) (time.Time, error)
+
+// runReport prints every KDexFunction of a namespace, or of the cluster,
+// with its state, image age, generation lag, scaling and last deploy, for
+// platform reviews.
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	allNamespaces := flags.Bool("all-namespaces", false, "report on the functions of every namespace instead of FUNCTION_NAMESPACE")
+	selector := flags.String("selector", "", "only report on the KDexFunctions matching this label selector")
+	output := flags.String("output", "table", "report format: table, json or csv")
+	imageAge := flags.Bool("image-age", true, "look up when each image was built in its registry")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" && *output != "csv" {
+		return fmt.Errorf("invalid output %q: expected table, json or csv", *output)
+	}
+	namespace := ""
+	if !*allNamespaces {
+		namespace = os.Getenv("FUNCTION_NAMESPACE")
+		if namespace == "" {
+			return fmt.Errorf("FUNCTION_NAMESPACE is required without --all-namespaces")
+		}
+	}
+
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	var imageCreated imageCreatedFunc
+	if *imageAge {
+		imageCreated = registryImageCreated
+	}
+	now := time.Now()
+	rows, err := buildReport(context.Background(), client, namespace, *selector, imageCreated)
+	if err != nil {
+		return err
+	}
+	return writeReport(outputRedactor().Writer(os.Stdout), *output, rows, now)
+}
+
+// registryImageCreated reads the build time from the image config.
+func registryImageCreated(ctx context.Context, image string) (time.Time, error) {
+	config, err := fetchImageConfig(ctx, image)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return config.Created.Time, nil
+}
+
+// buildReport lists the KDexFunctions of namespace, or of every namespace
+// when it is empty, with their Services and revisions, listing each kind
+// once. A nil imageCreated leaves the image age out. Images are looked up
+// once however many functions run them, and one that cannot be is left
+// out with a warning.
+func buildReport(ctx context.Context, client dynamic.Interface, namespace, selector string, imageCreated imageCreatedFunc) ([]reportRow, error) {
+	functions, err := client.Resource(kdexFunctionGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kdex functions: %w", err)
+	}
+	services, err := client.Resource(knativeServiceGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: "kdex.dev/function"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knative services: %w", err)
+	}
+	revisions, err := client.Resource(knativeRevisionGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: "serving.knative.dev/service"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knative revisions: %w", err)
+	}
+
+	byFunction := map[string]*unstructured.Unstructured{}
+	for i := range services.Items {
+		service := &services.Items[i]
+		byFunction[service.GetNamespace()+"/"+service.GetLabels()["kdex.dev/function"]] = service
+	}
+	revisionCreated := map[string]time.Time{}
+	for _, revision := range revisions.Items {
+		revisionCreated[revision.GetNamespace()+"/"+revision.GetName()] = revision.GetCreationTimestamp().Time
+	}
+	built := map[string]*time.Time{}
+
+	rows := make([]reportRow, 0, len(functions.Items))
+	for i := range functions.Items {
+		function := &functions.Items[i]
+		row := reportRow{
+			Namespace:  function.GetNamespace(),
+			Name:       function.GetName(),
+			Generation: function.GetGeneration(),
+		}
+		row.State, _, _ = unstructured.NestedString(function.Object, "status", "state")
+		row.URL, _, _ = unstructured.NestedString(function.Object, "status", "url")
+		observed, _, _ := unstructured.NestedInt64(function.Object, "status", "observedGeneration")
+		if lag := row.Generation - observed; lag > 0 {
+			row.GenerationLag = lag
+		}
+
+		if service, ok := byFunction[row.Namespace+"/"+row.Name]; ok {
+			annotations := service.GetAnnotations()
+			row.MinScale = annotations[minScaleAnnotation]
+			row.MaxScale = annotations[maxScaleAnnotation]
+			// The bounds of a scale profile are on the revision template
+			template, _, _ := unstructured.NestedStringMap(service.Object, templateAnnotationsPath...)
+			if bound, ok := template[minScaleAnnotation]; ok {
+				row.MinScale = bound
+			}
+			if bound, ok := template[maxScaleAnnotation]; ok {
+				row.MaxScale = bound
+			}
+			row.Target = annotations["autoscaling.knative.dev/target"]
+			containers, _, _ := unstructured.NestedSlice(service.Object, "spec", "template", "spec", "containers")
+			if len(containers) > 0 {
+				container, _ := containers[0].(map[string]any)
+				row.Image, _ = container["image"].(string)
+			}
+			latest, _, _ := unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+			if created, ok := revisionCreated[row.Namespace+"/"+latest]; ok {
+				row.LastDeploy = &created
+			}
+		}
+
+		if imageCreated != nil && row.Image != "" {
+			created, ok := built[row.Image]
+			if !ok {
+				if t, err := imageCreated(ctx, row.Image); err != nil {
+					warnf("failed to look up when %s was built: %v\n", row.Image, err)
+				} else if !t.IsZero() {
+					created = &t
+				}
+				built[row.Image] = created
+			}
+			row.ImageCreated = created
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Namespace != rows[j].Namespace {
+			return rows[i].Namespace < rows[j].Namespace
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows, nil
+}
+
+// writeReport prints the rows as an aligned table with ages relative to
+// now, as JSON, or as CSV with RFC 3339 times.
+func writeReport(w io.Writer, output string, rows []reportRow, now time.Time) error {
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "csv":
+		out := csv.NewWriter(w)
+		_ = out.Write([]string{"namespace", "name", "state", "url", "image", "imageCreated", "generation", "generationLag", "minScale", "maxScale", "target", "lastDeploy"})
+		for _, row := range rows {
+			_ = out.Write([]string{
+				row.Namespace, row.Name, row.State, row.URL, row.Image, reportTime(row.ImageCreated),
+				strconv.FormatInt(row.Generation, 10), strconv.FormatInt(row.GenerationLag, 10),
+				row.MinScale, row.MaxScale, row.Target, reportTime(row.LastDeploy),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tSTATE\tURL\tIMAGE AGE\tGEN LAG\tSCALE\tLAST DEPLOY")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			row.Namespace, row.Name, orDash(row.State), orDash(row.URL), reportAge(row.ImageCreated, now),
+			row.GenerationLag, scaleRange(row), reportAge(row.LastDeploy, now))
+	}
+	return tw.Flush()
+}
+
+// scaleRange tells the replica bounds as min..max, leaving out an unset
+// maximum.
+func scaleRange(row reportRow) string {
+	if row.MinScale == "" && row.MaxScale == "" {
+		return "-"
+	}
+	lower := row.MinScale
+	if lower == "" {
+		lower = "0"
+	}
+	return lower + ".." + row.MaxScale
+}
+
+func reportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// reportAge tells how long ago t was, in its largest unit.
+func reportAge(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	age := now.Sub(*t)
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildReport(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil)
+	function.SetGeneration(5)
+	function.Object["status"] = map[string]any{"state": "Ready", "url": "https://fn.example.com", "observedGeneration": int64(3)}
+	other := newObject("kdex.dev/v1alpha1", "KDexFunction", "other", "api", nil)
+	other.SetGeneration(1)
+
+	service := newObject("serving.knative.dev/v1", "Service", "ns", "fn", map[string]string{"kdex.dev/function": "fn"})
+	service.SetAnnotations(map[string]string{minScaleAnnotation: "1", maxScaleAnnotation: "10"})
+	_ = unstructured.SetNestedSlice(service.Object, []any{map[string]any{"image": "registry.example.com/fn:v2"}}, "spec", "template", "spec", "containers")
+	_ = unstructured.SetNestedField(service.Object, "fn-00002", "status", "latestCreatedRevisionName")
+	revision := newObject("serving.knative.dev/v1", "Revision", "ns", "fn-00002", map[string]string{"serving.knative.dev/service": "fn"})
+	revision.SetCreationTimestamp(metav1.NewTime(now.Add(-3 * time.Hour)))
+
+	client := newFakeDynamicClient(function, other, service, revision)
+	lookups := 0
+	imageCreated := func(ctx context.Context, image string) (time.Time, error) {
+		lookups++
+		if image != "registry.example.com/fn:v2" {
+			return time.Time{}, fmt.Errorf("unexpected image %s", image)
+		}
+		return now.Add(-72 * time.Hour), nil
+	}
+
+	rows, err := buildReport(context.Background(), client, "", "", imageCreated)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Name != "fn" || rows[1].Name != "api" {
+		t.Fatalf("Expected fn and api sorted by namespace, got %+v", rows)
+	}
+	row := rows[0]
+	if row.State != "Ready" || row.GenerationLag != 2 || row.MinScale != "1" || row.MaxScale != "10" {
+		t.Errorf("Unexpected row %+v", row)
+	}
+	if row.LastDeploy == nil || !row.LastDeploy.Equal(now.Add(-3*time.Hour)) {
+		t.Errorf("Expected the last deploy at the latest revision, got %v", row.LastDeploy)
+	}
+	if lookups != 1 || row.ImageCreated == nil {
+		t.Errorf("Expected the image looked up once, got %d lookups and %v", lookups, row.ImageCreated)
+	}
+
+	var out bytes.Buffer
+	if err := writeReport(&out, "table", rows, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "3d") || !strings.Contains(lines[1], "1..10") || !strings.Contains(lines[1], "3h") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writeReport(&out, "csv", rows, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "ns,fn,Ready,https://fn.example.com,registry.example.com/fn:v2,2026-10-13T12:00:00Z,5,2,1,10,,2026-10-16T09:00:00Z") {
+		t.Errorf("Unexpected csv:\n%s", out.String())
+	}
+}
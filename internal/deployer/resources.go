@@ -0,0 +1,55 @@
+package deployer
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// buildResources renders the container resources from FUNCTION_CPU_* and
+// FUNCTION_MEMORY_*. It returns nil when none is set, leaving the defaults
+// to the namespace LimitRange.
+func buildResources(cfg *EnvConfig) (map[string]any, error) {
+	requests := map[string]any{}
+	limits := map[string]any{}
+
+	for _, r := range []struct {
+		name    string
+		request string
+		limit   string
+		envName string
+	}{
+		{"cpu", cfg.FunctionCPURequest, cfg.FunctionCPULimit, "CPU"},
+		{"memory", cfg.FunctionMemoryRequest, cfg.FunctionMemoryLimit, "MEMORY"},
+	} {
+		var request, limit resource.Quantity
+		var err error
+		if r.request != "" {
+			if request, err = resource.ParseQuantity(r.request); err != nil || request.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid FUNCTION_%s_REQUEST %q: expected a positive quantity", r.envName, r.request)
+			}
+			requests[r.name] = request.String()
+		}
+		if r.limit != "" {
+			if limit, err = resource.ParseQuantity(r.limit); err != nil || limit.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid FUNCTION_%s_LIMIT %q: expected a positive quantity", r.envName, r.limit)
+			}
+			limits[r.name] = limit.String()
+		}
+		if r.request != "" && r.limit != "" && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("FUNCTION_%s_REQUEST %s exceeds FUNCTION_%s_LIMIT %s", r.envName, r.request, r.envName, r.limit)
+		}
+	}
+
+	if len(requests) == 0 && len(limits) == 0 {
+		return nil, nil
+	}
+	resources := map[string]any{}
+	if len(requests) > 0 {
+		resources["requests"] = requests
+	}
+	if len(limits) > 0 {
+		resources["limits"] = limits
+	}
+	return resources, nil
+}
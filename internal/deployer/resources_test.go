@@ -0,0 +1,39 @@
+package deployer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildResources(t *testing.T) {
+	resources, err := buildResources(&EnvConfig{})
+	if err != nil || resources != nil {
+		t.Errorf("Expected no resources, got %v %v", resources, err)
+	}
+
+	resources, err = buildResources(&EnvConfig{
+		FunctionCPURequest:    "0.25",
+		FunctionCPULimit:      "1",
+		FunctionMemoryRequest: "128Mi",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]any{
+		"requests": map[string]any{"cpu": "250m", "memory": "128Mi"},
+		"limits":   map[string]any{"cpu": "1"},
+	}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("Expected %v, got %v", want, resources)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{FunctionCPURequest: "lots"},
+		{FunctionMemoryLimit: "0"},
+		{FunctionMemoryRequest: "1Gi", FunctionMemoryLimit: "512Mi"},
+	} {
+		if _, err := buildResources(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+const scalingAnnotationPrefix = "autoscaling.knative.dev/"
+
+// maskedValue stands in for the value of an env var that looks like it
+// holds a secret.
+const maskedValue = "(masked)"
+
+// sensitiveEnvName matches the names of env vars whose literal values are
+// masked in diffs.
+var sensitiveEnvName = regexp.MustCompile(`(?i)(secret|token|password|passwd|credential|api_?key|private_?key|auth)`)
+
+// settingChange is one env var or scaling annotation a deploy would
+// change on the revision, with values rendered for review.
+type settingChange struct {
+	Op      string
+	Name    string
+	Live    string
+	Desired string
+}
+
+// revisionDiff is the semantic diff of the settings of the live revision
+// against the one a deploy would roll out.
+type revisionDiff struct {
+	Revision string
+	Env      []settingChange
+	Scaling  []settingChange
+}
+
+// diffRevision compares the env and scaling annotations of the live
+// revision with those of the candidate. It returns nil when the Service
+// does not exist yet, which the raw diff already reports.
+func diffRevision(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (*revisionDiff, error) {
+	existing, err := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get knative service: %w", err)
+	}
+	service, err := buildService(cfg, serviceStateOf(existing))
+	if err != nil {
+		return nil, err
+	}
+	desired, err := normalizeObject(service.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	// The revision serving now is what the candidate replaces; before one
+	// is ready, the live template is the closest thing to it
+	diff := &revisionDiff{Revision: "template"}
+	liveRevision := map[string]any{
+		"metadata": nestedMap(existing.Object, "spec", "template", "metadata"),
+		"spec":     nestedMap(existing.Object, "spec", "template", "spec"),
+	}
+	if name, _, _ := unstructured.NestedString(existing.Object, "status", "latestReadyRevisionName"); name != "" {
+		revision, err := client.Resource(knativeRevisionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			diff.Revision = name
+			liveRevision = revision.Object
+		case !errors.IsNotFound(err):
+			return nil, fmt.Errorf("failed to get knative revision: %w", err)
+		}
+	}
+	candidate := map[string]any{
+		"metadata": nestedMap(desired, "spec", "template", "metadata"),
+		"spec":     nestedMap(desired, "spec", "template", "spec"),
+	}
+
+	diff.Env = diffSettings(revisionEnv(liveRevision), revisionEnv(candidate), maskEnvValue)
+	diff.Scaling = diffSettings(
+		scalingSettings(existing.Object, liveRevision),
+		scalingSettings(desired, candidate),
+		func(_, value string) string { return value },
+	)
+	return diff, nil
+}
+
+func nestedMap(obj map[string]any, fields ...string) map[string]any {
+	m, _, _ := unstructured.NestedMap(obj, fields...)
+	return m
+}
+
+// revisionEnv renders the env of the function container by name.
+func revisionEnv(revision map[string]any) map[string]string {
+	env := map[string]string{}
+	containers, _, _ := unstructured.NestedSlice(revision, "spec", "containers")
+	if len(containers) == 0 {
+		return env
+	}
+	container, _ := containers[0].(map[string]any)
+	entries, _, _ := unstructured.NestedSlice(container, "env")
+	for _, e := range entries {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := entry["name"].(string)
+		if name == "" {
+			continue
+		}
+		env[name] = envValue(entry)
+	}
+	return env
+}
+
+// envValue renders a literal value quoted, and a reference by what it
+// refers to.
+func envValue(entry map[string]any) string {
+	valueFrom, ok := entry["valueFrom"].(map[string]any)
+	if !ok {
+		value := ""
+		if entry["value"] != nil {
+			value = fmt.Sprint(entry["value"])
+		}
+		return compactJSON(value)
+	}
+	for _, field := range []string{"secretKeyRef", "configMapKeyRef"} {
+		if ref, ok := valueFrom[field].(map[string]any); ok {
+			return fmt.Sprintf("%s %v/%v", field, ref["name"], ref["key"])
+		}
+	}
+	return compactJSON(valueFrom)
+}
+
+// scalingSettings collects the autoscaling annotations of the Service and
+// the revision; those of the revision win.
+func scalingSettings(service, revision map[string]any) map[string]string {
+	settings := map[string]string{}
+	for _, annotations := range []map[string]any{
+		nestedMap(service, "metadata", "annotations"),
+		nestedMap(revision, "metadata", "annotations"),
+	} {
+		for k, v := range annotations {
+			if strings.HasPrefix(k, scalingAnnotationPrefix) {
+				settings[k] = compactJSON(v)
+			}
+		}
+	}
+	return settings
+}
+
+// maskEnvValue hides the literal value of an env var that looks like it
+// holds a secret. References are shown, as they hold no secret themselves.
+func maskEnvValue(name, value string) string {
+	if strings.HasPrefix(value, `"`) && value != `""` && sensitiveEnvName.MatchString(name) {
+		return maskedValue
+	}
+	return value
+}
+
+// diffSettings lists the settings added, removed or changed from live to
+// desired, by name, with the values rendered through mask. A masked value
+// that changed shows as changed without revealing either value.
+func diffSettings(live, desired map[string]string, mask func(name, value string) string) []settingChange {
+	names := slices.Sorted(maps.Keys(desired))
+	for name := range live {
+		if _, ok := desired[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := []settingChange{}
+	for _, name := range names {
+		l, inLive := live[name]
+		d, inDesired := desired[name]
+		switch {
+		case !inLive:
+			changes = append(changes, settingChange{Op: changeAdded, Name: name, Desired: mask(name, d)})
+		case !inDesired:
+			changes = append(changes, settingChange{Op: changeRemoved, Name: name, Live: mask(name, l)})
+		case l != d:
+			changes = append(changes, settingChange{Op: changeModified, Name: name, Live: mask(name, l), Desired: mask(name, d)})
+		}
+	}
+	return changes
+}
+
+func printRevisionDiff(w io.Writer, diff *revisionDiff) error {
+	if diff == nil || len(diff.Env)+len(diff.Scaling) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "\nRevision settings (%s -> candidate):\n", diff.Revision); err != nil {
+		return err
+	}
+	for _, section := range []struct {
+		title   string
+		changes []settingChange
+	}{
+		{"env", diff.Env},
+		{"scaling", diff.Scaling},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "  %s:\n", section.title); err != nil {
+			return err
+		}
+		for _, c := range section.changes {
+			var line string
+			switch c.Op {
+			case changeAdded:
+				line = fmt.Sprintf("+ %s: %s", c.Name, c.Desired)
+			case changeRemoved:
+				line = fmt.Sprintf("- %s: %s", c.Name, c.Live)
+			default:
+				line = fmt.Sprintf("~ %s: %s -> %s", c.Name, c.Live, c.Desired)
+			}
+			if _, err := fmt.Fprintf(w, "    %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiffSettings(t *testing.T) {
+	live := map[string]string{"API_TOKEN": `"old"`, "DB_PASSWORD": "secretKeyRef db/password", "LOG_LEVEL": `"info"`, "OLD": `"x"`}
+	desired := map[string]string{"API_TOKEN": `"new"`, "DB_PASSWORD": "secretKeyRef db/password2", "LOG_LEVEL": `"debug"`, "NEW": `"y"`}
+
+	changes := diffSettings(live, desired, maskEnvValue)
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, c.Op+c.Name+":"+c.Live+">"+c.Desired)
+	}
+	want := []string{
+		`~API_TOKEN:(masked)>(masked)`,
+		`~DB_PASSWORD:secretKeyRef db/password>secretKeyRef db/password2`,
+		`~LOG_LEVEL:"info">"debug"`,
+		`+NEW:>"y"`,
+		`-OLD:"x">`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected changes:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestDiffRevision(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.SetAnnotations(map[string]string{"autoscaling.knative.dev/max-scale": "5"})
+	_ = unstructured.SetNestedField(service.Object, "myfunc-00002", "status", "latestReadyRevisionName")
+	revision := newObject("serving.knative.dev/v1", "Revision", "myns", "myfunc-00002", nil)
+	_ = unstructured.SetNestedSlice(revision.Object, []any{
+		map[string]any{
+			"image": "myimg:old",
+			"env": []any{
+				map[string]any{"name": "GREETING", "value": "hello"},
+				map[string]any{"name": "SIGNING_SECRET", "value": "s3cr3t"},
+			},
+		},
+	}, "spec", "containers")
+
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimg:new", ScalingMaxScale: "10"}
+	diff, err := diffRevision(context.Background(), newFakeDynamicClient(service, revision), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff.Revision != "myfunc-00002" {
+		t.Errorf("Expected the live revision to be compared, got %s", diff.Revision)
+	}
+
+	var out bytes.Buffer
+	if err := printRevisionDiff(&out, diff); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "s3cr3t") {
+		t.Errorf("Expected the secret value to be masked:\n%s", out.String())
+	}
+	for _, line := range []string{
+		`- GREETING: "hello"`,
+		`- SIGNING_SECRET: (masked)`,
+		`~ autoscaling.knative.dev/max-scale: "5" -> "10"`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestDiffRevisionNewService(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimg"}
+	diff, err := diffRevision(context.Background(), newFakeDynamicClient(), cfg)
+	if err != nil || diff != nil {
+		t.Errorf("Expected nothing to compare against, got %+v %v", diff, err)
+	}
+}
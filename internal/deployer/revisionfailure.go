@@ -0,0 +1,59 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// readyDiagnoser tells why an object is not ready, or nil when it cannot.
+type readyDiagnoser func(ctx context.Context, obj *unstructured.Unstructured) *report.RevisionFailure
+
+// revisionConditionTypes are the revision conditions checked for a cause,
+// the most specific first.
+var revisionConditionTypes = []string{"ContainerHealthy", "ResourcesAvailable", "Ready"}
+
+// diagnoseRevisions diagnoses a Service from the conditions of its latest
+// created revision.
+func diagnoseRevisions(revisions dynamic.ResourceInterface) readyDiagnoser {
+	return func(ctx context.Context, service *unstructured.Unstructured) *report.RevisionFailure {
+		name, _, _ := unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+		if name == "" {
+			return nil
+		}
+		revision, err := revisions.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			warnf("failed to get revision %s: %v\n", name, err)
+			return nil
+		}
+		return revisionFailureOf(revision)
+	}
+}
+
+// revisionFailureOf reads the cause from the first failed condition of the
+// revision. Knative reports image pull errors as Unknown while it retries,
+// so those count too.
+func revisionFailureOf(revision *unstructured.Unstructured) *report.RevisionFailure {
+	conditions, _, _ := unstructured.NestedSlice(revision.Object, "status", "conditions")
+	for _, conditionType := range revisionConditionTypes {
+		for _, c := range conditions {
+			cond, ok := c.(map[string]any)
+			if !ok || cond["type"] != conditionType {
+				continue
+			}
+			reason, _ := cond["reason"].(string)
+			message, _ := cond["message"].(string)
+			failed := cond["status"] == "False" ||
+				(cond["status"] == "Unknown" && (reason == "ImagePullBackOff" || reason == "ErrImagePull"))
+			if failed && reason != "" {
+				return &report.RevisionFailure{Revision: revision.GetName(), Reason: reason, Message: strings.TrimSpace(message)}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func revisionWithConditions(conditions ...any) *unstructured.Unstructured {
+	revision := newObject("serving.knative.dev/v1", "Revision", "myns", "myfunc-00002", nil)
+	revision.Object["status"] = map[string]any{"conditions": conditions}
+	return revision
+}
+
+func TestRevisionFailureOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		revision   *unstructured.Unstructured
+		wantReason string
+		terminal   bool
+	}{
+		{
+			name: "deploying",
+			revision: revisionWithConditions(
+				map[string]any{"type": "ResourcesAvailable", "status": "Unknown", "reason": "Deploying"},
+				map[string]any{"type": "Ready", "status": "Unknown", "reason": "Deploying"},
+			),
+		},
+		{
+			name: "image pull",
+			revision: revisionWithConditions(
+				map[string]any{"type": "ResourcesAvailable", "status": "Unknown", "reason": "ImagePullBackOff", "message": "Back-off pulling image"},
+			),
+			wantReason: "ImagePullBackOff",
+		},
+		{
+			name: "crashing",
+			revision: revisionWithConditions(
+				map[string]any{"type": "Ready", "status": "False", "reason": "ExitCode1"},
+				map[string]any{"type": "ContainerHealthy", "status": "False", "reason": "ExitCode1", "message": "Container failed with: panic"},
+			),
+			wantReason: "ExitCode1",
+		},
+		{
+			name: "missing image",
+			revision: revisionWithConditions(
+				map[string]any{"type": "Ready", "status": "False", "reason": "ContainerMissing"},
+			),
+			wantReason: "ContainerMissing",
+			terminal:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		failure := revisionFailureOf(tt.revision)
+		if tt.wantReason == "" {
+			if failure != nil {
+				t.Errorf("%s: expected no failure, got %v", tt.name, failure)
+			}
+			continue
+		}
+		if failure == nil || failure.Reason != tt.wantReason || failure.Terminal() != tt.terminal {
+			t.Errorf("%s: expected %s (terminal %v), got %+v", tt.name, tt.wantReason, tt.terminal, failure)
+		}
+	}
+}
+
+func TestWaitForReadyFailsOnTerminalRevision(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.Object["status"] = map[string]any{
+		"latestCreatedRevisionName": "myfunc-00002",
+		"conditions":                []any{map[string]any{"type": "Ready", "status": "False", "reason": "RevisionMissing"}},
+	}
+	revision := revisionWithConditions(
+		map[string]any{"type": "Ready", "status": "False", "reason": "ContainerMissing", "message": "Unable to fetch image"},
+	)
+	client := newFakeDynamicClient(service, revision)
+
+	diagnose := diagnoseRevisions(client.Resource(knativeRevisionGVR).Namespace("myns"))
+	timing := waitTiming{Timeout: 5 * time.Second, PollInterval: 10 * time.Millisecond}
+	_, err := waitForReady(context.Background(), client.Resource(knativeServiceGVR).Namespace("myns"), "myfunc", timing, diagnose)
+
+	var failure *report.RevisionFailure
+	if !errors.As(err, &failure) || failure.Reason != "ContainerMissing" || failure.Revision != "myfunc-00002" {
+		t.Fatalf("Expected the missing container to fail the wait, got %v", err)
+	}
+}
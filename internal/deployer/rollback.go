@@ -0,0 +1,182 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func runRollback() error {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	notifiers, err := loadNotifiers(cfg, client)
+	if err != nil {
+		return err
+	}
+
+	revision, url, err := rollbackFunction(context.Background(), client, cfg)
+	if err != nil {
+		return err
+	}
+
+	logf("Rolled back to %s, serving at: %s\n", revision, url)
+
+	n := newNotification(cfg, notificationRolledBack)
+	n.URL = url
+	n.Revision = revision
+	notifiers.notify(context.Background(), n)
+
+	if err := writeTerminationMessage(report.TerminationMessage{URL: url, Revision: revision}); err != nil {
+		return fmt.Errorf("failed to write termination message: %w", err)
+	}
+
+	return nil
+}
+
+// rollbackFunction pins all traffic to the newest ready revision of the
+// generation before the one serving now, then waits for the route. It
+// returns the revision now serving traffic and the service URL.
+func rollbackFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (string, string, error) {
+	resourceClient := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace)
+
+	service, err := resourceClient.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get knative service: %w", err)
+	}
+
+	list, err := client.Resource(knativeRevisionGVR).Namespace(cfg.FunctionNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "serving.knative.dev/service=" + cfg.FunctionName,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list revisions: %w", err)
+	}
+
+	current, ok := servingGeneration(service, list.Items)
+	if !ok {
+		return "", "", fmt.Errorf("cannot tell which generation is serving traffic")
+	}
+	target := previousRevision(list.Items, current)
+	if target == "" {
+		return "", "", fmt.Errorf("no ready revision found for a generation before %d", current)
+	}
+
+	logf("Pinning traffic from generation %d to %s\n", current, target)
+
+	// A merge patch replaces spec.traffic and leaves the template alone,
+	// which an apply of only the route would not
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"traffic": []any{
+				map[string]any{"revisionName": target, "latestRevision": false, "percent": int64(100)},
+			},
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	_, err = resourceClient.Patch(ctx, cfg.FunctionName, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to pin traffic: %w", err)
+	}
+
+	timing, err := parseWaitTiming(cfg)
+	if err != nil {
+		return "", "", err
+	}
+
+	logf("Waiting for route to be ready...\n")
+	url, err := waitForReady(ctx, resourceClient, cfg.FunctionName, timing, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to wait for route readiness: %w", err)
+	}
+
+	return target, url, nil
+}
+
+// revisionGeneration reads the kdex.dev/generation label of a revision.
+func revisionGeneration(revision *unstructured.Unstructured) (int64, bool) {
+	generation, err := strconv.ParseInt(revision.GetLabels()["kdex.dev/generation"], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return generation, true
+}
+
+// servingGeneration is the newest generation among the revisions receiving
+// traffic. It falls back to the generation label of the service, which
+// names the last generation deployed.
+func servingGeneration(service *unstructured.Unstructured, revisions []unstructured.Unstructured) (int64, bool) {
+	generations := map[string]int64{}
+	for i := range revisions {
+		if generation, ok := revisionGeneration(&revisions[i]); ok {
+			generations[revisions[i].GetName()] = generation
+		}
+	}
+
+	var serving int64
+	found := false
+	traffic, _, _ := unstructured.NestedSlice(service.Object, "status", "traffic")
+	for _, t := range traffic {
+		entry, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		if percent, _, _ := unstructured.NestedInt64(entry, "percent"); percent == 0 {
+			continue
+		}
+		name, _ := entry["revisionName"].(string)
+		if generation, ok := generations[name]; ok && (!found || generation > serving) {
+			serving = generation
+			found = true
+		}
+	}
+	if found {
+		return serving, true
+	}
+
+	return revisionGeneration(service)
+}
+
+// previousRevision picks the newest ready revision of the newest generation
+// older than current. It returns "" when there is none.
+func previousRevision(revisions []unstructured.Unstructured, current int64) string {
+	var best *unstructured.Unstructured
+	var bestGeneration int64
+	for i := range revisions {
+		revision := &revisions[i]
+		generation, ok := revisionGeneration(revision)
+		if !ok || generation >= current {
+			continue
+		}
+		if status, _, _ := findCondition(revision, "Ready"); status != "True" {
+			continue
+		}
+		if best == nil || generation > bestGeneration ||
+			(generation == bestGeneration && revision.GetCreationTimestamp().After(best.GetCreationTimestamp().Time)) {
+			best = revision
+			bestGeneration = generation
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.GetName()
+}
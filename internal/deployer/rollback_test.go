@@ -0,0 +1,81 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newRevision(name, generation string, ready bool, created time.Time) unstructured.Unstructured {
+	revision := newObject("serving.knative.dev/v1", "Revision", "myns", name, map[string]string{
+		"serving.knative.dev/service": "myfunc",
+		"kdex.dev/generation":         generation,
+	})
+	revision.SetCreationTimestamp(metav1.NewTime(created))
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	_ = unstructured.SetNestedSlice(revision.Object, []any{
+		map[string]any{"type": "Ready", "status": status},
+	}, "status", "conditions")
+	return *revision
+}
+
+func TestPreviousRevision(t *testing.T) {
+	now := time.Now()
+	revisions := []unstructured.Unstructured{
+		newRevision("myfunc-00001", "1", true, now.Add(-4*time.Hour)),
+		newRevision("myfunc-00002", "2", true, now.Add(-3*time.Hour)),
+		newRevision("myfunc-00003", "2", true, now.Add(-2*time.Hour)),
+		newRevision("myfunc-00004", "3", false, now.Add(-time.Hour)),
+		newRevision("myfunc-00005", "4", true, now),
+	}
+
+	if got := previousRevision(revisions, 4); got != "myfunc-00003" {
+		t.Errorf("Expected newest ready revision of generation 2, got %q", got)
+	}
+	if got := previousRevision(revisions, 2); got != "myfunc-00001" {
+		t.Errorf("Expected myfunc-00001, got %q", got)
+	}
+	if got := previousRevision(revisions, 1); got != "" {
+		t.Errorf("Expected no revision, got %q", got)
+	}
+}
+
+func TestServingGeneration(t *testing.T) {
+	now := time.Now()
+	revisions := []unstructured.Unstructured{
+		newRevision("myfunc-00001", "1", true, now),
+		newRevision("myfunc-00002", "2", true, now),
+	}
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", map[string]string{"kdex.dev/generation": "3"})
+
+	if got, ok := servingGeneration(service, revisions); !ok || got != 3 {
+		t.Errorf("Expected service generation without traffic status, got %d", got)
+	}
+
+	_ = unstructured.SetNestedSlice(service.Object, []any{
+		map[string]any{"revisionName": "myfunc-00001", "percent": int64(100)},
+		map[string]any{"revisionName": "myfunc-00002", "percent": int64(0), "tag": "candidate"},
+	}, "status", "traffic")
+	if got, ok := servingGeneration(service, revisions); !ok || got != 1 {
+		t.Errorf("Expected generation of the serving revision, got %d", got)
+	}
+}
+
+func TestRollbackFunctionNoPrevious(t *testing.T) {
+	revision := newRevision("myfunc-00001", "1", true, time.Now())
+	client := newFakeDynamicClient(
+		newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", map[string]string{"kdex.dev/generation": "1"}),
+		&revision,
+	)
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+
+	if _, _, err := rollbackFunction(context.Background(), client, cfg); err == nil {
+		t.Error("Expected error without a previous generation")
+	}
+}
@@ -0,0 +1,143 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+)
+
+// runtimeLabel lets an image declare its runtime explicitly.
+const runtimeLabel = "kdex.dev/runtime"
+
+// runtimeDefaults holds the container settings applied for a runtime when
+// the function does not configure them itself.
+type runtimeDefaults struct {
+	Port                    int64
+	HealthPath              string
+	StartupPeriodSeconds    int64
+	StartupFailureThreshold int64
+}
+
+// runtimes lists the known runtimes. Startup probe budgets reflect how long
+// each runtime typically takes to start serving: JVMs are slow to warm up,
+// interpreted runtimes less so and native binaries start almost instantly.
+var runtimes = map[string]runtimeDefaults{
+	"java": {
+		Port:                    8080,
+		HealthPath:              "/health",
+		StartupPeriodSeconds:    5,
+		StartupFailureThreshold: 24,
+	},
+	"nodejs": {
+		Port:                    8080,
+		HealthPath:              "/health",
+		StartupPeriodSeconds:    2,
+		StartupFailureThreshold: 15,
+	},
+	"python": {
+		Port:                    8080,
+		HealthPath:              "/health",
+		StartupPeriodSeconds:    2,
+		StartupFailureThreshold: 30,
+	},
+	"go": {
+		Port:                    8080,
+		HealthPath:              "/health",
+		StartupPeriodSeconds:    1,
+		StartupFailureThreshold: 10,
+	},
+}
+
+// detectRuntime derives the runtime from image labels, preferring an
+// explicit runtime label over the language of the buildpacks that built
+// the image. Runtimes without defaults, such as the other buildpack
+// languages, are logged and ignored: the user did not ask for them.
+func detectRuntime(labels map[string]string) string {
+	runtime := labels[runtimeLabel]
+	if runtime == "" {
+		build, err := parseBuildpackMetadata(labels)
+		if err != nil || build == nil {
+			return ""
+		}
+		runtime, _ = build["language"].(string)
+	}
+	if runtime == "" {
+		return ""
+	}
+	if _, ok := runtimes[runtime]; !ok {
+		logf("Image runtime %s has no defaults; deploying without them\n", runtime)
+		return ""
+	}
+	return runtime
+}
+
+// functionRuntime is the runtime of the function, as set or detected.
+func functionRuntime(cfg *EnvConfig) string {
+	if cfg.FunctionRuntime != "" {
+		return cfg.FunctionRuntime
+	}
+	return cfg.DetectedRuntime
+}
+
+// applyRuntimeDefaults sets the port and probes of the function container
+// from the defaults of the runtime given in FUNCTION_RUNTIME.
+func applyRuntimeDefaults(container map[string]any, runtime string) error {
+	if runtime == "" {
+		return nil
+	}
+
+	defaults, ok := runtimes[runtime]
+	if !ok {
+		return fmt.Errorf("unknown FUNCTION_RUNTIME: %s", runtime)
+	}
+
+	container["ports"] = []map[string]any{
+		{
+			"containerPort": defaults.Port,
+		},
+	}
+	container["readinessProbe"] = map[string]any{
+		"httpGet": map[string]any{
+			"path": defaults.HealthPath,
+		},
+	}
+	container["startupProbe"] = map[string]any{
+		"httpGet": map[string]any{
+			"path": defaults.HealthPath,
+		},
+		"periodSeconds":    defaults.StartupPeriodSeconds,
+		"failureThreshold": defaults.StartupFailureThreshold,
+	}
+
+	return nil
+}
+
+// applyDetectedRuntimeDefaults gives the function container the startup
+// budget of a runtime detected from its image. Nothing says the function
+// serves the health path of its runtime, or listens elsewhere than on
+// PORT, so the probe only waits for the port to open.
+func applyDetectedRuntimeDefaults(container map[string]any, runtime string) {
+	defaults, ok := runtimes[runtime]
+	if !ok {
+		return
+	}
+	container["startupProbe"] = map[string]any{
+		"tcpSocket":        map[string]any{},
+		"periodSeconds":    defaults.StartupPeriodSeconds,
+		"failureThreshold": defaults.StartupFailureThreshold,
+	}
+}
+
+// resolvePreviewRuntime detects the runtime from the image for commands that
+// render the Service without deploying it. Warnings are logged, so stdout
+// stays machine readable.
+func resolvePreviewRuntime(ctx context.Context, cfg *EnvConfig) {
+	if cfg.FunctionRuntime != "" {
+		return
+	}
+	imageConfig, err := fetchImageConfig(ctx, cfg.FunctionImage)
+	if err != nil {
+		warnf("failed to inspect image: %v\n", err)
+		return
+	}
+	cfg.DetectedRuntime = detectRuntime(imageConfig.Config.Labels)
+}
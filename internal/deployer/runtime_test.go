@@ -0,0 +1,76 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDetectRuntime(t *testing.T) {
+	if got := detectRuntime(nil); got != "" {
+		t.Errorf("Expected no runtime without labels, got %s", got)
+	}
+
+	labels := map[string]string{
+		buildpackMetadataLabel: `{"buildpacks":[{"id":"paketo-buildpacks/bellsoft-liberica","version":"11.0.0"}]}`,
+	}
+	if got := detectRuntime(labels); got != "java" {
+		t.Errorf("Expected java from buildpacks, got %s", got)
+	}
+
+	labels[runtimeLabel] = "python"
+	if got := detectRuntime(labels); got != "python" {
+		t.Errorf("Expected explicit runtime label to win, got %s", got)
+	}
+
+	for _, labels := range []map[string]string{
+		{runtimeLabel: "cobol"},
+		{buildpackMetadataLabel: `{"buildpacks":[{"id":"paketo-buildpacks/ruby","version":"1.0.0"}]}`},
+	} {
+		if got := detectRuntime(labels); got != "" {
+			t.Errorf("Expected a runtime without defaults to be ignored, got %s for %v", got, labels)
+		}
+	}
+}
+
+func TestBuildServiceDetectedRuntime(t *testing.T) {
+	service, err := buildService(&EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionImage: "img", DetectedRuntime: "java"}, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	containers, _, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "containers")
+	container := containers.([]map[string]any)[0]
+	if _, ok := container["readinessProbe"]; ok {
+		t.Errorf("Expected no readiness probe from a detected runtime, got %v", container["readinessProbe"])
+	}
+	if _, ok := container["ports"]; ok {
+		t.Errorf("Expected no port from a detected runtime, got %v", container["ports"])
+	}
+	startup, _ := container["startupProbe"].(map[string]any)
+	if _, ok := startup["tcpSocket"]; !ok || startup["failureThreshold"] != int64(24) {
+		t.Errorf("Expected a TCP startup probe with the java budget, got %v", startup)
+	}
+}
+
+func TestApplyRuntimeDefaults(t *testing.T) {
+	container := map[string]any{}
+	if err := applyRuntimeDefaults(container, ""); err != nil || len(container) != 0 {
+		t.Errorf("Expected no defaults without runtime. Got %v, %v", container, err)
+	}
+
+	if err := applyRuntimeDefaults(container, "cobol"); err == nil {
+		t.Error("Expected error for unknown runtime")
+	}
+
+	if err := applyRuntimeDefaults(container, "java"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	startup, _ := container["startupProbe"].(map[string]any)
+	if startup["failureThreshold"] != int64(24) || startup["periodSeconds"] != int64(5) {
+		t.Errorf("Unexpected startup probe: %v", startup)
+	}
+	ports, _ := container["ports"].([]map[string]any)
+	if len(ports) != 1 || ports[0]["containerPort"] != int64(8080) {
+		t.Errorf("Unexpected ports: %v", ports)
+	}
+}
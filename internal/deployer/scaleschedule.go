@@ -0,0 +1,552 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+var cronJobGVR = schema.GroupVersionResource{
+	Group:    "batch",
+	Version:  "v1",
+	Resource: "cronjobs",
+}
+
+// scaleProfileLabel marks the CronJobs of SCALING_SCHEDULE_JSON with the
+// profile they apply.
+const scaleProfileLabel = "kdex.dev/scale-profile"
+
+// The annotations a scale profile sets.
+const (
+	minScaleAnnotation = "autoscaling.knative.dev/min-scale"
+	maxScaleAnnotation = "autoscaling.knative.dev/max-scale"
+)
+
+// maxScaleProfileName leaves room for the function name in the CronJob
+// name, which Kubernetes limits to 52 characters.
+const (
+	maxScaleProfileName = 24
+	maxCronJobName      = 52
+)
+
+// scaleProfileLookback bounds how far back the deploy looks for the
+// profile in effect, enough for a yearly schedule.
+const scaleProfileLookback = 366 * 24 * time.Hour
+
+// scaleProfile is an entry of SCALING_SCHEDULE_JSON: the scale bounds to
+// apply from each time its schedule fires until another profile fires.
+// A bound it leaves out keeps its SCALING_* setting.
+type scaleProfile struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// TimeZone is an IANA time zone such as Europe/Paris; UTC when unset.
+	TimeZone string `json:"timeZone,omitempty"`
+	MinScale *int64 `json:"minScale,omitempty"`
+	MaxScale *int64 `json:"maxScale,omitempty"`
+}
+
+// parseScaleProfiles reads SCALING_SCHEDULE_JSON, a JSON array of scale
+// profiles such as
+//
+//	[{"name":"business-hours","schedule":"0 8 * * 1-5","timeZone":"Europe/Paris","minScale":3},
+//	 {"name":"overnight","schedule":"0 20 * * *","timeZone":"Europe/Paris","minScale":0}]
+func parseScaleProfiles(cfg *EnvConfig) ([]scaleProfile, error) {
+	if strings.TrimSpace(cfg.ScalingScheduleJSON) == "" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(cfg.ScalingScheduleJSON)))
+	decoder.DisallowUnknownFields()
+	var profiles []scaleProfile
+	if err := decoder.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON: %w", err)
+	}
+
+	names := map[string]bool{}
+	for _, p := range profiles {
+		if errs := validation.IsDNS1123Label(p.Name); len(errs) > 0 || len(p.Name) > maxScaleProfileName {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON profile name %q: expected a DNS label of at most %d characters", p.Name, maxScaleProfileName)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON: profile %s is listed twice", p.Name)
+		}
+		names[p.Name] = true
+		if _, err := parseCron(p.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON schedule of profile %s: %w", p.Name, err)
+		}
+		if _, err := time.LoadLocation(p.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON time zone of profile %s: %w", p.Name, err)
+		}
+		if p.MinScale == nil && p.MaxScale == nil {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON profile %s: expected minScale or maxScale", p.Name)
+		}
+		if (p.MinScale != nil && *p.MinScale < 0) || (p.MaxScale != nil && *p.MaxScale < 0) {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON profile %s: scales must not be negative", p.Name)
+		}
+		// A max-scale of 0 is no limit
+		if p.MinScale != nil && p.MaxScale != nil && *p.MaxScale > 0 && *p.MinScale > *p.MaxScale {
+			return nil, fmt.Errorf("invalid SCALING_SCHEDULE_JSON profile %s: minScale %d exceeds maxScale %d", p.Name, *p.MinScale, *p.MaxScale)
+		}
+	}
+	return profiles, nil
+}
+
+// validateScaleSchedule checks SCALING_SCHEDULE_JSON and that there is a
+// JOB_IMAGE for its CronJobs to run.
+func validateScaleSchedule(cfg *EnvConfig) error {
+	profiles, err := parseScaleProfiles(cfg)
+	if err != nil {
+		return err
+	}
+	if len(profiles) > 0 && cfg.JobImage == "" {
+		return fmt.Errorf("SCALING_SCHEDULE_JSON requires JOB_IMAGE to run its CronJobs")
+	}
+	return nil
+}
+
+// activeScaleProfile is the profile whose schedule fired last before now,
+// or nil when none fired within scaleProfileLookback. Of profiles firing
+// at the same time, the last listed wins.
+func activeScaleProfile(profiles []scaleProfile, now time.Time) *scaleProfile {
+	var active *scaleProfile
+	var activeSince time.Time
+	for i, p := range profiles {
+		schedule, err := parseCron(p.Schedule)
+		if err != nil {
+			continue
+		}
+		location, err := time.LoadLocation(p.TimeZone)
+		if err != nil {
+			continue
+		}
+		fired, ok := schedule.previous(now.In(location), now.Add(-scaleProfileLookback))
+		if ok && !fired.Before(activeSince) {
+			active, activeSince = &profiles[i], fired
+		}
+	}
+	return active
+}
+
+// scaleProfileAnnotations are the revision template annotations of the
+// scale bounds of the profile in effect, so a deploy between two firings
+// does not undo the last one.
+func scaleProfileAnnotations(cfg *EnvConfig, now time.Time) map[string]string {
+	profiles, err := parseScaleProfiles(cfg)
+	if err != nil {
+		return nil
+	}
+	profile := activeScaleProfile(profiles, now)
+	if profile == nil {
+		return nil
+	}
+	annotations := map[string]string{}
+	if profile.MinScale != nil {
+		annotations[minScaleAnnotation] = strconv.FormatInt(*profile.MinScale, 10)
+	}
+	if profile.MaxScale != nil {
+		annotations[maxScaleAnnotation] = strconv.FormatInt(*profile.MaxScale, 10)
+	}
+	return annotations
+}
+
+// scaleCronJobName is the name of the CronJob of a profile, shortening the
+// function name to fit.
+func scaleCronJobName(function, profile string) string {
+	suffix := "-scale-" + profile
+	if excess := len(function) + len(suffix) - maxCronJobName; excess > 0 {
+		function = strings.TrimRight(function[:len(function)-excess], "-")
+	}
+	return function + suffix
+}
+
+// buildScaleCronJob renders the CronJob running the deployer's scale
+// command with the bounds of the profile on its schedule.
+func buildScaleCronJob(cfg *EnvConfig, profile scaleProfile) *unstructured.Unstructured {
+	labels := map[string]any{
+		"kdex.dev/function": cfg.FunctionName,
+		scaleProfileLabel:   profile.Name,
+	}
+	env := []any{
+		map[string]any{"name": "FUNCTION_NAME", "value": cfg.FunctionName},
+		map[string]any{"name": "FUNCTION_NAMESPACE", "value": cfg.FunctionNamespace},
+		map[string]any{"name": "SCALE_PROFILE", "value": profile.Name},
+	}
+	if profile.MinScale != nil {
+		env = append(env, map[string]any{"name": "SCALING_MIN_SCALE", "value": strconv.FormatInt(*profile.MinScale, 10)})
+	}
+	if profile.MaxScale != nil {
+		env = append(env, map[string]any{"name": "SCALING_MAX_SCALE", "value": strconv.FormatInt(*profile.MaxScale, 10)})
+	}
+
+	podSpec := map[string]any{
+		"restartPolicy": "OnFailure",
+		"containers": []any{
+			map[string]any{
+				"name":  "scaler",
+				"image": cfg.JobImage,
+				"args":  []any{"scale"},
+				"env":   env,
+			},
+		},
+	}
+	if cfg.JobServiceAccount != "" {
+		podSpec["serviceAccountName"] = cfg.JobServiceAccount
+	}
+
+	spec := map[string]any{
+		"schedule": profile.Schedule,
+		// A later profile supersedes a run that is still going
+		"concurrencyPolicy": "Replace",
+		// A run missed by less than an hour still applies
+		"startingDeadlineSeconds":    int64(3600),
+		"successfulJobsHistoryLimit": int64(1),
+		"failedJobsHistoryLimit":     int64(1),
+		"jobTemplate": map[string]any{
+			"metadata": map[string]any{"labels": labels},
+			"spec": map[string]any{
+				"backoffLimit": int64(3),
+				"template": map[string]any{
+					"metadata": map[string]any{"labels": labels},
+					"spec":     podSpec,
+				},
+			},
+		},
+	}
+	if profile.TimeZone != "" {
+		spec["timeZone"] = profile.TimeZone
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": cronJobGVR.GroupVersion().String(),
+			"kind":       "CronJob",
+			"metadata": map[string]any{
+				"name":      scaleCronJobName(cfg.FunctionName, profile.Name),
+				"namespace": cfg.FunctionNamespace,
+				"labels":    labels,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// provisionScaleSchedule reconciles a CronJob per profile of
+// SCALING_SCHEDULE_JSON and removes those of profiles no longer listed.
+// When the schedule is dropped altogether, the bounds its runs left on the
+// Service go too, unless the SCALING_* settings now own them.
+func provisionScaleSchedule(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	profiles, err := parseScaleProfiles(cfg)
+	if err != nil {
+		return err
+	}
+	cronJobs := client.Resource(cronJobGVR).Namespace(cfg.FunctionNamespace)
+	current := map[string]bool{}
+	force := true
+	for _, profile := range profiles {
+		cronJob := buildScaleCronJob(cfg, profile)
+		data, err := json.Marshal(cronJob)
+		if err != nil {
+			return err
+		}
+		_, err = cronJobs.Patch(ctx, cronJob.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kdex-knative-deployer",
+			Force:        &force,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply cronjobs %s: %w", cronJob.GetName(), err)
+		}
+		current[cronJob.GetName()] = true
+		logf("Scale profile %s on %s\n", profile.Name, profile.Schedule)
+	}
+
+	list, err := cronJobs.List(ctx, metav1.ListOptions{
+		LabelSelector: "kdex.dev/function=" + cfg.FunctionName + "," + scaleProfileLabel,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list cronjobs: %w", err)
+	}
+	removed := 0
+	for _, item := range list.Items {
+		if current[item.GetName()] {
+			continue
+		}
+		err := cronJobs.Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete cronjobs %s: %w", item.GetName(), err)
+		}
+		removed++
+	}
+	if len(profiles) > 0 || removed == 0 {
+		return nil
+	}
+
+	// The scale runs patch the revision template under their own field
+	// manager, so the deployer's apply leaves their bounds behind
+	annotations := map[string]any{}
+	if strings.TrimSpace(cfg.ScalingMinScale) == "" {
+		annotations[minScaleAnnotation] = nil
+	}
+	if strings.TrimSpace(cfg.ScalingMaxScale) == "" {
+		annotations[maxScaleAnnotation] = nil
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(templateAnnotationsPatch(annotations))
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-scaler",
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to clear scale profile bounds: %w", err)
+	}
+	return nil
+}
+
+// runScale is run by the CronJobs of SCALING_SCHEDULE_JSON to set the
+// scale bounds of their profile.
+func runScale() error {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return scaleService(ctx, client, cfg, os.Getenv("SCALE_PROFILE"))
+}
+
+// scaleService sets the min-scale and max-scale annotations of
+// SCALING_MIN_SCALE and SCALING_MAX_SCALE on the revision template, where
+// Knative reads the bounds from. This rolls out a new revision with the
+// same code and config. It patches rather than applies, leaving the rest
+// of the Service to the deployer.
+func scaleService(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, profile string) error {
+	annotations := map[string]any{}
+	for _, s := range []struct {
+		env, annotation, value string
+	}{
+		{"SCALING_MIN_SCALE", minScaleAnnotation, cfg.ScalingMinScale},
+		{"SCALING_MAX_SCALE", maxScaleAnnotation, cfg.ScalingMaxScale},
+	} {
+		value := strings.TrimSpace(s.value)
+		if value == "" {
+			continue
+		}
+		normalized, err := normalizeScalingValue(value, scalingCount)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", s.env, value, err)
+		}
+		annotations[s.annotation] = normalized
+	}
+	if len(annotations) == 0 {
+		return fmt.Errorf("SCALING_MIN_SCALE or SCALING_MAX_SCALE is required")
+	}
+
+	data, err := json.Marshal(templateAnnotationsPatch(annotations))
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-scaler",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scale knative service: %w", err)
+	}
+	logf("Scale profile %s applied to %s: min-scale %v, max-scale %v\n", profile, cfg.FunctionName,
+		annotationOr(annotations, minScaleAnnotation), annotationOr(annotations, maxScaleAnnotation))
+	return nil
+}
+
+var templateAnnotationsPath = []string{"spec", "template", "metadata", "annotations"}
+
+// templateAnnotationsPatch is the merge patch setting annotations on the
+// revision template of a Service, removing those set to nil.
+func templateAnnotationsPatch(annotations map[string]any) map[string]any {
+	patch := map[string]any{}
+	_ = unstructured.SetNestedMap(patch, annotations, templateAnnotationsPath...)
+	return patch
+}
+
+func annotationOr(annotations map[string]any, key string) any {
+	if value, ok := annotations[key]; ok {
+		return value
+	}
+	return "unchanged"
+}
+
+// cronDescriptorFields are the five fields of the descriptors CronJob
+// accepts.
+var cronDescriptorFields = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	cronDayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// cronSchedule is a five field cron expression, each field a set of bits.
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDayOfMonth and anyDayOfWeek tell a field given as *: as in cron,
+	// a day matches both day fields when either is *, and either of them
+	// otherwise.
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCron parses the schedule of a CronJob: five fields of values, names,
+// ranges, steps and lists, or a descriptor such as @daily.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if fields, ok := cronDescriptorFields[expr]; ok {
+		expr = fields
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: expected five cron fields or a descriptor such as @daily", expr)
+	}
+	schedule := &cronSchedule{
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for _, f := range []struct {
+		bits     *uint64
+		value    string
+		min, max int
+		names    map[string]int
+	}{
+		{&schedule.minute, fields[0], 0, 59, nil},
+		{&schedule.hour, fields[1], 0, 23, nil},
+		{&schedule.dayOfMonth, fields[2], 1, 31, nil},
+		{&schedule.month, fields[3], 1, 12, cronMonthNames},
+		{&schedule.dayOfWeek, fields[4], 0, 7, cronDayNames},
+	} {
+		if *f.bits, err = parseCronField(f.value, f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var set uint64
+	for part := range strings.SplitSeq(field, ",") {
+		span, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepValue)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+
+		low, high := min, max
+		if span != "*" {
+			first, last, isRange := strings.Cut(span, "-")
+			var err error
+			if low, err = cronValue(first, names); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if high, err = cronValue(last, names); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				high = low
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func cronValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return n, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	if s.month&(1<<t.Month()) == 0 {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := s.dayOfWeek&(1<<t.Weekday()) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// previous is the last time at or before now, in the location of now, the
+// schedule fires, looking no further back than earliest.
+func (s *cronSchedule) previous(now, earliest time.Time) (time.Time, bool) {
+	location := now.Location()
+	t := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, location)
+	for !t.Before(earliest) {
+		switch {
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location).Add(-time.Minute)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location).Add(-time.Minute)
+		case s.minute&(1<<t.Minute()) == 0:
+			// Straight to the previous minute of the hour that fires
+			below := s.minute & (1<<t.Minute() - 1)
+			if below == 0 {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location).Add(-time.Minute)
+			} else {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), bits.Len64(below)-1, 0, 0, location)
+			}
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,186 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseScaleProfiles(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: ""},
+		{value: `[{"name":"day","schedule":"0 8 * * mon-fri","timeZone":"Europe/Paris","minScale":3},{"name":"night","schedule":"@daily","minScale":0}]`, want: 2},
+		{value: `[{"name":"day","schedule":"0 8 * * *"}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 8 * *","minScale":1}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 25 * * *","minScale":1}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 8 * * *","timeZone":"Mars/Olympus","minScale":1}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 8 * * *","minScale":5,"maxScale":2}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 8 * * *","minScale":1},{"name":"day","schedule":"0 9 * * *","minScale":2}]`, wantErr: true},
+		{value: `[{"name":"Day","schedule":"0 8 * * *","minScale":1}]`, wantErr: true},
+		{value: `[{"name":"day","schedule":"0 8 * * *","min":1}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseScaleProfiles(&EnvConfig{ScalingScheduleJSON: tt.value})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: expected %d profiles, got %d", tt.value, tt.want, len(got))
+		}
+	}
+}
+
+func TestCronSchedulePrevious(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 17, 30, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 14, 10, 15, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)},
+		{"0 20 * * *", time.Date(2026, 10, 13, 20, 0, 0, 0, time.UTC)},
+		{"30 9 * * sat,sun", time.Date(2026, 10, 11, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 7", time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.schedule)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.schedule, err)
+			continue
+		}
+		got, ok := schedule.previous(now, now.Add(-scaleProfileLookback))
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.schedule, tt.want, got, ok)
+		}
+	}
+}
+
+func TestScaleProfileAnnotations(t *testing.T) {
+	cfg := &EnvConfig{ScalingScheduleJSON: `[
+		{"name":"business-hours","schedule":"0 8 * * 1-5","timeZone":"Europe/Paris","minScale":3,"maxScale":10},
+		{"name":"overnight","schedule":"0 20 * * *","timeZone":"Europe/Paris","minScale":0}
+	]`}
+
+	// 10:00 in Paris on a Wednesday
+	day := scaleProfileAnnotations(cfg, time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC))
+	if day[minScaleAnnotation] != "3" || day[maxScaleAnnotation] != "10" {
+		t.Errorf("Expected the business hours bounds, got %v", day)
+	}
+
+	// 22:00 in Paris
+	night := scaleProfileAnnotations(cfg, time.Date(2026, 10, 14, 20, 0, 0, 0, time.UTC))
+	if night[minScaleAnnotation] != "0" {
+		t.Errorf("Expected the overnight min-scale, got %v", night)
+	}
+	if _, ok := night[maxScaleAnnotation]; ok {
+		t.Errorf("Expected max-scale to be left to SCALING_MAX_SCALE, got %v", night)
+	}
+}
+
+func TestBuildScaleCronJob(t *testing.T) {
+	minScale := int64(3)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", JobImage: "deployer:1", JobServiceAccount: "deployer"}
+	cronJob := buildScaleCronJob(cfg, scaleProfile{Name: "day", Schedule: "0 8 * * 1-5", TimeZone: "Europe/Paris", MinScale: &minScale})
+
+	if cronJob.GetName() != "fn-scale-day" {
+		t.Errorf("Expected the cronjob to be named after the profile, got %s", cronJob.GetName())
+	}
+	if zone, _, _ := unstructured.NestedString(cronJob.Object, "spec", "timeZone"); zone != "Europe/Paris" {
+		t.Errorf("Expected the profile time zone, got %q", zone)
+	}
+	containers, _, _ := unstructured.NestedSlice(cronJob.Object, "spec", "jobTemplate", "spec", "template", "spec", "containers")
+	env, _ := containers[0].(map[string]any)["env"].([]any)
+	found := false
+	for _, e := range env {
+		if v := e.(map[string]any); v["name"] == "SCALING_MIN_SCALE" && v["value"] == "3" {
+			found = true
+		}
+		if v := e.(map[string]any); v["name"] == "SCALING_MAX_SCALE" {
+			t.Errorf("Expected no max-scale for the profile, got %v", v)
+		}
+	}
+	if !found {
+		t.Errorf("Expected the profile min-scale in the env, got %v", env)
+	}
+
+	if name := scaleCronJobName("a-function-with-a-rather-long-name-indeed", "business-hours"); len(name) > maxCronJobName {
+		t.Errorf("Expected the cronjob name to fit, got %s", name)
+	}
+}
+
+func TestProvisionScaleScheduleRemoves(t *testing.T) {
+	ctx := context.Background()
+	service := newObject("serving.knative.dev/v1", "Service", "default", "fn", nil)
+	_ = unstructured.SetNestedStringMap(service.Object, map[string]string{minScaleAnnotation: "3", maxScaleAnnotation: "10"}, templateAnnotationsPath...)
+	client := newFakeDynamicClient(
+		service,
+		newObject("batch/v1", "CronJob", "default", "fn-scale-day", map[string]string{"kdex.dev/function": "fn", scaleProfileLabel: "day"}),
+		newObject("batch/v1", "CronJob", "default", "other", map[string]string{"kdex.dev/function": "fn"}),
+	)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", ScalingMaxScale: "10"}
+
+	if err := provisionScaleSchedule(ctx, client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cronJobs := client.Resource(cronJobGVR).Namespace("default")
+	if _, err := cronJobs.Get(ctx, "fn-scale-day", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the profile cronjob to be removed, got %v", err)
+	}
+	if _, err := cronJobs.Get(ctx, "other", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected other cronjobs to be kept, got %v", err)
+	}
+
+	got, err := client.Resource(knativeServiceGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations, _, _ := unstructured.NestedStringMap(got.Object, templateAnnotationsPath...)
+	if _, ok := annotations[minScaleAnnotation]; ok {
+		t.Errorf("Expected the profile min-scale to be cleared, got %v", annotations)
+	}
+	if annotations[maxScaleAnnotation] != "10" {
+		t.Errorf("Expected max-scale to be kept for SCALING_MAX_SCALE, got %v", annotations)
+	}
+}
+
+func TestScaleService(t *testing.T) {
+	ctx := context.Background()
+	service := newObject("serving.knative.dev/v1", "Service", "default", "fn", nil)
+	service.SetAnnotations(map[string]string{deployIDAnnotation: "rollout-1"})
+	client := newFakeDynamicClient(service)
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", ScalingMinScale: "2"}
+
+	if err := scaleService(ctx, client, cfg, "business-hours"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err := client.Resource(knativeServiceGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations, _, _ := unstructured.NestedStringMap(got.Object, templateAnnotationsPath...)
+	if annotations[minScaleAnnotation] != "2" {
+		t.Errorf("Expected the bound on the revision template, got %v", annotations)
+	}
+	if _, ok := got.GetAnnotations()[minScaleAnnotation]; ok || got.GetAnnotations()[deployIDAnnotation] != "rollout-1" {
+		t.Errorf("Expected the Service annotations to be left alone, got %v", got.GetAnnotations())
+	}
+}
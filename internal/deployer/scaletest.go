@@ -0,0 +1,347 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// podAutoscalerGVR is the autoscaler state Knative keeps per revision.
+var podAutoscalerGVR = schema.GroupVersionResource{
+	Group:    "autoscaling.internal.knative.dev",
+	Version:  "v1alpha1",
+	Resource: "podautoscalers",
+}
+
+// defaultPanicThreshold is Knative's panic-threshold-percentage.
+const defaultPanicThreshold = 200.0
+
+// scaleTestOptions are the flags of the scale-test command.
+type scaleTestOptions struct {
+	Concurrency  int
+	Duration     time.Duration
+	Path         string
+	TargetPods   int64
+	SampleEvery  time.Duration
+	MaxErrorRate float64
+}
+
+// scaleSample is the autoscaler state at a point of the test.
+type scaleSample struct {
+	Elapsed      time.Duration
+	ReadyPods    int64
+	DesiredScale int64
+}
+
+// scaleTestReport is what the scale-test command prints.
+type scaleTestReport struct {
+	Function    string `json:"function"`
+	Namespace   string `json:"namespace"`
+	Revision    string `json:"revision"`
+	Concurrency int    `json:"concurrency"`
+	Duration    string `json:"duration"`
+	Requests    int    `json:"requests"`
+	Errors      int    `json:"errors"`
+	LatencyP50  string `json:"latencyP50,omitempty"`
+	LatencyP99  string `json:"latencyP99,omitempty"`
+	PeakPods    int64  `json:"peakPods"`
+	// TimeToPods is when the revision first had each number of ready pods.
+	TimeToPods []podMilestone `json:"timeToPods,omitempty"`
+	// PanicEntries counts the times the autoscaler went into panic mode,
+	// inferred from the desired scale reaching the panic threshold of the
+	// ready pods.
+	PanicEntries int               `json:"panicEntries"`
+	Samples      []scaleSampleJSON `json:"samples"`
+	Checks       []scaleCheck      `json:"checks"`
+}
+
+type podMilestone struct {
+	Pods  int64  `json:"pods"`
+	After string `json:"after"`
+}
+
+type scaleSampleJSON struct {
+	Elapsed      string `json:"elapsed"`
+	ReadyPods    int64  `json:"readyPods"`
+	DesiredScale int64  `json:"desiredScale"`
+}
+
+// scaleCheck is whether the ramp behaved as the scaling settings intend.
+type scaleCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+func runScaleTest(args []string) error {
+	flags := flag.NewFlagSet("scale-test", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", 50, "requests kept in flight against the function")
+	duration := flags.Duration("duration", time.Minute, "how long to drive load")
+	path := flags.String("path", "/", "path requested, relative to the function URL")
+	targetPods := flags.Int64("target-pods", 0, "number of ready pods the revision is expected to reach")
+	sampleEvery := flags.Duration("sample-interval", time.Second, "how often to sample the autoscaler")
+	maxErrorRate := flags.Float64("max-error-rate", 0.01, "highest share of failed requests that passes")
+	output := flags.String("output", "json", "report format: json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 || *duration <= 0 || *sampleEvery <= 0 {
+		return fmt.Errorf("--concurrency, --duration and --sample-interval must be positive")
+	}
+	if *output != "json" && *output != "yaml" {
+		return fmt.Errorf("unknown output format: %s", *output)
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	opts := scaleTestOptions{
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Path:         *path,
+		TargetPods:   *targetPods,
+		SampleEvery:  *sampleEvery,
+		MaxErrorRate: *maxErrorRate,
+	}
+	report, err := scaleTest(context.Background(), client, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if *output == "yaml" {
+		data, err = yaml.Marshal(report)
+	} else {
+		data, err = json.MarshalIndent(report, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(data); err != nil {
+		return err
+	}
+
+	for _, check := range report.Checks {
+		if !check.Passed {
+			return fmt.Errorf("scale test failed: %s: %s", check.Name, check.Detail)
+		}
+	}
+	return nil
+}
+
+// scaleTest drives load against the ready revision of the function while
+// sampling its autoscaler, and reports how it ramped.
+func scaleTest(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, opts scaleTestOptions) (*scaleTestReport, error) {
+	service, err := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get knative service: %w", err)
+	}
+	ready, msg, url := parseKnativeStatus(service)
+	if !ready {
+		return nil, fmt.Errorf("service is not ready: %s", msg)
+	}
+	revision, _, _ := unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+	target := strings.TrimSuffix(url, "/") + "/" + strings.TrimPrefix(opts.Path, "/")
+	logf("Driving %d concurrent requests against %s for %s\n", opts.Concurrency, target, opts.Duration)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var latencies []time.Duration
+	var errs int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				latency, err := timeRequest(ctx, target)
+				if ctx.Err() != nil {
+					// Cut short by the end of the test
+					return
+				}
+				mu.Lock()
+				if err != nil {
+					errs++
+				} else {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	samples := sampleAutoscaler(ctx, client.Resource(podAutoscalerGVR).Namespace(cfg.FunctionNamespace), revision, opts.SampleEvery)
+	wg.Wait()
+
+	report := &scaleTestReport{
+		Function:    cfg.FunctionName,
+		Namespace:   cfg.FunctionNamespace,
+		Revision:    revision,
+		Concurrency: opts.Concurrency,
+		Duration:    opts.Duration.String(),
+		Requests:    len(latencies) + errs,
+		Errors:      errs,
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		report.LatencyP50 = percentile(latencies, 50).String()
+		report.LatencyP99 = percentile(latencies, 99).String()
+	}
+	summarizeRamp(report, samples, panicThreshold(cfg))
+	report.Checks = scaleChecks(cfg, opts, report, samples)
+	return report, nil
+}
+
+// timeRequest requests url, failing on server errors.
+func timeRequest(ctx context.Context, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return 0, fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// sampleAutoscaler reads the PodAutoscaler of the revision every interval
+// until ctx is done.
+func sampleAutoscaler(ctx context.Context, autoscalers dynamic.ResourceInterface, revision string, interval time.Duration) []scaleSample {
+	start := time.Now()
+	samples := []scaleSample{}
+	for {
+		// The test context ends the sampling, not the reads
+		pa, err := autoscalers.Get(context.WithoutCancel(ctx), revision, metav1.GetOptions{})
+		if err != nil {
+			warnf("failed to get pod autoscaler: %v\n", err)
+		} else {
+			actual, _, _ := unstructured.NestedInt64(pa.Object, "status", "actualScale")
+			desired, _, _ := unstructured.NestedInt64(pa.Object, "status", "desiredScale")
+			samples = append(samples, scaleSample{Elapsed: time.Since(start), ReadyPods: actual, DesiredScale: desired})
+		}
+
+		select {
+		case <-ctx.Done():
+			return samples
+		case <-time.After(interval):
+		}
+	}
+}
+
+// summarizeRamp fills in the peak, the time to each pod count and the
+// panic mode entries from the samples.
+func summarizeRamp(report *scaleTestReport, samples []scaleSample, threshold float64) {
+	panicking := false
+	for _, s := range samples {
+		report.Samples = append(report.Samples, scaleSampleJSON{
+			Elapsed:      s.Elapsed.Round(time.Millisecond).String(),
+			ReadyPods:    s.ReadyPods,
+			DesiredScale: s.DesiredScale,
+		})
+		for pods := report.PeakPods + 1; pods <= s.ReadyPods; pods++ {
+			report.TimeToPods = append(report.TimeToPods, podMilestone{Pods: pods, After: s.Elapsed.Round(time.Millisecond).String()})
+		}
+		report.PeakPods = max(report.PeakPods, s.ReadyPods)
+
+		// Knative panics once the load calls for threshold percent of the
+		// ready pods
+		panicNow := s.ReadyPods > 0 && float64(s.DesiredScale) >= float64(s.ReadyPods)*threshold/100
+		if panicNow && !panicking {
+			report.PanicEntries++
+		}
+		panicking = panicNow
+	}
+}
+
+// scaleChecks compares the ramp with what the scaling settings intend.
+func scaleChecks(cfg *EnvConfig, opts scaleTestOptions, report *scaleTestReport, samples []scaleSample) []scaleCheck {
+	checks := []scaleCheck{}
+
+	if opts.TargetPods > 0 {
+		check := scaleCheck{Name: "target-pods", Passed: report.PeakPods >= opts.TargetPods}
+		if check.Passed {
+			check.Detail = fmt.Sprintf("reached %d pods after %s", opts.TargetPods, report.TimeToPods[opts.TargetPods-1].After)
+		} else {
+			check.Detail = fmt.Sprintf("peaked at %d of %d pods", report.PeakPods, opts.TargetPods)
+		}
+		checks = append(checks, check)
+	}
+
+	if maxScale, err := strconv.ParseInt(strings.TrimSpace(cfg.ScalingMaxScale), 10, 64); err == nil && maxScale > 0 {
+		checks = append(checks, scaleCheck{
+			Name:   "max-scale",
+			Passed: report.PeakPods <= maxScale,
+			Detail: fmt.Sprintf("peaked at %d pods with SCALING_MAX_SCALE %d", report.PeakPods, maxScale),
+		})
+	}
+
+	if minScale, err := strconv.ParseInt(strings.TrimSpace(cfg.ScalingMinScale), 10, 64); err == nil && minScale > 0 && len(samples) > 0 {
+		lowest := samples[0].ReadyPods
+		for _, s := range samples {
+			lowest = min(lowest, s.ReadyPods)
+		}
+		checks = append(checks, scaleCheck{
+			Name:   "min-scale",
+			Passed: lowest >= minScale,
+			Detail: fmt.Sprintf("dropped to %d pods with SCALING_MIN_SCALE %d", lowest, minScale),
+		})
+	}
+
+	rate := 0.0
+	if report.Requests > 0 {
+		rate = float64(report.Errors) / float64(report.Requests)
+	}
+	checks = append(checks, scaleCheck{
+		Name:   "error-rate",
+		Passed: rate <= opts.MaxErrorRate,
+		Detail: fmt.Sprintf("%d of %d requests failed", report.Errors, report.Requests),
+	})
+	return checks
+}
+
+// panicThreshold is the SCALING_PANIC_THRESHOLD_PERCENTAGE of the function,
+// or Knative's default.
+func panicThreshold(cfg *EnvConfig) float64 {
+	if cfg.ScalingPanicThresholdPercentage == "" {
+		return defaultPanicThreshold
+	}
+	value, err := normalizeScalingValue(strings.TrimSpace(cfg.ScalingPanicThresholdPercentage), scalingThreshold)
+	if err != nil {
+		return defaultPanicThreshold
+	}
+	threshold, _ := strconv.ParseFloat(value, 64)
+	return threshold
+}
+
+// percentile picks the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)].Round(time.Millisecond)
+}
@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSummarizeRamp(t *testing.T) {
+	samples := []scaleSample{
+		{Elapsed: 0, ReadyPods: 1, DesiredScale: 1},
+		{Elapsed: time.Second, ReadyPods: 1, DesiredScale: 3},
+		{Elapsed: 2 * time.Second, ReadyPods: 3, DesiredScale: 4},
+		{Elapsed: 3 * time.Second, ReadyPods: 4, DesiredScale: 8},
+		{Elapsed: 4 * time.Second, ReadyPods: 8, DesiredScale: 8},
+	}
+
+	report := &scaleTestReport{}
+	summarizeRamp(report, samples, defaultPanicThreshold)
+	if report.PeakPods != 8 || len(report.TimeToPods) != 8 {
+		t.Fatalf("Expected a peak of 8 pods with a milestone each, got %d %v", report.PeakPods, report.TimeToPods)
+	}
+	if report.TimeToPods[2] != (podMilestone{Pods: 3, After: "2s"}) {
+		t.Errorf("Expected 3 pods after 2s, got %+v", report.TimeToPods[2])
+	}
+	if report.PanicEntries != 2 {
+		t.Errorf("Expected two panic mode entries, got %d", report.PanicEntries)
+	}
+}
+
+func TestScaleChecks(t *testing.T) {
+	cfg := &EnvConfig{ScalingMaxScale: "5", ScalingMinScale: "1"}
+	samples := []scaleSample{{ReadyPods: 1}, {ReadyPods: 6}}
+	report := &scaleTestReport{Requests: 100, Errors: 2}
+	summarizeRamp(report, samples, defaultPanicThreshold)
+
+	checks := scaleChecks(cfg, scaleTestOptions{TargetPods: 4, MaxErrorRate: 0.01}, report, samples)
+	passed := map[string]bool{}
+	for _, c := range checks {
+		passed[c.Name] = c.Passed
+	}
+	if !passed["target-pods"] || passed["max-scale"] || !passed["min-scale"] || passed["error-rate"] {
+		t.Errorf("Unexpected checks: %+v", checks)
+	}
+}
+
+func TestPanicThreshold(t *testing.T) {
+	if got := panicThreshold(&EnvConfig{}); got != defaultPanicThreshold {
+		t.Errorf("Expected the default threshold, got %v", got)
+	}
+	if got := panicThreshold(&EnvConfig{ScalingPanicThresholdPercentage: "150%"}); got != 150 {
+		t.Errorf("Expected 150, got %v", got)
+	}
+}
+
+func TestScaleTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	_ = unstructured.SetNestedField(service.Object, map[string]any{
+		"url":                     server.URL,
+		"latestReadyRevisionName": "myfunc-00001",
+		"conditions":              []any{map[string]any{"type": "Ready", "status": "True"}},
+	}, "status")
+	pa := newObject("autoscaling.internal.knative.dev/v1alpha1", "PodAutoscaler", "myns", "myfunc-00001", nil)
+	_ = unstructured.SetNestedField(pa.Object, map[string]any{"actualScale": int64(2), "desiredScale": int64(2)}, "status")
+
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+	opts := scaleTestOptions{Concurrency: 2, Duration: 50 * time.Millisecond, Path: "/", TargetPods: 2, SampleEvery: 10 * time.Millisecond, MaxErrorRate: 0.01}
+	report, err := scaleTest(context.Background(), newFakeDynamicClient(service, pa), cfg, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Revision != "myfunc-00001" || report.Requests == 0 || report.Errors != 0 || report.PeakPods != 2 || len(report.Samples) == 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	for _, c := range report.Checks {
+		if !c.Passed {
+			t.Errorf("Expected check %s to pass: %s", c.Name, c.Detail)
+		}
+	}
+}
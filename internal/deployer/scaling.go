@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scalingFormat is the kind of value a scaling annotation holds, which
+// decides its canonical form.
+type scalingFormat int
+
+const (
+	// scalingCount is a non-negative pod count.
+	scalingCount scalingFormat = iota
+	// scalingNumber is a positive number.
+	scalingNumber
+	// scalingDuration is a Go duration, rendered as time.Duration.String.
+	scalingDuration
+	// scalingPercent is a percentage, given as "70", "70%" or a fraction
+	// like "0.7", rendered as a bare number of percent.
+	scalingPercent
+	// scalingThreshold is a percentage that may exceed 100, given as "200"
+	// or "200%".
+	scalingThreshold
+	// scalingMetric is one of the autoscaler metrics.
+	scalingMetric
+)
+
+var scalingAnnotations = []struct {
+	env        string
+	annotation string
+	value      func(cfg *EnvConfig) string
+	format     scalingFormat
+}{
+	{"SCALING_ACTIVATION_SCALE", "autoscaling.knative.dev/activation-scale", func(cfg *EnvConfig) string { return cfg.ScalingActivationScale }, scalingCount},
+	{"SCALING_INITIAL_SCALE", "autoscaling.knative.dev/initial-scale", func(cfg *EnvConfig) string { return cfg.ScalingInitialScale }, scalingCount},
+	{"SCALING_MAX_SCALE", "autoscaling.knative.dev/max-scale", func(cfg *EnvConfig) string { return cfg.ScalingMaxScale }, scalingCount},
+	{"SCALING_METRIC", "autoscaling.knative.dev/metric", func(cfg *EnvConfig) string { return cfg.ScalingMetric }, scalingMetric},
+	{"SCALING_MIN_SCALE", "autoscaling.knative.dev/min-scale", func(cfg *EnvConfig) string { return cfg.ScalingMinScale }, scalingCount},
+	{"SCALING_PANIC_THRESHOLD_PERCENTAGE", "autoscaling.knative.dev/panic-threshold-percentage", func(cfg *EnvConfig) string { return cfg.ScalingPanicThresholdPercentage }, scalingThreshold},
+	{"SCALING_PANIC_WINDOW_PERCENTAGE", "autoscaling.knative.dev/panic-window-percentage", func(cfg *EnvConfig) string { return cfg.ScalingPanicWindowPercentage }, scalingPercent},
+	{"SCALING_SCALE_DOWN_DELAY", "autoscaling.knative.dev/scale-down-delay", func(cfg *EnvConfig) string { return cfg.ScalingScaleDownDelay }, scalingDuration},
+	{"SCALING_SCALE_TO_ZERO_POD_RETENTION_PERIOD", "autoscaling.knative.dev/scale-to-zero-pod-retention-period", func(cfg *EnvConfig) string { return cfg.ScalingScaleToZeroPodRetentionPeriod }, scalingDuration},
+	{"SCALING_TARGET", "autoscaling.knative.dev/target", func(cfg *EnvConfig) string { return cfg.ScalingTarget }, scalingNumber},
+	{"SCALING_TARGET_UTILIZATION_PERCENTAGE", "autoscaling.knative.dev/target-utilization-percentage", func(cfg *EnvConfig) string { return cfg.ScalingTargetUtilizationPercentage }, scalingPercent},
+	{"SCALING_STABLE_WINDOW", "autoscaling.knative.dev/window", func(cfg *EnvConfig) string { return cfg.ScalingStableWindow }, scalingDuration},
+}
+
+var scalingMetrics = []string{"concurrency", "rps", "cpu", "memory"}
+
+// buildScalingAnnotations renders the SCALING_* settings as autoscaling
+// annotations in canonical form, so equivalent values like "90s" and
+// "1m30s" or "0.5" and "50%" produce the same spec.
+func buildScalingAnnotations(cfg *EnvConfig) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, s := range scalingAnnotations {
+		value := strings.TrimSpace(s.value(cfg))
+		if value == "" {
+			continue
+		}
+		normalized, err := normalizeScalingValue(value, s.format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", s.env, value, err)
+		}
+		annotations[s.annotation] = normalized
+	}
+	return annotations, nil
+}
+
+func normalizeScalingValue(value string, format scalingFormat) (string, error) {
+	switch format {
+	case scalingCount:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("expected a non-negative integer")
+		}
+		return strconv.FormatInt(n, 10), nil
+	case scalingNumber:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return "", fmt.Errorf("expected a positive number")
+		}
+		return formatScalingFloat(f), nil
+	case scalingDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("expected a duration such as 90s or 1m30s")
+		}
+		return d.String(), nil
+	case scalingPercent, scalingThreshold:
+		number, isPercent := strings.CutSuffix(value, "%")
+		f, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || f <= 0 {
+			return "", fmt.Errorf("expected a positive percentage")
+		}
+		// Knative percentages start at 1, so a plain fraction is a ratio
+		if format == scalingPercent && !isPercent && f < 1 {
+			f *= 100
+		}
+		if format == scalingPercent && f > 100 {
+			return "", fmt.Errorf("expected a percentage of at most 100")
+		}
+		return formatScalingFloat(f), nil
+	case scalingMetric:
+		if name, ok := strings.CutPrefix(value, customMetricPrefix); ok {
+			if !prometheusMetricName.MatchString(name) {
+				return "", fmt.Errorf("expected a Prometheus metric name after %s", customMetricPrefix)
+			}
+			return name, nil
+		}
+		metric := strings.ToLower(value)
+		for _, m := range scalingMetrics {
+			if metric == m {
+				return metric, nil
+			}
+		}
+		return "", fmt.Errorf("expected one of %s, or %s<name>", strings.Join(scalingMetrics, ", "), customMetricPrefix)
+	default:
+		return value, nil
+	}
+}
+
+// formatScalingFloat renders f without trailing zeros or binary noise, as
+// in 50 rather than 50.00000000000001.
+func formatScalingFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e6)/1e6, 'f', -1, 64)
+}
@@ -0,0 +1,74 @@
+package deployer
+
+import (
+	"testing"
+)
+
+func TestNormalizeScalingValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		format scalingFormat
+		want   string
+	}{
+		{"05", scalingCount, "5"},
+		{"0", scalingCount, "0"},
+		{"100.0", scalingNumber, "100"},
+		{"90s", scalingDuration, "1m30s"},
+		{"1m30s", scalingDuration, "1m30s"},
+		{"0.5", scalingPercent, "50"},
+		{"50%", scalingPercent, "50"},
+		{"50", scalingPercent, "50"},
+		{"0.07", scalingPercent, "7"},
+		{"200%", scalingThreshold, "200"},
+		{"200.0", scalingThreshold, "200"},
+		{"RPS", scalingMetric, "rps"},
+		{"custom:queue_depth", scalingMetric, "queue_depth"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeScalingValue(tt.value, tt.format)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.want, got)
+		}
+	}
+
+	invalid := []struct {
+		value  string
+		format scalingFormat
+	}{
+		{"-1", scalingCount},
+		{"1.5", scalingCount},
+		{"0", scalingNumber},
+		{"soon", scalingDuration},
+		{"150%", scalingPercent},
+		{"latency", scalingMetric},
+		{"custom:queue-depth", scalingMetric},
+	}
+	for _, tt := range invalid {
+		if _, err := normalizeScalingValue(tt.value, tt.format); err == nil {
+			t.Errorf("%q: expected error", tt.value)
+		}
+	}
+}
+
+func TestBuildScalingAnnotations(t *testing.T) {
+	a, err := buildScalingAnnotations(&EnvConfig{ScalingScaleDownDelay: "90s", ScalingTargetUtilizationPercentage: "0.7"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, err := buildScalingAnnotations(&EnvConfig{ScalingScaleDownDelay: "1m30s", ScalingTargetUtilizationPercentage: "70%"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(a) != 2 || a["autoscaling.knative.dev/scale-down-delay"] != b["autoscaling.knative.dev/scale-down-delay"] ||
+		a["autoscaling.knative.dev/target-utilization-percentage"] != b["autoscaling.knative.dev/target-utilization-percentage"] {
+		t.Errorf("Expected equivalent values to normalize alike, got %v and %v", a, b)
+	}
+
+	if _, err := buildScalingAnnotations(&EnvConfig{ScalingMaxScale: "many"}); err == nil {
+		t.Error("Expected error for invalid max scale")
+	}
+}
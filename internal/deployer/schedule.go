@@ -0,0 +1,149 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+var pingSourceGVR = schema.GroupVersionResource{
+	Group:    "sources.knative.dev",
+	Version:  "v1",
+	Resource: "pingsources",
+}
+
+// cronDescriptors are the schedules PingSource accepts in place of the
+// five cron fields.
+var cronDescriptors = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// validateSchedule checks that SCHEDULE_CRON, when given, is a standard
+// five field cron expression or a descriptor such as @hourly.
+func validateSchedule(cfg *EnvConfig) error {
+	if cfg.ScheduleCron == "" {
+		if cfg.ScheduleData != "" {
+			return fmt.Errorf("SCHEDULE_DATA requires SCHEDULE_CRON")
+		}
+		return nil
+	}
+	schedule := cfg.ScheduleCron
+	// PingSource takes a leading CRON_TZ= or TZ= time zone
+	if fields := strings.Fields(schedule); len(fields) > 1 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		schedule = strings.Join(fields[1:], " ")
+	}
+	if !cronDescriptors[schedule] && len(strings.Fields(schedule)) != 5 {
+		return fmt.Errorf("invalid SCHEDULE_CRON %q: expected five cron fields or a descriptor such as @hourly", cfg.ScheduleCron)
+	}
+	return nil
+}
+
+// buildPingSource renders the PingSource sending SCHEDULE_DATA to the
+// Knative Service on SCHEDULE_CRON, owned by the Service so it is removed
+// along with it.
+func buildPingSource(cfg *EnvConfig, service *unstructured.Unstructured) *unstructured.Unstructured {
+	spec := map[string]any{
+		"schedule": cfg.ScheduleCron,
+		"sink": map[string]any{
+			"ref": map[string]any{
+				"apiVersion": service.GetAPIVersion(),
+				"kind":       service.GetKind(),
+				"name":       service.GetName(),
+			},
+		},
+	}
+	if cfg.ScheduleData != "" {
+		spec["data"] = cfg.ScheduleData
+		spec["contentType"] = "text/plain"
+		if json.Valid([]byte(cfg.ScheduleData)) {
+			spec["contentType"] = "application/json"
+		}
+	}
+
+	source := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": pingSourceGVR.GroupVersion().String(),
+			"kind":       "PingSource",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionName,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": spec,
+		},
+	}
+	source.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: service.GetAPIVersion(),
+			Kind:       service.GetKind(),
+			Name:       service.GetName(),
+			UID:        service.GetUID(),
+		},
+	})
+	return source
+}
+
+// scheduleFunction applies the PingSource for SCHEDULE_CRON. The source
+// becoming ready is left to the observer; the function is deployed either
+// way.
+func scheduleFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, service *unstructured.Unstructured) error {
+	data, err := json.Marshal(buildPingSource(cfg, service))
+	if err != nil {
+		return fmt.Errorf("failed to marshal ping source: %w", err)
+	}
+
+	force := true
+	_, err = client.Resource(pingSourceGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply ping source: %w", err)
+	}
+	return nil
+}
+
+// unscheduleFunction removes the PingSource of an earlier deploy once
+// SCHEDULE_CRON is unset.
+func unscheduleFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	// The CRD may not even be installed, which is fine
+	err := client.Resource(pingSourceGVR).Namespace(cfg.FunctionNamespace).Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ping source: %w", err)
+	}
+	return nil
+}
+
+// observeSchedule reports the schedule of the function and whether its
+// PingSource is ready, as recorded in the KDexFunction status. It returns
+// nil when the function has no schedule, or that cannot be told.
+func observeSchedule(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) map[string]any {
+	source, err := client.Resource(pingSourceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		warnf("failed to get ping source: %v\n", err)
+		return nil
+	}
+
+	cron, _, _ := unstructured.NestedString(source.Object, "spec", "schedule")
+	status, msg, _ := findCondition(source, "Ready")
+	// The message is always written, so a resolved failure clears it
+	if status != "False" {
+		msg = ""
+	}
+	return map[string]any{"cron": cron, "ready": status == "True", "message": msg}
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{ScheduleCron: "*/5 * * * *"}},
+		{cfg: EnvConfig{ScheduleCron: "@hourly", ScheduleData: `{"job":"sync"}`}},
+		{cfg: EnvConfig{ScheduleCron: "CRON_TZ=Europe/Paris 0 9 * * 1-5"}},
+		{cfg: EnvConfig{ScheduleCron: "* * * *"}, wantErr: true},
+		{cfg: EnvConfig{ScheduleCron: "@fortnightly"}, wantErr: true},
+		{cfg: EnvConfig{ScheduleData: "hello"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateSchedule(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBuildPingSource(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", ScheduleCron: "@hourly", ScheduleData: `{"job":"sync"}`}
+	service := newObject("serving.knative.dev/v1", "Service", "default", "fn", nil)
+
+	source := buildPingSource(cfg, service)
+	spec, _, _ := unstructured.NestedMap(source.Object, "spec")
+	if spec["schedule"] != "@hourly" || spec["data"] != `{"job":"sync"}` || spec["contentType"] != "application/json" {
+		t.Errorf("Unexpected ping source spec: %v", spec)
+	}
+	name, _, _ := unstructured.NestedString(spec, "sink", "ref", "name")
+	if name != "fn" {
+		t.Errorf("Expected the ping source to target the service, got %q", name)
+	}
+	if refs := source.GetOwnerReferences(); len(refs) != 1 || refs[0].Name != "fn" {
+		t.Errorf("Expected the ping source owned by the service, got %v", refs)
+	}
+
+	cfg.ScheduleData = "tick"
+	spec, _, _ = unstructured.NestedMap(buildPingSource(cfg, service).Object, "spec")
+	if spec["contentType"] != "text/plain" {
+		t.Errorf("Expected text data, got %v", spec["contentType"])
+	}
+}
+
+func TestUnscheduleFunction(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newObject("sources.knative.dev/v1", "PingSource", "default", "fn", nil))
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+
+	if err := unscheduleFunction(ctx, client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Resource(pingSourceGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the ping source to be removed, got %v", err)
+	}
+
+	// Nothing to remove
+	if err := unscheduleFunction(ctx, client, cfg); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestObserveSchedule(t *testing.T) {
+	ctx := context.Background()
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", ScheduleCron: "@hourly"}
+
+	if schedule := observeSchedule(ctx, newFakeDynamicClient(), cfg); schedule != nil {
+		t.Errorf("Expected no schedule, got %v", schedule)
+	}
+
+	source := buildPingSource(cfg, newObject("serving.knative.dev/v1", "Service", "default", "fn", nil))
+	_ = unstructured.SetNestedSlice(source.Object, []any{
+		map[string]any{"type": "Ready", "status": "False", "message": "sink not found"},
+	}, "status", "conditions")
+	schedule := observeSchedule(ctx, newFakeDynamicClient(source), cfg)
+	if schedule["cron"] != "@hourly" || schedule["ready"] != false || schedule["message"] != "sink not found" {
+		t.Errorf("Unexpected schedule: %v", schedule)
+	}
+
+	function := kdexFunctionInState("Ready")
+	update := observeStatus(cfg, knativeServiceWithReady("True"), function, map[string]map[string]any{"schedule": schedule})
+	if update == nil || update.blocks["schedule"]["cron"] != "@hourly" {
+		t.Errorf("Expected the schedule to be recorded, got %+v", update)
+	}
+
+	_ = unstructured.SetNestedMap(function.Object, map[string]any{"cron": "@hourly", "ready": false, "message": "sink not found"}, "status", "schedule")
+	if update := observeStatus(cfg, knativeServiceWithReady("True"), function, map[string]map[string]any{"schedule": schedule}); update != nil {
+		t.Errorf("Expected no update for an unchanged schedule, got %+v", update)
+	}
+}
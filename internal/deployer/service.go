@@ -0,0 +1,333 @@
+package deployer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// serviceState is what the deployer knows about the live Knative Service
+// before applying it.
+type serviceState struct {
+	// URL is the public URL of the function, used to resolve env templates.
+	URL string
+	// LatestReadyRevision is the revision serving before this deploy.
+	LatestReadyRevision string
+	// Live is the Service itself, nil before the first deploy.
+	Live *unstructured.Unstructured
+}
+
+func serviceStateOf(obj *unstructured.Unstructured) serviceState {
+	url := serviceURL(obj)
+	revision, _, _ := unstructured.NestedString(obj.Object, "status", "latestReadyRevisionName")
+	return serviceState{URL: url, LatestReadyRevision: revision, Live: obj}
+}
+
+// buildService renders the Knative Service for the function.
+func buildService(cfg *EnvConfig, state serviceState) (*unstructured.Unstructured, error) {
+	// Prepare env vars for the container
+	if err := validateForwardedEnvVars(cfg); err != nil {
+		return nil, err
+	}
+	containerEnv := buildContainerEnv(cfg, state.URL)
+
+	container := map[string]any{
+		"image": pinnedImage(cfg),
+		"env":   containerEnv,
+	}
+
+	envFrom, err := buildEnvFrom(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(envFrom) > 0 {
+		container["envFrom"] = envFrom
+	}
+
+	if err := applyRuntimeDefaults(container, cfg.FunctionRuntime); err != nil {
+		return nil, err
+	}
+	if cfg.FunctionRuntime == "" {
+		applyDetectedRuntimeDefaults(container, cfg.DetectedRuntime)
+	}
+
+	if err := applyProbes(container, cfg); err != nil {
+		return nil, err
+	}
+
+	resources, err := buildResources(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if resources != nil {
+		container["resources"] = resources
+	}
+
+	revisionSpec := map[string]any{
+		"containers": []map[string]any{container},
+	}
+
+	if err := applyVolumes(revisionSpec, container, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := applyLifecycle(container, revisionSpec, cfg); err != nil {
+		return nil, err
+	}
+
+	// Without one the revision runs as the namespace default service account
+	if cfg.FunctionServiceAccount != "" {
+		if errs := validation.IsDNS1123Subdomain(cfg.FunctionServiceAccount); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid FUNCTION_SERVICE_ACCOUNT %q: %s", cfg.FunctionServiceAccount, strings.Join(errs, "; "))
+		}
+		revisionSpec["serviceAccountName"] = cfg.FunctionServiceAccount
+	}
+
+	timeout, err := requestTimeout(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		revisionSpec["timeoutSeconds"] = int64(timeout / time.Second)
+	}
+
+	concurrency, err := containerConcurrency(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency >= 0 {
+		revisionSpec["containerConcurrency"] = concurrency
+	}
+
+	templateMetadata := map[string]any{
+		"labels": map[string]any{
+			"kdex.dev/function":   cfg.FunctionName,
+			"kdex.dev/generation": cfg.FunctionGeneration,
+		},
+	}
+	templateAnnotations := logSinkAnnotations(cfg)
+	for k, v := range customMetricAnnotations(cfg) {
+		if templateAnnotations == nil {
+			templateAnnotations = map[string]any{}
+		}
+		templateAnnotations[k] = v
+	}
+	for k, v := range sessionAffinityAnnotations(cfg) {
+		if templateAnnotations == nil {
+			templateAnnotations = map[string]any{}
+		}
+		templateAnnotations[k] = v
+	}
+	for k, v := range scaleProfileAnnotations(cfg, time.Now()) {
+		if templateAnnotations == nil {
+			templateAnnotations = map[string]any{}
+		}
+		templateAnnotations[k] = v
+	}
+	if cfg.FunctionImageDigest != "" {
+		if templateAnnotations == nil {
+			templateAnnotations = map[string]any{}
+		}
+		templateAnnotations[imageTagAnnotation] = cfg.FunctionImage
+		templateAnnotations[imageDigestAnnotation] = cfg.FunctionImageDigest
+	}
+	if len(templateAnnotations) > 0 {
+		templateMetadata["annotations"] = templateAnnotations
+	}
+
+	spec := map[string]any{
+		"template": map[string]any{
+			"metadata": templateMetadata,
+			"spec":     revisionSpec,
+		},
+	}
+
+	targets, err := parseTraffic(cfg.Traffic)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 0 {
+		traffic, err := buildTraffic(targets, state.LatestReadyRevision)
+		if err != nil {
+			return nil, err
+		}
+		spec["traffic"] = traffic
+	} else {
+		// Always own the route so a deploy after a rollback sends traffic
+		// to the new revision again
+		spec["traffic"] = []any{
+			map[string]any{"latestRevision": true, "percent": int64(100)},
+		}
+	}
+
+	// Prepare Knative Service definition
+	service := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "serving.knative.dev/v1",
+			"kind":       "Service",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionName,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": spec,
+		},
+	}
+
+	annotations, err := buildScalingAnnotations(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// On the Service rather than the template, so a redeploy without
+	// changes does not roll out a new revision
+	if cfg.DeployID != "" {
+		annotations[deployIDAnnotation] = cfg.DeployID
+	}
+
+	service.SetAnnotations(annotations)
+	applyVisibility(service, cfg)
+	if err := applyPreview(service, cfg, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := applyExtraMetadata(service, cfg); err != nil {
+		return nil, err
+	}
+
+	// A new generation alone would roll out a revision; when nothing else
+	// in the template changed, the template keeps the generation of the
+	// live one and only the Service metadata is updated
+	if state.Live != nil {
+		generation, found, _ := unstructured.NestedString(state.Live.Object, templateGenerationPath...)
+		if found && generation != cfg.FunctionGeneration {
+			if err := setTemplateGeneration(service, container, cfg, generation); err != nil {
+				return nil, err
+			}
+			if !revisionTemplateUnchanged(service, state.Live) {
+				if err := setTemplateGeneration(service, container, cfg, cfg.FunctionGeneration); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return service, nil
+}
+
+var templateGenerationPath = []string{"spec", "template", "metadata", "labels", "kdex.dev/generation"}
+
+// setTemplateGeneration sets the generation the revision template carries:
+// its label and the resource attributes of the function's traces.
+func setTemplateGeneration(service *unstructured.Unstructured, container map[string]any, cfg *EnvConfig, generation string) error {
+	if err := unstructured.SetNestedField(service.Object, generation, templateGenerationPath...); err != nil {
+		return err
+	}
+	traced := *cfg
+	traced.FunctionGeneration = generation
+	env, _ := container["env"].([]map[string]any)
+	for _, v := range tracingEnv(&traced) {
+		for _, e := range env {
+			if e["name"] == v["name"] {
+				e["value"] = v["value"]
+			}
+		}
+	}
+	return nil
+}
+
+// revisionTemplateUnchanged tells whether applying the service would leave
+// the revision template of the live one as it is. Like the diff, it only
+// compares fields the deployer sets or owns.
+func revisionTemplateUnchanged(service, live *unstructured.Unstructured) bool {
+	desired, err := normalizeObject(service.Object)
+	if err != nil {
+		return false
+	}
+	template, _, _ := unstructured.NestedMap(desired, "spec", "template")
+	liveTemplate, found, _ := unstructured.NestedMap(live.Object, "spec", "template")
+	if !found {
+		return false
+	}
+
+	changes := []fieldChange{}
+	diffValues("spec.template", template, liveTemplate, &changes)
+	if len(changes) > 0 {
+		return false
+	}
+	// Fields applied before but no longer set would change it too
+	for _, path := range ownedFieldPaths(live, "kdex-knative-deployer") {
+		if len(path) < 2 || path[0] != "spec" || path[1] != "template" {
+			continue
+		}
+		if _, found, _ := unstructured.NestedFieldNoCopy(desired, path...); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// requestTimeout parses REQUEST_TIMEOUT into the revision timeoutSeconds,
+// which Knative enforces in the queue-proxy and activator. The mesh
+// policies the deployer renders, the EnvoyFilter of the message limits
+// and the DestinationRule of the session affinity, carry it too, so none
+// disagrees with it. Bare integers are taken as seconds. A zero result
+// means no timeout was configured.
+func requestTimeout(cfg *EnvConfig) (time.Duration, error) {
+	if cfg.RequestTimeout == "" {
+		return 0, nil
+	}
+
+	var timeout time.Duration
+	if secs, err := strconv.Atoi(cfg.RequestTimeout); err == nil {
+		timeout = time.Duration(secs) * time.Second
+	} else {
+		timeout, err = time.ParseDuration(cfg.RequestTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid REQUEST_TIMEOUT %q: %w", cfg.RequestTimeout, err)
+		}
+	}
+
+	if timeout < time.Second || timeout%time.Second != 0 {
+		return 0, fmt.Errorf("invalid REQUEST_TIMEOUT %q: must be a positive whole number of seconds", cfg.RequestTimeout)
+	}
+
+	return timeout, nil
+}
+
+// maxContainerConcurrency is Knative's default container-concurrency-max-limit.
+const maxContainerConcurrency = 1000
+
+// containerConcurrency parses CONTAINER_CONCURRENCY, the hard limit of
+// requests a single pod serves at once, where 0 means unlimited. A negative
+// result means it was not configured and Knative's default applies.
+//
+// With the concurrency metric a SCALING_TARGET above the limit can never be
+// reached, so that combination is rejected.
+func containerConcurrency(cfg *EnvConfig) (int64, error) {
+	value := strings.TrimSpace(cfg.ContainerConcurrency)
+	if value == "" {
+		return -1, nil
+	}
+
+	concurrency, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || concurrency < 0 || concurrency > maxContainerConcurrency {
+		return 0, fmt.Errorf("invalid CONTAINER_CONCURRENCY %q: expected an integer from 0 to %d", cfg.ContainerConcurrency, maxContainerConcurrency)
+	}
+
+	metric := strings.ToLower(strings.TrimSpace(cfg.ScalingMetric))
+	if concurrency > 0 && cfg.ScalingTarget != "" && (metric == "" || metric == "concurrency") {
+		target, err := strconv.ParseFloat(strings.TrimSpace(cfg.ScalingTarget), 64)
+		if err == nil && target > float64(concurrency) {
+			return 0, fmt.Errorf("SCALING_TARGET %s exceeds CONTAINER_CONCURRENCY %d", cfg.ScalingTarget, concurrency)
+		}
+	}
+
+	return concurrency, nil
+}
@@ -0,0 +1,228 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "30", want: 30 * time.Second},
+		{value: "2m", want: 2 * time.Minute},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "0", wantErr: true},
+		{value: "-5s", wantErr: true},
+		{value: "1500ms", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := requestTimeout(&EnvConfig{RequestTimeout: tt.value})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("REQUEST_TIMEOUT=%q: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("REQUEST_TIMEOUT=%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("REQUEST_TIMEOUT=%q: expected %v, got %v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestBuildServiceTimeout(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "myimg",
+	}
+
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "timeoutSeconds"); found {
+		t.Error("Expected no timeoutSeconds when REQUEST_TIMEOUT is unset")
+	}
+
+	cfg.RequestTimeout = "5m"
+	service, err = buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	timeout, _, _ := unstructured.NestedInt64(service.Object, "spec", "template", "spec", "timeoutSeconds")
+	if timeout != 300 {
+		t.Errorf("Expected timeoutSeconds 300, got %d", timeout)
+	}
+
+	cfg.RequestTimeout = "bogus"
+	if _, err := buildService(cfg, serviceState{}); err == nil {
+		t.Error("Expected error for invalid REQUEST_TIMEOUT")
+	}
+}
+
+func TestContainerConcurrency(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		want    int64
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}, want: -1},
+		{cfg: EnvConfig{ContainerConcurrency: "0"}, want: 0},
+		{cfg: EnvConfig{ContainerConcurrency: "10", ScalingTarget: "8"}, want: 10},
+		{cfg: EnvConfig{ContainerConcurrency: "1", ScalingMetric: "rps", ScalingTarget: "50"}, want: 1},
+		{cfg: EnvConfig{ContainerConcurrency: "0", ScalingTarget: "50"}, want: 0},
+		{cfg: EnvConfig{ContainerConcurrency: "-1"}, wantErr: true},
+		{cfg: EnvConfig{ContainerConcurrency: "1001"}, wantErr: true},
+		{cfg: EnvConfig{ContainerConcurrency: "many"}, wantErr: true},
+		{cfg: EnvConfig{ContainerConcurrency: "10", ScalingTarget: "20"}, wantErr: true},
+		{cfg: EnvConfig{ContainerConcurrency: "10", ScalingMetric: "Concurrency", ScalingTarget: "20"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := containerConcurrency(&tt.cfg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v: expected error", tt.cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.cfg, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v: expected %d, got %d", tt.cfg, tt.want, got)
+		}
+	}
+
+	service, err := buildService(&EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimg", ContainerConcurrency: "1"}, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	concurrency, _, _ := unstructured.NestedInt64(service.Object, "spec", "template", "spec", "containerConcurrency")
+	if concurrency != 1 {
+		t.Errorf("Expected containerConcurrency 1, got %d", concurrency)
+	}
+}
+
+func TestBuildServiceServiceAccount(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:           "myfunc",
+		FunctionNamespace:      "myns",
+		FunctionImage:          "myimg",
+		FunctionServiceAccount: "myfunc-runner",
+	}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if name, _, _ := unstructured.NestedString(service.Object, "spec", "template", "spec", "serviceAccountName"); name != "myfunc-runner" {
+		t.Errorf("Expected serviceAccountName myfunc-runner, got %q", name)
+	}
+
+	cfg.FunctionServiceAccount = "Not_Valid"
+	if _, err := buildService(cfg, serviceState{}); err == nil {
+		t.Error("Expected error for invalid FUNCTION_SERVICE_ACCOUNT")
+	}
+}
+
+func TestBuildServiceKeepsUnchangedTemplate(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", FunctionImage: "myimg", FunctionGeneration: "2"}
+	live, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	liveObj, err := normalizeObject(live.Object)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	live.Object = liveObj
+	// Defaulted by Knative
+	_ = unstructured.SetNestedField(live.Object, int64(300), "spec", "template", "spec", "timeoutSeconds")
+
+	cfg.FunctionGeneration = "3"
+	cfg.ScalingMaxScale = "5"
+	service, err := buildService(cfg, serviceState{Live: live})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if generation, _, _ := unstructured.NestedString(service.Object, templateGenerationPath...); generation != "2" {
+		t.Errorf("Expected the template to keep generation 2 for a metadata change, got %q", generation)
+	}
+	if generation := service.GetLabels()["kdex.dev/generation"]; generation != "3" {
+		t.Errorf("Expected the Service to be labelled with generation 3, got %q", generation)
+	}
+
+	cfg.FunctionImage = "myimg:2"
+	service, err = buildService(cfg, serviceState{Live: live})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if generation, _, _ := unstructured.NestedString(service.Object, templateGenerationPath...); generation != "3" {
+		t.Errorf("Expected a template change to carry generation 3, got %q", generation)
+	}
+}
+
+func TestBuildServiceKeepsUnchangedTemplateWithTracing(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionImage:      "myimg",
+		FunctionGeneration: "2",
+		TracingEnabled:     "true",
+		TracingEndpoint:    "http://otel-collector:4317",
+	}
+	live, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	liveObj, err := normalizeObject(live.Object)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	live.Object = liveObj
+
+	attributes := func(service *unstructured.Unstructured) string {
+		containers, _, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "containers")
+		for _, e := range containers.([]map[string]any)[0]["env"].([]map[string]any) {
+			if e["name"] == "OTEL_RESOURCE_ATTRIBUTES" {
+				return e["value"].(string)
+			}
+		}
+		return ""
+	}
+
+	cfg.FunctionGeneration = "3"
+	service, err := buildService(cfg, serviceState{Live: live})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !revisionTemplateUnchanged(service, live) {
+		t.Error("Expected a new generation alone to leave the revision template unchanged")
+	}
+	if value := attributes(service); !strings.Contains(value, "kdex.function.generation=2") {
+		t.Errorf("Expected the traces to keep reporting generation 2, got %q", value)
+	}
+
+	cfg.FunctionImage = "myimg:2"
+	service, err = buildService(cfg, serviceState{Live: live})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value := attributes(service); !strings.Contains(value, "kdex.function.generation=3") {
+		t.Errorf("Expected a template change to report generation 3, got %q", value)
+	}
+}
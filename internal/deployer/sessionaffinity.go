@@ -0,0 +1,199 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+var destinationRuleGVR = schema.GroupVersionResource{
+	Group:    "networking.istio.io",
+	Version:  "v1",
+	Resource: "destinationrules",
+}
+
+// Kinds of SESSION_AFFINITY.
+const (
+	sessionAffinityCookie = "cookie"
+	sessionAffinityHeader = "header"
+)
+
+// Defaults of a cookie affinity given without name or lifetime. Envoy only
+// issues the cookie when it has a lifetime.
+const (
+	defaultSessionCookie    = "kdex-session"
+	defaultSessionCookieTTL = time.Hour
+)
+
+// sessionAffinity is SESSION_AFFINITY: cookie[:name[:ttl]], pinning a
+// client to a pod with a cookie the mesh issues, or header:name, hashing
+// on a header the client sends.
+type sessionAffinity struct {
+	Kind string
+	Name string
+	TTL  time.Duration
+}
+
+// parseSessionAffinity reads SESSION_AFFINITY, returning nil when unset.
+func parseSessionAffinity(cfg *EnvConfig) (*sessionAffinity, error) {
+	value := strings.TrimSpace(cfg.SessionAffinity)
+	if value == "" {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("invalid SESSION_AFFINITY %q: expected cookie[:name[:ttl]] or header:name", cfg.SessionAffinity)
+
+	parts := strings.Split(value, ":")
+	switch parts[0] {
+	case sessionAffinityCookie:
+		affinity := &sessionAffinity{Kind: sessionAffinityCookie, Name: defaultSessionCookie, TTL: defaultSessionCookieTTL}
+		if len(parts) > 3 {
+			return nil, invalid
+		}
+		if len(parts) > 1 {
+			affinity.Name = parts[1]
+			if affinity.Name == "" || !validCookieName(affinity.Name) {
+				return nil, invalid
+			}
+		}
+		if len(parts) > 2 {
+			ttl, err := time.ParseDuration(parts[2])
+			if err != nil || ttl <= 0 {
+				return nil, invalid
+			}
+			affinity.TTL = ttl
+		}
+		return affinity, nil
+	case sessionAffinityHeader:
+		if len(parts) != 2 || len(validation.IsHTTPHeaderName(parts[1])) > 0 {
+			return nil, invalid
+		}
+		return &sessionAffinity{Kind: sessionAffinityHeader, Name: http.CanonicalHeaderKey(parts[1])}, nil
+	default:
+		return nil, invalid
+	}
+}
+
+// validCookieName tells whether name is a cookie name, a token of RFC 6265.
+func validCookieName(name string) bool {
+	return (&http.Cookie{Name: name, Value: "v"}).Valid() == nil
+}
+
+// validateSessionAffinity checks SESSION_AFFINITY and that the function
+// takes the requests of its sessions as they come. With a hard
+// CONTAINER_CONCURRENCY limit Knative keeps the activator in the path to
+// spread requests over pods with room, which defeats the affinity.
+func validateSessionAffinity(cfg *EnvConfig) error {
+	affinity, err := parseSessionAffinity(cfg)
+	if err != nil || affinity == nil {
+		return err
+	}
+	concurrency, err := containerConcurrency(cfg)
+	if err != nil {
+		return err
+	}
+	if concurrency > 0 {
+		return fmt.Errorf("SESSION_AFFINITY cannot be combined with a CONTAINER_CONCURRENCY limit of %d: the activator would balance requests itself", concurrency)
+	}
+	return nil
+}
+
+// sessionAffinityAnnotations keep the activator out of the request path
+// once the revision has pods, so requests reach them through the mesh and
+// its affinity.
+func sessionAffinityAnnotations(cfg *EnvConfig) map[string]string {
+	if affinity, err := parseSessionAffinity(cfg); err != nil || affinity == nil {
+		return nil
+	}
+	return map[string]string{"autoscaling.knative.dev/target-burst-capacity": "0"}
+}
+
+// buildDestinationRule renders the DestinationRule hashing the requests to
+// the revision on the affinity cookie or header. A non-zero timeout,
+// REQUEST_TIMEOUT, is also the idle timeout of the connections of the
+// sidecars to the revision, in place of the 1 hour default of the mesh.
+func buildDestinationRule(cfg *EnvConfig, affinity *sessionAffinity, revision string, timeout time.Duration) *unstructured.Unstructured {
+	hash := map[string]any{}
+	switch affinity.Kind {
+	case sessionAffinityCookie:
+		hash["httpCookie"] = map[string]any{
+			"name": affinity.Name,
+			"path": "/",
+			"ttl":  fmt.Sprintf("%ds", int64(affinity.TTL.Seconds())),
+		}
+	case sessionAffinityHeader:
+		hash["httpHeaderName"] = affinity.Name
+	}
+	policy := map[string]any{
+		"loadBalancer": map[string]any{"consistentHash": hash},
+	}
+	if timeout > 0 {
+		policy["connectionPool"] = map[string]any{
+			"http": map[string]any{"idleTimeout": fmt.Sprintf("%ds", int64(timeout.Seconds()))},
+		}
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": destinationRuleGVR.GroupVersion().String(),
+			"kind":       "DestinationRule",
+			"metadata":   authMetadata(cfg),
+			"spec": map[string]any{
+				"host":          fmt.Sprintf("%s.%s.svc.cluster.local", revision, cfg.FunctionNamespace),
+				"trafficPolicy": policy,
+			},
+		},
+	}
+}
+
+// applySessionAffinity points the DestinationRule of SESSION_AFFINITY at
+// the revision now serving, or removes that of an earlier deploy when it
+// is unset. Knative routes to each revision by its own Service, so the
+// rule follows the rollout.
+func applySessionAffinity(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, revision string) error {
+	affinity, err := parseSessionAffinity(cfg)
+	if err != nil {
+		return err
+	}
+	rules := client.Resource(destinationRuleGVR).Namespace(cfg.FunctionNamespace)
+	if affinity == nil {
+		// The CRD may not even be installed, which is fine
+		err := rules.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete destinationrules %s: %w", cfg.FunctionName, err)
+		}
+		return nil
+	}
+
+	if revision == "" {
+		return nil
+	}
+
+	timeout, err := requestTimeout(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(buildDestinationRule(cfg, affinity, revision, timeout))
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = rules.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply destinationrules: %w", err)
+	}
+	logf("Session affinity on %s %s for revision %s\n", affinity.Kind, affinity.Name, revision)
+	return nil
+}
@@ -0,0 +1,74 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseSessionAffinity(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *sessionAffinity
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "cookie", want: &sessionAffinity{Kind: "cookie", Name: defaultSessionCookie, TTL: time.Hour}},
+		{value: "cookie:sid:30m", want: &sessionAffinity{Kind: "cookie", Name: "sid", TTL: 30 * time.Minute}},
+		{value: "header:x-user-id", want: &sessionAffinity{Kind: "header", Name: "X-User-Id"}},
+		{value: "cookie:sid:forever", wantErr: true},
+		{value: "cookie:bad name", wantErr: true},
+		{value: "header", wantErr: true},
+		{value: "ip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSessionAffinity(&EnvConfig{SessionAffinity: tt.value})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%q: expected %+v, got %+v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestValidateSessionAffinity(t *testing.T) {
+	if err := validateSessionAffinity(&EnvConfig{SessionAffinity: "cookie", ContainerConcurrency: "0"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateSessionAffinity(&EnvConfig{SessionAffinity: "cookie", ContainerConcurrency: "10"}); err == nil {
+		t.Error("Expected a hard concurrency limit to be rejected")
+	}
+}
+
+func TestBuildDestinationRule(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+	rule := buildDestinationRule(cfg, &sessionAffinity{Kind: "cookie", Name: "sid", TTL: 90 * time.Minute}, "fn-00003", 0)
+
+	host, _, _ := unstructured.NestedString(rule.Object, "spec", "host")
+	if host != "fn-00003.ns.svc.cluster.local" {
+		t.Errorf("Expected the revision service host, got %q", host)
+	}
+	ttl, _, _ := unstructured.NestedString(rule.Object, "spec", "trafficPolicy", "loadBalancer", "consistentHash", "httpCookie", "ttl")
+	if ttl != "5400s" {
+		t.Errorf("Expected the cookie lifetime in seconds, got %q", ttl)
+	}
+	if _, found, _ := unstructured.NestedMap(rule.Object, "spec", "trafficPolicy", "connectionPool"); found {
+		t.Error("Expected the mesh defaults without REQUEST_TIMEOUT")
+	}
+
+	rule = buildDestinationRule(cfg, &sessionAffinity{Kind: "header", Name: "X-Session"}, "fn-00003", 90*time.Second)
+	idle, _, _ := unstructured.NestedString(rule.Object, "spec", "trafficPolicy", "connectionPool", "http", "idleTimeout")
+	if idle != "90s" {
+		t.Errorf("Expected REQUEST_TIMEOUT as the idle timeout, got %q", idle)
+	}
+}
@@ -0,0 +1,370 @@
+package deployer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Annotations cosign puts on the layers of a signature image.
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// cosignSignatureType is the type of the simple signing payload cosign
+// signs.
+const cosignSignatureType = "cosign container image signature"
+
+// Fulcio certificate extensions holding the OIDC issuer of the signer's
+// identity, in the current DER encoded form and the legacy raw form.
+var (
+	fulcioIssuerOID       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	fulcioLegacyIssuerOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+)
+
+// signaturePolicy is what an image signature must satisfy: either be made
+// with the configured key, or keyless, by the configured identity with a
+// Fulcio certificate logged in Rekor.
+type signaturePolicy struct {
+	publicKey crypto.PublicKey
+
+	identity string
+	issuer   string
+	roots    *x509.CertPool
+	rekorKey crypto.PublicKey
+}
+
+// simpleSigning is the part of the cosign payload the deployer checks.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// rekorBundle is the transparency log entry cosign attaches to keyless
+// signatures.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+	Payload              struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogIndex       int64  `json:"logIndex"`
+		LogID          string `json:"logID"`
+	} `json:"Payload"`
+}
+
+// hashedRekord is the Rekor entry body of a signature.
+type hashedRekord struct {
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// validateSignatureVerification checks that VERIFY_IMAGE_SIGNATURE comes
+// with a COSIGN_PUBLIC_KEY, or with everything keyless verification needs.
+func validateSignatureVerification(cfg *EnvConfig) error {
+	if cfg.VerifyImageSignature != "true" || cfg.CosignPublicKey != "" {
+		return nil
+	}
+	missing := []string{}
+	for _, setting := range []struct{ name, value string }{
+		{"COSIGN_CERTIFICATE_IDENTITY", cfg.CosignCertificateIdentity},
+		{"COSIGN_CERTIFICATE_OIDC_ISSUER", cfg.CosignCertificateOIDCIssuer},
+		{"COSIGN_ROOTS", cfg.CosignRoots},
+		{"COSIGN_REKOR_PUBLIC_KEY", cfg.CosignRekorPublicKey},
+	} {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("VERIFY_IMAGE_SIGNATURE needs COSIGN_PUBLIC_KEY, or %s for keyless verification", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// loadSignaturePolicy reads the PEM files the COSIGN_* settings point to.
+func loadSignaturePolicy(cfg *EnvConfig) (*signaturePolicy, error) {
+	if cfg.CosignPublicKey != "" {
+		key, err := readPublicKey(cfg.CosignPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid COSIGN_PUBLIC_KEY: %w", err)
+		}
+		return &signaturePolicy{publicKey: key}, nil
+	}
+
+	roots, err := os.ReadFile(cfg.CosignRoots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read COSIGN_ROOTS: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		return nil, fmt.Errorf("invalid COSIGN_ROOTS %s: no PEM certificates", cfg.CosignRoots)
+	}
+	rekorKey, err := readPublicKey(cfg.CosignRekorPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid COSIGN_REKOR_PUBLIC_KEY: %w", err)
+	}
+	return &signaturePolicy{
+		identity: cfg.CosignCertificateIdentity,
+		issuer:   cfg.CosignCertificateOIDCIssuer,
+		roots:    pool,
+		rekorKey: rekorKey,
+	}, nil
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM public key", path)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifyImageSignature checks that the image at digest carries a cosign
+// signature satisfying policy. It looks for the signature where cosign
+// stores it, under the sha256-<hex>.sig tag of the image repository.
+func verifyImageSignature(ctx context.Context, keychain authn.Keychain, image, digest string, policy *signaturePolicy) error {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %q: %w", image, err)
+	}
+	signatureTag := ref.Context().Tag(strings.Replace(digest, ":", "-", 1) + ".sig")
+	signatures, err := remote.Image(signatureTag, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return fmt.Errorf("no signature found for %s@%s: %w", ref.Context(), digest, err)
+	}
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to read signatures of %s: %w", image, err)
+	}
+
+	reasons := []string{}
+	for _, layer := range manifest.Layers {
+		payload, err := signaturePayload(signatures, layer.Digest)
+		if err == nil {
+			err = policy.verify(layer.Annotations, payload, digest)
+		}
+		if err == nil {
+			return nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("no signature found for %s@%s", ref.Context(), digest)
+	}
+	return fmt.Errorf("no valid signature for %s@%s: %s", ref.Context(), digest, strings.Join(reasons, "; "))
+}
+
+func signaturePayload(signatures v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := signatures.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	r, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// verify checks one signature: that it signs digest, and that it was made
+// with the policy key or, keyless, by the policy identity.
+func (p *signaturePolicy) verify(annotations map[string]string, payload []byte, digest string) error {
+	signature, err := base64.StdEncoding.DecodeString(annotations[cosignSignatureAnnotation])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing or malformed signature")
+	}
+
+	var signed simpleSigning
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("malformed payload: %w", err)
+	}
+	if signed.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unexpected payload type %q", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s", signed.Critical.Image.DockerManifestDigest)
+	}
+
+	if p.publicKey != nil {
+		return verifySignature(p.publicKey, payload, signature)
+	}
+
+	cert, err := p.verifyCertificate(annotations, payload)
+	if err != nil {
+		return err
+	}
+	return verifySignature(cert.PublicKey, payload, signature)
+}
+
+// verifyCertificate checks the Fulcio certificate of a keyless signature:
+// the Rekor entry proving when it was used, the chain to COSIGN_ROOTS at
+// that time, and the signer's identity and issuer.
+func (p *signaturePolicy) verifyCertificate(annotations map[string]string, payload []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(annotations[cosignCertificateAnnotation]))
+	if block == nil {
+		return nil, fmt.Errorf("keyless signature without a certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	integratedTime, err := p.verifyBundle(annotations, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	chain := []byte(annotations[cosignChainAnnotation])
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		CurrentTime:   integratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %w", err)
+	}
+
+	identities := slices.Clone(cert.EmailAddresses)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	if !slices.Contains(identities, p.identity) {
+		return nil, fmt.Errorf("signed by %s, expected %s", strings.Join(identities, ", "), p.identity)
+	}
+	if issuer := certificateIssuer(cert); issuer != p.issuer {
+		return nil, fmt.Errorf("identity issued by %q, expected %q", issuer, p.issuer)
+	}
+	return cert, nil
+}
+
+// verifyBundle checks the Rekor signed entry timestamp of a keyless
+// signature and that the logged entry is this signature, returning when it
+// was logged.
+func (p *signaturePolicy) verifyBundle(annotations map[string]string, payload []byte) (time.Time, error) {
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(annotations[cosignBundleAnnotation]), &bundle); err != nil || bundle.Payload.Body == "" {
+		return time.Time{}, fmt.Errorf("keyless signature without a transparency log entry")
+	}
+
+	// Rekor signs the entry in canonical JSON, which for these fields is
+	// what encoding/json produces from a map
+	entry, err := json.Marshal(map[string]any{
+		"body":           bundle.Payload.Body,
+		"integratedTime": bundle.Payload.IntegratedTime,
+		"logIndex":       bundle.Payload.LogIndex,
+		"logID":          bundle.Payload.LogID,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := verifySignature(p.rekorKey, entry, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed transparency log entry: %w", err)
+	}
+	var rekord hashedRekord
+	if err := json.Unmarshal(body, &rekord); err != nil {
+		return time.Time{}, fmt.Errorf("malformed transparency log entry: %w", err)
+	}
+	payloadHash := sha256.Sum256(payload)
+	if rekord.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]) ||
+		rekord.Spec.Signature.Content != annotations[cosignSignatureAnnotation] {
+		return time.Time{}, fmt.Errorf("transparency log entry is for another signature")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// certificateIssuer returns the OIDC issuer Fulcio recorded in cert.
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerOID):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(fulcioLegacyIssuerOID):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// verifySignature checks a signature over the SHA-256 digest of payload,
+// or over payload itself for Ed25519.
+func verifySignature(key crypto.PublicKey, payload, signature []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return fmt.Errorf("signature does not match")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("signature does not match")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, signature) {
+			return fmt.Errorf("signature does not match")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
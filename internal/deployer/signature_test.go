@@ -0,0 +1,224 @@
+package deployer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// pushSignedImage pushes a random image and a cosign signature image for
+// it holding one layer per annotation set, returning the image and its
+// digest.
+func pushSignedImage(t *testing.T, sign func(payload []byte) map[string]string) (string, string) {
+	t.Helper()
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+
+	image := strings.TrimPrefix(server.URL, "http://") + "/kdex/fn:v1"
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := fmt.Appendf(nil, `{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`,
+		ref.Context().String(), digest.String(), cosignSignatureType)
+	signatures, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json")),
+		Annotations: sign(payload),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureTag := ref.Context().Tag(strings.Replace(digest.String(), ":", "-", 1) + ".sig")
+	if err := remote.Write(signatureTag, signatures); err != nil {
+		t.Fatal(err)
+	}
+	return image, digest.String()
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func signPayload(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestVerifyImageSignatureWithKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	image, digest := pushSignedImage(t, func(payload []byte) map[string]string {
+		return map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signPayload(t, key, payload))}
+	})
+
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	cfg := &EnvConfig{VerifyImageSignature: "true", CosignPublicKey: writePEM(t, "PUBLIC KEY", der)}
+	if err := validateSignatureVerification(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	policy, err := loadSignaturePolicy(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := verifyImageSignature(context.Background(), authn.DefaultKeychain, image, digest, policy); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err := verifyImageSignature(context.Background(), authn.DefaultKeychain, image, digest, &signaturePolicy{publicKey: &other.PublicKey}); err == nil {
+		t.Error("Expected a signature by another key to be rejected")
+	}
+
+	unsigned := "sha256:" + strings.Repeat("0", 64)
+	if err := verifyImageSignature(context.Background(), authn.DefaultKeychain, image, unsigned, policy); err == nil {
+		t.Error("Expected an image without signature to be rejected")
+	}
+}
+
+func TestVerifyImageSignatureKeyless(t *testing.T) {
+	now := time.Now()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	signerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer, _ := asn1.Marshal("https://token.actions.githubusercontent.com")
+	leafDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(9 * time.Minute),
+		EmailAddresses:  []string{"ci@kdex.dev"},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerOID, Value: issuer}},
+	}, ca, &signerKey.PublicKey, caKey)
+
+	rekorKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	image, digest := pushSignedImage(t, func(payload []byte) map[string]string {
+		signature := base64.StdEncoding.EncodeToString(signPayload(t, signerKey, payload))
+		payloadHash := sha256.Sum256(payload)
+		body, _ := json.Marshal(map[string]any{
+			"apiVersion": "0.0.1",
+			"kind":       "hashedrekord",
+			"spec": map[string]any{
+				"data":      map[string]any{"hash": map[string]any{"algorithm": "sha256", "value": hex.EncodeToString(payloadHash[:])}},
+				"signature": map[string]any{"content": signature},
+			},
+		})
+		entry := map[string]any{
+			"body":           base64.StdEncoding.EncodeToString(body),
+			"integratedTime": now.Unix(),
+			"logIndex":       int64(42),
+			"logID":          "c0d23d6ad406973f",
+		}
+		canonical, _ := json.Marshal(entry)
+		bundle, _ := json.Marshal(map[string]any{
+			"SignedEntryTimestamp": signPayload(t, rekorKey, canonical),
+			"Payload":              entry,
+		})
+		return map[string]string{
+			cosignSignatureAnnotation:   signature,
+			cosignCertificateAnnotation: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})),
+			cosignBundleAnnotation:      string(bundle),
+		}
+	})
+
+	rekorDER, _ := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	cfg := &EnvConfig{
+		VerifyImageSignature:        "true",
+		CosignCertificateIdentity:   "ci@kdex.dev",
+		CosignCertificateOIDCIssuer: "https://token.actions.githubusercontent.com",
+		CosignRoots:                 writePEM(t, "CERTIFICATE", caDER),
+		CosignRekorPublicKey:        writePEM(t, "PUBLIC KEY", rekorDER),
+	}
+	if err := validateSignatureVerification(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	policy, err := loadSignaturePolicy(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := verifyImageSignature(context.Background(), authn.DefaultKeychain, image, digest, policy); err != nil {
+		t.Errorf("Expected the keyless signature to verify, got %v", err)
+	}
+
+	policy.identity = "someone@else.dev"
+	err = verifyImageSignature(context.Background(), authn.DefaultKeychain, image, digest, policy)
+	if err == nil || !strings.Contains(err.Error(), "signed by ci@kdex.dev") {
+		t.Errorf("Expected another identity to be rejected, got %v", err)
+	}
+
+	policy.identity = "ci@kdex.dev"
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	policy.rekorKey = &other.PublicKey
+	if err := verifyImageSignature(context.Background(), authn.DefaultKeychain, image, digest, policy); err == nil {
+		t.Error("Expected an entry not signed by the configured Rekor key to be rejected")
+	}
+}
+
+func TestValidateSignatureVerification(t *testing.T) {
+	err := validateSignatureVerification(&EnvConfig{VerifyImageSignature: "true", CosignCertificateIdentity: "ci@kdex.dev"})
+	if err == nil || !strings.Contains(err.Error(), "COSIGN_CERTIFICATE_OIDC_ISSUER, COSIGN_ROOTS, COSIGN_REKOR_PUBLIC_KEY") {
+		t.Errorf("Expected the missing keyless settings to be listed, got %v", err)
+	}
+	if err := validateSignatureVerification(&EnvConfig{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
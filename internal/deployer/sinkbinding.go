@@ -0,0 +1,163 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+var sinkBindingGVR = schema.GroupVersionResource{
+	Group:    "sources.knative.dev",
+	Version:  "v1",
+	Resource: "sinkbindings",
+}
+
+// eventSinkKinds are the addressables EVENT_SINK can name as kind/name.
+var eventSinkKinds = map[string]map[string]any{
+	"broker":  {"apiVersion": "eventing.knative.dev/v1", "kind": "Broker"},
+	"channel": {"apiVersion": "messaging.knative.dev/v1", "kind": "Channel"},
+	"service": {"apiVersion": "serving.knative.dev/v1", "kind": "Service"},
+}
+
+// ceExtensionName is what the CloudEvents spec allows an extension
+// attribute to be called.
+var ceExtensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// validateEventSink checks that EVENT_SINK, when given, is broker/<name>,
+// channel/<name>, service/<name> or an http(s) URL, and that
+// EVENT_SINK_EXTENSIONS are valid CloudEvents extensions.
+func validateEventSink(cfg *EnvConfig) error {
+	if cfg.EventSink == "" {
+		if cfg.EventSinkExtensions != "" {
+			return fmt.Errorf("EVENT_SINK_EXTENSIONS requires EVENT_SINK")
+		}
+		return nil
+	}
+	if _, err := eventSink(cfg); err != nil {
+		return err
+	}
+	extensions, err := parseKeyValues(cfg.EventSinkExtensions)
+	if err != nil {
+		return fmt.Errorf("invalid EVENT_SINK_EXTENSIONS: %w", err)
+	}
+	for name := range extensions {
+		if !ceExtensionName.MatchString(name) {
+			return fmt.Errorf("invalid EVENT_SINK_EXTENSIONS %q: expected lowercase alphanumeric names of at most 20 characters", name)
+		}
+	}
+	return nil
+}
+
+// eventSink renders the sink of the SinkBinding for EVENT_SINK.
+func eventSink(cfg *EnvConfig) (map[string]any, error) {
+	if strings.HasPrefix(cfg.EventSink, "http://") || strings.HasPrefix(cfg.EventSink, "https://") {
+		if u, err := url.Parse(cfg.EventSink); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid EVENT_SINK %q: expected an http(s) URL", cfg.EventSink)
+		}
+		return map[string]any{"uri": cfg.EventSink}, nil
+	}
+
+	kind, name, _ := strings.Cut(cfg.EventSink, "/")
+	ref, ok := eventSinkKinds[kind]
+	if !ok || len(validation.IsDNS1123Label(name)) > 0 {
+		return nil, fmt.Errorf("invalid EVENT_SINK %q: expected broker/<name>, channel/<name>, service/<name> or an http(s) URL", cfg.EventSink)
+	}
+	return map[string]any{
+		"ref": map[string]any{
+			"apiVersion": ref["apiVersion"],
+			"kind":       ref["kind"],
+			"name":       name,
+			"namespace":  cfg.FunctionNamespace,
+		},
+	}, nil
+}
+
+// buildSinkBinding renders the SinkBinding injecting K_SINK, and
+// K_CE_OVERRIDES with EVENT_SINK_EXTENSIONS, into the revisions of the
+// function.
+func buildSinkBinding(cfg *EnvConfig) (*unstructured.Unstructured, error) {
+	sink, err := eventSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	spec := map[string]any{
+		"subject": map[string]any{
+			"apiVersion": knativeServiceGVR.GroupVersion().String(),
+			"kind":       "Service",
+			"name":       cfg.FunctionName,
+		},
+		"sink": sink,
+	}
+
+	extensions, err := parseKeyValues(cfg.EventSinkExtensions)
+	if err != nil {
+		return nil, err
+	}
+	if len(extensions) > 0 {
+		overrides := map[string]any{}
+		for k, v := range extensions {
+			overrides[k] = v
+		}
+		spec["ceOverrides"] = map[string]any{"extensions": overrides}
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": sinkBindingGVR.GroupVersion().String(),
+			"kind":       "SinkBinding",
+			"metadata": map[string]any{
+				"name":      cfg.FunctionName,
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": spec,
+		},
+	}, nil
+}
+
+// provisionSinkBinding applies the SinkBinding for EVENT_SINK before the
+// Service, so the first revision already gets K_SINK, or removes that of an
+// earlier deploy when it is unset.
+func provisionSinkBinding(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	bindings := client.Resource(sinkBindingGVR).Namespace(cfg.FunctionNamespace)
+	if cfg.EventSink == "" {
+		// The CRD may not even be installed, which is fine
+		err := bindings.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete sink binding: %w", err)
+		}
+		return nil
+	}
+
+	binding, err := buildSinkBinding(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(binding)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sink binding: %w", err)
+	}
+	force := true
+	_, err = bindings.Patch(ctx, cfg.FunctionName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply sink binding: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateEventSink(t *testing.T) {
+	tests := []struct {
+		cfg     EnvConfig
+		wantErr bool
+	}{
+		{cfg: EnvConfig{}},
+		{cfg: EnvConfig{EventSink: "broker/default"}},
+		{cfg: EnvConfig{EventSink: "service/consumer", EventSinkExtensions: "team=payments"}},
+		{cfg: EnvConfig{EventSink: "https://events.example.com/ingest"}},
+		{cfg: EnvConfig{EventSink: "topic/orders"}, wantErr: true},
+		{cfg: EnvConfig{EventSink: "broker/Default"}, wantErr: true},
+		{cfg: EnvConfig{EventSink: "https://"}, wantErr: true},
+		{cfg: EnvConfig{EventSink: "broker/default", EventSinkExtensions: "Team=payments"}, wantErr: true},
+		{cfg: EnvConfig{EventSinkExtensions: "team=payments"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateEventSink(&tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.cfg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBuildSinkBinding(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default", EventSink: "broker/default", EventSinkExtensions: "team=payments"}
+
+	binding, err := buildSinkBinding(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	subject, _, _ := unstructured.NestedString(binding.Object, "spec", "subject", "name")
+	if subject != "fn" {
+		t.Errorf("Expected the function as subject, got %q", subject)
+	}
+	ref, _, _ := unstructured.NestedStringMap(binding.Object, "spec", "sink", "ref")
+	if ref["kind"] != "Broker" || ref["name"] != "default" || ref["namespace"] != "default" {
+		t.Errorf("Unexpected sink: %v", ref)
+	}
+	extensions, _, _ := unstructured.NestedStringMap(binding.Object, "spec", "ceOverrides", "extensions")
+	if extensions["team"] != "payments" {
+		t.Errorf("Unexpected extensions: %v", extensions)
+	}
+
+	cfg.EventSink, cfg.EventSinkExtensions = "https://events.example.com/ingest", ""
+	binding, _ = buildSinkBinding(cfg)
+	uri, _, _ := unstructured.NestedString(binding.Object, "spec", "sink", "uri")
+	if _, found, _ := unstructured.NestedMap(binding.Object, "spec", "ceOverrides"); uri != cfg.EventSink || found {
+		t.Errorf("Expected a URI sink without overrides, got %v", binding.Object["spec"])
+	}
+}
+
+func TestProvisionSinkBindingRemoves(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamicClient(newObject("sources.knative.dev/v1", "SinkBinding", "default", "fn", nil))
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "default"}
+
+	if err := provisionSinkBinding(ctx, client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Resource(sinkBindingGVR).Namespace("default").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the sink binding to be removed, got %v", err)
+	}
+
+	// Nothing to remove
+	if err := provisionSinkBinding(ctx, client, cfg); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// sloWindow is the period the error budget is spent over. The burn-rate
+// factors below are the usual ones for a 30 day budget.
+const sloWindow = "30d"
+
+// sloRecordWindows are the rate windows recorded for each SLI.
+var sloRecordWindows = []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}
+
+// burnRateAlert fires when the error budget burns factor times faster than
+// sustainable over both the long and the short window.
+type burnRateAlert struct {
+	Long, Short string
+	Factor      float64
+	Severity    string
+}
+
+var burnRateAlerts = []burnRateAlert{
+	{Long: "1h", Short: "5m", Factor: 14.4, Severity: "critical"},
+	{Long: "6h", Short: "30m", Factor: 6, Severity: "critical"},
+	{Long: "1d", Short: "2h", Factor: 3, Severity: "warning"},
+	{Long: "3d", Short: "6h", Factor: 1, Severity: "warning"},
+}
+
+// sloSpec is the validated SLO_* configuration.
+type sloSpec struct {
+	// AvailabilityTarget is the percentage of requests that must succeed,
+	// and of requests that must beat LatencyThreshold.
+	AvailabilityTarget float64
+	LatencyThreshold   time.Duration
+}
+
+func parseSLO(cfg *EnvConfig) (*sloSpec, error) {
+	target, err := strconv.ParseFloat(cfg.SLOAvailabilityTarget, 64)
+	if err != nil || target <= 0 || target >= 100 {
+		return nil, fmt.Errorf("invalid SLO_AVAILABILITY_TARGET %q: expected a percentage below 100", cfg.SLOAvailabilityTarget)
+	}
+	slo := &sloSpec{AvailabilityTarget: target}
+
+	if cfg.SLOLatencyThreshold != "" {
+		threshold, err := time.ParseDuration(cfg.SLOLatencyThreshold)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid SLO_LATENCY_THRESHOLD %q", cfg.SLOLatencyThreshold)
+		}
+		slo.LatencyThreshold = threshold
+	}
+
+	return slo, nil
+}
+
+// sloIndicator renders the error ratio of an SLI over a window.
+type sloIndicator struct {
+	name string
+	expr func(window string) string
+}
+
+func sloRuleName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-slo"
+}
+
+// buildSLORules renders the PrometheusRule recording the error ratio of
+// each SLI over every window and alerting on multiwindow burn rates. The
+// latency SLI counts requests slower than the threshold as errors; the
+// threshold must match a bucket of revision_request_latencies.
+func buildSLORules(cfg *EnvConfig, slo *sloSpec) *unstructured.Unstructured {
+	selector := fmt.Sprintf(`namespace_name="%s", configuration_name="%s"`, cfg.FunctionNamespace, cfg.FunctionName)
+	recordedSelector := fmt.Sprintf(`kdex_function="%s", namespace="%s"`, cfg.FunctionName, cfg.FunctionNamespace)
+	budget := 1 - slo.AvailabilityTarget/100
+
+	slis := []sloIndicator{
+		{"availability", func(w string) string {
+			return fmt.Sprintf(`sum(rate(revision_request_count{%s, response_code_class="5xx"}[%s])) / sum(rate(revision_request_count{%s}[%s]))`,
+				selector, w, selector, w)
+		}},
+	}
+	if slo.LatencyThreshold > 0 {
+		le := strconv.FormatFloat(float64(slo.LatencyThreshold)/float64(time.Millisecond), 'g', -1, 64)
+		slis = append(slis, sloIndicator{"latency", func(w string) string {
+			return fmt.Sprintf(`1 - sum(rate(revision_request_latencies_bucket{%s, le="%s"}[%s])) / sum(rate(revision_request_latencies_count{%s}[%s]))`,
+				selector, le, w, selector, w)
+		}})
+	}
+
+	recordLabels := map[string]any{
+		"kdex_function": cfg.FunctionName,
+		"namespace":     cfg.FunctionNamespace,
+	}
+
+	recordings := []any{}
+	alerts := []any{}
+	for _, sli := range slis {
+		record := func(window string) string {
+			return fmt.Sprintf("kdex:function_%s_errors:ratio_rate%s", sli.name, window)
+		}
+		for _, w := range sloRecordWindows {
+			recordings = append(recordings, map[string]any{
+				"record": record(w),
+				"expr":   sli.expr(w),
+				"labels": recordLabels,
+			})
+		}
+
+		title := strings.ToUpper(sli.name[:1]) + sli.name[1:]
+		for _, a := range burnRateAlerts {
+			threshold := a.Factor * budget
+			alerts = append(alerts, map[string]any{
+				"alert": "KDexFunction" + title + "BudgetBurn",
+				"expr": fmt.Sprintf(`%s{%s} > %.6g and %s{%s} > %.6g`,
+					record(a.Long), recordedSelector, threshold, record(a.Short), recordedSelector, threshold),
+				"labels": map[string]any{
+					"severity":      a.Severity,
+					"kdex_function": cfg.FunctionName,
+					"namespace":     cfg.FunctionNamespace,
+					"long_window":   a.Long,
+				},
+				"annotations": map[string]any{
+					"summary": fmt.Sprintf("Function %s/%s burns its %s error budget %gx too fast over %s", cfg.FunctionNamespace, cfg.FunctionName, sli.name, a.Factor, a.Long),
+				},
+			})
+		}
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"metadata": map[string]any{
+				"name":      sloRuleName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"spec": map[string]any{
+				"groups": []any{
+					map[string]any{
+						"name":  "kdex-function-" + cfg.FunctionName + "-slo-recordings",
+						"rules": recordings,
+					},
+					map[string]any{
+						"name":  "kdex-function-" + cfg.FunctionName + "-slo-alerts",
+						"rules": alerts,
+					},
+				},
+			},
+		},
+	}
+}
+
+// provisionSLO applies the SLO rules and records the SLO on the
+// KDexFunction status, unless SKIP_STATUS_UPDATE is set.
+func provisionSLO(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) error {
+	slo, err := parseSLO(cfg)
+	if err != nil {
+		return err
+	}
+
+	rule := buildSLORules(cfg, slo)
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	_, err = client.Resource(prometheusRuleGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, rule.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply slo rules: %w", err)
+	}
+
+	if cfg.SkipStatusUpdate == "true" {
+		return nil
+	}
+	return recordSLO(ctx, client, cfg, slo)
+}
+
+// recordSLO writes the SLO into the KDexFunction status.
+func recordSLO(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, slo *sloSpec) error {
+	status := map[string]any{
+		"availabilityTarget": cfg.SLOAvailabilityTarget,
+		"window":             sloWindow,
+	}
+	if slo.LatencyThreshold > 0 {
+		status["latencyThreshold"] = slo.LatencyThreshold.String()
+	}
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"slo": status,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, cfg.FunctionName, types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+	}, "status")
+	if err != nil {
+		return fmt.Errorf("failed to record slo: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseSLO(t *testing.T) {
+	slo, err := parseSLO(&EnvConfig{SLOAvailabilityTarget: "99.9", SLOLatencyThreshold: "250ms"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if slo.AvailabilityTarget != 99.9 || slo.LatencyThreshold.Milliseconds() != 250 {
+		t.Errorf("Unexpected slo: %+v", slo)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{SLOAvailabilityTarget: "100"},
+		{SLOAvailabilityTarget: "high"},
+		{SLOAvailabilityTarget: "99", SLOLatencyThreshold: "fast"},
+	} {
+		if _, err := parseSLO(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestBuildSLORules(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+
+	groups := func(obj *unstructured.Unstructured) (recordings, alerts []any) {
+		g, _, _ := unstructured.NestedSlice(obj.Object, "spec", "groups")
+		recordings, _, _ = unstructured.NestedSlice(g[0].(map[string]any), "rules")
+		alerts, _, _ = unstructured.NestedSlice(g[1].(map[string]any), "rules")
+		return recordings, alerts
+	}
+
+	recordings, alerts := groups(buildSLORules(cfg, &sloSpec{AvailabilityTarget: 99.9}))
+	if len(recordings) != len(sloRecordWindows) || len(alerts) != len(burnRateAlerts) {
+		t.Errorf("Expected availability rules only, got %d recordings and %d alerts", len(recordings), len(alerts))
+	}
+	page := alerts[0].(map[string]any)["expr"].(string)
+	if !strings.Contains(page, "kdex:function_availability_errors:ratio_rate1h") || !strings.Contains(page, "> 0.0144") {
+		t.Errorf("Unexpected page expression: %s", page)
+	}
+
+	recordings, _ = groups(buildSLORules(cfg, &sloSpec{AvailabilityTarget: 99.9, LatencyThreshold: 250_000_000}))
+	if len(recordings) != 2*len(sloRecordWindows) {
+		t.Fatalf("Expected latency recordings, got %d", len(recordings))
+	}
+	latency := recordings[len(sloRecordWindows)].(map[string]any)["expr"].(string)
+	if !strings.Contains(latency, `le="250"`) {
+		t.Errorf("Expected threshold bucket in ms, got %s", latency)
+	}
+}
+
+func TestRecordSLO(t *testing.T) {
+	client := newFakeDynamicClient(
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil),
+	)
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", SLOAvailabilityTarget: "99.5", SLOLatencyThreshold: "500ms"}
+	slo, err := parseSLO(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := recordSLO(context.Background(), client, cfg, slo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	function, err := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status, _, _ := unstructured.NestedStringMap(function.Object, "status", "slo")
+	if status["availabilityTarget"] != "99.5" || status["latencyThreshold"] != "500ms" || status["window"] != sloWindow {
+		t.Errorf("Unexpected slo status: %v", status)
+	}
+}
+
+func TestProvisionSLOSkipStatusUpdate(t *testing.T) {
+	client := newFakeDynamicClient(
+		newObject("kdex.dev/v1alpha1", "KDexFunction", "myns", "myfunc", nil),
+	)
+	applied := false
+	client.PrependReactor("patch", "prometheusrules", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		applied = true
+		return true, nil, nil
+	})
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns", SLOAvailabilityTarget: "99.5", SkipStatusUpdate: "true"}
+
+	if err := provisionSLO(context.Background(), client, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !applied {
+		t.Error("Expected the slo rules to be applied")
+	}
+	function, _ := client.Resource(kdexFunctionGVR).Namespace("myns").Get(context.Background(), "myfunc", metav1.GetOptions{})
+	if _, found, _ := unstructured.NestedMap(function.Object, "status", "slo"); found {
+		t.Error("Expected the status not to be updated with SKIP_STATUS_UPDATE")
+	}
+}
@@ -0,0 +1,146 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var podGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// crashReasons are the container waiting reasons that mean the revision is
+// unstable, restarted or not.
+var crashReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"RunContainerError":          true,
+}
+
+// parseSoakDuration reads SOAK_DURATION, how long the new revision must
+// stay stable once ready. Zero skips the soak.
+func parseSoakDuration(cfg *EnvConfig) (time.Duration, error) {
+	if cfg.SoakDuration == "" {
+		return 0, nil
+	}
+	soak, err := time.ParseDuration(cfg.SoakDuration)
+	if err != nil || soak < 0 {
+		return 0, fmt.Errorf("invalid SOAK_DURATION %q: expected a non-negative duration", cfg.SoakDuration)
+	}
+	return soak, nil
+}
+
+// soakRevision watches the new revision for SOAK_DURATION, failing as soon
+// as the Service turns unready or a container of the revision crashes or
+// restarts.
+func soakRevision(ctx context.Context, d *deployment) error {
+	cfg := d.cfg
+	pods := d.client.Resource(podGVR).Namespace(cfg.FunctionNamespace)
+	logf("Soaking revision %s for %s\n", d.candidate, d.soak)
+
+	// Restarts before readiness are the revision starting up
+	baseline, err := revisionRestarts(ctx, pods, d.candidate)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(d.soak)
+	for {
+		if err := checkRevisionStability(ctx, d.services, pods, cfg, d.candidate, baseline); err != nil {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(remaining, d.timing.PollInterval)):
+		}
+	}
+
+	logf("Revision %s stayed stable for %s\n", d.candidate, d.soak)
+	return nil
+}
+
+// checkRevisionStability fails when the Service is no longer ready or a
+// container of the revision crashed or restarted since baseline.
+func checkRevisionStability(ctx context.Context, services, pods dynamic.ResourceInterface, cfg *EnvConfig, revision string, baseline map[string]int64) error {
+	service, err := services.Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get knative service: %w", err)
+	}
+	if ready, msg, _ := parseKnativeStatus(service); !ready {
+		return fmt.Errorf("service became unready: %s", msg)
+	}
+
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: "serving.knative.dev/revision=" + revision})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range list.Items {
+		for _, status := range containerStatuses(&pod) {
+			name, _, _ := unstructured.NestedString(status, "name")
+			reason, _, _ := unstructured.NestedString(status, "state", "waiting", "reason")
+			if crashReasons[reason] {
+				return fmt.Errorf("container %s of pod %s is in %s", name, pod.GetName(), reason)
+			}
+			restarts, _, _ := unstructured.NestedInt64(status, "restartCount")
+			if restarts > baseline[pod.GetName()+"/"+name] {
+				return fmt.Errorf("container %s of pod %s restarted %d time(s)", name, pod.GetName(), restarts)
+			}
+		}
+	}
+	return nil
+}
+
+// revisionRestarts counts the restarts of each container of the revision,
+// keyed by pod and container name.
+func revisionRestarts(ctx context.Context, pods dynamic.ResourceInterface, revision string) (map[string]int64, error) {
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: "serving.knative.dev/revision=" + revision})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	restarts := map[string]int64{}
+	for _, pod := range list.Items {
+		for _, status := range containerStatuses(&pod) {
+			name, _, _ := unstructured.NestedString(status, "name")
+			restarts[pod.GetName()+"/"+name], _, _ = unstructured.NestedInt64(status, "restartCount")
+		}
+	}
+	return restarts, nil
+}
+
+func containerStatuses(pod *unstructured.Unstructured) []map[string]any {
+	statuses, _, _ := unstructured.NestedSlice(pod.Object, "status", "containerStatuses")
+	result := make([]map[string]any, 0, len(statuses))
+	for _, s := range statuses {
+		if status, ok := s.(map[string]any); ok {
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+// abortSoak routes all traffic back to the revision serving before the
+// deploy when the new one proved unstable. A first deploy has nothing to go
+// back to and just fails.
+func abortSoak(ctx context.Context, d *deployment, cause error) error {
+	if d.state.LatestReadyRevision == "" {
+		return cause
+	}
+	logf("Rolling back to %s: %v\n", d.state.LatestReadyRevision, cause)
+	d.cfg.Traffic = "current=100"
+	if err := applyService(ctx, d.services, d.cfg, d.state); err != nil {
+		return fmt.Errorf("revision %s is unstable: %w; rollback to %s failed: %w", d.candidate, cause, d.state.LatestReadyRevision, err)
+	}
+	return fmt.Errorf("revision %s is unstable, rolled back to %s: %w", d.candidate, d.state.LatestReadyRevision, cause)
+}
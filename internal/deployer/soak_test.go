@@ -0,0 +1,93 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func revisionPod(name string, restarts int64, waiting string) *unstructured.Unstructured {
+	pod := newObject("v1", "Pod", "myns", name, map[string]string{"serving.knative.dev/revision": "myfunc-00002"})
+	status := map[string]any{"name": "user-container", "restartCount": restarts}
+	if waiting != "" {
+		status["state"] = map[string]any{"waiting": map[string]any{"reason": waiting}}
+	}
+	_ = unstructured.SetNestedSlice(pod.Object, []any{status}, "status", "containerStatuses")
+	return pod
+}
+
+func readyService() *unstructured.Unstructured {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	_ = unstructured.SetNestedSlice(service.Object, []any{
+		map[string]any{"type": "Ready", "status": "True"},
+	}, "status", "conditions")
+	return service
+}
+
+func TestParseSoakDuration(t *testing.T) {
+	if soak, err := parseSoakDuration(&EnvConfig{}); err != nil || soak != 0 {
+		t.Errorf("Expected no soak, got %v %v", soak, err)
+	}
+	if soak, err := parseSoakDuration(&EnvConfig{SoakDuration: "2m"}); err != nil || soak != 2*time.Minute {
+		t.Errorf("Expected a 2m soak, got %v %v", soak, err)
+	}
+	for _, value := range []string{"-1m", "a while"} {
+		if _, err := parseSoakDuration(&EnvConfig{SoakDuration: value}); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestCheckRevisionStability(t *testing.T) {
+	ctx := context.Background()
+	cfg := &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"}
+	baseline := map[string]int64{"pod-a/user-container": 1}
+
+	tests := []struct {
+		pod     *unstructured.Unstructured
+		wantErr string
+	}{
+		{pod: revisionPod("pod-a", 1, "")},
+		{pod: revisionPod("pod-a", 2, ""), wantErr: "restarted 2 time(s)"},
+		{pod: revisionPod("pod-b", 1, ""), wantErr: "restarted 1 time(s)"},
+		{pod: revisionPod("pod-a", 1, "CrashLoopBackOff"), wantErr: "CrashLoopBackOff"},
+	}
+
+	for _, tt := range tests {
+		client := newFakeDynamicClient(readyService(), tt.pod)
+		services := client.Resource(knativeServiceGVR).Namespace("myns")
+		pods := client.Resource(podGVR).Namespace("myns")
+
+		err := checkRevisionStability(ctx, services, pods, cfg, "myfunc-00002", baseline)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.pod.GetName(), err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.pod.GetName(), tt.wantErr, err)
+		}
+	}
+}
+
+func TestSoakRevision(t *testing.T) {
+	client := newFakeDynamicClient(readyService(), revisionPod("pod-a", 1, ""))
+	d := &deployment{
+		cfg:       &EnvConfig{FunctionName: "myfunc", FunctionNamespace: "myns"},
+		client:    client,
+		services:  client.Resource(knativeServiceGVR).Namespace("myns"),
+		timing:    waitTiming{PollInterval: time.Millisecond, Timeout: time.Second},
+		soak:      5 * time.Millisecond,
+		candidate: "myfunc-00002",
+	}
+
+	// Restarts from before the revision became ready do not count
+	if err := soakRevision(context.Background(), d); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := abortSoak(context.Background(), d, context.DeadlineExceeded); err != context.DeadlineExceeded {
+		t.Errorf("Expected a first deploy to fail without rollback, got %v", err)
+	}
+}
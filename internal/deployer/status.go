@@ -0,0 +1,234 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// functionStatus is the state of a deployed function, from its Knative
+// Service and, when there is one, its KDexFunction.
+type functionStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Ready is the status of the Ready condition of the Service: True,
+	// False or Unknown.
+	Ready          string     `json:"ready"`
+	Reason         string     `json:"reason,omitempty"`
+	Message        string     `json:"message,omitempty"`
+	LastTransition *time.Time `json:"lastTransition,omitempty"`
+	URL            string     `json:"url,omitempty"`
+	// State, CustomURL and the generations are those of the KDexFunction.
+	State                 string           `json:"state,omitempty"`
+	CustomURL             string           `json:"customUrl,omitempty"`
+	Generation            int64            `json:"generation,omitempty"`
+	ObservedGeneration    int64            `json:"observedGeneration,omitempty"`
+	LatestCreatedRevision string           `json:"latestCreatedRevision,omitempty"`
+	LatestReadyRevision   string           `json:"latestReadyRevision,omitempty"`
+	Revisions             []revisionStatus `json:"revisions,omitempty"`
+	Traffic               []trafficStatus  `json:"traffic,omitempty"`
+}
+
+type revisionStatus struct {
+	Name    string    `json:"name"`
+	Ready   string    `json:"ready"`
+	Created time.Time `json:"created"`
+}
+
+type trafficStatus struct {
+	Revision string `json:"revision"`
+	Percent  int64  `json:"percent"`
+	Tag      string `json:"tag,omitempty"`
+	URL      string `json:"url,omitempty"`
+	// Latest means the traffic follows the latest ready revision.
+	Latest bool `json:"latest,omitempty"`
+}
+
+// runStatus prints the state of the function without changing anything.
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	output := flags.String("output", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("invalid output %q: expected text or json", *output)
+	}
+
+	cfg, err := LoadEnv()
+	if err != nil {
+		return err
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	status, err := functionStatusOf(context.Background(), client, cfg)
+	if err != nil {
+		return err
+	}
+	return writeFunctionStatus(outputRedactor().Writer(os.Stdout), *output, status, time.Now())
+}
+
+// functionStatusOf reads the state of the function. The Service must exist;
+// the KDexFunction may not, for functions deployed without one.
+func functionStatusOf(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) (*functionStatus, error) {
+	service, err := client.Resource(knativeServiceGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("knative service %s/%s not found", cfg.FunctionNamespace, cfg.FunctionName)
+		}
+		return nil, fmt.Errorf("failed to get knative service: %w", err)
+	}
+
+	status := &functionStatus{Namespace: cfg.FunctionNamespace, Name: cfg.FunctionName, Ready: readyConditionStatus(service)}
+	conditions, _, _ := unstructured.NestedSlice(service.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		status.Reason, _ = cond["reason"].(string)
+		status.Message, _ = cond["message"].(string)
+		if value, ok := cond["lastTransitionTime"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				status.LastTransition = &t
+			}
+		}
+	}
+	status.URL, _, _ = unstructured.NestedString(service.Object, "status", "url")
+	status.LatestCreatedRevision, _, _ = unstructured.NestedString(service.Object, "status", "latestCreatedRevisionName")
+	status.LatestReadyRevision, _, _ = unstructured.NestedString(service.Object, "status", "latestReadyRevisionName")
+
+	traffic, _, _ := unstructured.NestedSlice(service.Object, "status", "traffic")
+	for _, t := range traffic {
+		target, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		entry := trafficStatus{}
+		entry.Revision, _ = target["revisionName"].(string)
+		entry.Percent, _, _ = unstructured.NestedInt64(target, "percent")
+		entry.Tag, _ = target["tag"].(string)
+		entry.URL, _ = target["url"].(string)
+		entry.Latest, _ = target["latestRevision"].(bool)
+		status.Traffic = append(status.Traffic, entry)
+	}
+
+	revisions, err := client.Resource(knativeRevisionGVR).Namespace(cfg.FunctionNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "serving.knative.dev/service=" + cfg.FunctionName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knative revisions: %w", err)
+	}
+	for i := range revisions.Items {
+		revision := &revisions.Items[i]
+		status.Revisions = append(status.Revisions, revisionStatus{
+			Name:    revision.GetName(),
+			Ready:   readyConditionStatus(revision),
+			Created: revision.GetCreationTimestamp().Time,
+		})
+	}
+	// Newest first
+	sort.Slice(status.Revisions, func(i, j int) bool {
+		if !status.Revisions[i].Created.Equal(status.Revisions[j].Created) {
+			return status.Revisions[i].Created.After(status.Revisions[j].Created)
+		}
+		return status.Revisions[i].Name > status.Revisions[j].Name
+	})
+
+	function, err := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace).Get(ctx, cfg.FunctionName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get kdex function: %w", err)
+	}
+	if err == nil {
+		status.State, _, _ = unstructured.NestedString(function.Object, "status", "state")
+		status.CustomURL, _, _ = unstructured.NestedString(function.Object, "status", "customUrl")
+		status.Generation = function.GetGeneration()
+		status.ObservedGeneration, _, _ = unstructured.NestedInt64(function.Object, "status", "observedGeneration")
+	}
+	return status, nil
+}
+
+// writeFunctionStatus prints the status as JSON, or for people with times
+// relative to now.
+func writeFunctionStatus(w io.Writer, output string, status *functionStatus, now time.Time) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Function:\t%s/%s\n", status.Namespace, status.Name)
+	ready := status.Ready
+	if status.Reason != "" {
+		ready += " (" + status.Reason + ")"
+	}
+	fmt.Fprintf(tw, "Ready:\t%s\n", ready)
+	if status.Message != "" {
+		fmt.Fprintf(tw, "Message:\t%s\n", status.Message)
+	}
+	if status.LastTransition != nil {
+		fmt.Fprintf(tw, "Last transition:\t%s (%s ago)\n", status.LastTransition.UTC().Format(time.RFC3339), reportAge(status.LastTransition, now))
+	}
+	fmt.Fprintf(tw, "URL:\t%s\n", orDash(status.URL))
+	if status.CustomURL != "" {
+		fmt.Fprintf(tw, "Custom URL:\t%s\n", status.CustomURL)
+	}
+	if status.State != "" {
+		fmt.Fprintf(tw, "State:\t%s\n", status.State)
+	}
+	if status.Generation != 0 {
+		fmt.Fprintf(tw, "Generation:\t%d (observed %d)\n", status.Generation, status.ObservedGeneration)
+	}
+	fmt.Fprintf(tw, "Latest created:\t%s\n", orDash(status.LatestCreatedRevision))
+	fmt.Fprintf(tw, "Latest ready:\t%s\n", orDash(status.LatestReadyRevision))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(status.Traffic) > 0 {
+		fmt.Fprintln(w, "\nTraffic:")
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  REVISION\tPERCENT\tTAG\tURL")
+		for _, t := range status.Traffic {
+			revision := t.Revision
+			if t.Latest {
+				revision += " (latest)"
+			}
+			fmt.Fprintf(tw, "  %s\t%d%%\t%s\t%s\n", revision, t.Percent, orDash(t.Tag), orDash(t.URL))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(status.Revisions) > 0 {
+		fmt.Fprintln(w, "\nRevisions:")
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  NAME\tREADY\tAGE")
+		for _, r := range status.Revisions {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", r.Name, r.Ready, reportAge(&r.Created, now))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
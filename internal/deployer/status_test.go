@@ -0,0 +1,94 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFunctionStatus(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil)
+	function.SetGeneration(4)
+	function.Object["status"] = map[string]any{"state": "Ready", "customUrl": "https://fn.example.com", "observedGeneration": int64(4)}
+
+	service := newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil)
+	service.Object["status"] = map[string]any{
+		"url":                       "http://fn.ns.example.com",
+		"latestCreatedRevisionName": "fn-00002",
+		"latestReadyRevisionName":   "fn-00002",
+		"conditions": []any{
+			map[string]any{"type": "Ready", "status": "True", "lastTransitionTime": "2026-10-16T10:00:00Z"},
+		},
+		"traffic": []any{
+			map[string]any{"revisionName": "fn-00002", "percent": int64(90), "latestRevision": true},
+			map[string]any{"revisionName": "fn-00001", "percent": int64(10), "tag": "previous", "url": "http://previous-fn.ns.example.com"},
+		},
+	}
+	older := newObject("serving.knative.dev/v1", "Revision", "ns", "fn-00001", map[string]string{"serving.knative.dev/service": "fn"})
+	older.SetCreationTimestamp(metav1.NewTime(now.Add(-48 * time.Hour)))
+	older.Object["status"] = map[string]any{"conditions": []any{map[string]any{"type": "Ready", "status": "True"}}}
+	newer := newObject("serving.knative.dev/v1", "Revision", "ns", "fn-00002", map[string]string{"serving.knative.dev/service": "fn"})
+	newer.SetCreationTimestamp(metav1.NewTime(now.Add(-2 * time.Hour)))
+
+	client := newFakeDynamicClient(function, service, older, newer)
+	status, err := functionStatusOf(context.Background(), client, &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.Ready != "True" || status.URL != "http://fn.ns.example.com" || status.State != "Ready" || status.Generation != 4 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.LastTransition == nil || !status.LastTransition.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Expected the last transition of the Ready condition, got %v", status.LastTransition)
+	}
+	if len(status.Revisions) != 2 || status.Revisions[0].Name != "fn-00002" || status.Revisions[0].Ready != "Unknown" {
+		t.Errorf("Expected the revisions newest first, got %+v", status.Revisions)
+	}
+	if len(status.Traffic) != 2 || !status.Traffic[0].Latest || status.Traffic[1].Percent != 10 || status.Traffic[1].Tag != "previous" {
+		t.Errorf("Unexpected traffic %+v", status.Traffic)
+	}
+
+	var out bytes.Buffer
+	if err := writeFunctionStatus(&out, "text", status, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{"ns/fn", "2026-10-16T10:00:00Z (2h ago)", "fn-00002 (latest)", "previous", "fn-00001"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := writeFunctionStatus(&out, "json", status, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded functionStatus
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.LatestReadyRevision != "fn-00002" {
+		t.Errorf("Unexpected JSON %s (%v)", out.String(), err)
+	}
+}
+
+func TestFunctionStatusWithoutService(t *testing.T) {
+	_, err := functionStatusOf(context.Background(), newFakeDynamicClient(), &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected the missing service to be reported, got %v", err)
+	}
+}
+
+func TestFunctionStatusWithoutFunction(t *testing.T) {
+	client := newFakeDynamicClient(newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil))
+	status, err := functionStatusOf(context.Background(), client, &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.Ready != "Unknown" || status.State != "" || status.Generation != 0 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,123 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes the spans of the deployer itself, as opposed to tracingEnv
+// which configures those of the function. Until setupTracing installs a
+// provider its spans are dropped.
+var tracer = otel.Tracer("github.com/kdex-tech/knative-deployer")
+
+// setupTracing exports the spans of the deployer over OTLP/HTTP when an
+// OTLP endpoint is set through the standard OTEL_* env vars, which the
+// exporter and the SDK read for everything else: headers, sampler, service
+// name and resource attributes. It returns the func flushing the spans
+// before the process exits.
+func setupTracing(ctx context.Context) func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracingConfigured() {
+		return func() {}
+	}
+	if protocol := otlpProtocol(); protocol != "" && protocol != "http/protobuf" {
+		warnf("OTLP protocol %s is not supported; exporting spans over http/protobuf\n", protocol)
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		warnf("failed to set up tracing: %v\n", err)
+		return func() {}
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "kdex-knative-deployer")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		warnf("failed to detect tracing resource: %v\n", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			warnf("failed to flush spans: %v\n", err)
+		}
+	}
+}
+
+// tracingConfigured tells whether the OTEL_* env vars ask for spans to be
+// exported.
+func tracingConfigured() bool {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+func otlpProtocol() string {
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"); protocol != "" {
+		return protocol
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+}
+
+// envCarrier reads the trace context handed down to the Job in the
+// TRACEPARENT, TRACESTATE and BAGGAGE env vars, so the deploy shows up in
+// the trace of whatever started it.
+type envCarrier struct{}
+
+func (envCarrier) Get(key string) string {
+	return os.Getenv(strings.ToUpper(key))
+}
+
+func (envCarrier) Set(string, string) {}
+
+func (envCarrier) Keys() []string {
+	return []string{"traceparent", "tracestate", "baggage"}
+}
+
+// traceContextFromEnv returns ctx with the trace context of the env, if
+// any, as the parent of the spans started from it.
+func traceContextFromEnv(ctx context.Context) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, envCarrier{})
+}
+
+// startSpan starts a span of the function cfg deploys or observes. Without
+// a cfg the span is not tagged with the function.
+func startSpan(ctx context.Context, name string, cfg *EnvConfig, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if cfg != nil {
+		attrs = append(attrs,
+			attribute.String("kdex.function.name", cfg.FunctionName),
+			attribute.String("k8s.namespace.name", cfg.FunctionNamespace),
+			attribute.String("kdex.function.generation", cfg.FunctionGeneration),
+		)
+		if cfg.DeployID != "" {
+			attrs = append(attrs, attribute.String("kdex.deploy.id", cfg.DeployID))
+		}
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
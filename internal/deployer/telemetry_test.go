@@ -0,0 +1,69 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartSpanFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	setupTracing(context.Background())()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	defer func() { tracer = previous }()
+
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionGeneration: "3", DeployID: "abc"}
+	ctx, span := startSpan(traceContextFromEnv(context.Background()), "deploy", cfg)
+	_, phase := startSpan(ctx, "deploy.Apply", cfg)
+	endSpan(phase, fmt.Errorf("apply failed"))
+	endSpan(span, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	apply, deploy := spans[0], spans[1]
+	if got := deploy.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the deploy in the trace of TRACEPARENT, got %s", got)
+	}
+	if got := deploy.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected the deploy parented to TRACEPARENT, got %s", got)
+	}
+	if apply.Parent().SpanID() != deploy.SpanContext().SpanID() {
+		t.Error("Expected the phase span to be a child of the deploy span")
+	}
+	if apply.Status().Code != codes.Error || deploy.Status().Code == codes.Error {
+		t.Errorf("Expected only the failed phase to be an error, got %v and %v", apply.Status(), deploy.Status())
+	}
+	attrs := map[string]string{}
+	for _, attr := range deploy.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsString()
+	}
+	if attrs["kdex.function.name"] != "fn" || attrs["kdex.deploy.id"] != "abc" {
+		t.Errorf("Unexpected attributes %v", attrs)
+	}
+}
+
+func TestTracingConfigured(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_SDK_DISABLED", "")
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	if !tracingConfigured() {
+		t.Error("Expected an OTLP endpoint to enable tracing")
+	}
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if tracingConfigured() {
+		t.Error("Expected OTEL_TRACES_EXPORTER=none to disable tracing")
+	}
+}
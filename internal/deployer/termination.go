@@ -0,0 +1,164 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+// maxTerminationMessageSize is the most the kubelet reads of the
+// termination log.
+const maxTerminationMessageSize = 4096
+
+// terminationErrorLimits are the lengths errors are cut to, in turn, until
+// a message fits.
+var terminationErrorLimits = []int{1024, 256}
+
+func writeTerminationMessage(msg report.TerminationMessage) error {
+	msg.Version = report.Version
+	msg, err := redactTerminationMessage(outputRedactor(), msg)
+	if err != nil {
+		return err
+	}
+	data, err := fitTerminationMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	path := "/dev/termination-log"
+	if custom := os.Getenv("TERMINATION_LOG_PATH"); custom != "" {
+		path = custom
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// fitTerminationMessage encodes the message within the size the kubelet
+// reads, cutting what matters least first: the errors of the phases, then
+// long errors, then the phase reports and tags. The outcome, URLs, failed
+// phase and reason are always kept.
+func fitTerminationMessage(msg report.TerminationMessage) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil || len(data) <= maxTerminationMessageSize {
+		return data, err
+	}
+
+	// The phases and diagnosis are the caller's
+	msg.Truncated = true
+	msg.Phases = append([]report.PhaseReport{}, msg.Phases...)
+	if msg.Diagnosis != nil {
+		diagnosis := *msg.Diagnosis
+		msg.Diagnosis = &diagnosis
+	}
+	shrink := []func(){func() {
+		for i := range msg.Phases {
+			msg.Phases[i].Error = ""
+		}
+	}}
+	for _, limit := range terminationErrorLimits {
+		shrink = append(shrink, func() {
+			msg.Error = truncateTo(msg.Error, limit)
+			if msg.Diagnosis != nil {
+				msg.Diagnosis.Message = truncateTo(msg.Diagnosis.Message, limit)
+			}
+		})
+	}
+	shrink = append(shrink,
+		func() { msg.Phases = nil },
+		func() { msg.Tags = nil },
+	)
+
+	for _, step := range shrink {
+		step()
+		if data, err = json.Marshal(msg); err != nil || len(data) <= maxTerminationMessageSize {
+			return data, err
+		}
+	}
+	return nil, fmt.Errorf("termination message of %d bytes does not fit in %d", len(data), maxTerminationMessageSize)
+}
+
+// redactTerminationMessage masks the secrets in the fields of msg. The
+// encoded message would hold them JSON escaped, no longer matching.
+func redactTerminationMessage(r *redactor, msg report.TerminationMessage) (report.TerminationMessage, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return msg, err
+	}
+	if data, err = json.Marshal(r.Object(decoded)); err != nil {
+		return msg, err
+	}
+	redacted := report.TerminationMessage{}
+	return redacted, json.Unmarshal(data, &redacted)
+}
+
+func truncateTo(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "..."
+}
+
+// terminationConfigMapName is the ConfigMap holding a termination message
+// too large for the termination log.
+func terminationConfigMapName(cfg *EnvConfig) string {
+	return cfg.FunctionName + "-termination-message"
+}
+
+// saveFullTerminationMessage keeps a message too large for the termination
+// log in a ConfigMap, pointing the message at it. Messages that fit are
+// left as they are.
+func saveFullTerminationMessage(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, msg *report.TerminationMessage) error {
+	msg.Version = report.Version
+	redacted, err := redactTerminationMessage(outputRedactor(), *msg)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil || len(data) <= maxTerminationMessageSize {
+		return err
+	}
+
+	configMap := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":      terminationConfigMapName(cfg),
+				"namespace": cfg.FunctionNamespace,
+				"labels": map[string]any{
+					"kdex.dev/function":   cfg.FunctionName,
+					"kdex.dev/generation": cfg.FunctionGeneration,
+				},
+			},
+			"data": map[string]any{
+				"message.json": string(data),
+			},
+		},
+	}
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal termination message config map: %w", err)
+	}
+	force := true
+	_, err = client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace).Patch(ctx, configMap.GetName(), types.ApplyPatchType, body, metav1.PatchOptions{
+		FieldManager: "kdex-knative-deployer",
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply termination message config map: %w", err)
+	}
+	msg.FullMessageConfigMap = configMap.GetName()
+	return nil
+}
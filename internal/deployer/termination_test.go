@@ -0,0 +1,193 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/kdex-tech/knative-deployer/pkg/report"
+)
+
+func TestWriteTerminationMessage(t *testing.T) {
+	path := t.TempDir() + "/term-log"
+	t.Setenv("TERMINATION_LOG_PATH", path)
+
+	err := writeTerminationMessage(report.TerminationMessage{URL: "http://foo.bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if string(b) != `{"version":1,"url":"http://foo.bar"}` {
+		t.Errorf("Unexpected output: %s", string(b))
+	}
+}
+
+func TestWriteTerminationMessageTags(t *testing.T) {
+	path := t.TempDir() + "/term-log"
+	t.Setenv("TERMINATION_LOG_PATH", path)
+
+	err := writeTerminationMessage(report.TerminationMessage{
+		URL:  "http://foo.bar",
+		Tags: map[string]string{"canary": "http://canary-foo.bar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if string(b) != `{"version":1,"url":"http://foo.bar","tags":{"canary":"http://canary-foo.bar"}}` {
+		t.Errorf("Unexpected output: %s", string(b))
+	}
+}
+
+func TestDeploymentTerminationMessage(t *testing.T) {
+	d := &deployment{
+		cfg:         &EnvConfig{FunctionBasePath: "/api", FunctionGeneration: "4", DeployID: "abc"},
+		url:         "http://fn.default.example.com",
+		candidate:   "fn-00004",
+		imageDigest: "sha256:resolved",
+		timeToReady: 12345 * time.Microsecond,
+	}
+	msg := d.terminationMessage()
+	if msg.FunctionURL != "http://fn.default.example.com/api" || msg.LatestReadyRevision != "fn-00004" || msg.Generation != "4" ||
+		msg.ImageDigest != "sha256:resolved" || msg.TimeToReady != "12ms" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	// A pinned digest is the one applied; a resumed deploy reports the
+	// revision of the checkpoint
+	d.cfg.FunctionImageDigest = "sha256:pinned"
+	d.candidate, d.timeToReady = "", 0
+	d.checkpoint = &deployCheckpoint{Revision: "fn-00003"}
+	msg = d.terminationMessage()
+	if msg.ImageDigest != "sha256:pinned" || msg.LatestReadyRevision != "fn-00003" || msg.TimeToReady != "" {
+		t.Errorf("Unexpected resumed message: %+v", msg)
+	}
+}
+
+func TestRevisionImageDigest(t *testing.T) {
+	revision := newObject("serving.knative.dev/v1", "Revision", "ns", "fn-00001", nil)
+	if got := revisionImageDigest(revision); got != "" {
+		t.Errorf("Expected no digest before it resolved, got %q", got)
+	}
+	revision.Object["status"] = map[string]any{"containerStatuses": []any{
+		map[string]any{"name": "user-container", "imageDigest": "registry.example.com/fn@sha256:abc"},
+	}}
+	if got := revisionImageDigest(revision); got != "sha256:abc" {
+		t.Errorf("Expected sha256:abc, got %q", got)
+	}
+}
+
+func TestFitTerminationMessage(t *testing.T) {
+	long := strings.Repeat("x", 3000)
+	msg := report.TerminationMessage{
+		URL:         "http://fn.default.example.com",
+		Outcome:     report.OutcomeFailed,
+		FailedPhase: report.PhaseAwaitRevision,
+		Reason:      "ExitCode1",
+		Error:       long,
+		Phases: []report.PhaseReport{
+			{Phase: report.PhaseApply, Outcome: report.PhaseFailed, Error: long},
+			{Phase: report.PhaseAwaitRevision, Outcome: report.PhaseFailed, Error: long},
+		},
+		Diagnosis: &report.RevisionFailure{Revision: "fn-00001", Reason: "ExitCode1", Message: long},
+	}
+
+	data, err := fitTerminationMessage(msg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(data) > maxTerminationMessageSize {
+		t.Fatalf("Expected at most %d bytes, got %d", maxTerminationMessageSize, len(data))
+	}
+	var got report.TerminationMessage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !got.Truncated || got.FailedPhase != report.PhaseAwaitRevision || got.Reason != "ExitCode1" || len(got.Phases) != 2 || got.Phases[0].Error != "" {
+		t.Errorf("Unexpected message: %+v", got)
+	}
+	if len(got.Error) != 1024+len("...") {
+		t.Errorf("Expected the error cut to 1024 bytes, got %d", len(got.Error))
+	}
+	if msg.Phases[0].Error != long || msg.Diagnosis.Message != long {
+		t.Error("Expected the message of the caller to be left as it was")
+	}
+
+	small, _ := fitTerminationMessage(report.TerminationMessage{URL: "http://foo.bar"})
+	if strings.Contains(string(small), "truncated") {
+		t.Errorf("Expected a message that fits to be left whole, got %s", small)
+	}
+}
+
+func TestFailureMessage(t *testing.T) {
+	d := &deployment{
+		cfg: &EnvConfig{FunctionGeneration: "2", DeployID: "abc"},
+		reports: []report.PhaseReport{
+			{Phase: report.PhaseApply, Outcome: report.PhaseSucceeded},
+			{Phase: report.PhaseAwaitRevision, Outcome: report.PhaseFailed},
+		},
+	}
+	failure := &report.RevisionFailure{Revision: "fn-00002", Reason: "ImagePullBackOff"}
+	msg := d.failureMessage(fmt.Errorf("AwaitRevision phase failed: %w", failure))
+	if msg.Outcome != report.OutcomeFailed || msg.FailedPhase != report.PhaseAwaitRevision || msg.Reason != "ImagePullBackOff" || msg.Diagnosis != failure {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	msg = d.failureMessage(fmt.Errorf("Apply phase failed: %w", errors.NewForbidden(knativeServiceGVR.GroupResource(), "fn", fmt.Errorf("denied"))))
+	if msg.Reason != "Forbidden" || msg.Diagnosis != nil {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+}
+
+func TestSaveFullTerminationMessage(t *testing.T) {
+	client := newFakeDynamicClient()
+	// The fake client cannot apply, so the applied config map is captured
+	saved := ""
+	client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		saved = action.(k8stesting.PatchAction).GetName()
+		return true, &unstructured.Unstructured{}, nil
+	})
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+
+	msg := report.TerminationMessage{URL: "http://foo.bar"}
+	if err := saveFullTerminationMessage(context.Background(), client, cfg, &msg); err != nil || msg.FullMessageConfigMap != "" {
+		t.Fatalf("Expected a message that fits not to be saved, got %q (%v)", msg.FullMessageConfigMap, err)
+	}
+
+	msg.Error = strings.Repeat("x", 5000)
+	if err := saveFullTerminationMessage(context.Background(), client, cfg, &msg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if msg.FullMessageConfigMap != "fn-termination-message" || saved != "fn-termination-message" {
+		t.Errorf("Expected the message to point at its config map, got %q", msg.FullMessageConfigMap)
+	}
+}
+
+func TestRedactTerminationMessage(t *testing.T) {
+	secret := `se"cr<et>&1`
+	r := newRedactor("", []string{"API_TOKEN=" + secret})
+	msg := report.TerminationMessage{Version: report.Version, URL: "http://foo.bar", Error: "login with " + secret + " failed"}
+
+	redacted, err := redactTerminationMessage(r, msg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := json.Marshal(redacted)
+	if string(data) != `{"version":1,"url":"http://foo.bar","error":"login with *** failed"}` {
+		t.Errorf("Expected the secret to be masked before encoding, got %s", data)
+	}
+	if !strings.Contains(msg.Error, secret) {
+		t.Error("Expected the message of the caller to be left as it is")
+	}
+}
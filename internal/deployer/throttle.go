@@ -0,0 +1,238 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// deployThrottleConfigMapName holds the deploy slots of a namespace, one
+// key per deploying function with the time its slot expires, and one
+// queuedSlotPrefix key per waiting function with its priority and the time
+// it stops counting as waiting.
+const deployThrottleConfigMapName = "kdex-deploy-throttle"
+
+const queuedSlotPrefix = "queued."
+
+// Deploy priorities selected by FUNCTION_PRIORITY. When the throttle is
+// saturated, freed slots go to the highest priority waiting.
+const (
+	priorityCritical   = "critical"
+	priorityNormal     = "normal"
+	priorityBestEffort = "best-effort"
+)
+
+var priorityRanks = map[string]int{
+	priorityBestEffort: 0,
+	priorityNormal:     1,
+	priorityCritical:   2,
+}
+
+// defaultDeployThrottleWindow bounds how long a slot is held when the Job
+// holding it dies without releasing it.
+const defaultDeployThrottleWindow = 15 * time.Minute
+
+// deployThrottle limits how many functions may deploy at once in a
+// namespace. A zero Limit disables it.
+type deployThrottle struct {
+	Limit    int
+	Window   time.Duration
+	Priority string
+}
+
+// parseDeployThrottle reads DEPLOY_THROTTLE as the number of concurrent
+// deploys per namespace and DEPLOY_THROTTLE_WINDOW as how long a slot is
+// held at most.
+func parseDeployThrottle(cfg *EnvConfig) (deployThrottle, error) {
+	throttle := deployThrottle{Window: defaultDeployThrottleWindow, Priority: priorityNormal}
+	if cfg.DeployThrottle != "" {
+		limit, err := strconv.Atoi(cfg.DeployThrottle)
+		if err != nil || limit < 0 {
+			return deployThrottle{}, fmt.Errorf("invalid DEPLOY_THROTTLE %q: expected a non-negative integer", cfg.DeployThrottle)
+		}
+		throttle.Limit = limit
+	}
+	if cfg.DeployThrottleWindow != "" {
+		window, err := time.ParseDuration(cfg.DeployThrottleWindow)
+		if err != nil || window <= 0 {
+			return deployThrottle{}, fmt.Errorf("invalid DEPLOY_THROTTLE_WINDOW %q: expected a positive duration", cfg.DeployThrottleWindow)
+		}
+		throttle.Window = window
+	}
+	if cfg.FunctionPriority != "" {
+		if _, ok := priorityRanks[cfg.FunctionPriority]; !ok {
+			return deployThrottle{}, fmt.Errorf("unknown FUNCTION_PRIORITY: %s", cfg.FunctionPriority)
+		}
+		throttle.Priority = cfg.FunctionPriority
+	}
+	return throttle, nil
+}
+
+// slotClaim is the outcome of one attempt at claiming a deploy slot.
+type slotClaim struct {
+	Claimed bool
+	// InUse is how many slots are held.
+	InUse int
+	// Ahead lists the waiting functions of higher priority the slot was
+	// left to.
+	Ahead []string
+}
+
+// claimDeploySlot drops the expired entries and claims a slot for name
+// when fewer than limit are held and no function of higher priority is
+// waiting for one. Otherwise it queues name until queuedUntil. A function
+// redeploying keeps its own slot.
+func claimDeploySlot(slots map[string]string, name string, now, queuedUntil time.Time, throttle deployThrottle) slotClaim {
+	inUse := 0
+	for key, value := range slots {
+		expiry := value
+		if strings.HasPrefix(key, queuedSlotPrefix) {
+			_, expiry, _ = strings.Cut(value, "@")
+		}
+		expires, err := time.Parse(time.RFC3339, expiry)
+		if err != nil || !expires.After(now) {
+			delete(slots, key)
+		} else if !strings.HasPrefix(key, queuedSlotPrefix) {
+			inUse++
+		}
+	}
+
+	if _, held := slots[name]; !held {
+		ahead := []string{}
+		for key, value := range slots {
+			waiting, ok := strings.CutPrefix(key, queuedSlotPrefix)
+			priority, _, _ := strings.Cut(value, "@")
+			if ok && waiting != name && priorityRanks[priority] > priorityRanks[throttle.Priority] {
+				ahead = append(ahead, waiting)
+			}
+		}
+		if inUse >= throttle.Limit || len(ahead) > 0 {
+			slices.Sort(ahead)
+			slots[queuedSlotPrefix+name] = throttle.Priority + "@" + queuedUntil.UTC().Format(time.RFC3339)
+			return slotClaim{InUse: inUse, Ahead: ahead}
+		}
+		inUse++
+	}
+
+	delete(slots, queuedSlotPrefix+name)
+	slots[name] = now.Add(throttle.Window).UTC().Format(time.RFC3339)
+	return slotClaim{Claimed: true, InUse: inUse}
+}
+
+// acquireDeploySlot waits until the function may deploy in its namespace
+// and returns a func releasing the slot. Slots live in a ConfigMap updated
+// with optimistic concurrency, so Jobs racing for the last slot cannot
+// both win.
+func acquireDeploySlot(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, throttle deployThrottle, timing waitTiming) (func(), error) {
+	if throttle.Limit == 0 {
+		return func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timing.Timeout)
+	defer cancel()
+
+	configMaps := client.Resource(configMapGVR).Namespace(cfg.FunctionNamespace)
+	for {
+		claim, err := tryDeploySlot(ctx, configMaps, cfg, throttle, timing)
+		switch {
+		case err == nil && claim.Claimed:
+			logf("Acquired %s deploy slot in %s\n", throttle.Priority, cfg.FunctionNamespace)
+			return func() { releaseDeploySlot(configMaps, cfg.FunctionName) }, nil
+		case errors.IsConflict(err) || errors.IsAlreadyExists(err):
+			// Another Job changed the slots first; look again
+			continue
+		case err != nil:
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("timeout waiting for a deploy slot")
+			}
+			return nil, fmt.Errorf("failed to acquire deploy slot: %w", err)
+		}
+
+		if len(claim.Ahead) > 0 {
+			logf("Deferring %s deploy behind higher priority functions: %s\n", throttle.Priority, strings.Join(claim.Ahead, ", "))
+		} else {
+			logf("Waiting for a deploy slot in %s (%d of %d in use)...\n", cfg.FunctionNamespace, claim.InUse, throttle.Limit)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timeout waiting for a deploy slot")
+		case <-time.After(timing.PollInterval):
+		}
+	}
+}
+
+// tryDeploySlot makes one attempt at claiming a slot, queueing the
+// function when it has to wait. A queued function that stops polling drops
+// out of the queue after a few poll intervals.
+func tryDeploySlot(ctx context.Context, configMaps dynamic.ResourceInterface, cfg *EnvConfig, throttle deployThrottle, timing waitTiming) (slotClaim, error) {
+	configMap, err := configMaps.Get(ctx, deployThrottleConfigMapName, metav1.GetOptions{})
+	create := errors.IsNotFound(err)
+	if create {
+		configMap = &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]any{
+					"name":      deployThrottleConfigMapName,
+					"namespace": cfg.FunctionNamespace,
+				},
+			},
+		}
+	} else if err != nil {
+		return slotClaim{}, err
+	}
+
+	slots, _, err := unstructured.NestedStringMap(configMap.Object, "data")
+	if err != nil {
+		return slotClaim{}, fmt.Errorf("invalid %s config map: %w", deployThrottleConfigMapName, err)
+	}
+	if slots == nil {
+		slots = map[string]string{}
+	}
+	now := time.Now()
+	claim := claimDeploySlot(slots, cfg.FunctionName, now, now.Add(3*timing.PollInterval), throttle)
+	if err := unstructured.SetNestedStringMap(configMap.Object, slots, "data"); err != nil {
+		return slotClaim{}, err
+	}
+
+	if create {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{FieldManager: "kdex-knative-deployer"})
+	} else {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{FieldManager: "kdex-knative-deployer"})
+	}
+	if err != nil {
+		return slotClaim{}, err
+	}
+	return claim, nil
+}
+
+// releaseDeploySlot gives the slot back. Failing to is only logged; the
+// slot expires with its window.
+func releaseDeploySlot(configMaps dynamic.ResourceInterface, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for {
+		configMap, err := configMaps.Get(ctx, deployThrottleConfigMapName, metav1.GetOptions{})
+		if err == nil {
+			unstructured.RemoveNestedField(configMap.Object, "data", name)
+			unstructured.RemoveNestedField(configMap.Object, "data", queuedSlotPrefix+name)
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{FieldManager: "kdex-knative-deployer"})
+		}
+		if errors.IsConflict(err) {
+			continue
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			warnf("failed to release deploy slot: %v\n", err)
+		}
+		return
+	}
+}
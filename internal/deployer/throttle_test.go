@@ -0,0 +1,122 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseDeployThrottle(t *testing.T) {
+	throttle, err := parseDeployThrottle(&EnvConfig{})
+	if err != nil || throttle.Limit != 0 || throttle.Window != defaultDeployThrottleWindow || throttle.Priority != priorityNormal {
+		t.Errorf("Expected disabled throttle, got %+v %v", throttle, err)
+	}
+
+	throttle, err = parseDeployThrottle(&EnvConfig{DeployThrottle: "3", DeployThrottleWindow: "5m", FunctionPriority: "critical"})
+	if err != nil || throttle.Limit != 3 || throttle.Window != 5*time.Minute || throttle.Priority != priorityCritical {
+		t.Errorf("Expected 3 critical slots for 5m, got %+v %v", throttle, err)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{DeployThrottle: "-1"},
+		{DeployThrottle: "few"},
+		{DeployThrottleWindow: "0s"},
+		{FunctionPriority: "urgent"},
+	} {
+		if _, err := parseDeployThrottle(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestClaimDeploySlot(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	queuedUntil := now.Add(time.Minute)
+	throttle := deployThrottle{Limit: 2, Window: 10 * time.Minute, Priority: priorityNormal}
+	slots := map[string]string{
+		"a":     now.Add(time.Minute).Format(time.RFC3339),
+		"stale": now.Add(-time.Minute).Format(time.RFC3339),
+	}
+
+	if !claimDeploySlot(slots, "b", now, queuedUntil, throttle).Claimed {
+		t.Fatal("Expected the expired slot to be reclaimed")
+	}
+	if _, ok := slots["stale"]; ok {
+		t.Error("Expected the expired slot to be dropped")
+	}
+	if claim := claimDeploySlot(slots, "c", now, queuedUntil, throttle); claim.Claimed || claim.InUse != 2 {
+		t.Errorf("Expected no slot beyond the limit, got %+v", claim)
+	}
+	if _, ok := slots[queuedSlotPrefix+"c"]; !ok {
+		t.Error("Expected the waiting function to be queued")
+	}
+	if !claimDeploySlot(slots, "a", now, queuedUntil, throttle).Claimed {
+		t.Error("Expected a holder to keep its slot")
+	}
+	if slots["a"] != now.Add(10*time.Minute).Format(time.RFC3339) {
+		t.Errorf("Expected the slot to be renewed, got %s", slots["a"])
+	}
+}
+
+func TestClaimDeploySlotPriority(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	queuedUntil := now.Add(time.Minute)
+	slots := map[string]string{
+		"busy": now.Add(time.Minute).Format(time.RFC3339),
+	}
+	critical := deployThrottle{Limit: 1, Window: time.Minute, Priority: priorityCritical}
+	bestEffort := deployThrottle{Limit: 1, Window: time.Minute, Priority: priorityBestEffort}
+
+	claimDeploySlot(slots, "urgent", now, queuedUntil, critical)
+	claimDeploySlot(slots, "later", now, queuedUntil, bestEffort)
+	delete(slots, "busy")
+
+	claim := claimDeploySlot(slots, "later", now, queuedUntil, bestEffort)
+	if claim.Claimed || len(claim.Ahead) != 1 || claim.Ahead[0] != "urgent" {
+		t.Errorf("Expected best-effort deploy deferred behind urgent, got %+v", claim)
+	}
+	if !claimDeploySlot(slots, "urgent", now, queuedUntil, critical).Claimed {
+		t.Error("Expected the critical deploy to take the freed slot")
+	}
+	if _, ok := slots[queuedSlotPrefix+"urgent"]; ok {
+		t.Error("Expected the critical deploy to leave the queue")
+	}
+
+	// A waiter that stopped polling no longer holds others back
+	delete(slots, "urgent")
+	slots[queuedSlotPrefix+"gone"] = priorityCritical + "@" + now.Add(-time.Second).Format(time.RFC3339)
+	if !claimDeploySlot(slots, "later", now, queuedUntil, bestEffort).Claimed {
+		t.Error("Expected an expired waiter to be ignored")
+	}
+}
+
+func TestAcquireDeploySlot(t *testing.T) {
+	client := newFakeDynamicClient()
+	throttle := deployThrottle{Limit: 1, Window: time.Minute, Priority: priorityNormal}
+	timing := waitTiming{Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+
+	release, err := acquireDeploySlot(context.Background(), client, &EnvConfig{FunctionName: "a", FunctionNamespace: "myns"}, throttle, timing)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := acquireDeploySlot(context.Background(), client, &EnvConfig{FunctionName: "b", FunctionNamespace: "myns"}, throttle, timing); err == nil {
+		t.Fatal("Expected a timeout while the only slot is held")
+	}
+
+	release()
+	configMap, err := client.Resource(configMapGVR).Namespace("myns").Get(context.Background(), deployThrottleConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if slots, _, _ := unstructured.NestedStringMap(configMap.Object, "data"); slots["a"] != "" {
+		t.Errorf("Expected the slot to be released, got %v", slots)
+	}
+
+	if _, err := acquireDeploySlot(context.Background(), client, &EnvConfig{FunctionName: "b", FunctionNamespace: "myns"}, throttle, timing); err != nil {
+		t.Errorf("Expected the released slot to be free: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package deployer
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+func validateTracing(cfg *EnvConfig) error {
+	if cfg.TracingEnabled != "true" {
+		return nil
+	}
+	if cfg.TracingEndpoint == "" {
+		return fmt.Errorf("TRACING_ENDPOINT is required when TRACING_ENABLED is true")
+	}
+	if cfg.TracingSampleRatio != "" {
+		ratio, err := strconv.ParseFloat(cfg.TracingSampleRatio, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return fmt.Errorf("invalid TRACING_SAMPLE_RATIO %q: expected a number between 0 and 1", cfg.TracingSampleRatio)
+		}
+	}
+	return nil
+}
+
+// tracingEnv is the OpenTelemetry SDK configuration injected into the
+// function when tracing is enabled platform-wide, so every function reports
+// to the same collector under a consistent identity. Variables the function
+// forwards itself win.
+func tracingEnv(cfg *EnvConfig) []map[string]any {
+	if cfg.TracingEnabled != "true" {
+		return nil
+	}
+
+	vars := [][2]string{
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", cfg.TracingEndpoint},
+		{"OTEL_SERVICE_NAME", cfg.FunctionName},
+		{"OTEL_RESOURCE_ATTRIBUTES", fmt.Sprintf("service.namespace=%s,service.version=%s,k8s.namespace.name=%s,kdex.function.generation=%s",
+			cfg.FunctionNamespace, cfg.FunctionGeneration, cfg.FunctionNamespace, cfg.FunctionGeneration)},
+		{"OTEL_PROPAGATORS", "tracecontext,baggage"},
+	}
+	if cfg.TracingSampleRatio != "" {
+		vars = append(vars,
+			[2]string{"OTEL_TRACES_SAMPLER", "parentbased_traceidratio"},
+			[2]string{"OTEL_TRACES_SAMPLER_ARG", cfg.TracingSampleRatio},
+		)
+	}
+
+	forwarded := forwardedEnvVars(cfg)
+	env := []map[string]any{}
+	for _, v := range vars {
+		if slices.Contains(forwarded, v[0]) {
+			continue
+		}
+		env = append(env, map[string]any{"name": v[0], "value": v[1]})
+	}
+	return env
+}
@@ -0,0 +1,66 @@
+package deployer
+
+import (
+	"testing"
+)
+
+func TestValidateTracing(t *testing.T) {
+	for _, cfg := range []*EnvConfig{
+		{},
+		{TracingEnabled: "true", TracingEndpoint: "http://otel-collector:4317"},
+		{TracingEnabled: "true", TracingEndpoint: "http://otel-collector:4317", TracingSampleRatio: "0.25"},
+	} {
+		if err := validateTracing(cfg); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", cfg, err)
+		}
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{TracingEnabled: "true"},
+		{TracingEnabled: "true", TracingEndpoint: "http://otel-collector:4317", TracingSampleRatio: "2"},
+	} {
+		if err := validateTracing(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestTracingEnv(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "myfunc",
+		FunctionNamespace:  "myns",
+		FunctionGeneration: "4",
+		TracingEndpoint:    "http://otel-collector:4317",
+		TracingSampleRatio: "0.1",
+	}
+
+	if env := tracingEnv(cfg); len(env) != 0 {
+		t.Errorf("Expected no env with tracing disabled, got %v", env)
+	}
+
+	cfg.TracingEnabled = "true"
+	values := map[string]any{}
+	for _, e := range tracingEnv(cfg) {
+		values[e["name"].(string)] = e["value"]
+	}
+	want := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel-collector:4317",
+		"OTEL_SERVICE_NAME":           "myfunc",
+		"OTEL_RESOURCE_ATTRIBUTES":    "service.namespace=myns,service.version=4,k8s.namespace.name=myns,kdex.function.generation=4",
+		"OTEL_PROPAGATORS":            "tracecontext,baggage",
+		"OTEL_TRACES_SAMPLER":         "parentbased_traceidratio",
+		"OTEL_TRACES_SAMPLER_ARG":     "0.1",
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s: expected %q, got %v", name, value, values[name])
+		}
+	}
+
+	cfg.ForwardedEnvVars = "OTEL_SERVICE_NAME"
+	for _, e := range tracingEnv(cfg) {
+		if e["name"] == "OTEL_SERVICE_NAME" {
+			t.Error("Expected forwarded OTEL_SERVICE_NAME to win")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package deployer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	trafficLatest  = "latest"
+	trafficCurrent = "current"
+)
+
+// trafficTarget is one entry of TRAFFIC.
+type trafficTarget struct {
+	// Target is "latest", "current" or a revision name.
+	Target  string
+	Tag     string
+	Percent int64
+}
+
+// parseTraffic parses TRAFFIC, a comma separated list of target=percent
+// entries where target is "latest", "current" (the revision serving before
+// this deploy) or a revision name, optionally suffixed with @tag to expose
+// the target under its own URL, e.g. "current@stable=90,latest@canary=10".
+// Percentages must add up to 100.
+func parseTraffic(value string) ([]trafficTarget, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	targets := []trafficTarget{}
+	var total int64
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, percent, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid TRAFFIC entry %q: expected target=percent", entry)
+		}
+		p, err := strconv.ParseInt(strings.TrimSpace(percent), 10, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid TRAFFIC entry %q: percent must be between 0 and 100", entry)
+		}
+		target, tag, _ := strings.Cut(strings.TrimSpace(target), "@")
+		if target == "" {
+			return nil, fmt.Errorf("invalid TRAFFIC entry %q: missing target", entry)
+		}
+
+		targets = append(targets, trafficTarget{Target: target, Tag: tag, Percent: p})
+		total += p
+	}
+
+	if total != 100 {
+		return nil, fmt.Errorf("invalid TRAFFIC %q: percentages add up to %d, not 100", value, total)
+	}
+
+	return targets, nil
+}
+
+// buildTraffic renders spec.traffic, resolving "current" to the revision
+// that was serving before this deploy.
+func buildTraffic(targets []trafficTarget, currentRevision string) ([]any, error) {
+	traffic := []any{}
+	for _, t := range targets {
+		entry := map[string]any{
+			"percent": t.Percent,
+		}
+		switch t.Target {
+		case trafficLatest:
+			entry["latestRevision"] = true
+		case trafficCurrent:
+			if currentRevision == "" {
+				return nil, fmt.Errorf("TRAFFIC references the current revision but the service has no ready revision yet")
+			}
+			entry["revisionName"] = currentRevision
+			entry["latestRevision"] = false
+		default:
+			entry["revisionName"] = t.Target
+			entry["latestRevision"] = false
+		}
+		if t.Tag != "" {
+			entry["tag"] = t.Tag
+		}
+		traffic = append(traffic, entry)
+	}
+	return traffic, nil
+}
+
+// taggedURLs returns the URL of every tagged traffic target of a Service.
+func taggedURLs(obj *unstructured.Unstructured) map[string]string {
+	traffic, _, _ := unstructured.NestedSlice(obj.Object, "status", "traffic")
+	urls := map[string]string{}
+	for _, t := range traffic {
+		entry, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		tag, _ := entry["tag"].(string)
+		url, _ := entry["url"].(string)
+		if tag != "" && url != "" {
+			urls[tag] = url
+		}
+	}
+	return urls
+}
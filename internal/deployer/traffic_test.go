@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseTraffic(t *testing.T) {
+	targets, err := parseTraffic("")
+	if err != nil || targets != nil {
+		t.Errorf("Expected no targets for empty TRAFFIC. Got %v, %v", targets, err)
+	}
+
+	targets, err = parseTraffic("current@stable=90, latest@canary=10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0] != (trafficTarget{Target: "current", Tag: "stable", Percent: 90}) {
+		t.Errorf("Unexpected target: %+v", targets[0])
+	}
+	if targets[1] != (trafficTarget{Target: "latest", Tag: "canary", Percent: 10}) {
+		t.Errorf("Unexpected target: %+v", targets[1])
+	}
+
+	for _, value := range []string{
+		"latest=90",
+		"latest=50,current=60",
+		"latest",
+		"latest=abc",
+		"latest=110,current=-10",
+		"=100",
+	} {
+		if _, err := parseTraffic(value); err == nil {
+			t.Errorf("Expected error for TRAFFIC=%q", value)
+		}
+	}
+}
+
+func TestBuildTraffic(t *testing.T) {
+	targets, _ := parseTraffic("current=80,myfunc-00001@old=0,latest=20")
+
+	if _, err := buildTraffic(targets, ""); err == nil {
+		t.Error("Expected error when current revision is unknown")
+	}
+
+	traffic, err := buildTraffic(targets, "myfunc-00002")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	current := traffic[0].(map[string]any)
+	if current["revisionName"] != "myfunc-00002" || current["percent"] != int64(80) {
+		t.Errorf("Unexpected current target: %v", current)
+	}
+	pinned := traffic[1].(map[string]any)
+	if pinned["revisionName"] != "myfunc-00001" || pinned["tag"] != "old" {
+		t.Errorf("Unexpected pinned target: %v", pinned)
+	}
+	latest := traffic[2].(map[string]any)
+	if latest["latestRevision"] != true {
+		t.Errorf("Unexpected latest target: %v", latest)
+	}
+}
+
+func TestTaggedURLs(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]any{
+			"status": map[string]any{
+				"traffic": []any{
+					map[string]any{"percent": int64(90), "revisionName": "myfunc-00001"},
+					map[string]any{"percent": int64(10), "tag": "canary", "url": "http://canary-myfunc"},
+				},
+			},
+		},
+	}
+
+	urls := taggedURLs(obj)
+	if len(urls) != 1 || urls["canary"] != "http://canary-myfunc" {
+		t.Errorf("Unexpected tagged URLs: %v", urls)
+	}
+}
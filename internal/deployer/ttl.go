@@ -0,0 +1,107 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// ttlAnnotation on a KDexFunction is how long it lives after its creation,
+// as a Go duration such as 24h, for demos and other short-lived functions.
+const ttlAnnotation = "kdex.dev/ttl"
+
+// stateExpired is the final state of a function torn down by its TTL.
+const stateExpired = "Expired"
+
+// functionExpiry reads when the function expires, reporting false when it
+// has no TTL.
+func functionExpiry(function *unstructured.Unstructured) (time.Time, bool, error) {
+	value := function.GetAnnotations()[ttlAnnotation]
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return time.Time{}, false, fmt.Errorf("invalid %s annotation %q: expected a positive duration such as 24h", ttlAnnotation, value)
+	}
+	created := function.GetCreationTimestamp()
+	if created.IsZero() {
+		return time.Time{}, false, nil
+	}
+	return created.Add(ttl), true, nil
+}
+
+// expireFunction tears down the function once its TTL expired by now: it
+// deletes the Service and everything deployed alongside it, writes the
+// final Expired status, records an Event and deletes the KDexFunction. It
+// reports whether the function expired.
+func expireFunction(ctx context.Context, client dynamic.Interface, cfg *EnvConfig, function *unstructured.Unstructured, now time.Time) (bool, error) {
+	expires, ok, err := functionExpiry(function)
+	if err != nil {
+		warnf("%v\n", err)
+		return false, nil
+	}
+	if !ok || now.Before(expires) {
+		return false, nil
+	}
+
+	logf("Function %s/%s expired at %s; tearing it down\n", cfg.FunctionNamespace, cfg.FunctionName, expires.Format(time.RFC3339))
+	if _, err := deleteFunction(ctx, client, cfg); err != nil {
+		return false, fmt.Errorf("failed to tear down expired function: %w", err)
+	}
+
+	functions := client.Resource(kdexFunctionGVR).Namespace(cfg.FunctionNamespace)
+	message := fmt.Sprintf("TTL %s expired at %s", function.GetAnnotations()[ttlAnnotation], expires.UTC().Format(time.RFC3339))
+	if err := recordExpiredStatus(ctx, functions, function, message, statusDetail(cfg, detailExpired, "", message, message), now); err != nil {
+		warnf("failed to record expired status: %v\n", err)
+	}
+
+	n := newNotification(cfg, notificationExpired)
+	n.Message = message
+	if err := (eventNotifier{client: client}).Notify(ctx, n); err != nil {
+		warnf("failed to record event: %v\n", err)
+	}
+
+	err = functions.Delete(ctx, cfg.FunctionName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return true, fmt.Errorf("failed to delete expired kdex function: %w", err)
+	}
+	return true, nil
+}
+
+// recordExpiredStatus writes the Expired state with its detail and a Ready
+// condition saying why, which a finalizer holding the KDexFunction leaves
+// readable.
+func recordExpiredStatus(ctx context.Context, functions dynamic.ResourceInterface, function *unstructured.Unstructured, message, detail string, now time.Time) error {
+	conditions, _, _ := unstructured.NestedSlice(function.Object, "status", "conditions")
+	condition := map[string]any{
+		"type":               conditionReady,
+		"status":             string(metav1.ConditionFalse),
+		"reason":             stateExpired,
+		"message":            message,
+		"observedGeneration": function.GetGeneration(),
+		"lastTransitionTime": now.UTC().Format(time.RFC3339),
+	}
+	patchBytes, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"state":      stateExpired,
+			"url":        "",
+			"detail":     detail,
+			"conditions": setCondition(conditions, condition),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = functions.Patch(ctx, function.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{
+		FieldManager: "kdex-knative-observer",
+	}, "status")
+	return err
+}
@@ -0,0 +1,60 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFunctionExpiry(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil)
+	function.SetCreationTimestamp(metav1.NewTime(created))
+
+	if _, ok, err := functionExpiry(function); ok || err != nil {
+		t.Errorf("Expected no expiry without a TTL, got %v, %v", ok, err)
+	}
+
+	function.SetAnnotations(map[string]string{ttlAnnotation: "36h"})
+	expires, ok, err := functionExpiry(function)
+	if !ok || err != nil || !expires.Equal(created.Add(36*time.Hour)) {
+		t.Errorf("Expected expiry 36h after creation, got %v, %v, %v", expires, ok, err)
+	}
+
+	function.SetAnnotations(map[string]string{ttlAnnotation: "tomorrow"})
+	if _, _, err := functionExpiry(function); err == nil {
+		t.Error("Expected an invalid TTL to be rejected")
+	}
+}
+
+func TestExpireFunction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	function := newObject("kdex.dev/v1alpha1", "KDexFunction", "ns", "fn", nil)
+	function.SetCreationTimestamp(metav1.NewTime(now.Add(-48 * time.Hour)))
+	function.SetAnnotations(map[string]string{ttlAnnotation: "24h"})
+	client := newFakeDynamicClient(function, newObject("serving.knative.dev/v1", "Service", "ns", "fn", nil))
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+
+	if expired, err := expireFunction(ctx, client, cfg, function, now.Add(-36*time.Hour)); expired || err != nil {
+		t.Fatalf("Expected the function to live on before its TTL, got %v, %v", expired, err)
+	}
+
+	expired, err := expireFunction(ctx, client, cfg, function, now)
+	if err != nil || !expired {
+		t.Fatalf("Expected the function to expire, got %v, %v", expired, err)
+	}
+	if _, err := client.Resource(knativeServiceGVR).Namespace("ns").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the service to be deleted, got %v", err)
+	}
+	if _, err := client.Resource(kdexFunctionGVR).Namespace("ns").Get(ctx, "fn", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the kdex function to be deleted, got %v", err)
+	}
+	events, err := client.Resource(eventGVR).Namespace("ns").List(ctx, metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 || events.Items[0].Object["reason"] != notificationExpired {
+		t.Errorf("Expected an Expired event, got %v (%v)", events, err)
+	}
+}
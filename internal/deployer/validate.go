@@ -0,0 +1,352 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+	selfSubjectAccessReviewGVR = schema.GroupVersionResource{
+		Group:    "authorization.k8s.io",
+		Version:  "v1",
+		Resource: "selfsubjectaccessreviews",
+	}
+)
+
+// Checks a validation issue is reported by.
+const (
+	checkConfig     = "config"
+	checkCluster    = "cluster"
+	checkNamespace  = "namespace"
+	checkCRD        = "crd"
+	checkPermission = "rbac"
+)
+
+// validationIssue is one problem found by the validate command, with what
+// to do about it.
+type validationIssue struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// permission is an access the deploy needs, in the function namespace
+// unless another is given.
+type permission struct {
+	gvr         schema.GroupVersionResource
+	subresource string
+	verb        string
+	namespace   string
+}
+
+func (p permission) String() string {
+	resource := p.gvr.Resource
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	if p.gvr.Group != "" {
+		resource += "." + p.gvr.Group
+	}
+	return p.verb + " " + resource
+}
+
+// runValidate checks everything a deploy needs before one is attempted,
+// reporting every issue found rather than stopping at the first, for
+// pipelines to gate the deploy Job on.
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	output := flags.String("output", "text", "output format: text or json")
+	configOnly := flags.Bool("config-only", false, "only check the env config, without contacting the cluster")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("invalid output %q: expected text or json", *output)
+	}
+
+	cfg := readEnv()
+	logFunction(cfg)
+	issues := validateConfig(cfg)
+	if !*configOnly && cfg.FunctionName != "" && cfg.FunctionNamespace != "" {
+		client, err := getDynamicClient()
+		if err != nil {
+			issues = append(issues, validationIssue{checkCluster, fmt.Sprintf("cannot connect to the cluster: %v; check the kubeconfig, or the service account of the Job", err)})
+		} else {
+			issues = append(issues, validateCluster(context.Background(), client, cfg)...)
+		}
+	}
+
+	if err := writeValidation(outputRedactor().Writer(os.Stdout), *output, cfg, issues); err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("validation found %d issue(s)", len(issues))
+	}
+	return nil
+}
+
+// validateConfig checks the env config as the deploy would, each setting
+// on its own.
+func validateConfig(cfg *EnvConfig) []validationIssue {
+	issues := []validationIssue{}
+	add := func(err error) {
+		if err != nil {
+			issues = append(issues, validationIssue{checkConfig, err.Error()})
+		}
+	}
+
+	if cfg.FunctionName == "" {
+		add(fmt.Errorf("FUNCTION_NAME is required"))
+	}
+	if cfg.FunctionNamespace == "" {
+		add(fmt.Errorf("FUNCTION_NAMESPACE is required"))
+	}
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit == "" {
+		add(fmt.Errorf("FUNCTION_IMAGE or FUNCTION_SOURCE_GIT is required for deploy"))
+	}
+	if cfg.SkipTrafficShift == "true" && cfg.Traffic != "" {
+		add(fmt.Errorf("TRAFFIC cannot be combined with SKIP_TRAFFIC_SHIFT"))
+	}
+
+	_, err := parseWaitTiming(cfg)
+	add(err)
+	_, err = parseDeployThrottle(cfg)
+	add(err)
+	_, err = parseDeployQuota(cfg)
+	add(err)
+	_, err = parseSoakDuration(cfg)
+	add(err)
+	_, err = parseLogTail(cfg)
+	add(err)
+	_, err = parseReadinessRegressionFactor(cfg)
+	add(err)
+	for _, validate := range configValidators {
+		add(validate(cfg))
+	}
+	add(verifyDeployPayload(cfg))
+	// Falls back to the built-in messages when deploying, with a warning
+	// easily missed
+	_, err = loadMessageTemplates(cfg.MessageTemplates)
+	add(err)
+
+	// The Service is only built from settings that passed their checks
+	if len(issues) == 0 && cfg.FunctionImage != "" {
+		_, err := buildService(cfg, serviceState{})
+		add(err)
+	}
+	return issues
+}
+
+// validateCluster checks that the cluster is reachable, serves the CRDs,
+// has the function namespace and lets the deploy do what it needs to.
+func validateCluster(ctx context.Context, client dynamic.Interface, cfg *EnvConfig) []validationIssue {
+	issues := []validationIssue{}
+
+	_, err := client.Resource(namespaceGVR).Get(ctx, cfg.FunctionNamespace, metav1.GetOptions{})
+	switch {
+	case err == nil, errors.IsForbidden(err):
+		// Reading namespaces is not a permission the deploy needs
+	case errors.IsNotFound(err):
+		issues = append(issues, validationIssue{checkNamespace, fmt.Sprintf("namespace %s does not exist; create it or fix FUNCTION_NAMESPACE", cfg.FunctionNamespace)})
+	case !isAPIError(err):
+		return append(issues, validationIssue{checkCluster, fmt.Sprintf("cannot reach the cluster: %v", err)})
+	default:
+		issues = append(issues, validationIssue{checkNamespace, fmt.Sprintf("failed to get namespace %s: %v", cfg.FunctionNamespace, err)})
+	}
+
+	for _, crd := range []struct {
+		gvr     schema.GroupVersionResource
+		install string
+	}{
+		{knativeServiceGVR, "install Knative Serving"},
+		{kdexFunctionGVR, "install the KDex CRDs"},
+	} {
+		_, err := client.Resource(crd.gvr).Namespace(cfg.FunctionNamespace).List(ctx, metav1.ListOptions{Limit: 1})
+		if errors.IsNotFound(err) {
+			issues = append(issues, validationIssue{checkCRD, fmt.Sprintf("the cluster does not serve %s.%s/%s; %s", crd.gvr.Resource, crd.gvr.Group, crd.gvr.Version, crd.install)})
+		}
+		// Being forbidden to list is reported with the permissions
+	}
+
+	for _, p := range requiredPermissions(cfg) {
+		namespace := p.namespace
+		if namespace == "" {
+			namespace = cfg.FunctionNamespace
+		}
+		allowed, err := canI(ctx, client, namespace, p)
+		if err != nil {
+			issues = append(issues, validationIssue{checkPermission, fmt.Sprintf("failed to check whether the deploy can %s: %v", p, err)})
+			continue
+		}
+		if !allowed {
+			issues = append(issues, validationIssue{checkPermission, fmt.Sprintf("cannot %s in namespace %s; grant it to the service account of the deploy Job", p, namespace)})
+		}
+	}
+	return issues
+}
+
+// requiredPermissions are the accesses the deploy of cfg needs, derived
+// from the settings the deploy enables each step with. Resources deployed
+// alongside the Service are applied when their setting is given and
+// deleted, as left over from an earlier deploy, when it is not. Settings
+// that fail to parse are reported by validateConfig and need nothing here.
+func requiredPermissions(cfg *EnvConfig) []permission {
+	permissions := []permission{
+		{gvr: knativeServiceGVR, verb: "get"},
+		{gvr: knativeServiceGVR, verb: "list"},
+		{gvr: knativeServiceGVR, verb: "watch"},
+		{gvr: knativeServiceGVR, verb: "patch"},
+		{gvr: knativeRevisionGVR, verb: "get"},
+		{gvr: knativeRevisionGVR, verb: "list"},
+		{gvr: kdexFunctionGVR, verb: "get"},
+		{gvr: kdexFunctionGVR, subresource: "status", verb: "patch"},
+		{gvr: configMapGVR, verb: "get"},
+		{gvr: configMapGVR, verb: "patch"},
+		{gvr: eventGVR, verb: "create"},
+	}
+
+	applyOrDelete := func(gvr schema.GroupVersionResource, enabled bool) {
+		verb := "delete"
+		if enabled {
+			verb = "patch"
+		}
+		permissions = append(permissions, permission{gvr: gvr, verb: verb})
+	}
+	applyOrDelete(requestAuthenticationGVR, cfg.RequestAuthentication != "")
+	applyOrDelete(authorizationPolicyGVR, cfg.RequestAuthentication != "")
+	applyOrDelete(sinkBindingGVR, cfg.EventSink != "")
+	applyOrDelete(networkPolicyGVR, cfg.EgressAllow != "")
+	applyOrDelete(serviceEntryGVR, cfg.EgressIstio == "true")
+	applyOrDelete(sidecarGVR, cfg.EgressIstio == "true")
+	applyOrDelete(pingSourceGVR, cfg.ScheduleCron != "")
+	if limits, err := parseMessageLimits(cfg); err == nil {
+		applyOrDelete(envoyFilterGVR, !limits.empty())
+	}
+	if affinity, err := parseSessionAffinity(cfg); err == nil {
+		applyOrDelete(destinationRuleGVR, affinity != nil)
+	}
+	if cfg.FunctionHost != "" {
+		permissions = append(permissions, permission{gvr: domainMappingGVR, verb: "patch"}, permission{gvr: domainMappingGVR, verb: "get"})
+		if cfg.FunctionHostTLSIssuer != "" {
+			permissions = append(permissions, permission{gvr: certificateGVR, verb: "patch"}, permission{gvr: certificateGVR, verb: "get"})
+		}
+	}
+
+	// Scale profiles no longer configured are listed to be deleted
+	permissions = append(permissions, permission{gvr: cronJobGVR, verb: "list"}, permission{gvr: cronJobGVR, verb: "delete"})
+	if profiles, err := parseScaleProfiles(cfg); err == nil && len(profiles) > 0 {
+		permissions = append(permissions, permission{gvr: cronJobGVR, verb: "patch"})
+	}
+
+	// Deploy slots and quotas are claimed with optimistic concurrency
+	if throttle, err := parseDeployThrottle(cfg); err == nil && throttle.Limit > 0 {
+		permissions = append(permissions, permission{gvr: configMapGVR, verb: "create"}, permission{gvr: configMapGVR, verb: "update"})
+	}
+	if quota, err := parseDeployQuota(cfg); err == nil && quota.Limit > 0 && cfg.ReadOnly != "true" {
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, permission{gvr: configMapGVR, verb: verb, namespace: cfg.DeployQuotaNamespace})
+		}
+	}
+
+	if cfg.FunctionImage == "" && cfg.FunctionSourceGit != "" && cfg.ReadOnly != "true" {
+		switch cfg.BuildStrategy {
+		case "", buildStrategyKpack:
+			permissions = append(permissions, permission{gvr: kpackImageGVR, verb: "patch"}, permission{gvr: kpackImageGVR, verb: "get"})
+		case buildStrategyTekton:
+			permissions = append(permissions, permission{gvr: tektonPipelineRunGVR, verb: "create"}, permission{gvr: tektonPipelineRunGVR, verb: "get"})
+		}
+	}
+	if cfg.ResolveImageDigest == "true" || cfg.VerifyImageSignature == "true" {
+		// For the pull secrets of the service account of the revision
+		permissions = append(permissions, permission{gvr: serviceAccountGVR, verb: "get"}, permission{gvr: secretGVR, verb: "get"})
+	}
+	if cfg.LogSink == logSinkOTel {
+		permissions = append(permissions, permission{gvr: otelCollectorGVR, verb: "patch"})
+	}
+	if cfg.IPFamilyPolicy != "" || cfg.IPFamilies != "" {
+		permissions = append(permissions, permission{gvr: coreServiceGVR, verb: "patch"})
+	}
+	if soak, err := parseSoakDuration(cfg); err == nil && soak > 0 {
+		permissions = append(permissions, permission{gvr: podGVR, verb: "list"})
+	}
+	if lines, err := parseLogTail(cfg); err == nil && lines > 0 {
+		permissions = append(permissions, permission{gvr: podGVR, verb: "list"}, permission{gvr: podGVR, subresource: "log", verb: "get"})
+	}
+	if cfg.AlertsEnabled == "true" || cfg.SLOAvailabilityTarget != "" {
+		permissions = append(permissions, permission{gvr: prometheusRuleGVR, verb: "patch"})
+	}
+	if cfg.DashboardProvisioning == dashboardProvisioningOperator {
+		permissions = append(permissions, permission{gvr: grafanaDashboardGVR, verb: "patch"})
+	}
+	return slices.Compact(permissions)
+}
+
+// canI asks the cluster whether the deployer has p in namespace. A review
+// the cluster did not answer, as with READ_ONLY, counts as allowed.
+func canI(ctx context.Context, client dynamic.Interface, namespace string, p permission) (bool, error) {
+	review := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": selfSubjectAccessReviewGVR.GroupVersion().String(),
+			"kind":       "SelfSubjectAccessReview",
+			"spec": map[string]any{
+				"resourceAttributes": map[string]any{
+					"namespace":   namespace,
+					"verb":        p.verb,
+					"group":       p.gvr.Group,
+					"resource":    p.gvr.Resource,
+					"subresource": p.subresource,
+				},
+			},
+		},
+	}
+	result, err := client.Resource(selfSubjectAccessReviewGVR).Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	allowed, found, _ := unstructured.NestedBool(result.Object, "status", "allowed")
+	return allowed || !found, nil
+}
+
+// isAPIError tells whether err is an answer of the API server, rather than
+// a failure to reach it.
+func isAPIError(err error) bool {
+	var status errors.APIStatus
+	return stderrors.As(err, &status)
+}
+
+// writeValidation prints the issues, one per line, or as JSON.
+func writeValidation(w io.Writer, output string, cfg *EnvConfig, issues []validationIssue) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(map[string]any{"valid": len(issues) == 0, "issues": issues}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	if len(issues) == 0 {
+		_, err := fmt.Fprintf(w, "%s/%s is ready to deploy\n", cfg.FunctionNamespace, cfg.FunctionName)
+		return err
+	}
+	var out strings.Builder
+	for _, issue := range issues {
+		fmt.Fprintf(&out, "[%s] %s\n", issue.Check, issue.Message)
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
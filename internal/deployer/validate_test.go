@@ -0,0 +1,145 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionImage: "registry.example.com/fn:1"}
+	if issues := validateConfig(cfg); len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+
+	issues := validateConfig(&EnvConfig{ScheduleCron: "* * * *", FunctionLogLevel: "trace"})
+	var messages []string
+	for _, issue := range issues {
+		if issue.Check != checkConfig {
+			t.Errorf("Unexpected check %q", issue.Check)
+		}
+		messages = append(messages, issue.Message)
+	}
+	all := strings.Join(messages, "\n")
+	for _, want := range []string{"FUNCTION_NAME", "FUNCTION_NAMESPACE", "FUNCTION_IMAGE", "SCHEDULE_CRON", "FUNCTION_LOG_LEVEL"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected every issue to be reported, %s missing from:\n%s", want, all)
+		}
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", ScheduleCron: "@hourly"}
+	client := newFakeDynamicClient(&unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]any{"name": "ns"},
+	}})
+	client.PrependReactor("list", "kdexfunctions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(kdexFunctionGVR.GroupResource(), "")
+	})
+	var reviewed []string
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		verb, _, _ := unstructured.NestedString(review.Object, "spec", "resourceAttributes", "verb")
+		resource, _, _ := unstructured.NestedString(review.Object, "spec", "resourceAttributes", "resource")
+		reviewed = append(reviewed, verb+" "+resource)
+		review = review.DeepCopy()
+		review.Object["status"] = map[string]any{"allowed": !(verb == "patch" && resource == "pingsources")}
+		return true, review, nil
+	})
+
+	issues := validateCluster(context.Background(), client, cfg)
+	if len(issues) != 2 {
+		t.Fatalf("Expected the missing CRD and permission, got %v", issues)
+	}
+	if issues[0].Check != checkCRD || !strings.Contains(issues[0].Message, "kdexfunctions.kdex.dev") {
+		t.Errorf("Unexpected CRD issue %+v", issues[0])
+	}
+	if issues[1].Check != checkPermission || !strings.Contains(issues[1].Message, "cannot patch pingsources.sources.knative.dev in namespace ns") {
+		t.Errorf("Unexpected permission issue %+v", issues[1])
+	}
+	if !strings.Contains(strings.Join(reviewed, ","), "patch services") {
+		t.Errorf("Expected the service permissions to be reviewed, got %v", reviewed)
+	}
+
+	issues = validateCluster(context.Background(), client, &EnvConfig{FunctionName: "fn", FunctionNamespace: "missing"})
+	if len(issues) == 0 || issues[0].Check != checkNamespace {
+		t.Errorf("Expected the missing namespace to be reported, got %v", issues)
+	}
+}
+
+func TestWriteValidation(t *testing.T) {
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns"}
+	var out bytes.Buffer
+	if err := writeValidation(&out, "text", cfg, nil); err != nil || out.String() != "ns/fn is ready to deploy\n" {
+		t.Errorf("Unexpected output %q (%v)", out.String(), err)
+	}
+
+	out.Reset()
+	issues := []validationIssue{{checkNamespace, "namespace ns does not exist"}}
+	if err := writeValidation(&out, "text", cfg, issues); err != nil || out.String() != "[namespace] namespace ns does not exist\n" {
+		t.Errorf("Unexpected output %q (%v)", out.String(), err)
+	}
+
+	out.Reset()
+	if err := writeValidation(&out, "json", cfg, issues); err != nil || !strings.Contains(out.String(), `"valid": false`) {
+		t.Errorf("Unexpected output %q (%v)", out.String(), err)
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	has := func(permissions []permission, want permission) bool {
+		for _, p := range permissions {
+			if p == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	cfg := &EnvConfig{FunctionName: "fn", FunctionNamespace: "ns", FunctionImage: "registry.example.com/fn:1"}
+	permissions := requiredPermissions(cfg)
+	for _, unwanted := range []permission{
+		{gvr: configMapGVR, verb: "create"},
+		{gvr: prometheusRuleGVR, verb: "patch"},
+		{gvr: kpackImageGVR, verb: "patch"},
+	} {
+		if has(permissions, unwanted) {
+			t.Errorf("Expected no %s without the setting that needs it", unwanted)
+		}
+	}
+
+	cfg = &EnvConfig{
+		FunctionName:          "fn",
+		FunctionNamespace:     "ns",
+		FunctionSourceGit:     "https://example.com/fn.git",
+		BuildStrategy:         buildStrategyTekton,
+		DeployThrottle:        "2",
+		DeployQuota:           "20/1h",
+		DeployQuotaNamespace:  "platform",
+		SLOAvailabilityTarget: "99.9",
+		LogSink:               logSinkOTel,
+	}
+	permissions = requiredPermissions(cfg)
+	for _, want := range []permission{
+		{gvr: configMapGVR, verb: "create"},
+		{gvr: configMapGVR, verb: "update", namespace: "platform"},
+		{gvr: prometheusRuleGVR, verb: "patch"},
+		{gvr: otelCollectorGVR, verb: "patch"},
+		{gvr: tektonPipelineRunGVR, verb: "create"},
+		{gvr: cronJobGVR, verb: "delete"},
+		{gvr: envoyFilterGVR, verb: "delete"},
+		{gvr: destinationRuleGVR, verb: "delete"},
+	} {
+		if !has(permissions, want) {
+			t.Errorf("Expected %s in namespace %q to be required, got %v", want, want.namespace, permissions)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package deployer
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// visibilityLabel on the Service decides whether Knative exposes it on the
+// external domain.
+const visibilityLabel = "networking.knative.dev/visibility"
+
+const (
+	// visibilityExternal is Knative's default: reachable on the external
+	// domain as well as inside the cluster.
+	visibilityExternal = "external"
+	// visibilityClusterLocal only serves the function inside the cluster.
+	visibilityClusterLocal = "cluster-local"
+)
+
+func validateVisibility(cfg *EnvConfig) error {
+	switch cfg.FunctionVisibility {
+	case "", visibilityExternal, visibilityClusterLocal:
+		return nil
+	default:
+		return fmt.Errorf("invalid FUNCTION_VISIBILITY %q: expected %s or %s", cfg.FunctionVisibility, visibilityExternal, visibilityClusterLocal)
+	}
+}
+
+// applyVisibility labels a cluster-local Service. External needs no label,
+// and leaving it out drops one applied by an earlier deploy.
+func applyVisibility(service *unstructured.Unstructured, cfg *EnvConfig) {
+	if cfg.FunctionVisibility != visibilityClusterLocal {
+		return
+	}
+	labels := service.GetLabels()
+	labels[visibilityLabel] = visibilityClusterLocal
+	service.SetLabels(labels)
+}
+
+// serviceURL is the URL the function is reached at. For a cluster-local
+// Service that is its address inside the cluster.
+func serviceURL(obj *unstructured.Unstructured) string {
+	if obj.GetLabels()[visibilityLabel] == visibilityClusterLocal {
+		if url, _, _ := unstructured.NestedString(obj.Object, "status", "address", "url"); url != "" {
+			return url
+		}
+	}
+	url, _, _ := unstructured.NestedString(obj.Object, "status", "url")
+	return url
+}
@@ -0,0 +1,62 @@
+package deployer
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBuildServiceVisibility(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:       "fn",
+		FunctionNamespace:  "default",
+		FunctionImage:      "registry.example.com/fn:v1",
+		FunctionVisibility: visibilityClusterLocal,
+	}
+	if err := validateVisibility(cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := service.GetLabels()[visibilityLabel]; got != visibilityClusterLocal {
+		t.Errorf("Expected the cluster-local label, got %q", got)
+	}
+
+	cfg.FunctionVisibility = visibilityExternal
+	service, err = buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := service.GetLabels()[visibilityLabel]; ok {
+		t.Error("Expected no visibility label for an external function")
+	}
+
+	if err := validateVisibility(&EnvConfig{FunctionVisibility: "private"}); err == nil {
+		t.Error("Expected an error for an unknown FUNCTION_VISIBILITY")
+	}
+}
+
+func TestServiceURL(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]any{visibilityLabel: visibilityClusterLocal},
+		},
+		"status": map[string]any{
+			"url":     "http://fn.default.example.com",
+			"address": map[string]any{"url": "http://fn.default.svc.cluster.local"},
+			"conditions": []any{
+				map[string]any{"type": "Ready", "status": "True"},
+			},
+		},
+	}}
+	if _, _, url := parseKnativeStatus(obj); url != "http://fn.default.svc.cluster.local" {
+		t.Errorf("Expected the cluster-local address, got %q", url)
+	}
+
+	obj.SetLabels(nil)
+	if url := serviceURL(obj); url != "http://fn.default.example.com" {
+		t.Errorf("Expected the external URL, got %q", url)
+	}
+}
@@ -0,0 +1,168 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// volumeSources are the volume types a function may declare. Knative
+// rejects the others.
+var volumeSources = []string{"configMap", "secret", "emptyDir", "projected"}
+
+var volumeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// volume is one entry of VOLUMES: a volume with exactly one source and
+// where to mount it in the function container.
+type volume struct {
+	Name      string         `json:"name"`
+	ConfigMap any            `json:"configMap,omitempty"`
+	Secret    any            `json:"secret,omitempty"`
+	EmptyDir  map[string]any `json:"emptyDir,omitempty"`
+	Projected map[string]any `json:"projected,omitempty"`
+	MountPath string         `json:"mountPath"`
+	SubPath   string         `json:"subPath,omitempty"`
+	ReadOnly  *bool          `json:"readOnly,omitempty"`
+}
+
+// parseVolumes parses VOLUMES, a JSON list of volumes such as
+// [{"name":"certs","secret":"my-certs","mountPath":"/etc/certs"}]. A
+// configMap or secret given as a string names the object; given as an
+// object it is the Kubernetes volume source, allowing items and
+// defaultMode. ConfigMap and Secret volumes are mounted read-only unless
+// readOnly says otherwise. It returns the revision volumes and the
+// container volumeMounts.
+func parseVolumes(value string) ([]any, []any, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var entries []volume
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, nil, fmt.Errorf("invalid VOLUMES: %w", err)
+	}
+
+	volumes := []any{}
+	mounts := []any{}
+	names := map[string]bool{}
+	mountPaths := map[string]bool{}
+	for _, v := range entries {
+		if !volumeNamePattern.MatchString(v.Name) || len(v.Name) > 63 {
+			return nil, nil, fmt.Errorf("invalid VOLUMES: volume name %q must be a DNS label", v.Name)
+		}
+		if names[v.Name] {
+			return nil, nil, fmt.Errorf("invalid VOLUMES: duplicate volume %q", v.Name)
+		}
+		names[v.Name] = true
+
+		rendered, readOnly, err := renderVolume(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid VOLUMES volume %q: %w", v.Name, err)
+		}
+		volumes = append(volumes, rendered)
+
+		if !path.IsAbs(v.MountPath) {
+			return nil, nil, fmt.Errorf("invalid VOLUMES volume %q: mountPath %q must be absolute", v.Name, v.MountPath)
+		}
+		mountPath := path.Clean(v.MountPath)
+		if mountPaths[mountPath] {
+			return nil, nil, fmt.Errorf("invalid VOLUMES volume %q: mountPath %s is already mounted", v.Name, mountPath)
+		}
+		mountPaths[mountPath] = true
+
+		mount := map[string]any{
+			"name":      v.Name,
+			"mountPath": mountPath,
+		}
+		if v.SubPath != "" {
+			mount["subPath"] = v.SubPath
+		}
+		if v.ReadOnly != nil {
+			readOnly = *v.ReadOnly
+		}
+		if readOnly {
+			mount["readOnly"] = true
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return volumes, mounts, nil
+}
+
+// renderVolume renders the Kubernetes volume of v and tells whether it
+// mounts read-only by default.
+func renderVolume(v volume) (map[string]any, bool, error) {
+	sources := 0
+	for _, set := range []bool{v.ConfigMap != nil, v.Secret != nil, v.EmptyDir != nil, v.Projected != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, false, fmt.Errorf("expected exactly one of %s", strings.Join(volumeSources, ", "))
+	}
+
+	rendered := map[string]any{"name": v.Name}
+	switch {
+	case v.ConfigMap != nil:
+		source, err := namedVolumeSource(v.ConfigMap, "name")
+		if err != nil {
+			return nil, false, fmt.Errorf("configMap: %w", err)
+		}
+		rendered["configMap"] = source
+		return rendered, true, nil
+	case v.Secret != nil:
+		source, err := namedVolumeSource(v.Secret, "secretName")
+		if err != nil {
+			return nil, false, fmt.Errorf("secret: %w", err)
+		}
+		rendered["secret"] = source
+		return rendered, true, nil
+	case v.EmptyDir != nil:
+		rendered["emptyDir"] = intJSONValues(v.EmptyDir)
+		return rendered, false, nil
+	default:
+		if projectedSources, _ := v.Projected["sources"].([]any); len(projectedSources) == 0 {
+			return nil, false, fmt.Errorf("projected needs sources")
+		}
+		rendered["projected"] = intJSONValues(v.Projected)
+		return rendered, true, nil
+	}
+}
+
+// namedVolumeSource expands a ConfigMap or Secret given by name into its
+// volume source, where nameField holds the name.
+func namedVolumeSource(value any, nameField string) (map[string]any, error) {
+	if name, ok := value.(string); ok {
+		value = map[string]any{nameField: name}
+	}
+	source, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a name or an object")
+	}
+	if name, _ := source[nameField].(string); name == "" {
+		return nil, fmt.Errorf("%s is required", nameField)
+	}
+	return intJSONValues(source).(map[string]any), nil
+}
+
+// applyVolumes adds the volumes configured in VOLUMES to the revision and
+// mounts them in the function container.
+func applyVolumes(revisionSpec, container map[string]any, cfg *EnvConfig) error {
+	volumes, mounts, err := parseVolumes(cfg.Volumes)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+	revisionSpec["volumes"] = volumes
+	container["volumeMounts"] = mounts
+	return nil
+}
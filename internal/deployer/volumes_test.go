@@ -0,0 +1,80 @@
+package deployer
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseVolumes(t *testing.T) {
+	volumes, mounts, err := parseVolumes(`[
+		{"name":"certs","secret":"my-certs","mountPath":"/etc/certs/"},
+		{"name":"config","configMap":{"name":"my-config","items":[{"key":"app.yaml","path":"app.yaml"}],"defaultMode":420},"mountPath":"/etc/app","readOnly":false},
+		{"name":"scratch","emptyDir":{},"mountPath":"/tmp"}
+	]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	wantVolumes := []any{
+		map[string]any{"name": "certs", "secret": map[string]any{"secretName": "my-certs"}},
+		map[string]any{"name": "config", "configMap": map[string]any{
+			"name":        "my-config",
+			"items":       []any{map[string]any{"key": "app.yaml", "path": "app.yaml"}},
+			"defaultMode": int64(420),
+		}},
+		map[string]any{"name": "scratch", "emptyDir": map[string]any{}},
+	}
+	if !reflect.DeepEqual(volumes, wantVolumes) {
+		t.Errorf("Expected volumes %v, got %v", wantVolumes, volumes)
+	}
+	wantMounts := []any{
+		map[string]any{"name": "certs", "mountPath": "/etc/certs", "readOnly": true},
+		map[string]any{"name": "config", "mountPath": "/etc/app"},
+		map[string]any{"name": "scratch", "mountPath": "/tmp"},
+	}
+	if !reflect.DeepEqual(mounts, wantMounts) {
+		t.Errorf("Expected mounts %v, got %v", wantMounts, mounts)
+	}
+
+	for _, value := range []string{
+		`{"name":"certs"}`,
+		`[{"name":"Certs","secret":"my-certs","mountPath":"/etc/certs"}]`,
+		`[{"name":"certs","mountPath":"/etc/certs"}]`,
+		`[{"name":"certs","secret":"a","configMap":"b","mountPath":"/etc/certs"}]`,
+		`[{"name":"certs","secret":"my-certs","mountPath":"etc/certs"}]`,
+		`[{"name":"certs","secret":{},"mountPath":"/etc/certs"}]`,
+		`[{"name":"bundle","projected":{},"mountPath":"/etc/bundle"}]`,
+		`[{"name":"a","emptyDir":{},"mountPath":"/tmp"},{"name":"a","emptyDir":{},"mountPath":"/var/tmp"}]`,
+		`[{"name":"a","emptyDir":{},"mountPath":"/tmp"},{"name":"b","emptyDir":{},"mountPath":"/tmp/"}]`,
+		`[{"name":"certs","secret":"my-certs","mountPath":"/etc/certs","hostPath":"/etc"}]`,
+	} {
+		if _, _, err := parseVolumes(value); err == nil {
+			t.Errorf("Expected error for %s", value)
+		}
+	}
+}
+
+func TestBuildServiceVolumes(t *testing.T) {
+	cfg := &EnvConfig{
+		FunctionName:      "myfunc",
+		FunctionNamespace: "myns",
+		FunctionImage:     "myimg",
+		Volumes:           `[{"name":"certs","secret":"my-certs","mountPath":"/etc/certs"}]`,
+	}
+	service, err := buildService(cfg, serviceState{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(service.Object, "spec", "template", "spec", "volumes")
+	if len(volumes) != 1 {
+		t.Errorf("Expected 1 volume, got %v", volumes)
+	}
+	containers, _, _ := unstructured.NestedFieldNoCopy(service.Object, "spec", "template", "spec", "containers")
+	container := containers.([]map[string]any)[0]
+	if mounts, _ := container["volumeMounts"].([]any); len(mounts) != 1 {
+		t.Errorf("Expected 1 volume mount, got %v", container["volumeMounts"])
+	}
+}
@@ -0,0 +1,117 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestWaitForReadyWatch(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.SetGeneration(2)
+	client := newFakeDynamicClient(service)
+	resourceClient := client.Resource(knativeServiceGVR).Namespace("myns")
+
+	type result struct {
+		url string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		url, err := waitForReady(context.Background(), resourceClient, "myfunc", defaultWaitTiming, nil)
+		done <- result{url, err}
+	}()
+
+	ready := func() {
+		obj, err := resourceClient.Get(context.Background(), "myfunc", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		_ = unstructured.SetNestedField(obj.Object, map[string]any{
+			"observedGeneration": int64(2),
+			"url":                "http://myfunc.myns.example.com",
+			"conditions": []any{
+				map[string]any{"type": "Ready", "status": "True"},
+			},
+		}, "status")
+		if _, err := resourceClient.Update(context.Background(), obj, metav1.UpdateOptions{}); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				t.Fatalf("Unexpected error: %v", r.err)
+			}
+			if r.url != "http://myfunc.myns.example.com" {
+				t.Errorf("Unexpected url: %s", r.url)
+			}
+			return
+		case <-ticker.C:
+			// Watch events are only seen once the watch is established,
+			// so keep updating until the wait returns
+			ready()
+		case <-timeout:
+			t.Fatal("Timed out waiting for readiness")
+		}
+	}
+}
+
+func TestCheckReady(t *testing.T) {
+	service := newObject("serving.knative.dev/v1", "Service", "myns", "myfunc", nil)
+	service.SetGeneration(2)
+	_ = unstructured.SetNestedField(service.Object, map[string]any{
+		"observedGeneration": int64(1),
+		"url":                "http://myfunc.myns.example.com",
+		"conditions": []any{
+			map[string]any{"type": "Ready", "status": "True"},
+		},
+	}, "status")
+
+	if _, ready := checkReady(service); ready {
+		t.Error("Expected Ready for the previous generation to be ignored")
+	}
+
+	_ = unstructured.SetNestedField(service.Object, int64(2), "status", "observedGeneration")
+	if url, ready := checkReady(service); !ready || url != "http://myfunc.myns.example.com" {
+		t.Errorf("Expected ready with url, got %v %q", ready, url)
+	}
+}
+
+func TestParseWaitTiming(t *testing.T) {
+	timing, err := parseWaitTiming(&EnvConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timing != defaultWaitTiming {
+		t.Errorf("Expected defaults, got %+v", timing)
+	}
+
+	timing, err = parseWaitTiming(&EnvConfig{DeployTimeout: "15m", PollInterval: "500ms"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timing.Timeout != 15*time.Minute || timing.PollInterval != 500*time.Millisecond {
+		t.Errorf("Unexpected timing: %+v", timing)
+	}
+
+	for _, cfg := range []*EnvConfig{
+		{DeployTimeout: "300"},
+		{DeployTimeout: "-1m"},
+		{PollInterval: "0s"},
+		{DeployTimeout: "1s", PollInterval: "5s"},
+	} {
+		if _, err := parseWaitTiming(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
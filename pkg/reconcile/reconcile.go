@@ -0,0 +1,36 @@
+// Package reconcile exposes the KDexFunction reconciler of the deployer,
+// for an operator to deploy functions from its own manager and only
+// launch deploy Jobs for the tenants that need one to themselves.
+//
+// The reconciler must run alone. The deploy ID and log fields of the
+// function being deployed are package state, so a process runs a single
+// FunctionReconciler, and SetupWithManager limits it to one reconcile at a
+// time. A reconcile that deploys returns only once the rollout is ready or
+// failed, which takes up to DEPLOY_TIMEOUT plus the SOAK_DURATION soak,
+// and every other KDexFunction waits for it. Namespaces listed in
+// ISOLATED_NAMESPACES deploy in a Job instead, leaving the reconciler free
+// while it runs.
+package reconcile
+
+import (
+	"time"
+
+	"k8s.io/client-go/dynamic"
+
+	"github.com/kdex-tech/knative-deployer/internal/deployer"
+)
+
+// FunctionReconciler deploys KDexFunctions to Knative and keeps their
+// status in sync, the way the deploy Job and observe CronJob would. It
+// implements the Reconciler of controller-runtime, and must run alone, as
+// the package documentation explains.
+type FunctionReconciler = deployer.FunctionReconciler
+
+// NewFunctionReconciler returns a reconciler deploying with client.
+// settings are the platform settings by the env var a deploy Job reads
+// them from, such as VERIFY_IMAGE_SIGNATURE; the process env is not read
+// for them. A deployed function is observed every observeInterval, or
+// every 5 minutes when it is zero.
+func NewFunctionReconciler(client dynamic.Interface, settings map[string]string, observeInterval time.Duration) *FunctionReconciler {
+	return deployer.NewFunctionReconciler(client, settings, observeInterval)
+}
@@ -0,0 +1,53 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	ctrlreconcile "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var kdexFunctionGVR = schema.GroupVersionResource{Group: "kdex.dev", Version: "v1alpha1", Resource: "kdexfunctions"}
+
+func TestFunctionReconciler(t *testing.T) {
+	// Without an image the function is reported invalid, short of a deploy
+	function := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "kdex.dev/v1alpha1",
+		"kind":       "KDexFunction",
+		"metadata":   map[string]any{"name": "fn", "namespace": "ns", "generation": int64(1)},
+		"spec":       map[string]any{},
+	}}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		kdexFunctionGVR: "KDexFunctionList",
+	}, function)
+
+	var r ctrlreconcile.Reconciler = NewFunctionReconciler(client, map[string]string{"DEPLOY_QUOTA": "20/1h"}, 0)
+	result, err := r.Reconcile(context.Background(), ctrlreconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "fn"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Errorf("Expected an invalid function not to be observed, got %+v", result)
+	}
+
+	got, err := client.Resource(kdexFunctionGVR).Namespace("ns").Get(context.Background(), "fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(got.Object, "status", "conditions")
+	if len(conditions) != 1 || conditions[0].(map[string]any)["reason"] != "InvalidSpec" {
+		t.Errorf("Expected the function to be reported invalid, got %v", conditions)
+	}
+
+	// A function that is gone has nothing left to reconcile
+	result, err = r.Reconcile(context.Background(), ctrlreconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "missing"}})
+	if err != nil || result != (ctrlreconcile.Result{}) {
+		t.Errorf("Expected nothing to do for a missing function, got %+v, %v", result, err)
+	}
+}
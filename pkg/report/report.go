@@ -0,0 +1,162 @@
+// Package report holds the formats the deployer reports a run in: the
+// termination message of its Job and the deploy result ConfigMap. The
+// controller launching the deployer decodes them with these types, so the
+// two can be upgraded independently.
+package report
+
+import "fmt"
+
+// Version is the version of the termination message format. Fields may be
+// added to the format without changing it, as readers ignore fields they
+// do not know; removing a field, renaming it or changing its meaning bumps
+// it, so a controller can tell a message it does not understand from one
+// that lacks a field.
+const Version = 1
+
+// Outcomes of a run other than a deploy that succeeded.
+const (
+	// OutcomeDeleted is the outcome of a delete that removed the function.
+	OutcomeDeleted = "Deleted"
+	// OutcomeNotFound is the outcome of a delete of a function that did
+	// not exist.
+	OutcomeNotFound = "NotFound"
+	// OutcomeFailed is the outcome of a deploy that failed.
+	OutcomeFailed = "Failed"
+	// OutcomeThrottled is the outcome of a deploy refused by the tenant
+	// quota.
+	OutcomeThrottled = "Throttled"
+)
+
+// Phase is a phase of a deploy.
+type Phase string
+
+// Deploy phases, in the order they run.
+const (
+	PhaseValidate      Phase = "Validate"
+	PhasePreflight     Phase = "Preflight"
+	PhaseApply         Phase = "Apply"
+	PhaseAwaitRevision Phase = "AwaitRevision"
+	PhaseVerify        Phase = "Verify"
+	PhaseShiftTraffic  Phase = "ShiftTraffic"
+	PhaseSoak          Phase = "Soak"
+	PhaseFinalize      Phase = "Finalize"
+)
+
+// Phase outcomes.
+const (
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+	PhaseSkipped   = "Skipped"
+)
+
+// TerminationMessage is written to the termination log for the controller
+// that launched the job. Every command writes one; fields a command has
+// nothing for are left out.
+type TerminationMessage struct {
+	// Version is the Version the message was written in. Messages from
+	// before versioning have none and read as 0.
+	Version int `json:"version"`
+	// URL is where the Service serves the function.
+	URL string `json:"url"`
+	// CustomURL is the URL of the function on FUNCTION_HOST.
+	CustomURL string `json:"customUrl,omitempty"`
+	// FunctionURL is URL followed by FUNCTION_BASEPATH, where the function
+	// answers.
+	FunctionURL string `json:"functionUrl,omitempty"`
+	// Outcome tells what a delete did, Deleted or NotFound, or that a
+	// deploy Failed or was Throttled by the tenant quota.
+	Outcome string `json:"outcome,omitempty"`
+	// FailedPhase is the phase a failed deploy stopped at.
+	FailedPhase Phase `json:"failedPhase,omitempty"`
+	// Reason is the cause of a failed deploy in a word, such as the reason
+	// of the failing revision condition or of the API error.
+	Reason string `json:"reason,omitempty"`
+	// Error is what a failed deploy failed with.
+	Error string `json:"error,omitempty"`
+	// Revision is the revision serving traffic after a rollback.
+	Revision string `json:"revision,omitempty"`
+	// LatestReadyRevision is the revision a deploy rolled out.
+	LatestReadyRevision string `json:"latestReadyRevision,omitempty"`
+	// Generation is the KDexFunction generation deployed, the
+	// kdex.dev/generation label of the Service.
+	Generation string `json:"generation,omitempty"`
+	// ImageDigest is the digest of the image the revision runs.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// TimeToReady is how long the Service took to become ready.
+	TimeToReady string `json:"timeToReady,omitempty"`
+	// ReadinessRegression tells that TimeToReady regressed past
+	// READINESS_REGRESSION_FACTOR times the median of the previous deploys.
+	ReadinessRegression string `json:"readinessRegression,omitempty"`
+	// Tags maps traffic tags to their URLs.
+	Tags map[string]string `json:"tags,omitempty"`
+	// DeployID correlates a deploy with its logs, Events and notifications.
+	DeployID string `json:"deployId,omitempty"`
+	// Phases reports how each phase of a deploy went, in the order they ran.
+	Phases []PhaseReport `json:"phases,omitempty"`
+	// Diagnosis is why the revision of a failed deploy did not become
+	// ready.
+	Diagnosis *RevisionFailure `json:"diagnosis,omitempty"`
+	// Truncated tells that the message was cut to fit the termination log.
+	Truncated bool `json:"truncated,omitempty"`
+	// FullMessageConfigMap is the ConfigMap, in the namespace of the
+	// function, holding the message before it was cut.
+	FullMessageConfigMap string `json:"fullMessageConfigMap,omitempty"`
+}
+
+// PhaseReport is how a phase went.
+type PhaseReport struct {
+	Phase Phase `json:"phase"`
+	// Outcome is Succeeded, Failed or Skipped.
+	Outcome string `json:"outcome"`
+	// Attempts counts the runs of the phase, retries included.
+	Attempts int `json:"attempts,omitempty"`
+	// Duration is how long the phase took, as a Go duration.
+	Duration string `json:"duration,omitempty"`
+	// Error is why the phase failed.
+	Error string `json:"error,omitempty"`
+}
+
+// RevisionFailure is why the latest revision of a Service is not becoming
+// ready, as its conditions tell.
+type RevisionFailure struct {
+	Revision string `json:"revision"`
+	// Reason is the reason of the failing condition, such as
+	// ImagePullBackOff, ContainerMissing, ExitCode1 or
+	// ProgressDeadlineExceeded.
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+func (f *RevisionFailure) Error() string {
+	if f.Message == "" {
+		return fmt.Sprintf("revision %s: %s", f.Revision, f.Reason)
+	}
+	return fmt.Sprintf("revision %s: %s: %s", f.Revision, f.Reason, f.Message)
+}
+
+// Terminal tells whether the revision will never become ready: its image
+// does not exist, or Knative gave up on it.
+func (f *RevisionFailure) Terminal() bool {
+	return f.Reason == "ContainerMissing" || f.Reason == "ProgressDeadlineExceeded"
+}
+
+// Result is the data of the deploy result ConfigMap, which components in
+// the cluster read the outcome of the last deploy from. Times are RFC 3339.
+type Result struct {
+	// Status is Succeeded, or the Outcome of the termination message.
+	Status string `json:"status"`
+	URL    string `json:"url"`
+	// Revision is the revision the deploy rolled out, or rolled back to.
+	Revision    string `json:"revision"`
+	Generation  string `json:"generation"`
+	StartedAt   string `json:"startedAt"`
+	FinishedAt  string `json:"finishedAt"`
+	FunctionURL string `json:"functionUrl,omitempty"`
+	CustomURL   string `json:"customUrl,omitempty"`
+	DeployID    string `json:"deployId,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	TimeToReady string `json:"timeToReady,omitempty"`
+	FailedPhase Phase  `json:"failedPhase,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
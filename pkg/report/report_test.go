@@ -0,0 +1,119 @@
+package report
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// terminationMessageV1 is a deploy's termination message in version 1 of
+// the format. It must keep decoding to the same message for as long as the
+// version stays 1.
+const terminationMessageV1 = `{"version":1,"url":"http://fn.default.example.com","customUrl":"https://fn.example.com","tags":{"canary":"http://canary-fn.default.example.com"},"deployId":"20261016-000000-abcdef","phases":[{"phase":"Validate","outcome":"Succeeded","attempts":1,"duration":"1ms"},{"phase":"Apply","outcome":"Failed","attempts":3,"duration":"2s","error":"conflict"},{"phase":"Finalize","outcome":"Skipped"}]}`
+
+// failureMessageV1 is the termination message of a deploy whose revision
+// did not become ready, in version 1 of the format.
+const failureMessageV1 = `{"version":1,"url":"","outcome":"Failed","failedPhase":"AwaitRevision","reason":"ImagePullBackOff","error":"revision fn-00002: ImagePullBackOff","generation":"2","diagnosis":{"revision":"fn-00002","reason":"ImagePullBackOff"}}`
+
+// resultV1 is the data of a deploy result ConfigMap, which the format
+// version covers too.
+const resultV1 = `{"status":"Succeeded","url":"http://fn.default.example.com","revision":"fn-00007","generation":"7","startedAt":"2026-10-16T12:00:00Z","finishedAt":"2026-10-16T12:01:30Z","deployId":"abc","timeToReady":"12s"}`
+
+func TestCompatibility(t *testing.T) {
+	if Version != 1 {
+		t.Fatalf("Version is %d: add fixtures for it and keep those for version 1", Version)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		fixture string
+		want    any
+	}{
+		{
+			name:    "deploy",
+			fixture: terminationMessageV1,
+			want: &TerminationMessage{
+				Version:   1,
+				URL:       "http://fn.default.example.com",
+				CustomURL: "https://fn.example.com",
+				Tags:      map[string]string{"canary": "http://canary-fn.default.example.com"},
+				DeployID:  "20261016-000000-abcdef",
+				Phases: []PhaseReport{
+					{Phase: PhaseValidate, Outcome: PhaseSucceeded, Attempts: 1, Duration: "1ms"},
+					{Phase: PhaseApply, Outcome: PhaseFailed, Attempts: 3, Duration: "2s", Error: "conflict"},
+					{Phase: PhaseFinalize, Outcome: PhaseSkipped},
+				},
+			},
+		},
+		{
+			name:    "failure",
+			fixture: failureMessageV1,
+			want: &TerminationMessage{
+				Version:     1,
+				Outcome:     OutcomeFailed,
+				FailedPhase: PhaseAwaitRevision,
+				Reason:      "ImagePullBackOff",
+				Error:       "revision fn-00002: ImagePullBackOff",
+				Generation:  "2",
+				Diagnosis:   &RevisionFailure{Revision: "fn-00002", Reason: "ImagePullBackOff"},
+			},
+		},
+		{
+			name:    "result",
+			fixture: resultV1,
+			want: &Result{
+				Status:      PhaseSucceeded,
+				URL:         "http://fn.default.example.com",
+				Revision:    "fn-00007",
+				Generation:  "7",
+				StartedAt:   "2026-10-16T12:00:00Z",
+				FinishedAt:  "2026-10-16T12:01:30Z",
+				DeployID:    "abc",
+				TimeToReady: "12s",
+			},
+		},
+	} {
+		got := reflect.New(reflect.TypeOf(tt.want).Elem()).Interface()
+		if err := json.Unmarshal([]byte(tt.fixture), got); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: version 1 fixture decoded to %+v", tt.name, got)
+		}
+
+		data, err := json.Marshal(tt.want)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if string(data) != tt.fixture {
+			t.Errorf("%s: no longer encodes as version 1:\n%s", tt.name, data)
+		}
+	}
+
+	// Messages from before versioning, and from newer deployers that added
+	// fields, still decode
+	var old TerminationMessage
+	if err := json.Unmarshal([]byte(`{"url":"http://foo.bar","outcome":"Deleted","future":true}`), &old); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if old.Version != 0 || old.URL != "http://foo.bar" || old.Outcome != OutcomeDeleted {
+		t.Errorf("Unexpected message: %+v", old)
+	}
+}
+
+func TestRevisionFailure(t *testing.T) {
+	failure := &RevisionFailure{Revision: "fn-00002", Reason: "ContainerMissing", Message: "image not found"}
+	if got := failure.Error(); got != "revision fn-00002: ContainerMissing: image not found" {
+		t.Errorf("Unexpected error %q", got)
+	}
+	if !failure.Terminal() {
+		t.Error("Expected a missing image to be terminal")
+	}
+	failure = &RevisionFailure{Revision: "fn-00002", Reason: "ImagePullBackOff"}
+	if got := failure.Error(); got != "revision fn-00002: ImagePullBackOff" {
+		t.Errorf("Unexpected error %q", got)
+	}
+	if failure.Terminal() {
+		t.Error("Expected an image pull back-off to be retried")
+	}
+}